@@ -28,8 +28,140 @@ type AwsAccountSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	UserName string   `json:"userName"`
-	Groups   []string `json:"groups"`
+	UserName string `json:"userName"`
+
+	// Groups are names of existing IAM groups the user is added to
+	// +optional
+	Groups []string `json:"groups"`
+
+	// GroupRefs are names of AwsGroups in the same namespace the user is added to
+	// +optional
+	GroupRefs []string `json:"groupRefs,omitempty"`
+
+	// ManagedPolicyArns are managed policies attached directly to the user
+	// +optional
+	ManagedPolicyArns []string `json:"managedPolicyArns,omitempty"`
+
+	// InlinePolicies are the inline policies of the user, keyed by policy name
+	// +optional
+	InlinePolicies map[string]InlinePolicy `json:"inlinePolicies,omitempty"`
+
+	// Credentials controls where the login and access key Secrets are written
+	// and how the access key Secret is laid out.
+	// +optional
+	Credentials *CredentialsSpec `json:"credentials,omitempty"`
+
+	// HostedZone is the Route53 hosted zone assigned to the user
+	// +optional
+	HostedZone *HostedZoneSpec `json:"hostedZone,omitempty"`
+
+	// PermissionsBoundary is the ARN of a managed policy used as the user's permissions
+	// boundary instead of the one configured for the namespace or cluster. It is only
+	// honoured when the controller allows permissions boundary overrides.
+	// +optional
+	PermissionsBoundary string `json:"permissionsBoundary,omitempty"`
+
+	// Suspended deletes the login profile of the user and deactivates its access keys, keeping
+	// the user, its Secrets and its permissions. Clearing it creates the login profile again
+	// with the password in the login Secret and activates the access keys.
+	// +optional
+	Suspended bool `json:"suspended,omitempty"`
+}
+
+// InlinePolicy holds a JSON policy document, either directly, from a ConfigMap or
+// rendered from an AwsPolicyTemplate
+type InlinePolicy struct {
+	// +optional
+	Document string `json:"document,omitempty"`
+
+	// ConfigMapKeyRef reads the document from a ConfigMap in the namespace of the AwsAccount
+	// +optional
+	ConfigMapKeyRef *ConfigMapKeyReference `json:"configMapKeyRef,omitempty"`
+
+	// TemplateRef renders the document from an AwsPolicyTemplate in the namespace of the AwsAccount
+	// +optional
+	TemplateRef *PolicyTemplateReference `json:"templateRef,omitempty"`
+}
+
+// PolicyTemplateReference names an AwsPolicyTemplate
+type PolicyTemplateReference struct {
+	Name string `json:"name"`
+}
+
+// ConfigMapKeyReference selects a key of a ConfigMap
+type ConfigMapKeyReference struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// HostedZoneSpec describes a Route53 hosted zone assigned to the user
+type HostedZoneSpec struct {
+	// ID of the Route53 hosted zone
+	ID string `json:"id"`
+
+	// DomainName served by the hosted zone
+	DomainName string `json:"domainName"`
+
+	// CreateManagedZone creates a Kuadrant ManagedZone for the hosted zone in the
+	// user namespace, backed by a DNS provider Secret holding the user's access key
+	// +optional
+	CreateManagedZone bool `json:"createManagedZone,omitempty"`
+}
+
+// CredentialsFormat selects the key layout of the access key Secret
+// +kubebuilder:validation:Enum=env;ini;config;json;kuadrant
+type CredentialsFormat string
+
+const (
+	// CredentialsFormatEnv stores one environment variable per Secret key,
+	// e.g. AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+	CredentialsFormatEnv CredentialsFormat = "env"
+	// CredentialsFormatIni stores an ~/.aws/credentials file under the "credentials" key
+	CredentialsFormatIni CredentialsFormat = "ini"
+	// CredentialsFormatConfig stores an ~/.aws/config file under the "config" key
+	CredentialsFormatConfig CredentialsFormat = "config"
+	// CredentialsFormatJson stores a credential_process compatible JSON
+	// document under the "credentials.json" key
+	CredentialsFormatJson CredentialsFormat = "json"
+	// CredentialsFormatKuadrant stores the keys read by the Kuadrant DNS operator
+	// in a Secret of type kuadrant.io/aws
+	CredentialsFormatKuadrant CredentialsFormat = "kuadrant"
+)
+
+// SecretReference identifies a Secret written by the controller
+type SecretReference struct {
+	Name string `json:"name"`
+
+	// Namespace defaults to the namespace created for the user
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// CredentialsSpec defines how credentials are delivered to the user
+type CredentialsSpec struct {
+	// SecretRef is the Secret holding the access key. Defaults to "aws-credentials".
+	// +optional
+	SecretRef *SecretReference `json:"secretRef,omitempty"`
+
+	// LoginSecretRef is the Secret holding the console password. Defaults to "aws-login".
+	// +optional
+	LoginSecretRef *SecretReference `json:"loginSecretRef,omitempty"`
+
+	// +optional
+	Format CredentialsFormat `json:"format,omitempty"`
+
+	// Region written alongside the access key. Defaults to the controller's region.
+	// +optional
+	Region string `json:"region,omitempty"`
+
+	// Profile is the profile name used by the ini and config formats. Defaults to "default".
+	// +optional
+	Profile string `json:"profile,omitempty"`
+
+	// DNSProviderSecretRef, when set, is an additional Secret of type kuadrant.io/aws
+	// that can be referenced by Kuadrant DNS policies and managed zones
+	// +optional
+	DNSProviderSecretRef *SecretReference `json:"dnsProviderSecretRef,omitempty"`
 }
 
 // AwsAccountStatus defines the observed state of AwsAccount
@@ -37,6 +169,19 @@ type AwsAccountStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
+	// +optional
+	Phase AwsAccountPhase `json:"phase,omitempty"`
+
+	// UserName is the name of the IAM user as last applied. A different spec.userName
+	// renames the user.
+	// +optional
+	UserName string `json:"userName,omitempty"`
+
+	// PreviousUserName is the name the IAM user had before a rename whose namespace and
+	// Secrets have not been moved yet. Its namespace is deleted once they have.
+	// +optional
+	PreviousUserName string `json:"previousUserName,omitempty"`
+
 	// +optional
 	UserCreated bool `json:"userCreated"`
 
@@ -46,15 +191,137 @@ type AwsAccountStatus struct {
 	// +optional
 	AccessKeyCreated bool `json:"accessKeyCreated"`
 
+	// AccessKeyCreationDate is when the oldest access key of the user was created
+	// +optional
+	AccessKeyCreationDate *metav1.Time `json:"accessKeyCreationDate,omitempty"`
+
+	// Suspended is set while the login profile of the user is deleted and its access keys
+	// are deactivated because of spec.suspended
+	// +optional
+	Suspended bool `json:"suspended,omitempty"`
+
 	// +optional
 	UserGroups []string `json:"userGroups"`
 
 	// +optional
 	NamespaceCreated bool `json:"namespaceCreated"`
+
+	// AttachedPolicyArns are the managed policies attached directly to the user
+	// +optional
+	AttachedPolicyArns []string `json:"attachedPolicyArns,omitempty"`
+
+	// InlinePolicyNames are the names of the user's inline policies
+	// +optional
+	InlinePolicyNames []string `json:"inlinePolicyNames,omitempty"`
+
+	// Credentials records where credentials were last written so that they
+	// can be moved and cleaned up when the spec changes
+	// +optional
+	Credentials *CredentialsSpec `json:"credentials,omitempty"`
+
+	// ManagedZone is the name of the Kuadrant ManagedZone created in the user namespace
+	// +optional
+	ManagedZone string `json:"managedZone,omitempty"`
+
+	// PermissionsBoundary is the ARN of the permissions boundary set on the user
+	// +optional
+	PermissionsBoundary string `json:"permissionsBoundary,omitempty"`
+
+	// Path is the IAM path of the user
+	// +optional
+	Path string `json:"path,omitempty"`
+
+	// TagKeys are the keys of the tags last set on the user, so that tags are removed once
+	// their key is no longer copied
+	// +optional
+	TagKeys []string `json:"tagKeys,omitempty"`
+
+	// Drift lists the most recent changes made to the IAM user outside of Kuadra
+	// +optional
+	Drift []DriftItem `json:"drift,omitempty"`
+
+	// PlannedActions are the changes the last dry run reconcile would have made
+	// +optional
+	PlannedActions []PlannedAction `json:"plannedActions,omitempty"`
+
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
+// AwsAccountPhase summarises the state of an AwsAccount
+// +kubebuilder:validation:Enum=Pending;Ready;Failed;Deleting
+type AwsAccountPhase string
+
+const (
+	AwsAccountPhasePending  AwsAccountPhase = "Pending"
+	AwsAccountPhaseReady    AwsAccountPhase = "Ready"
+	AwsAccountPhaseFailed   AwsAccountPhase = "Failed"
+	AwsAccountPhaseDeleting AwsAccountPhase = "Deleting"
+)
+
+// DriftType names a kind of change made to an IAM user outside of Kuadra
+type DriftType string
+
+const (
+	DriftUserDeleted                DriftType = "UserDeleted"
+	DriftLoginProfileDeleted        DriftType = "LoginProfileDeleted"
+	DriftAccessKeyDeleted           DriftType = "AccessKeyDeleted"
+	DriftGroupRemoved               DriftType = "GroupRemoved"
+	DriftGroupAdded                 DriftType = "GroupAdded"
+	DriftPolicyDetached             DriftType = "PolicyDetached"
+	DriftPolicyAttached             DriftType = "PolicyAttached"
+	DriftInlinePolicyDeleted        DriftType = "InlinePolicyDeleted"
+	DriftInlinePolicyAdded          DriftType = "InlinePolicyAdded"
+	DriftPermissionsBoundaryChanged DriftType = "PermissionsBoundaryChanged"
+	DriftPathChanged                DriftType = "PathChanged"
+)
+
+// DriftItem is a change to the IAM user that Kuadra did not make
+type DriftItem struct {
+	Type DriftType `json:"type"`
+
+	// Subject is the group, policy or value that changed
+	// +optional
+	Subject string `json:"subject,omitempty"`
+
+	DetectedAt metav1.Time `json:"detectedAt"`
+
+	// Corrected is false when the controller only reports drift
+	Corrected bool `json:"corrected"`
+}
+
+// PlannedAction is a change a reconcile in dry run mode found necessary but did not make
+type PlannedAction struct {
+	// Action is the IAM operation, such as iam:CreateUser, or the Kubernetes verb and kind,
+	// such as "create Namespace"
+	Action string `json:"action"`
+
+	// Target is the user, group, policy or object the action applies to
+	Target string `json:"target"`
+}
+
+const (
+	// ConditionGroupsResolved reports whether every entry of spec.groupRefs names an existing AwsGroup
+	ConditionGroupsResolved = "GroupsResolved"
+	// ConditionPoliciesResolved reports whether every inline policy could be read, rendered and validated
+	ConditionPoliciesResolved = "PoliciesResolved"
+	// ConditionPermissionsBoundary reports whether the user carries the permissions boundary asked for in the spec
+	ConditionPermissionsBoundary = "PermissionsBoundary"
+	// ConditionReady reports whether the last reconcile of the resource succeeded
+	ConditionReady = "Ready"
+	// ConditionDryRun is set while the resource is reconciled in dry run mode
+	ConditionDryRun = "DryRun"
+	// ConditionOrphansCollected reports whether the last search of IAM for orphaned users succeeded
+	ConditionOrphansCollected = "OrphansCollected"
+)
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="User",type=string,JSONPath=".spec.userName"
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=".status.phase"
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=".metadata.creationTimestamp"
 
 // AwsAccount is the Schema for the awsaccounts API
 type AwsAccount struct {
@@ -65,6 +332,16 @@ type AwsAccount struct {
 	Status AwsAccountStatus `json:"status,omitempty"`
 }
 
+// AppliedUserName returns the name the IAM user has, which differs from spec.userName until
+// a rename has been reconciled. AwsAccounts last reconciled before the name was recorded in
+// status use the spec.
+func (a *AwsAccount) AppliedUserName() string {
+	if a.Status.UserName != "" {
+		return a.Status.UserName
+	}
+	return a.Spec.UserName
+}
+
 //+kubebuilder:object:root=true
 
 // AwsAccountList contains a list of AwsAccount
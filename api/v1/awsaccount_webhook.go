@@ -17,6 +17,9 @@ limitations under the License.
 package v1
 
 import (
+	"context"
+	"fmt"
+
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -26,9 +29,26 @@ import (
 // log is for logging in this package.
 var awsaccountlog = logf.Log.WithName("awsaccount-resource")
 
-func (r *AwsAccount) SetupWebhookWithManager(mgr ctrl.Manager) error {
+//+kubebuilder:object:generate=false
+
+// AwsAccountValidator validates AwsAccounts against the options the controller runs with
+type AwsAccountValidator struct {
+	// AllowPermissionsBoundaryOverride lets AwsAccounts set spec.permissionsBoundary. It mirrors
+	// the controller's --allow-permissions-boundary-override flag.
+	AllowPermissionsBoundaryOverride bool
+	// ForbidUserRename rejects changes to spec.userName. It mirrors the controller's
+	// --forbid-user-rename flag.
+	ForbidUserRename bool
+	// AllowCrossNamespaceSecrets lets spec.credentials write Secrets to namespaces other than
+	// those of the AwsAccount and of its user. It mirrors the controller's
+	// --allow-cross-namespace-secrets flag.
+	AllowCrossNamespaceSecrets bool
+}
+
+func (r *AwsAccount) SetupWebhookWithManager(mgr ctrl.Manager, validator *AwsAccountValidator) error {
 	return ctrl.NewWebhookManagedBy(mgr).
 		For(r).
+		WithValidator(validator).
 		Complete()
 }
 
@@ -48,26 +68,84 @@ func (r *AwsAccount) Default() {
 // TODO(user): change verbs to "verbs=create;update;delete" if you want to enable deletion validation.
 //+kubebuilder:webhook:path=/validate-kuadra-kuadrant-io-v1-awsaccount,mutating=false,failurePolicy=fail,sideEffects=None,groups=kuadra.kuadrant.io,resources=awsaccounts,verbs=create;update,versions=v1,name=vawsaccount.kb.io,admissionReviewVersions=v1
 
-var _ webhook.Validator = &AwsAccount{}
+var _ webhook.CustomValidator = &AwsAccountValidator{}
 
-// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
-func (r *AwsAccount) ValidateCreate() error {
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *AwsAccountValidator) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	r, ok := obj.(*AwsAccount)
+	if !ok {
+		return fmt.Errorf("expected an AwsAccount but got a %T", obj)
+	}
 	awsaccountlog.Info("validate create", "name", r.Name)
 
-	// TODO(user): fill in your validation logic upon object creation.
-	return nil
+	if err := v.validateCredentials(r); err != nil {
+		return err
+	}
+	return v.validatePermissionsBoundary(r)
 }
 
-// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
-func (r *AwsAccount) ValidateUpdate(old runtime.Object) error {
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *AwsAccountValidator) ValidateUpdate(ctx context.Context, old runtime.Object, obj runtime.Object) error {
+	r, ok := obj.(*AwsAccount)
+	if !ok {
+		return fmt.Errorf("expected an AwsAccount but got a %T", obj)
+	}
 	awsaccountlog.Info("validate update", "name", r.Name)
 
-	// TODO(user): fill in your validation logic upon object update.
+	oldAwsAccount, ok := old.(*AwsAccount)
+	if !ok {
+		return fmt.Errorf("expected an AwsAccount but got a %T", old)
+	}
+	if v.ForbidUserRename && r.Spec.UserName != oldAwsAccount.Spec.UserName {
+		return fmt.Errorf("spec.userName cannot be changed, renaming IAM users is not allowed; create a new AwsAccount instead")
+	}
+	if oldAwsAccount.Spec.PermissionsBoundary != "" && r.Spec.PermissionsBoundary == "" {
+		return fmt.Errorf("spec.permissionsBoundary cannot be removed")
+	}
+	if err := v.validateCredentials(r); err != nil {
+		return err
+	}
+	if r.Spec.PermissionsBoundary == oldAwsAccount.Spec.PermissionsBoundary {
+		return nil
+	}
+	return v.validatePermissionsBoundary(r)
+}
+
+// validateCredentials keeps the Secrets of an AwsAccount in its own namespace or in the
+// namespace created for its user, so that it cannot write to namespaces it has no access to
+func (v *AwsAccountValidator) validateCredentials(r *AwsAccount) error {
+	if v.AllowCrossNamespaceSecrets || r.Spec.Credentials == nil {
+		return nil
+	}
+	for _, target := range []struct {
+		field string
+		ref   *SecretReference
+	}{
+		{"spec.credentials.secretRef", r.Spec.Credentials.SecretRef},
+		{"spec.credentials.loginSecretRef", r.Spec.Credentials.LoginSecretRef},
+		{"spec.credentials.dnsProviderSecretRef", r.Spec.Credentials.DNSProviderSecretRef},
+	} {
+		if target.ref == nil || target.ref.Namespace == "" || target.ref.Namespace == r.Namespace || target.ref.Namespace == r.Spec.UserName {
+			continue
+		}
+		return fmt.Errorf("%s.namespace must be %s or %s, Secrets cannot be written to other namespaces", target.field, r.Namespace, r.Spec.UserName)
+	}
+	return nil
+}
+
+func (v *AwsAccountValidator) validatePermissionsBoundary(r *AwsAccount) error {
+	if r.Spec.PermissionsBoundary != "" && !v.AllowPermissionsBoundaryOverride {
+		return fmt.Errorf("spec.permissionsBoundary is not allowed, permissions boundaries are set by the cluster administrator")
+	}
 	return nil
 }
 
-// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
-func (r *AwsAccount) ValidateDelete() error {
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *AwsAccountValidator) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	r, ok := obj.(*AwsAccount)
+	if !ok {
+		return fmt.Errorf("expected an AwsAccount but got a %T", obj)
+	}
 	awsaccountlog.Info("validate delete", "name", r.Name)
 
 	// TODO(user): fill in your validation logic upon object deletion.
@@ -0,0 +1,33 @@
+package v1
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("AwsAccount webhook", func() {
+	It("Should keep credentials Secrets in the namespaces of the AwsAccount and its user", func() {
+		validator := &AwsAccountValidator{}
+		awsAccount := &AwsAccount{
+			ObjectMeta: metav1.ObjectMeta{Name: "ef-dns", Namespace: "team-a"},
+			Spec: AwsAccountSpec{
+				UserName: "ef-dns",
+				Credentials: &CredentialsSpec{
+					SecretRef:      &SecretReference{Name: "aws-credentials", Namespace: "team-a"},
+					LoginSecretRef: &SecretReference{Name: "aws-login", Namespace: "ef-dns"},
+				},
+			},
+		}
+		Expect(validator.ValidateCreate(ctx, awsAccount)).Should(Succeed())
+
+		elsewhere := awsAccount.DeepCopy()
+		elsewhere.Spec.Credentials.DNSProviderSecretRef = &SecretReference{Name: "aws-dns-provider", Namespace: "kube-system"}
+		Expect(validator.ValidateCreate(ctx, elsewhere)).Should(MatchError(
+			"spec.credentials.dnsProviderSecretRef.namespace must be team-a or ef-dns, Secrets cannot be written to other namespaces"))
+		Expect(validator.ValidateUpdate(ctx, awsAccount, elsewhere)).ShouldNot(Succeed())
+
+		validator.AllowCrossNamespaceSecrets = true
+		Expect(validator.ValidateCreate(ctx, elsewhere)).Should(Succeed())
+	})
+})
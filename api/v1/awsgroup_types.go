@@ -0,0 +1,113 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AwsGroupSpec defines the desired state of AwsGroup
+type AwsGroupSpec struct {
+	// Important: Run "make" to regenerate code after modifying this file
+
+	// GroupName of the IAM group. Defaults to the name of the AwsGroup.
+	// +optional
+	GroupName string `json:"groupName,omitempty"`
+
+	// Path of the IAM group
+	// +optional
+	// +kubebuilder:default=/
+	// +kubebuilder:validation:Pattern=`^/(.*/)?$`
+	Path string `json:"path,omitempty"`
+
+	// ManagedPolicyArns are the managed policies attached to the group
+	// +optional
+	ManagedPolicyArns []string `json:"managedPolicyArns,omitempty"`
+
+	// InlinePolicies maps inline policy names to JSON policy documents
+	// +optional
+	InlinePolicies map[string]string `json:"inlinePolicies,omitempty"`
+}
+
+// AwsGroupStatus defines the observed state of AwsGroup
+type AwsGroupStatus struct {
+	// Important: Run "make" to regenerate code after modifying this file
+
+	// GroupName is the name of the IAM group managed for this AwsGroup
+	// +optional
+	GroupName string `json:"groupName,omitempty"`
+
+	// GroupCreated is set once the AwsGroup has created the IAM group, or taken it over.
+	// Only then is the group changed and, with the AwsGroup, deleted.
+	// +optional
+	GroupCreated bool `json:"groupCreated"`
+
+	// +optional
+	Arn string `json:"arn,omitempty"`
+
+	// +optional
+	AttachedPolicyArns []string `json:"attachedPolicyArns,omitempty"`
+
+	// +optional
+	InlinePolicyNames []string `json:"inlinePolicyNames,omitempty"`
+
+	// Members are the IAM users in the group
+	// +optional
+	Members []string `json:"members,omitempty"`
+
+	// PlannedActions are the changes the last dry run reconcile would have made
+	// +optional
+	PlannedActions []PlannedAction `json:"plannedActions,omitempty"`
+
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// AwsGroup is the Schema for the awsgroups API
+type AwsGroup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AwsGroupSpec   `json:"spec,omitempty"`
+	Status AwsGroupStatus `json:"status,omitempty"`
+}
+
+// IamGroupName returns the name of the IAM group described by the AwsGroup
+func (g *AwsGroup) IamGroupName() string {
+	if g.Spec.GroupName != "" {
+		return g.Spec.GroupName
+	}
+	return g.Name
+}
+
+//+kubebuilder:object:root=true
+
+// AwsGroupList contains a list of AwsGroup
+type AwsGroupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AwsGroup `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&AwsGroup{}, &AwsGroupList{})
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var awsgrouplog = logf.Log.WithName("awsgroup-resource")
+
+//+kubebuilder:object:generate=false
+
+// AwsGroupValidator rejects AwsGroups for an IAM group another AwsGroup already manages
+type AwsGroupValidator struct {
+	// Reader lists the AwsGroups of every namespace
+	Reader client.Reader
+}
+
+func (r *AwsGroup) SetupWebhookWithManager(mgr ctrl.Manager, validator *AwsGroupValidator) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithValidator(validator).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-kuadra-kuadrant-io-v1-awsgroup,mutating=false,failurePolicy=fail,sideEffects=None,groups=kuadra.kuadrant.io,resources=awsgroups,verbs=create;update,versions=v1,name=vawsgroup.kb.io,admissionReviewVersions=v1
+
+var _ webhook.CustomValidator = &AwsGroupValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *AwsGroupValidator) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	r, ok := obj.(*AwsGroup)
+	if !ok {
+		return fmt.Errorf("expected an AwsGroup but got a %T", obj)
+	}
+	awsgrouplog.Info("validate create", "name", r.Name)
+
+	return v.validateGroupName(ctx, r)
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *AwsGroupValidator) ValidateUpdate(ctx context.Context, old runtime.Object, obj runtime.Object) error {
+	r, ok := obj.(*AwsGroup)
+	if !ok {
+		return fmt.Errorf("expected an AwsGroup but got a %T", obj)
+	}
+	awsgrouplog.Info("validate update", "name", r.Name)
+
+	oldAwsGroup, ok := old.(*AwsGroup)
+	if !ok {
+		return fmt.Errorf("expected an AwsGroup but got a %T", old)
+	}
+	if strings.EqualFold(r.IamGroupName(), oldAwsGroup.IamGroupName()) {
+		return nil
+	}
+	return v.validateGroupName(ctx, r)
+}
+
+// validateGroupName checks that no other AwsGroup, in any namespace, names the same IAM group.
+// IAM group names are unique regardless of case.
+func (v *AwsGroupValidator) validateGroupName(ctx context.Context, r *AwsGroup) error {
+	var awsGroups AwsGroupList
+	if err := v.Reader.List(ctx, &awsGroups); err != nil {
+		return err
+	}
+	for _, other := range awsGroups.Items {
+		if other.Namespace == r.Namespace && other.Name == r.Name {
+			continue
+		}
+		if strings.EqualFold(other.IamGroupName(), r.IamGroupName()) {
+			return fmt.Errorf("IAM group %s is already managed by AwsGroup %s/%s", r.IamGroupName(), other.Namespace, other.Name)
+		}
+	}
+	return nil
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *AwsGroupValidator) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	return nil
+}
@@ -0,0 +1,30 @@
+package v1
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("AwsGroup webhook", func() {
+	It("Should reject a group name another AwsGroup manages", func() {
+		scheme := runtime.NewScheme()
+		Expect(AddToScheme(scheme)).Should(Succeed())
+		existing := &AwsGroup{
+			ObjectMeta: metav1.ObjectMeta{Name: "admins", Namespace: "team-a"},
+			Spec:       AwsGroupSpec{GroupName: "Admins"},
+		}
+		validator := &AwsGroupValidator{Reader: fake.NewClientBuilder().WithScheme(scheme).WithObjects(existing).Build()}
+
+		duplicate := &AwsGroup{ObjectMeta: metav1.ObjectMeta{Name: "admins", Namespace: "team-b"}}
+		Expect(validator.ValidateCreate(ctx, duplicate)).Should(MatchError("IAM group admins is already managed by AwsGroup team-a/admins"))
+		Expect(validator.ValidateUpdate(ctx, existing, existing)).Should(Succeed())
+
+		renamed := existing.DeepCopy()
+		renamed.Name = "operators"
+		renamed.Spec.GroupName = ""
+		Expect(validator.ValidateCreate(ctx, renamed)).Should(Succeed())
+	})
+})
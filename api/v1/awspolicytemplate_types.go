@@ -0,0 +1,55 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AwsPolicyTemplateSpec defines the desired state of AwsPolicyTemplate
+type AwsPolicyTemplateSpec struct {
+	// Important: Run "make" to regenerate code after modifying this file
+
+	// Document is the policy document rendered for each AwsAccount referencing the template.
+	// Both Go template actions such as {{ .UserName }} and ${userName} placeholders are replaced;
+	// the available variables are userName, namespace, accountId, hostedZoneId and labels.<key>.
+	// Placeholders that are not Kuadra variables, such as ${aws:username}, are left for IAM.
+	Document string `json:"document"`
+}
+
+//+kubebuilder:object:root=true
+
+// AwsPolicyTemplate is the Schema for the awspolicytemplates API
+type AwsPolicyTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec AwsPolicyTemplateSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// AwsPolicyTemplateList contains a list of AwsPolicyTemplate
+type AwsPolicyTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AwsPolicyTemplate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&AwsPolicyTemplate{}, &AwsPolicyTemplateList{})
+}
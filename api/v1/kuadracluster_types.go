@@ -0,0 +1,99 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// KuadraClusterName is the name of the single KuadraCluster, created by the controller
+const KuadraClusterName = "kuadra"
+
+// KuadraClusterSpec defines the desired state of KuadraCluster
+type KuadraClusterSpec struct {
+	// Important: Run "make" to regenerate code after modifying this file
+}
+
+// OrphanedUser is an IAM user tagged as managed by this cluster that no AwsAccount manages
+type OrphanedUser struct {
+	UserName string `json:"userName"`
+
+	// AwsAccount is the namespace/name of the AwsAccount the user was created for, read from its tags
+	// +optional
+	AwsAccount string `json:"awsAccount,omitempty"`
+
+	// FirstSeen is when the user was first found without an AwsAccount. The orphan policy
+	// is applied once the grace period has passed since then.
+	FirstSeen metav1.Time `json:"firstSeen"`
+
+	// Suspended is set once the user's login profile was deleted and its access keys deactivated
+	// +optional
+	Suspended bool `json:"suspended,omitempty"`
+}
+
+// KuadraClusterStatus defines the observed state of KuadraCluster
+type KuadraClusterStatus struct {
+	// Important: Run "make" to regenerate code after modifying this file
+
+	// ClusterId is the identity of this cluster, tagged onto the IAM users it manages
+	// +optional
+	ClusterId string `json:"clusterId,omitempty"`
+
+	// OrphanedUsers are the IAM users of this cluster without an AwsAccount
+	// +optional
+	OrphanedUsers []OrphanedUser `json:"orphanedUsers,omitempty"`
+
+	// OrphanCount is the number of OrphanedUsers
+	OrphanCount int `json:"orphanCount"`
+
+	// LastCollectionTime is when IAM was last searched for orphaned users
+	// +optional
+	LastCollectionTime *metav1.Time `json:"lastCollectionTime,omitempty"`
+
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Cluster ID",type=string,JSONPath=".status.clusterId"
+//+kubebuilder:printcolumn:name="Orphans",type=integer,JSONPath=".status.orphanCount"
+//+kubebuilder:printcolumn:name="Last Collection",type=date,JSONPath=".status.lastCollectionTime"
+
+// KuadraCluster reports the state of Kuadra in the cluster, such as the IAM users left
+// behind by deleted AwsAccounts
+type KuadraCluster struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   KuadraClusterSpec   `json:"spec,omitempty"`
+	Status KuadraClusterStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// KuadraClusterList contains a list of KuadraCluster
+type KuadraClusterList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []KuadraCluster `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&KuadraCluster{}, &KuadraClusterList{})
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LdapSourceSpec defines the desired state of LdapSource
+type LdapSourceSpec struct {
+	// URL of the directory server, ldap://host[:port] or ldaps://host[:port]
+	// +kubebuilder:validation:Pattern=`^ldaps?://[^/]+/?$`
+	URL string `json:"url"`
+
+	// StartTLS upgrades the connection to an ldap:// URL to TLS before binding. The password
+	// of the bind Secret is only sent over ldaps:// or with StartTLS.
+	// +optional
+	StartTLS bool `json:"startTLS,omitempty"`
+
+	// BindSecret is the name of a Secret in the namespace of the LdapSource holding the
+	// bindDN and password keys to bind with, and optionally ca.crt to verify the server with
+	// over ldaps:// or StartTLS. The search binds anonymously without it.
+	// +optional
+	BindSecret string `json:"bindSecret,omitempty"`
+
+	// BaseDN is the entry under which users are searched, for example ou=people,dc=example,dc=org
+	BaseDN string `json:"baseDN"`
+
+	// UserFilter selects the user entries under the base DN
+	// +optional
+	// +kubebuilder:default="(objectClass=person)"
+	UserFilter string `json:"userFilter,omitempty"`
+
+	// UserNameAttribute holds the name of the user, lower-cased into the name of its User
+	// and IAM user
+	// +optional
+	// +kubebuilder:default=uid
+	UserNameAttribute string `json:"userNameAttribute,omitempty"`
+
+	// EmailAttribute holds the email address of the user
+	// +optional
+	// +kubebuilder:default=mail
+	EmailAttribute string `json:"emailAttribute,omitempty"`
+
+	// GroupAttribute lists the DNs of the groups the user is a member of
+	// +optional
+	// +kubebuilder:default=memberOf
+	GroupAttribute string `json:"groupAttribute,omitempty"`
+
+	// GroupMappings add the members of directory groups to IAM groups and AwsGroups
+	// +optional
+	GroupMappings []LdapGroupMapping `json:"groupMappings,omitempty"`
+
+	// SyncInterval is the time between two searches of the directory
+	// +optional
+	// +kubebuilder:default="10m"
+	SyncInterval metav1.Duration `json:"syncInterval,omitempty"`
+
+	// MaxPrunePercent is the largest share of its Users a sync deletes because their entries
+	// are gone. A sync that would delete more, or all of them, deletes none and reports
+	// PruneRefused, so that a directory returning too few entries does not remove IAM users.
+	// +optional
+	// +kubebuilder:default=20
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	MaxPrunePercent int `json:"maxPrunePercent,omitempty"`
+
+	// AllowMassPrune lets syncs delete the Users of gone entries beyond maxPrunePercent, or
+	// all of them. Set it to confirm a large removal and clear it afterwards.
+	// +optional
+	AllowMassPrune bool `json:"allowMassPrune,omitempty"`
+}
+
+// LdapGroupMapping maps a directory group to the groups its members get
+type LdapGroupMapping struct {
+	// GroupDN is the DN of the directory group, compared case-insensitively with the values
+	// of the group attribute of users
+	GroupDN string `json:"groupDN"`
+
+	// Groups are names of existing IAM groups the members are added to
+	// +optional
+	Groups []string `json:"groups,omitempty"`
+
+	// GroupRefs are names of AwsGroups in the namespace of the LdapSource the members are
+	// added to
+	// +optional
+	GroupRefs []string `json:"groupRefs,omitempty"`
+}
+
+// LdapSourceStatus defines the observed state of LdapSource
+type LdapSourceStatus struct {
+	// LastSyncTime is when the directory was last searched, successfully or not
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+
+	// LastSuccessfulSyncTime is when the Users were last brought in line with the directory
+	// +optional
+	LastSuccessfulSyncTime *metav1.Time `json:"lastSuccessfulSyncTime,omitempty"`
+
+	// Entries is the number of entries the last successful search returned
+	// +optional
+	Entries int `json:"entries"`
+
+	// Users is the number of Users generated from those entries
+	// +optional
+	Users int `json:"users"`
+
+	// Applied is the number of Users the last sync created or updated
+	// +optional
+	Applied int `json:"applied"`
+
+	// Deleted is the number of Users the last sync deleted because their entry was gone
+	// +optional
+	Deleted int `json:"deleted"`
+
+	// PruneRefused is the number of Users the last sync kept although their entry was gone,
+	// because deleting them would have exceeded maxPrunePercent
+	// +optional
+	PruneRefused int `json:"pruneRefused,omitempty"`
+
+	// Errors explain the entries the last sync could not turn into Users
+	// +optional
+	Errors []string `json:"errors,omitempty"`
+
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Users",type=integer,JSONPath=".status.users"
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+//+kubebuilder:printcolumn:name="Last Sync",type=date,JSONPath=".status.lastSyncTime"
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=".metadata.creationTimestamp"
+
+// LdapSource is the Schema for the ldapsources API. It periodically searches a directory
+// and creates, updates and deletes a User for each user entry found.
+type LdapSource struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   LdapSourceSpec   `json:"spec,omitempty"`
+	Status LdapSourceStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// LdapSourceList contains a list of LdapSource
+type LdapSourceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []LdapSource `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&LdapSource{}, &LdapSourceList{})
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// TeamLabel on a User or AwsAccount names the Team it was created for
+	TeamLabel = "kuadra.kuadrant.io/team"
+	// EmailAnnotation on a User or AwsAccount holds the email address of the team member
+	EmailAnnotation = "kuadra.kuadrant.io/email"
+)
+
+// TeamSpec defines the desired state of Team
+type TeamSpec struct {
+	// Members of the team, each given a User
+	// +optional
+	Members []TeamMember `json:"members,omitempty"`
+
+	// Defaults apply to every member that does not override them
+	// +optional
+	Defaults TeamDefaults `json:"defaults,omitempty"`
+
+	// DeletionPolicy is what happens to the User of a member removed from the team, or of
+	// every member when the Team is deleted. Delete removes the User and with it the IAM
+	// user, Orphan keeps the User without the Team.
+	// +optional
+	// +kubebuilder:default=Delete
+	DeletionPolicy TeamDeletionPolicy `json:"deletionPolicy,omitempty"`
+}
+
+// TeamMember is a person in the team. Fields left unset take the team defaults.
+type TeamMember struct {
+	// Name of the member, available to the namespace template as ${member}
+	Name string `json:"name"`
+
+	// Email of the member, set as the kuadra.kuadrant.io/email annotation of their User
+	// +optional
+	Email string `json:"email,omitempty"`
+
+	// Groups replace the default groups of the team
+	// +optional
+	Groups []string `json:"groups,omitempty"`
+
+	// GroupRefs replace the default AwsGroup references of the team
+	// +optional
+	GroupRefs []string `json:"groupRefs,omitempty"`
+
+	// ManagedPolicyArns replace the default managed policies of the team
+	// +optional
+	ManagedPolicyArns []string `json:"managedPolicyArns,omitempty"`
+
+	// InlinePolicies replace the default inline policies of the team
+	// +optional
+	InlinePolicies map[string]InlinePolicy `json:"inlinePolicies,omitempty"`
+
+	// HostedZone replaces the hosted zone of the team
+	// +optional
+	HostedZone *HostedZoneSpec `json:"hostedZone,omitempty"`
+}
+
+// TeamDefaults are the settings shared by the members of a team
+type TeamDefaults struct {
+	// Groups are names of existing IAM groups every member is added to
+	// +optional
+	Groups []string `json:"groups,omitempty"`
+
+	// GroupRefs are names of AwsGroups in the team's namespace every member is added to
+	// +optional
+	GroupRefs []string `json:"groupRefs,omitempty"`
+
+	// ManagedPolicyArns are managed policies attached to every member
+	// +optional
+	ManagedPolicyArns []string `json:"managedPolicyArns,omitempty"`
+
+	// InlinePolicies are the inline policies of every member, keyed by policy name
+	// +optional
+	InlinePolicies map[string]InlinePolicy `json:"inlinePolicies,omitempty"`
+
+	// NamespaceTemplate names the IAM user of a member, which is also the name of its User
+	// and namespace. ${team} and ${member} are replaced by the names of the Team and member.
+	// Defaults to "${member}".
+	// +optional
+	NamespaceTemplate string `json:"namespaceTemplate,omitempty"`
+
+	// HostedZone is the parent Route53 hosted zone of the team. Every member is assigned the
+	// subdomain named after their user in it, e.g. alice.dns.example.com for dns.example.com.
+	// +optional
+	HostedZone *HostedZoneSpec `json:"hostedZone,omitempty"`
+}
+
+// TeamDeletionPolicy decides what happens to the User of a departed member
+// +kubebuilder:validation:Enum=Delete;Orphan
+type TeamDeletionPolicy string
+
+const (
+	TeamDeletionPolicyDelete TeamDeletionPolicy = "Delete"
+	TeamDeletionPolicyOrphan TeamDeletionPolicy = "Orphan"
+)
+
+// TeamStatus defines the observed state of Team
+type TeamStatus struct {
+	// Ready counts the members whose AwsAccount is Ready, for example 7/8
+	// +optional
+	Ready string `json:"ready,omitempty"`
+
+	// ReadyMembers is the number of members whose AwsAccount is Ready
+	// +optional
+	ReadyMembers int `json:"readyMembers"`
+
+	// TotalMembers is the number of members in the spec
+	// +optional
+	TotalMembers int `json:"totalMembers"`
+
+	// Members reports the User and phase of each member
+	// +optional
+	Members []TeamMemberStatus `json:"members,omitempty"`
+
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// TeamMemberStatus is the state of the User of a team member
+type TeamMemberStatus struct {
+	Name string `json:"name"`
+
+	// UserName is the name of the member's User and IAM user
+	// +optional
+	UserName string `json:"userName,omitempty"`
+
+	// Phase of the member's AwsAccount, Pending until it has been reconciled
+	// +optional
+	Phase AwsAccountPhase `json:"phase,omitempty"`
+
+	// Message explains why the member has no User
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=".status.ready"
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=".metadata.creationTimestamp"
+
+// Team is the Schema for the teams API. It creates and owns a User for each member.
+type Team struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TeamSpec   `json:"spec,omitempty"`
+	Status TeamStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// TeamList contains a list of Team
+type TeamList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Team `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Team{}, &TeamList{})
+}
@@ -99,7 +99,10 @@ var _ = BeforeSuite(func() {
 	})
 	Expect(err).NotTo(HaveOccurred())
 
-	err = (&AwsAccount{}).SetupWebhookWithManager(mgr)
+	err = (&AwsAccount{}).SetupWebhookWithManager(mgr, &AwsAccountValidator{})
+	Expect(err).NotTo(HaveOccurred())
+
+	err = (&AwsGroup{}).SetupWebhookWithManager(mgr, &AwsGroupValidator{Reader: mgr.GetClient()})
 	Expect(err).NotTo(HaveOccurred())
 
 	//+kubebuilder:scaffold:webhook
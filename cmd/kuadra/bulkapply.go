@@ -0,0 +1,446 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+	"github.com/Kuadrant/kuadra/internal/controller"
+)
+
+const bulkApplyDescription = "Create or update Users from a CSV or YAML list, showing the changes first."
+
+// bulkApplyFieldManager owns the fields of the Users written by bulk-apply
+const bulkApplyFieldManager = "kuadra-bulk-apply"
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(kuadrav1.AddToScheme(scheme))
+}
+
+// bulkApplyOptions select the list, the cluster and how the Users are applied
+type bulkApplyOptions struct {
+	filename       string
+	format         string
+	kubeconfig     string
+	context        string
+	namespace      string
+	dryRun         bool
+	forceConflicts bool
+
+	client client.Client
+}
+
+// bulkList is the YAML form of the list: the user list of a ConfigMap with a team per entry
+type bulkList struct {
+	Users []bulkEntry `json:"users"`
+}
+
+type bulkEntry struct {
+	controller.UserConfigEntry `json:",inline"`
+	Team                       string `json:"team,omitempty"`
+}
+
+// bulkRow is a User to apply, with the reasons it cannot be
+type bulkRow struct {
+	entry    controller.UserConfigEntry
+	team     string
+	problems []string
+}
+
+// bulkResult is the outcome of applying a row
+type bulkResult struct {
+	row     int
+	name    string
+	result  string
+	message string
+	failed  bool
+}
+
+func runBulkApply(ctx context.Context, args []string, out io.Writer, report io.Writer) error {
+	o := bulkApplyOptions{}
+	fs := flag.NewFlagSet("kuadra bulk-apply", flag.ContinueOnError)
+	fs.SetOutput(report)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "%s\n\nUsage: kuadra bulk-apply [flags] -f users.csv\n\n", bulkApplyDescription)
+		fmt.Fprintln(fs.Output(), "A CSV list has a header row with the columns username, email, groups and team;")
+		fmt.Fprintln(fs.Output(), "groups are separated by semicolons. A YAML list has the schema of a user ConfigMap")
+		fmt.Fprint(fs.Output(), "with an optional team per entry.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&o.filename, "f", "", "The CSV or YAML list of users, - for standard input.")
+	fs.StringVar(&o.format, "format", "", "Format of the list, csv or yaml. Taken from the file extension by default.")
+	fs.StringVar(&o.kubeconfig, "kubeconfig", "", "Path to the kubeconfig file, $KUBECONFIG or ~/.kube/config by default.")
+	fs.StringVar(&o.context, "context", "", "The kubeconfig context to use, the current context by default.")
+	fs.StringVar(&o.namespace, "namespace", "", "Namespace of the Users, the namespace of the context by default.")
+	fs.BoolVar(&o.dryRun, "dry-run", false, "Show the changes and validate them with the API server without making them.")
+	fs.BoolVar(&o.forceConflicts, "force-conflicts", false,
+		"Take over fields of existing Users that were last set by someone else, instead of failing the row.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if o.filename == "" {
+		return errors.New("the list of users is required, pass it with -f")
+	}
+	if o.format == "" {
+		o.format = formatFromFilename(o.filename)
+	}
+	if o.format != "csv" && o.format != "yaml" {
+		return fmt.Errorf("unsupported format %q, expected csv or yaml", o.format)
+	}
+
+	var in io.Reader = os.Stdin
+	if o.filename != "-" {
+		file, err := os.Open(o.filename)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+	rows, err := readBulkRows(in, o.format)
+	if err != nil {
+		return err
+	}
+	validateBulkRows(rows)
+
+	if err := o.complete(); err != nil {
+		return err
+	}
+	results := bulkApply(ctx, o.client, rows, o, out)
+	return writeBulkResults(out, results)
+}
+
+// complete builds the Kubernetes client from the kubeconfig flags
+func (o *bulkApplyOptions) complete() error {
+	if o.client != nil {
+		return nil
+	}
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = o.kubeconfig
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{CurrentContext: o.context})
+	if o.namespace == "" {
+		namespace, _, err := clientConfig.Namespace()
+		if err != nil {
+			return err
+		}
+		o.namespace = namespace
+	}
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return err
+	}
+	o.client, err = client.New(config, client.Options{Scheme: scheme})
+	return err
+}
+
+func formatFromFilename(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return "csv"
+	case ".yaml", ".yml", ".json":
+		return "yaml"
+	}
+	return ""
+}
+
+// readBulkRows parses the list. Errors in the shape of the list fail it as a whole; the rows
+// themselves are validated by validateBulkRows.
+func readBulkRows(in io.Reader, format string) ([]bulkRow, error) {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	if format == "csv" {
+		return readBulkCsv(data)
+	}
+
+	list := bulkList{}
+	if err := yaml.UnmarshalStrict(data, &list); err != nil {
+		return nil, fmt.Errorf("unable to parse the list of users: %w", err)
+	}
+	var rows []bulkRow
+	for _, entry := range list.Users {
+		if entry.UserName == "" {
+			entry.UserName = entry.Name
+		}
+		rows = append(rows, bulkRow{entry: entry.UserConfigEntry, team: entry.Team})
+	}
+	return rows, nil
+}
+
+func readBulkCsv(data []byte) ([]bulkRow, error) {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse the list of users: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	columns := map[string]int{}
+	for i, column := range records[0] {
+		column = strings.ToLower(strings.TrimSpace(column))
+		switch column {
+		case "username", "email", "groups", "team":
+			columns[column] = i
+		default:
+			return nil, fmt.Errorf("unknown column %q, expected username, email, groups and team", column)
+		}
+	}
+	if _, found := columns["username"]; !found {
+		return nil, errors.New("the username column is required")
+	}
+	value := func(record []string, column string) string {
+		if i, found := columns[column]; found {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var rows []bulkRow
+	for _, record := range records[1:] {
+		userName := value(record, "username")
+		row := bulkRow{
+			entry: controller.UserConfigEntry{
+				Name:           userName,
+				Email:          value(record, "email"),
+				AwsAccountSpec: kuadrav1.AwsAccountSpec{UserName: userName},
+			},
+			team: value(record, "team"),
+		}
+		for _, group := range strings.Split(value(record, "groups"), ";") {
+			if group = strings.TrimSpace(group); group != "" {
+				row.entry.Groups = append(row.entry.Groups, group)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// validateBulkRows applies the rules of the webhook and the User controllers to every row,
+// and rejects the second row for a name
+func validateBulkRows(rows []bulkRow) {
+	seen := map[string]bool{}
+	for i := range rows {
+		row := &rows[i]
+		// Rows are reported by number, so the fields are named without the path of a list
+		for _, err := range controller.ValidateUserConfigEntry(nil, row.entry) {
+			row.problems = append(row.problems, err.Error())
+		}
+		for _, msg := range validation.IsValidLabelValue(row.team) {
+			row.problems = append(row.problems, fmt.Sprintf("team: %s", msg))
+		}
+		if row.entry.Name != "" && seen[row.entry.Name] {
+			row.problems = append(row.problems, fmt.Sprintf("%s is already in an earlier row", row.entry.Name))
+		}
+		seen[row.entry.Name] = true
+	}
+}
+
+// bulkApply writes the changes each valid row makes to out and applies them
+func bulkApply(ctx context.Context, c client.Client, rows []bulkRow, o bulkApplyOptions, out io.Writer) []bulkResult {
+	var results []bulkResult
+	for i, row := range rows {
+		result := bulkResult{row: i + 1, name: row.entry.Name}
+		if len(row.problems) > 0 {
+			result.result, result.message, result.failed = "invalid", strings.Join(row.problems, "; "), true
+			results = append(results, result)
+			continue
+		}
+
+		existing := &kuadrav1.User{}
+		err := c.Get(ctx, types.NamespacedName{Name: row.entry.Name, Namespace: o.namespace}, existing)
+		if client.IgnoreNotFound(err) != nil {
+			result.result, result.message, result.failed = "failed", err.Error(), true
+			results = append(results, result)
+			continue
+		}
+		if apierrors.IsNotFound(err) {
+			existing = nil
+		}
+
+		changes := diffUser(existing, row)
+		switch {
+		case existing == nil:
+			fmt.Fprintf(out, "+ %s\n", row.entry.Name)
+			result.result = "created"
+		case len(changes) > 0:
+			fmt.Fprintf(out, "~ %s\n", row.entry.Name)
+			result.result = "configured"
+		default:
+			result.result = "unchanged"
+		}
+		for _, change := range changes {
+			fmt.Fprintf(out, "    %s\n", change)
+		}
+
+		if err := applyUser(ctx, c, row, o); err != nil {
+			result.result, result.message, result.failed = "failed", err.Error(), true
+			if apierrors.IsConflict(err) {
+				result.message += " (use --force-conflicts to take over the fields)"
+			}
+		}
+		if o.dryRun && !result.failed {
+			result.result += " (dry run)"
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// userManifest is the User as applied, holding only the fields bulk-apply owns
+func userManifest(row bulkRow, namespace string) (*unstructured.Unstructured, error) {
+	spec := row.entry.AwsAccountSpec
+	if spec.Groups == nil {
+		spec.Groups = []string{}
+	}
+	m := manifest{
+		TypeMeta: metav1.TypeMeta{APIVersion: kuadrav1.GroupVersion.String(), Kind: "User"},
+		Metadata: manifestMetadata{Name: row.entry.Name, Namespace: namespace},
+		Spec:     kuadrav1.UserSpec{AwsAccount: &kuadrav1.AwsAccountNestedSpec{Spec: kuadrav1.AwsSpec{User: spec}}},
+	}
+	if row.team != "" {
+		m.Metadata.Labels = map[string]string{kuadrav1.TeamLabel: row.team}
+	}
+	if row.entry.Email != "" {
+		m.Metadata.Annotations = map[string]string{kuadrav1.EmailAnnotation: row.entry.Email}
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	user := &unstructured.Unstructured{}
+	return user, user.UnmarshalJSON(data)
+}
+
+func applyUser(ctx context.Context, c client.Client, row bulkRow, o bulkApplyOptions) error {
+	user, err := userManifest(row, o.namespace)
+	if err != nil {
+		return err
+	}
+	opts := []client.PatchOption{client.FieldOwner(bulkApplyFieldManager)}
+	if o.forceConflicts {
+		opts = append(opts, client.ForceOwnership)
+	}
+	if o.dryRun {
+		opts = append(opts, client.DryRunAll)
+	}
+	return c.Patch(ctx, user, client.Apply, opts...)
+}
+
+// diffUser lists the fields the row changes on an existing User, or all it sets on a new one.
+// Fields the row does not set are left as they are by the apply and not compared.
+func diffUser(existing *kuadrav1.User, row bulkRow) []string {
+	var changes []string
+	compare := func(name string, before interface{}, after interface{}) {
+		if reflect.DeepEqual(before, after) {
+			return
+		}
+		if existing == nil {
+			changes = append(changes, fmt.Sprintf("%s: %s", name, compactJson(after)))
+		} else {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", name, compactJson(before), compactJson(after)))
+		}
+	}
+
+	var before kuadrav1.User
+	if existing != nil {
+		before = *existing
+	}
+	compare("email", nilIfEmpty(before.Annotations[kuadrav1.EmailAnnotation]), nilIfEmpty(row.entry.Email))
+	compare("team", nilIfEmpty(before.Labels[kuadrav1.TeamLabel]), nilIfEmpty(row.team))
+
+	var beforeSpec kuadrav1.AwsAccountSpec
+	if before.Spec.AwsAccount != nil {
+		beforeSpec = before.Spec.AwsAccount.Spec.User
+	}
+	beforeFields, afterFields := jsonFields(beforeSpec), jsonFields(row.entry.AwsAccountSpec)
+	names := make([]string, 0, len(afterFields))
+	for name := range afterFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		compare(name, beforeFields[name], afterFields[name])
+	}
+	return changes
+}
+
+// jsonFields are the fields of a spec as they are serialized, leaving out empty ones
+func jsonFields(spec kuadrav1.AwsAccountSpec) map[string]interface{} {
+	fields := map[string]interface{}{}
+	data, _ := json.Marshal(spec)
+	_ = json.Unmarshal(data, &fields)
+	for name, value := range fields {
+		if value == nil || reflect.DeepEqual(value, []interface{}{}) {
+			delete(fields, name)
+		}
+	}
+	return fields
+}
+
+func nilIfEmpty(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+func compactJson(value interface{}) string {
+	if value == nil {
+		return "<none>"
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// writeBulkResults prints a line per row and fails if any row did
+func writeBulkResults(out io.Writer, results []bulkResult) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ROW\tUSER\tRESULT\tMESSAGE")
+	failed := 0
+	for _, result := range results {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", result.row, result.name, result.result, result.message)
+		if result.failed {
+			failed++
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d rows failed", failed, len(results))
+	}
+	return nil
+}
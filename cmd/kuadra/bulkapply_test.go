@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+)
+
+// applyClient creates the objects of apply patches that do not exist yet, as the API server
+// does and the fake client does not
+type applyClient struct {
+	client.Client
+}
+
+func (c applyClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	err := c.Client.Patch(ctx, obj, patch, opts...)
+	if patch == client.Apply && apierrors.IsNotFound(err) {
+		return c.Client.Create(ctx, obj)
+	}
+	return err
+}
+
+var _ = Describe("kuadra bulk-apply", func() {
+	ctx := context.Background()
+
+	var c client.Client
+
+	BeforeEach(func() {
+		c = applyClient{fake.NewClientBuilder().WithScheme(scheme).WithObjects(&kuadrav1.User{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "bob",
+				Namespace:   "default",
+				Annotations: map[string]string{kuadrav1.EmailAnnotation: "bob@old.example.com"},
+			},
+			Spec: kuadrav1.UserSpec{AwsAccount: &kuadrav1.AwsAccountNestedSpec{Spec: kuadrav1.AwsSpec{User: kuadrav1.AwsAccountSpec{
+				UserName:          "bob",
+				Groups:            []string{"dns-management"},
+				ManagedPolicyArns: []string{"arn:aws:iam::aws:policy/AmazonRoute53ReadOnlyAccess"},
+			}}}},
+		}).Build()}
+	})
+
+	It("Should apply the valid rows of a CSV list and report the others", func() {
+		rows, err := readBulkRows(strings.NewReader(`username,email,groups,team
+alice,alice@example.com,dns-management;dns-admin,dns
+bob,bob@example.com,dns-management,
+Carol,,,
+alice,,,
+`), "csv")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(rows).Should(HaveLen(4))
+		validateBulkRows(rows)
+
+		var out bytes.Buffer
+		results := bulkApply(ctx, c, rows, bulkApplyOptions{namespace: "default"}, &out)
+		Expect(out.String()).Should(ContainSubstring("+ alice\n"))
+		Expect(out.String()).Should(ContainSubstring(`groups: ["dns-management","dns-admin"]`))
+		Expect(out.String()).Should(ContainSubstring("~ bob\n    email: \"bob@old.example.com\" -> \"bob@example.com\"\n"))
+		Expect(out.String()).ShouldNot(ContainSubstring("managedPolicyArns"))
+
+		out.Reset()
+		err = writeBulkResults(&out, results)
+		Expect(err).Should(MatchError("2 of 4 rows failed"))
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		Expect(lines).Should(HaveLen(5))
+		Expect(lines[1]).Should(MatchRegexp(`^1\s+alice\s+created\s*$`))
+		Expect(lines[2]).Should(MatchRegexp(`^2\s+bob\s+configured\s*$`))
+		Expect(lines[3]).Should(MatchRegexp(`^3\s+Carol\s+invalid\s+name: Invalid value: "Carol"`))
+		Expect(lines[4]).Should(MatchRegexp(`^4\s+alice\s+invalid\s+alice is already in an earlier row`))
+
+		alice := &kuadrav1.User{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "alice", Namespace: "default"}, alice)).Should(Succeed())
+		Expect(alice.Labels).Should(HaveKeyWithValue(kuadrav1.TeamLabel, "dns"))
+		Expect(alice.Annotations).Should(HaveKeyWithValue(kuadrav1.EmailAnnotation, "alice@example.com"))
+		Expect(alice.Spec.AwsAccount.Spec.User.Groups).Should(Equal([]string{"dns-management", "dns-admin"}))
+
+		bob := &kuadrav1.User{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "bob", Namespace: "default"}, bob)).Should(Succeed())
+		Expect(bob.Annotations).Should(HaveKeyWithValue(kuadrav1.EmailAnnotation, "bob@example.com"))
+
+		By("By leaving Users that already match unchanged")
+		out.Reset()
+		results = bulkApply(ctx, c, rows[:2], bulkApplyOptions{namespace: "default"}, &out)
+		Expect(out.String()).Should(BeEmpty())
+		Expect(results[0].result).Should(Equal("unchanged"))
+		Expect(results[1].result).Should(Equal("unchanged"))
+	})
+
+	It("Should read a YAML list and make no changes in a dry run", func() {
+		rows, err := readBulkRows(strings.NewReader(`
+users:
+  - name: dave
+    email: dave@example.com
+    team: dns
+    groupRefs:
+      - dns-management
+`), "yaml")
+		Expect(err).ShouldNot(HaveOccurred())
+		validateBulkRows(rows)
+		Expect(rows[0].problems).Should(BeEmpty())
+		Expect(rows[0].entry.UserName).Should(Equal("dave"))
+
+		var out bytes.Buffer
+		results := bulkApply(ctx, c, rows, bulkApplyOptions{namespace: "default", dryRun: true}, &out)
+		Expect(out.String()).Should(ContainSubstring(`groupRefs: ["dns-management"]`))
+		Expect(results[0].result).Should(Equal("created (dry run)"))
+		err = c.Get(ctx, types.NamespacedName{Name: "dave", Namespace: "default"}, &kuadrav1.User{})
+		Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+	})
+
+	It("Should reject lists it cannot read", func() {
+		_, err := readBulkRows(strings.NewReader("users:\n  - name: dave\n    group: dns\n"), "yaml")
+		Expect(err).Should(MatchError(ContainSubstring(`unknown field "group"`)))
+		_, err = readBulkRows(strings.NewReader("user,email\ndave,dave@example.com\n"), "csv")
+		Expect(err).Should(MatchError(`unknown column "user", expected username, email, groups and team`))
+	})
+})
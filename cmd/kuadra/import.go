@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+	"github.com/Kuadrant/kuadra/internal/controller"
+	"github.com/Kuadrant/kuadra/pkg/aws"
+)
+
+const importDescription = "Write User or AwsAccount manifests for the existing users of an AWS account."
+
+// ImportedFromAnnotation records the ARN of the IAM user a resource was imported from
+const ImportedFromAnnotation = "kuadra.kuadrant.io/imported-from"
+
+// iamReader is the part of the IAM wrapper used to read existing users
+type iamReader interface {
+	ListUsers(ctx context.Context, pathPrefix string, maxUsers int32) ([]types.User, error)
+	ListUserTags(ctx context.Context, userName string) (map[string]string, error)
+	ListGroupsForUser(ctx context.Context, userName string) ([]types.Group, error)
+	HasLoginProfile(ctx context.Context, userName string) (bool, error)
+	ListAccessKeys(ctx context.Context, userName string) ([]types.AccessKeyMetadata, error)
+	ListAttachedUserPolicies(ctx context.Context, userName string) ([]types.AttachedPolicy, error)
+	ListUserPolicies(ctx context.Context, userName string) ([]string, error)
+	GetUserPolicy(ctx context.Context, userName string, policyName string) (string, error)
+}
+
+// importOptions select the users to import and how they are written
+type importOptions struct {
+	awsRegion  string
+	kind       string
+	namespace  string
+	pathPrefix string
+	tags       tagFilter
+	adopt      bool
+}
+
+// tagFilter matches users holding all of its tags. A tag without a value matches any value.
+type tagFilter map[string]*string
+
+func (f tagFilter) String() string {
+	var tags []string
+	for key, value := range f {
+		if value == nil {
+			tags = append(tags, key)
+		} else {
+			tags = append(tags, key+"="+*value)
+		}
+	}
+	sort.Strings(tags)
+	return strings.Join(tags, ",")
+}
+
+func (f tagFilter) Set(tag string) error {
+	key, value, found := strings.Cut(tag, "=")
+	if key == "" {
+		return fmt.Errorf("invalid tag %q, expected KEY or KEY=VALUE", tag)
+	}
+	if found {
+		f[key] = &value
+	} else {
+		f[key] = nil
+	}
+	return nil
+}
+
+func (f tagFilter) matches(tags map[string]string) bool {
+	for key, value := range f {
+		actual, found := tags[key]
+		if !found || (value != nil && actual != *value) {
+			return false
+		}
+	}
+	return true
+}
+
+// importedUser is an IAM user as it is written out
+type importedUser struct {
+	arn          string
+	spec         kuadrav1.AwsAccountSpec
+	loginProfile bool
+	accessKeys   []string
+	// problems are the reasons the user cannot be managed by Kuadra as it is
+	problems []string
+}
+
+// manifest is a User or AwsAccount without the fields left for the API server and controller
+type manifest struct {
+	metav1.TypeMeta `json:",inline"`
+	Metadata        manifestMetadata `json:"metadata"`
+	Spec            interface{}      `json:"spec"`
+}
+
+type manifestMetadata struct {
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+func runImport(ctx context.Context, args []string, out io.Writer, report io.Writer) error {
+	o := importOptions{tags: tagFilter{}}
+	fs := flag.NewFlagSet("kuadra import", flag.ContinueOnError)
+	fs.SetOutput(report)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "%s\n\nUsage: kuadra import [flags] > users.yaml\n\nFlags:\n", importDescription)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&o.awsRegion, "aws-region", os.Getenv("AWS_REGION"),
+		"The AWS region of the IAM client, which uses the default AWS credentials chain.")
+	fs.StringVar(&o.kind, "kind", "AwsAccount", "The kind of resource written for each user, AwsAccount or User.")
+	fs.StringVar(&o.namespace, "namespace", "", "Namespace of the resources written, left out by default.")
+	fs.StringVar(&o.pathPrefix, "path-prefix", "/", "Only import users whose IAM path starts with this prefix.")
+	fs.Var(o.tags, "tag", "Only import users with this tag, as KEY or KEY=VALUE. Can be repeated.")
+	fs.BoolVar(&o.adopt, "adopt", false,
+		"Annotate the AwsAccounts with the user they were imported from, and for a dry run so that the changes "+
+			"Kuadra would make to the users can be reviewed before it makes them.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if o.kind != "AwsAccount" && o.kind != "User" {
+		return fmt.Errorf("unsupported kind %q, expected AwsAccount or User", o.kind)
+	}
+	if o.adopt && o.kind != "AwsAccount" {
+		return errors.New("--adopt needs --kind AwsAccount, as a User does not pass its annotations on to its AwsAccount")
+	}
+
+	iam, err := aws.NewIamWrapper(o.awsRegion, aws.ClientOptions{})
+	if err != nil {
+		return err
+	}
+	users, err := importUsers(ctx, iam, o)
+	if err != nil {
+		return err
+	}
+	return writeImport(out, report, users, o)
+}
+
+// importUsers reads the IAM users selected by the options
+func importUsers(ctx context.Context, iam iamReader, o importOptions) ([]importedUser, error) {
+	users, err := iam.ListUsers(ctx, o.pathPrefix, math.MaxInt32)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list users: %w", err)
+	}
+	var imported []importedUser
+	for _, user := range users {
+		if !strings.HasPrefix(valueOr(user.Path, "/"), o.pathPrefix) {
+			continue
+		}
+		userName := *user.UserName
+		tags, err := iam.ListUserTags(ctx, userName)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list the tags of %s: %w", userName, err)
+		}
+		if !o.tags.matches(tags) {
+			continue
+		}
+		importedUser, err := importUser(ctx, iam, user, tags)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %w", userName, err)
+		}
+		imported = append(imported, *importedUser)
+	}
+	return imported, nil
+}
+
+func importUser(ctx context.Context, iam iamReader, user types.User, tags map[string]string) (*importedUser, error) {
+	userName := *user.UserName
+	imported := &importedUser{
+		arn:  valueOr(user.Arn, ""),
+		spec: kuadrav1.AwsAccountSpec{UserName: userName, Groups: []string{}},
+	}
+	if user.PermissionsBoundary != nil && user.PermissionsBoundary.PermissionsBoundaryArn != nil {
+		imported.spec.PermissionsBoundary = *user.PermissionsBoundary.PermissionsBoundaryArn
+	}
+
+	// The user name is also the name of the user's namespace
+	for _, msg := range validation.IsDNS1123Label(userName) {
+		imported.problems = append(imported.problems, fmt.Sprintf("the user name cannot be a namespace name: %s", msg))
+	}
+	if namespace, found := tags[controller.NamespaceTag]; found {
+		imported.problems = append(imported.problems,
+			fmt.Sprintf("already managed by AwsAccount %s/%s", namespace, tags[controller.NameTag]))
+	}
+
+	groups, err := iam.ListGroupsForUser(ctx, userName)
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		imported.spec.Groups = append(imported.spec.Groups, *group.GroupName)
+	}
+	sort.Strings(imported.spec.Groups)
+
+	attached, err := iam.ListAttachedUserPolicies(ctx, userName)
+	if err != nil {
+		return nil, err
+	}
+	for _, policy := range attached {
+		imported.spec.ManagedPolicyArns = append(imported.spec.ManagedPolicyArns, *policy.PolicyArn)
+	}
+	sort.Strings(imported.spec.ManagedPolicyArns)
+
+	policyNames, err := iam.ListUserPolicies(ctx, userName)
+	if err != nil {
+		return nil, err
+	}
+	for _, policyName := range policyNames {
+		document, err := iam.GetUserPolicy(ctx, userName, policyName)
+		if err != nil {
+			return nil, err
+		}
+		if imported.spec.InlinePolicies == nil {
+			imported.spec.InlinePolicies = map[string]kuadrav1.InlinePolicy{}
+		}
+		imported.spec.InlinePolicies[policyName] = kuadrav1.InlinePolicy{Document: document}
+	}
+
+	if imported.loginProfile, err = iam.HasLoginProfile(ctx, userName); err != nil {
+		return nil, err
+	}
+	accessKeys, err := iam.ListAccessKeys(ctx, userName)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range accessKeys {
+		imported.accessKeys = append(imported.accessKeys, *key.AccessKeyId)
+	}
+	return imported, nil
+}
+
+// writeImport writes the manifests of the users that can be imported to out, and lists the
+// others with the reason they were left out in report
+func writeImport(out io.Writer, report io.Writer, users []importedUser, o importOptions) error {
+	written, skipped := 0, 0
+	for _, user := range users {
+		if len(user.problems) > 0 {
+			for _, problem := range user.problems {
+				fmt.Fprintf(report, "skipped %s: %s\n", user.spec.UserName, problem)
+			}
+			skipped++
+			continue
+		}
+
+		m := manifest{
+			TypeMeta: metav1.TypeMeta{APIVersion: kuadrav1.GroupVersion.String(), Kind: o.kind},
+			Metadata: manifestMetadata{Name: user.spec.UserName, Namespace: o.namespace},
+			Spec:     user.spec,
+		}
+		if o.kind == "User" {
+			m.Spec = kuadrav1.UserSpec{AwsAccount: &kuadrav1.AwsAccountNestedSpec{Spec: kuadrav1.AwsSpec{User: user.spec}}}
+		}
+		if o.adopt {
+			m.Metadata.Annotations = map[string]string{
+				ImportedFromAnnotation:      user.arn,
+				controller.DryRunAnnotation: "true",
+			}
+		}
+		data, err := yaml.Marshal(m)
+		if err != nil {
+			return err
+		}
+
+		if written > 0 {
+			fmt.Fprintln(out, "---")
+		}
+		// Existing credentials are kept by Kuadra, but it has no Secrets for them
+		if user.loginProfile {
+			fmt.Fprintf(out, "# %s has a login profile, its password is kept and no login Secret is written\n", user.spec.UserName)
+		}
+		if len(user.accessKeys) > 0 {
+			fmt.Fprintf(out, "# %s has access keys %s, they are kept and no access key Secret is written\n",
+				user.spec.UserName, strings.Join(user.accessKeys, ", "))
+		}
+		if _, err := out.Write(data); err != nil {
+			return err
+		}
+		written++
+	}
+	fmt.Fprintf(report, "imported %d users, skipped %d\n", written, skipped)
+	return nil
+}
+
+func valueOr(value *string, fallback string) string {
+	if value == nil {
+		return fallback
+	}
+	return *value
+}
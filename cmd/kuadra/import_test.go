@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/yaml"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+	"github.com/Kuadrant/kuadra/internal/controller"
+	"github.com/Kuadrant/kuadra/pkg/aws"
+	"github.com/Kuadrant/kuadra/pkg/aws/iamfake"
+)
+
+var _ = Describe("kuadra import", func() {
+	ctx := context.Background()
+
+	var iam interface {
+		controller.IamWrapper
+		iamReader
+	}
+
+	BeforeEach(func() {
+		server := iamfake.NewServer()
+		iam = aws.NewIamWrapperFromConfig(server.Config(), aws.ClientOptions{
+			RequestsPerSecond: 1000,
+			Burst:             1000,
+			MaxBackoff:        time.Millisecond,
+		})
+
+		Expect(iam.CreateUserIfNotExists(ctx, "ib-dns", aws.UserOptions{Path: "/teams/dns/", Tags: map[string]string{"team": "dns"}})).Should(Succeed())
+		Expect(iam.CreateGroupIfNotExists(ctx, "dns-management", "/")).Should(Succeed())
+		_, err := iam.AddUserToGroup(ctx, "dns-management", "ib-dns")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(iam.PutUserPolicy(ctx, "ib-dns", "read-zones", `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"route53:ListHostedZones","Resource":"*"}]}`)).Should(Succeed())
+		Expect(iam.CreateLoginProfileIfNotExists(ctx, "password", "ib-dns", false)).Should(Succeed())
+		_, err = iam.CreateAccessKeyPair(ctx, "ib-dns")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(iam.CreateUserIfNotExists(ctx, "Alice.Smith", aws.UserOptions{Path: "/teams/dns/", Tags: map[string]string{"team": "dns"}})).Should(Succeed())
+		Expect(iam.CreateUserIfNotExists(ctx, "ef-dns", aws.UserOptions{Path: "/teams/dns/", Tags: map[string]string{
+			"team":                  "dns",
+			controller.NamespaceTag: "default",
+			controller.NameTag:      "ef-dns",
+		}})).Should(Succeed())
+		Expect(iam.CreateUserIfNotExists(ctx, "billing", aws.UserOptions{Path: "/teams/billing/"})).Should(Succeed())
+	})
+
+	It("Should write AwsAccounts for the users that can be imported", func() {
+		users, err := importUsers(ctx, iam, importOptions{pathPrefix: "/teams/", tags: tagFilter{"team": nil}, kind: "AwsAccount", adopt: true})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(users).Should(HaveLen(3))
+
+		var out, report bytes.Buffer
+		Expect(writeImport(&out, &report, users, importOptions{kind: "AwsAccount", namespace: "default", adopt: true})).Should(Succeed())
+		Expect(report.String()).Should(ContainSubstring("skipped Alice.Smith: the user name cannot be a namespace name"))
+		Expect(report.String()).Should(ContainSubstring("skipped ef-dns: already managed by AwsAccount default/ef-dns"))
+		Expect(report.String()).Should(ContainSubstring("imported 1 users, skipped 2"))
+		Expect(out.String()).Should(ContainSubstring("# ib-dns has a login profile"))
+
+		var awsAccount kuadrav1.AwsAccount
+		Expect(yaml.Unmarshal(out.Bytes(), &awsAccount)).Should(Succeed())
+		Expect(awsAccount.Kind).Should(Equal("AwsAccount"))
+		Expect(awsAccount.Namespace).Should(Equal("default"))
+		Expect(awsAccount.Annotations).Should(HaveKeyWithValue(controller.DryRunAnnotation, "true"))
+		Expect(awsAccount.Annotations).Should(HaveKeyWithValue(ImportedFromAnnotation, "arn:aws:iam::"+iamfake.AccountId+":user/teams/dns/ib-dns"))
+		Expect(awsAccount.Spec.UserName).Should(Equal("ib-dns"))
+		Expect(awsAccount.Spec.Groups).Should(Equal([]string{"dns-management"}))
+		Expect(awsAccount.Spec.InlinePolicies).Should(HaveKey("read-zones"))
+		Expect(awsAccount.Spec.InlinePolicies["read-zones"].Document).Should(ContainSubstring("route53:ListHostedZones"))
+	})
+
+	It("Should filter users by path and tag", func() {
+		users, err := importUsers(ctx, iam, importOptions{pathPrefix: "/teams/billing/", tags: tagFilter{}})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(users).Should(HaveLen(1))
+		Expect(users[0].spec.UserName).Should(Equal("billing"))
+
+		dns := "dns"
+		users, err = importUsers(ctx, iam, importOptions{pathPrefix: "/", tags: tagFilter{"team": &dns}})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(users).Should(HaveLen(3))
+	})
+
+	It("Should write Users", func() {
+		users, err := importUsers(ctx, iam, importOptions{pathPrefix: "/teams/billing/", tags: tagFilter{}})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var out, report bytes.Buffer
+		Expect(writeImport(&out, &report, users, importOptions{kind: "User"})).Should(Succeed())
+		var user kuadrav1.User
+		Expect(yaml.Unmarshal(out.Bytes(), &user)).Should(Succeed())
+		Expect(user.Kind).Should(Equal("User"))
+		Expect(user.Spec.AwsAccount.Spec.User.UserName).Should(Equal("billing"))
+		Expect(strings.Count(out.String(), "kind:")).Should(Equal(1))
+	})
+
+	It("Should refuse to adopt Users", func() {
+		err := run(ctx, []string{"import", "--kind", "User", "--adopt"}, &bytes.Buffer{}, &bytes.Buffer{})
+		Expect(err).Should(MatchError(ContainSubstring("--adopt needs --kind AwsAccount")))
+	})
+})
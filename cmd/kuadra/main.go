@@ -0,0 +1,63 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// kuadra is the command line for moving existing AWS accounts and users onto Kuadra. import
+// works with IAM only and needs no cluster access; its output is applied with kubectl.
+// bulk-apply writes Users to the cluster of the current kubeconfig context.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+func main() {
+	if err := run(context.Background(), os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "error:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, out io.Writer, report io.Writer) error {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(report)
+		return flag.ErrHelp
+	}
+	switch args[0] {
+	case "import":
+		return runImport(ctx, args[1:], out, report)
+	case "bulk-apply":
+		return runBulkApply(ctx, args[1:], out, report)
+	}
+	usage(report)
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "kuadra moves existing AWS accounts and users onto Kuadra.")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	fmt.Fprintf(w, "  %-10s %s\n", "import", importDescription)
+	fmt.Fprintf(w, "  %-10s %s\n", "bulk-apply", bulkApplyDescription)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Use \"kuadra <command> -h\" for the flags of a command.")
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+	"github.com/Kuadrant/kuadra/internal/controller"
+)
+
+var credsCommand = command{
+	name:        "creds",
+	args:        "NAME",
+	description: "Write the access key of an AwsAccount into a profile of the local AWS credentials file.",
+	flags: func(fs *flag.FlagSet, o *options) {
+		fs.StringVar(&o.profile, "profile", "", "Name of the profile, the IAM user name by default.")
+		fs.StringVar(&o.credentialsFile, "credentials-file", os.Getenv("AWS_SHARED_CREDENTIALS_FILE"),
+			"The AWS credentials file, ~/.aws/credentials by default.")
+	},
+	run: runCreds,
+}
+
+func runCreds(ctx context.Context, o *options, args []string) error {
+	awsAccount, err := getAwsAccount(ctx, o, args)
+	if err != nil {
+		return err
+	}
+	credentials := awsAccount.Status.Credentials
+	if credentials == nil || credentials.SecretRef == nil {
+		credentials = &kuadrav1.CredentialsSpec{
+			SecretRef: &kuadrav1.SecretReference{Name: controller.DefaultCredentialsSecretName, Namespace: awsAccount.Spec.UserName},
+			Format:    kuadrav1.CredentialsFormatEnv,
+		}
+	}
+	secret := &corev1.Secret{}
+	if err := o.client.Get(ctx, client.ObjectKey{Namespace: credentials.SecretRef.Namespace, Name: credentials.SecretRef.Name}, secret); err != nil {
+		return fmt.Errorf("unable to read the access key: %w", err)
+	}
+	creds, err := controller.ParseCredentials(credentials.Format, secret.Data)
+	if err != nil {
+		return err
+	}
+
+	path := o.credentialsFile
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	profile := valueOr(o.profile, awsAccount.Spec.UserName)
+	values := [][2]string{
+		{"aws_access_key_id", creds.AccessKeyId},
+		{"aws_secret_access_key", creds.SecretAccessKey},
+	}
+	if err := writeProfile(path, profile, values); err != nil {
+		return err
+	}
+	fmt.Fprintf(o.out, "Wrote the access key %s of %s to profile %q in %s.\n", creds.AccessKeyId, awsAccount.Spec.UserName, profile, path)
+	if creds.Region != "" {
+		fmt.Fprintf(o.out, "Its region is %s, use it with: export AWS_PROFILE=%s AWS_REGION=%s\n", creds.Region, profile, creds.Region)
+	}
+	return nil
+}
+
+// writeProfile sets the profile in the credentials file at path, creating the file if needed
+// and leaving its other profiles untouched
+func writeProfile(path string, profile string, values [][2]string) error {
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	updated := setProfile(string(content), profile, values)
+
+	// Replace the file in one step so that a failed write cannot lose the other profiles
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".credentials-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(updated); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// setProfile replaces the section of profile in an INI formatted credentials file with
+// values, appending it when it is not there yet
+func setProfile(content string, profile string, values [][2]string) string {
+	var section strings.Builder
+	fmt.Fprintf(&section, "[%s]\n", profile)
+	for _, value := range values {
+		fmt.Fprintf(&section, "%s = %s\n", value[0], value[1])
+	}
+
+	var out strings.Builder
+	replaced := false
+	inProfile := false
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			inProfile = strings.TrimSpace(trimmed[1:len(trimmed)-1]) == profile
+			if inProfile {
+				out.WriteString(section.String())
+				replaced = true
+				continue
+			}
+		}
+		if !inProfile {
+			out.WriteString(line + "\n")
+		}
+	}
+	if !replaced {
+		if out.Len() > 0 && !strings.HasSuffix(out.String(), "\n\n") {
+			out.WriteString("\n")
+		}
+		out.WriteString(section.String())
+	}
+	return out.String()
+}
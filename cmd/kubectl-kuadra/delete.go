@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+)
+
+var deleteCommand = command{
+	name:        "delete",
+	args:        "NAME",
+	description: "Delete an AwsAccount after showing what will be removed from AWS and Kubernetes.",
+	flags: func(fs *flag.FlagSet, o *options) {
+		o.bindAwsFlags(fs)
+		fs.BoolVar(&o.yes, "yes", false, "Delete without asking for confirmation.")
+	},
+	run: runDelete,
+}
+
+func runDelete(ctx context.Context, o *options, args []string) error {
+	awsAccount, err := getAwsAccount(ctx, o, args)
+	if err != nil {
+		return err
+	}
+	userName := awsAccount.AppliedUserName()
+
+	// An AwsAccount created for a User is recreated unless the User goes with it
+	var toDelete client.Object = awsAccount
+	kind := "AwsAccount"
+	if owner := metav1.GetControllerOf(awsAccount); owner != nil && owner.Kind == "User" {
+		user := &kuadrav1.User{}
+		if err := o.client.Get(ctx, client.ObjectKey{Namespace: awsAccount.Namespace, Name: owner.Name}, user); err != nil {
+			return err
+		}
+		toDelete = user
+		kind = "User"
+		fmt.Fprintf(o.out, "AwsAccount %s/%s belongs to User %s/%s, which is deleted instead.\n", awsAccount.Namespace, awsAccount.Name, user.Namespace, user.Name)
+	}
+
+	fmt.Fprintf(o.out, "Deleting %s %s/%s removes:\n", kind, toDelete.GetNamespace(), toDelete.GetName())
+	for _, item := range removals(ctx, o, awsAccount) {
+		fmt.Fprintf(o.out, "  - %s\n", item)
+	}
+
+	if !o.yes {
+		fmt.Fprintf(o.out, "Type the user name %q to confirm: ", userName)
+		answer, _ := bufio.NewReader(o.in).ReadString('\n')
+		if strings.TrimSpace(answer) != userName {
+			return errors.New("deletion not confirmed")
+		}
+	}
+	if err := o.client.Delete(ctx, toDelete); err != nil {
+		return err
+	}
+	fmt.Fprintf(o.out, "%s %s/%s deleted, Kuadra removes the IAM user and its resources.\n", kind, toDelete.GetNamespace(), toDelete.GetName())
+	return nil
+}
+
+// removals lists what the controller removes when the AwsAccount is deleted, from IAM when
+// it can be read and from the AwsAccount status otherwise
+func removals(ctx context.Context, o *options, awsAccount *kuadrav1.AwsAccount) []string {
+	userName := awsAccount.AppliedUserName()
+	status := awsAccount.Status
+	var items []string
+
+	iam, err := o.iamClient()
+	var live *liveUser
+	if err == nil {
+		live, err = readLiveUser(ctx, iam, userName)
+	}
+	switch {
+	case err != nil:
+		items = append(items, fmt.Sprintf("IAM user %s, as last recorded in status (IAM unavailable: %v)", userName, err))
+		if status.LoginProfileCreated {
+			items = append(items, "its login profile")
+		}
+		if status.AccessKeyCreated {
+			items = append(items, "its access keys")
+		}
+		items = append(items, memberships(status.UserGroups, status.AttachedPolicyArns, status.InlinePolicyNames)...)
+	case !live.exists:
+		items = append(items, fmt.Sprintf("no IAM user, %s does not exist", userName))
+	default:
+		items = append(items, "IAM user "+valueOr(live.arn, userName))
+		if live.loginProfile {
+			items = append(items, "its login profile")
+		}
+		for _, key := range live.accessKeys {
+			items = append(items, "access key "+key)
+		}
+		items = append(items, memberships(live.groups, live.attached, live.inline)...)
+	}
+
+	if status.NamespaceCreated {
+		items = append(items, fmt.Sprintf("Namespace %s and everything in it", userName))
+	}
+	if status.PreviousUserName != "" {
+		items = append(items, fmt.Sprintf("Namespace %s of the rename in progress and everything in it", status.PreviousUserName))
+	}
+	if credentials := status.Credentials; credentials != nil {
+		for _, ref := range []*kuadrav1.SecretReference{credentials.SecretRef, credentials.LoginSecretRef, credentials.DNSProviderSecretRef} {
+			if ref != nil && (ref.Namespace != userName || !status.NamespaceCreated) {
+				items = append(items, fmt.Sprintf("Secret %s/%s", ref.Namespace, ref.Name))
+			}
+		}
+	}
+	if status.ManagedZone != "" {
+		items = append(items, fmt.Sprintf("ManagedZone %s/%s", userName, status.ManagedZone))
+	}
+	return items
+}
+
+func memberships(groups []string, attachedPolicies []string, inlinePolicies []string) []string {
+	var items []string
+	for _, group := range groups {
+		items = append(items, "membership of group "+group)
+	}
+	for _, policyArn := range attachedPolicies {
+		items = append(items, "attachment of policy "+policyArn)
+	}
+	for _, policyName := range inlinePolicies {
+		items = append(items, "inline policy "+policyName)
+	}
+	return items
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/duration"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+)
+
+var describeCommand = command{
+	name:        "describe",
+	args:        "NAME",
+	description: "Show an AwsAccount next to the live state of its IAM user.",
+	flags: func(fs *flag.FlagSet, o *options) {
+		o.bindAwsFlags(fs)
+	},
+	run: runDescribe,
+}
+
+// liveUser is the state of an IAM user as read from IAM
+type liveUser struct {
+	exists       bool
+	arn          string
+	path         string
+	boundary     string
+	loginProfile bool
+	accessKeys   []string
+	groups       []string
+	attached     []string
+	inline       []string
+	tags         []string
+}
+
+func readLiveUser(ctx context.Context, iam iamReader, userName string) (*liveUser, error) {
+	user, err := iam.GetUser(ctx, userName)
+	if err != nil || user == nil {
+		return &liveUser{}, err
+	}
+	live := &liveUser{exists: true}
+	if user.Arn != nil {
+		live.arn = *user.Arn
+	}
+	if user.Path != nil {
+		live.path = *user.Path
+	}
+	if user.PermissionsBoundary != nil && user.PermissionsBoundary.PermissionsBoundaryArn != nil {
+		live.boundary = *user.PermissionsBoundary.PermissionsBoundaryArn
+	}
+	if live.loginProfile, err = iam.HasLoginProfile(ctx, userName); err != nil {
+		return nil, err
+	}
+	accessKeys, err := iam.ListAccessKeys(ctx, userName)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, key := range accessKeys {
+		description := *key.AccessKeyId
+		if key.CreateDate != nil {
+			description += fmt.Sprintf(" (%s, %s)", key.Status, duration.HumanDuration(now.Sub(*key.CreateDate)))
+		}
+		live.accessKeys = append(live.accessKeys, description)
+	}
+	groups, err := iam.ListGroupsForUser(ctx, userName)
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		live.groups = append(live.groups, *group.GroupName)
+	}
+	attached, err := iam.ListAttachedUserPolicies(ctx, userName)
+	if err != nil {
+		return nil, err
+	}
+	for _, policy := range attached {
+		live.attached = append(live.attached, *policy.PolicyArn)
+	}
+	if live.inline, err = iam.ListUserPolicies(ctx, userName); err != nil {
+		return nil, err
+	}
+	tags, err := iam.ListUserTags(ctx, userName)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range tags {
+		live.tags = append(live.tags, key+"="+value)
+	}
+	sort.Strings(live.tags)
+	return live, nil
+}
+
+func runDescribe(ctx context.Context, o *options, args []string) error {
+	awsAccount, err := getAwsAccount(ctx, o, args)
+	if err != nil {
+		return err
+	}
+	status := awsAccount.Status
+
+	w := tabwriter.NewWriter(o.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s/%s\n", awsAccount.Namespace, awsAccount.Name)
+	fmt.Fprintf(w, "User:\t%s\n", awsAccount.Spec.UserName)
+	fmt.Fprintf(w, "Phase:\t%s\n", valueOr(string(status.Phase), string(kuadrav1.AwsAccountPhasePending)))
+	for _, condition := range status.Conditions {
+		fmt.Fprintf(w, "Condition:\t%s=%s %s %s\n", condition.Type, condition.Status, condition.Reason, condition.Message)
+	}
+	for _, drift := range status.Drift {
+		fmt.Fprintf(w, "Drift:\t%s %s (corrected: %t)\n", drift.Type, drift.Subject, drift.Corrected)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(o.out)
+
+	var live *liveUser
+	iam, err := o.iamClient()
+	if err == nil {
+		live, err = readLiveUser(ctx, iam, awsAccount.Spec.UserName)
+	}
+	if err != nil {
+		fmt.Fprintf(o.out, "IAM state unavailable: %v\n\n", err)
+	}
+
+	// Rows holding the same representation on both sides are compared
+	type row struct {
+		field, status, iam string
+		compare            bool
+	}
+	rows := []row{
+		{field: "User", status: createdOr(status.UserCreated, status.Path+awsAccount.Spec.UserName)},
+		{field: "Path", status: valueOr(status.Path, "-"), compare: true},
+		{field: "Permissions boundary", status: valueOr(status.PermissionsBoundary, "-"), compare: true},
+		{field: "Login profile", status: createdOr(status.LoginProfileCreated, "created"), compare: true},
+		{field: "Access keys", status: accessKeyStatus(status)},
+		{field: "Groups", status: joinSorted(status.UserGroups), compare: true},
+		{field: "Attached policies", status: joinSorted(status.AttachedPolicyArns), compare: true},
+		{field: "Inline policies", status: joinSorted(status.InlinePolicyNames), compare: true},
+		{field: "Tags", status: "-"},
+	}
+	if live != nil {
+		liveValues := []string{
+			createdOr(live.exists, live.arn),
+			valueOr(live.path, "-"),
+			valueOr(live.boundary, "-"),
+			createdOr(live.loginProfile, "created"),
+			joinSorted(live.accessKeys),
+			joinSorted(live.groups),
+			joinSorted(live.attached),
+			joinSorted(live.inline),
+			joinSorted(live.tags),
+		}
+		for i := range rows {
+			rows[i].iam = liveValues[i]
+		}
+	}
+
+	w = tabwriter.NewWriter(o.out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "\tFIELD\tAWSACCOUNT STATUS\tIAM")
+	differences := false
+	for _, row := range rows {
+		marker := ""
+		if live != nil && row.compare && row.status != row.iam {
+			marker = "*"
+			differences = true
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", marker, row.field, row.status, valueOr(row.iam, "?"))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if differences {
+		fmt.Fprintln(o.out, "\n* IAM differs from the last reconcile, the next resync corrects it.")
+	}
+	return nil
+}
+
+func createdOr(created bool, value string) string {
+	if !created {
+		return "missing"
+	}
+	return value
+}
+
+func accessKeyStatus(status kuadrav1.AwsAccountStatus) string {
+	if !status.AccessKeyCreated {
+		return "missing"
+	}
+	if status.AccessKeyCreationDate == nil {
+		return "created"
+	}
+	return "created " + duration.HumanDuration(time.Since(status.AccessKeyCreationDate.Time)) + " ago"
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var eventsCommand = command{
+	name:        "events",
+	args:        "NAME",
+	description: "Show the recent reconcile history of an AwsAccount: its conditions and events.",
+	run:         runEvents,
+}
+
+func runEvents(ctx context.Context, o *options, args []string) error {
+	awsAccount, err := getAwsAccount(ctx, o, args)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+
+	w := tabwriter.NewWriter(o.out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "CONDITION\tSTATUS\tREASON\tSINCE\tMESSAGE")
+	for _, condition := range awsAccount.Status.Conditions {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", condition.Type, condition.Status, condition.Reason,
+			duration.HumanDuration(now.Sub(condition.LastTransitionTime.Time)), condition.Message)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(o.out)
+
+	var events corev1.EventList
+	if err := o.client.List(ctx, &events, client.InNamespace(awsAccount.Namespace), client.MatchingFields{
+		"involvedObject.kind": "AwsAccount",
+		"involvedObject.name": awsAccount.Name,
+	}); err != nil {
+		return err
+	}
+	if len(events.Items) == 0 {
+		fmt.Fprintln(o.out, "No events found.")
+		return nil
+	}
+	sort.Slice(events.Items, func(i, j int) bool {
+		return lastSeen(events.Items[i]).Before(lastSeen(events.Items[j]))
+	})
+
+	w = tabwriter.NewWriter(o.out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "LAST SEEN\tTYPE\tREASON\tCOUNT\tMESSAGE")
+	for _, event := range events.Items {
+		count := event.Count
+		if count == 0 {
+			count = 1
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", duration.HumanDuration(now.Sub(lastSeen(event))), event.Type, event.Reason, count, event.Message)
+	}
+	return w.Flush()
+}
+
+// lastSeen returns when the event last happened, whichever API wrote it
+func lastSeen(event corev1.Event) time.Time {
+	switch {
+	case event.Series != nil && !event.Series.LastObservedTime.IsZero():
+		return event.Series.LastObservedTime.Time
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	}
+	return event.CreationTimestamp.Time
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+)
+
+var listCommand = command{
+	name:        "list",
+	description: "List the AwsAccounts with their phase, groups, access key age and user namespace.",
+	flags: func(fs *flag.FlagSet, o *options) {
+		fs.BoolVar(&o.allNamespaces, "all-namespaces", false, "List the AwsAccounts of all namespaces.")
+		fs.BoolVar(&o.allNamespaces, "A", false, "Shorthand for --all-namespaces.")
+	},
+	run: runList,
+}
+
+func runList(ctx context.Context, o *options, args []string) error {
+	var listOptions []client.ListOption
+	if !o.allNamespaces {
+		listOptions = append(listOptions, client.InNamespace(o.namespace))
+	}
+	var awsAccounts kuadrav1.AwsAccountList
+	if err := o.client.List(ctx, &awsAccounts, listOptions...); err != nil {
+		return err
+	}
+	if len(awsAccounts.Items) == 0 {
+		fmt.Fprintln(o.out, "No AwsAccounts found.")
+		return nil
+	}
+	sort.Slice(awsAccounts.Items, func(i, j int) bool {
+		a, b := awsAccounts.Items[i], awsAccounts.Items[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+
+	w := tabwriter.NewWriter(o.out, 0, 0, 3, ' ', 0)
+	if o.allNamespaces {
+		fmt.Fprint(w, "NAMESPACE\t")
+	}
+	fmt.Fprintln(w, "NAME\tUSER\tPHASE\tGROUPS\tKEY AGE\tUSER NAMESPACE")
+	now := time.Now()
+	for _, awsAccount := range awsAccounts.Items {
+		if o.allNamespaces {
+			fmt.Fprintf(w, "%s\t", awsAccount.Namespace)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			awsAccount.Name,
+			awsAccount.Spec.UserName,
+			valueOr(string(awsAccount.Status.Phase), string(kuadrav1.AwsAccountPhasePending)),
+			joinSorted(awsAccount.Status.UserGroups),
+			keyAge(awsAccount.Status.AccessKeyCreationDate, now),
+			userNamespace(awsAccount))
+	}
+	return w.Flush()
+}
+
+func keyAge(created *metav1.Time, now time.Time) string {
+	if created == nil {
+		return "-"
+	}
+	return duration.HumanDuration(now.Sub(created.Time))
+}
+
+func userNamespace(awsAccount kuadrav1.AwsAccount) string {
+	if !awsAccount.Status.NamespaceCreated {
+		return "-"
+	}
+	return awsAccount.Spec.UserName
+}
+
+func valueOr(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
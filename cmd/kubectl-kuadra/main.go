@@ -0,0 +1,238 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// kubectl-kuadra is a kubectl plugin for administering the IAM users managed by Kuadra.
+// Installed on the PATH it runs as "kubectl kuadra".
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+	"github.com/Kuadrant/kuadra/pkg/aws"
+)
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(kuadrav1.AddToScheme(scheme))
+}
+
+// iamReader is the part of the IAM wrapper used to show the live state of a user
+type iamReader interface {
+	GetUser(ctx context.Context, userName string) (*types.User, error)
+	HasLoginProfile(ctx context.Context, userName string) (bool, error)
+	ListAccessKeys(ctx context.Context, userName string) ([]types.AccessKeyMetadata, error)
+	ListGroupsForUser(ctx context.Context, userName string) ([]types.Group, error)
+	ListAttachedUserPolicies(ctx context.Context, userName string) ([]types.AttachedPolicy, error)
+	ListUserPolicies(ctx context.Context, userName string) ([]string, error)
+	ListUserTags(ctx context.Context, userName string) (map[string]string, error)
+}
+
+// options are the flags shared by all commands, and the clients built from them
+type options struct {
+	kubeconfig    string
+	context       string
+	namespace     string
+	allNamespaces bool
+	awsRegion     string
+	// yes skips the confirmation of delete
+	yes bool
+	// profile and credentialsFile are where creds writes the access key
+	profile         string
+	credentialsFile string
+
+	in     io.Reader
+	out    io.Writer
+	client client.Client
+	iam    iamReader
+}
+
+func (o *options) bindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.kubeconfig, "kubeconfig", "", "Path to the kubeconfig file, $KUBECONFIG or ~/.kube/config by default.")
+	fs.StringVar(&o.context, "context", "", "The kubeconfig context to use, the current context by default.")
+	fs.StringVar(&o.namespace, "namespace", "", "Namespace of the AwsAccounts, the namespace of the context by default.")
+	fs.StringVar(&o.namespace, "n", "", "Shorthand for --namespace.")
+}
+
+func (o *options) bindAwsFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.awsRegion, "aws-region", os.Getenv("AWS_REGION"),
+		"The AWS region of the IAM client, which uses the default AWS credentials chain.")
+}
+
+// complete builds the Kubernetes client from the kubeconfig flags
+func (o *options) complete() error {
+	if o.client != nil {
+		return nil
+	}
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = o.kubeconfig
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{CurrentContext: o.context})
+	if o.namespace == "" {
+		namespace, _, err := clientConfig.Namespace()
+		if err != nil {
+			return err
+		}
+		o.namespace = namespace
+	}
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return err
+	}
+	o.client, err = client.New(config, client.Options{Scheme: scheme})
+	return err
+}
+
+// iamClient returns the IAM client, or an error explaining why the live IAM state cannot be shown
+func (o *options) iamClient() (iamReader, error) {
+	if o.iam != nil {
+		return o.iam, nil
+	}
+	wrapper, err := aws.NewIamWrapper(o.awsRegion, aws.ClientOptions{})
+	if err != nil {
+		return nil, err
+	}
+	o.iam = wrapper
+	return o.iam, nil
+}
+
+// command is a subcommand of the plugin
+type command struct {
+	name        string
+	args        string
+	description string
+	flags       func(fs *flag.FlagSet, o *options)
+	run         func(ctx context.Context, o *options, args []string) error
+}
+
+var commands = []command{listCommand, describeCommand, eventsCommand, deleteCommand, credsCommand}
+
+func main() {
+	o := &options{in: os.Stdin, out: os.Stdout}
+	if err := run(context.Background(), o, os.Args[1:]); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "error:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, o *options, args []string) error {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(os.Stderr)
+		return flag.ErrHelp
+	}
+	for _, cmd := range commands {
+		if cmd.name != args[0] {
+			continue
+		}
+		fs := flag.NewFlagSet("kubectl kuadra "+cmd.name, flag.ContinueOnError)
+		fs.Usage = func() {
+			fmt.Fprintf(fs.Output(), "%s\n\nUsage: kubectl kuadra %s [flags] %s\n\nFlags:\n", cmd.description, cmd.name, cmd.args)
+			fs.PrintDefaults()
+		}
+		o.bindFlags(fs)
+		if cmd.flags != nil {
+			cmd.flags(fs, o)
+		}
+		if err := fs.Parse(interleaveFlags(fs, args[1:])); err != nil {
+			return err
+		}
+		if err := o.complete(); err != nil {
+			return err
+		}
+		return cmd.run(ctx, o, fs.Args())
+	}
+	usage(os.Stderr)
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+// interleaveFlags moves positional arguments after the flags, so that flags can also follow
+// the AwsAccount name as they can with kubectl
+func interleaveFlags(fs *flag.FlagSet, args []string) []string {
+	var flags, positional []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			positional = append(positional, args[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			positional = append(positional, arg)
+			continue
+		}
+		flags = append(flags, arg)
+		name := strings.TrimLeft(arg, "-")
+		if strings.Contains(name, "=") {
+			continue
+		}
+		if f := fs.Lookup(name); f != nil && i+1 < len(args) {
+			if boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !boolFlag.IsBoolFlag() {
+				i++
+				flags = append(flags, args[i])
+			}
+		}
+	}
+	return append(flags, positional...)
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "kubectl kuadra administers the AWS IAM users managed by Kuadra.")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.description)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Use \"kubectl kuadra <command> -h\" for the flags of a command.")
+}
+
+// getAwsAccount fetches the named AwsAccount from the namespace of the options
+func getAwsAccount(ctx context.Context, o *options, args []string) (*kuadrav1.AwsAccount, error) {
+	if len(args) != 1 {
+		return nil, errors.New("expected the name of one AwsAccount")
+	}
+	awsAccount := &kuadrav1.AwsAccount{}
+	if err := o.client.Get(ctx, client.ObjectKey{Namespace: o.namespace, Name: args[0]}, awsAccount); err != nil {
+		return nil, err
+	}
+	return awsAccount, nil
+}
+
+// joinSorted lists values in order, or "-" when there are none
+func joinSorted(values []string) string {
+	if len(values) == 0 {
+		return "-"
+	}
+	sorted := append([]string(nil), values...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
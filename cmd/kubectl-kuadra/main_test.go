@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+)
+
+// fakeIam serves a single IAM user
+type fakeIam struct {
+	user   string
+	groups []string
+}
+
+func (f *fakeIam) GetUser(ctx context.Context, userName string) (*types.User, error) {
+	if userName != f.user {
+		return nil, nil
+	}
+	return &types.User{UserName: aws.String(userName), Arn: aws.String("arn:aws:iam::123456789012:user/" + userName), Path: aws.String("/")}, nil
+}
+
+func (f *fakeIam) HasLoginProfile(ctx context.Context, userName string) (bool, error) {
+	return true, nil
+}
+
+func (f *fakeIam) ListAccessKeys(ctx context.Context, userName string) ([]types.AccessKeyMetadata, error) {
+	return []types.AccessKeyMetadata{{AccessKeyId: aws.String("AKIAEXAMPLE"), Status: types.StatusTypeActive}}, nil
+}
+
+func (f *fakeIam) ListGroupsForUser(ctx context.Context, userName string) ([]types.Group, error) {
+	var groups []types.Group
+	for _, group := range f.groups {
+		groups = append(groups, types.Group{GroupName: aws.String(group)})
+	}
+	return groups, nil
+}
+
+func (f *fakeIam) ListAttachedUserPolicies(ctx context.Context, userName string) ([]types.AttachedPolicy, error) {
+	return nil, nil
+}
+
+func (f *fakeIam) ListUserPolicies(ctx context.Context, userName string) ([]string, error) {
+	return nil, nil
+}
+
+func (f *fakeIam) ListUserTags(ctx context.Context, userName string) (map[string]string, error) {
+	return map[string]string{"managed-by": "kuadra"}, nil
+}
+
+var _ = Describe("kubectl kuadra", func() {
+	ctx := context.Background()
+
+	var o *options
+	var out *bytes.Buffer
+
+	BeforeEach(func() {
+		awsAccount := &kuadrav1.AwsAccount{
+			ObjectMeta: metav1.ObjectMeta{Name: "ib-dns", Namespace: "default"},
+			Spec:       kuadrav1.AwsAccountSpec{UserName: "ib-dns", Groups: []string{"dns-admins"}},
+			Status: kuadrav1.AwsAccountStatus{
+				Phase:               kuadrav1.AwsAccountPhaseReady,
+				UserCreated:         true,
+				Path:                "/",
+				LoginProfileCreated: true,
+				AccessKeyCreated:    true,
+				UserGroups:          []string{"dns-admins"},
+			},
+		}
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "aws-credentials", Namespace: "ib-dns"},
+			Data: map[string][]byte{
+				"AWS_ACCESS_KEY_ID":     []byte("AKIAEXAMPLE"),
+				"AWS_SECRET_ACCESS_KEY": []byte("secret"),
+				"AWS_REGION":            []byte("eu-west-1"),
+			},
+		}
+		out = &bytes.Buffer{}
+		o = &options{
+			out:    out,
+			in:     strings.NewReader(""),
+			client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(awsAccount, secret).Build(),
+			iam:    &fakeIam{user: "ib-dns", groups: []string{"dns-admins"}},
+		}
+	})
+
+	It("Should list the AwsAccounts of the namespace", func() {
+		Expect(run(ctx, o, []string{"list", "-n", "default"})).Should(Succeed())
+		Expect(out.String()).Should(ContainSubstring("NAME"))
+		Expect(out.String()).Should(MatchRegexp(`ib-dns\s+ib-dns\s+Ready\s+dns-admins`))
+	})
+
+	It("Should show the differences between the AwsAccount and IAM", func() {
+		o.iam = &fakeIam{user: "ib-dns", groups: []string{"dns-admins", "billing"}}
+		Expect(run(ctx, o, []string{"describe", "ib-dns", "-n", "default"})).Should(Succeed())
+		Expect(out.String()).Should(MatchRegexp(`\*\s+Groups\s+dns-admins\s+billing,dns-admins`))
+		Expect(out.String()).Should(ContainSubstring("managed-by=kuadra"))
+		Expect(out.String()).Should(ContainSubstring("IAM differs from the last reconcile"))
+	})
+
+	It("Should only delete after confirmation", func() {
+		Expect(run(ctx, o, []string{"delete", "ib-dns", "-n", "default"})).Should(MatchError("deletion not confirmed"))
+		Expect(out.String()).Should(ContainSubstring("access key AKIAEXAMPLE"))
+		Expect(out.String()).Should(ContainSubstring("membership of group dns-admins"))
+		Expect(o.client.Get(ctx, client.ObjectKey{Namespace: "default", Name: "ib-dns"}, &kuadrav1.AwsAccount{})).Should(Succeed())
+
+		o.in = strings.NewReader("ib-dns\n")
+		Expect(run(ctx, o, []string{"delete", "ib-dns", "-n", "default"})).Should(Succeed())
+		err := o.client.Get(ctx, client.ObjectKey{Namespace: "default", Name: "ib-dns"}, &kuadrav1.AwsAccount{})
+		Expect(client.IgnoreNotFound(err)).Should(Succeed())
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("Should write the access key to a profile", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "aws", "credentials")
+		Expect(os.MkdirAll(filepath.Dir(path), 0o700)).Should(Succeed())
+		existing := "[default]\naws_access_key_id = AKIADEFAULT\n\n[ib-dns]\naws_access_key_id = AKIAOLD\naws_secret_access_key = old\n\n[other]\nregion = us-east-1\n"
+		Expect(os.WriteFile(path, []byte(existing), 0o600)).Should(Succeed())
+
+		Expect(run(ctx, o, []string{"creds", "ib-dns", "-n", "default", "--credentials-file", path})).Should(Succeed())
+		content, err := os.ReadFile(path)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(content)).Should(Equal("[default]\naws_access_key_id = AKIADEFAULT\n\n" +
+			"[ib-dns]\naws_access_key_id = AKIAEXAMPLE\naws_secret_access_key = secret\n" +
+			"[other]\nregion = us-east-1\n"))
+		Expect(out.String()).Should(ContainSubstring("AWS_REGION=eu-west-1"))
+
+		info, err := os.Stat(path)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(info.Mode().Perm()).Should(Equal(os.FileMode(0o600)))
+	})
+
+	It("Should append a new profile", func() {
+		Expect(setProfile("[default]\nregion = eu-west-1\n", "ib-dns", [][2]string{{"aws_access_key_id", "AKIAEXAMPLE"}})).
+			Should(Equal("[default]\nregion = eu-west-1\n\n[ib-dns]\naws_access_key_id = AKIAEXAMPLE\n"))
+		Expect(setProfile("", "ib-dns", [][2]string{{"aws_access_key_id", "AKIAEXAMPLE"}})).
+			Should(Equal("[ib-dns]\naws_access_key_id = AKIAEXAMPLE\n"))
+	})
+})
@@ -17,22 +17,29 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
 	"github.com/Kuadrant/kuadra/internal/controller"
+	"github.com/Kuadrant/kuadra/internal/scim"
+	"github.com/Kuadrant/kuadra/pkg/audit"
 	"github.com/Kuadrant/kuadra/pkg/aws"
 	//+kubebuilder:scaffold:imports
 )
@@ -53,11 +60,92 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var awsRegion string
+	var permissionsBoundary string
+	var allowPermissionsBoundaryOverride bool
+	var forbidUserRename bool
+	var allowCrossNamespaceSecrets bool
+	var userPathPrefix string
+	var tagKeys string
+	var userConfigMapNamespaces string
+	var resyncInterval time.Duration
+	var driftReportOnly bool
+	var dryRun bool
+	var auditLogPath string
+	var auditWebhookURL string
+	var clusterId string
+	var orphanPolicy string
+	var orphanGracePeriod time.Duration
+	var orphanCollectionInterval time.Duration
+	var scimAddr string
+	var scimNamespace string
+	var scimTokenSecret string
+	var scimCertFile string
+	var scimKeyFile string
+	var scimInsecure bool
+	var awsClientOptions aws.ClientOptions
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&awsRegion, "aws-region", "us-west-2", "The AWS region used by the IAM client and written into credentials Secrets.")
+	flag.StringVar(&permissionsBoundary, "permissions-boundary", "",
+		"ARN of the managed policy set as the permissions boundary of every IAM user. "+
+			"Namespaces can replace it with the "+controller.PermissionsBoundaryAnnotation+" annotation.")
+	flag.BoolVar(&allowPermissionsBoundaryOverride, "allow-permissions-boundary-override", false,
+		"Allow AwsAccounts to set their own permissions boundary with spec.permissionsBoundary.")
+	flag.BoolVar(&forbidUserRename, "forbid-user-rename", false,
+		"Reject changes to spec.userName in the AwsAccount webhook instead of renaming the IAM user.")
+	flag.BoolVar(&allowCrossNamespaceSecrets, "allow-cross-namespace-secrets", false,
+		"Allow AwsAccounts to write their credentials Secrets to namespaces other than their own and their user's.")
+	flag.StringVar(&userPathPrefix, "iam-user-path-prefix", "",
+		"Prefix of the IAM path of users, followed by the namespace of their AwsAccount, e.g. /kuadra/. "+
+			"Existing users are moved to the new path. Paths are left alone when empty.")
+	flag.StringVar(&tagKeys, "iam-tag-keys", "",
+		"Comma separated list of AwsAccount label and annotation keys copied to IAM user tags, e.g. team,cost-centre,owner-email.")
+	flag.DurationVar(&resyncInterval, "resync-interval", 10*time.Minute,
+		"How often AwsAccounts and AwsGroups are reconciled to detect changes made in IAM outside of Kuadra. Set to 0 to disable.")
+	flag.BoolVar(&driftReportOnly, "drift-report-only", false,
+		"Record changes made in IAM outside of Kuadra in the AwsAccount status without reverting them.")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Write the IAM and Kubernetes changes Kuadra would make into status.plannedActions and events without making them. "+
+			"Single resources can be planned with the "+controller.DryRunAnnotation+"=true annotation.")
+	flag.Float64Var(&awsClientOptions.RequestsPerSecond, "aws-requests-per-second", aws.DefaultRequestsPerSecond,
+		"Sustained rate of IAM API requests shared by all controllers, retries included.")
+	flag.IntVar(&awsClientOptions.Burst, "aws-burst", aws.DefaultBurst,
+		"Number of IAM API requests that can be sent at once above --aws-requests-per-second.")
+	flag.IntVar(&awsClientOptions.MaxAttempts, "aws-max-attempts", aws.DefaultMaxAttempts,
+		"Number of times an IAM API call failing with a throttling or other transient error is tried.")
+	flag.StringVar(&auditLogPath, "audit-log-path", "",
+		"File the tamper-evident audit log of IAM and Secret changes is appended to as JSON lines, or - for stdout.")
+	flag.StringVar(&auditWebhookURL, "audit-webhook-url", "",
+		"URL each audit log record is POSTed to as JSON, in addition to --audit-log-path.")
+	flag.StringVar(&clusterId, "cluster-id", "",
+		"Identity of this cluster, tagged onto the IAM users it manages as "+controller.ClusterTag+". "+
+			"Users tagged with another identity are left alone. The UID of the kube-system namespace by default.")
+	flag.StringVar(&orphanPolicy, "orphan-policy", string(controller.OrphanPolicyReport),
+		"What to do with IAM users of this cluster that no AwsAccount manages once their grace period has passed: report, suspend or delete.")
+	flag.DurationVar(&orphanGracePeriod, "orphan-grace-period", 24*time.Hour,
+		"How long an IAM user stays orphaned before --orphan-policy is applied to it.")
+	flag.DurationVar(&orphanCollectionInterval, "orphan-collection-interval", time.Hour,
+		"How often IAM is searched for orphaned users.")
+	flag.StringVar(&userConfigMapNamespaces, "user-configmap-namespaces", "",
+		"Comma separated list of namespaces whose ConfigMaps labelled "+controller.UserConfigLabel+"=true generate Users. "+
+			"Anyone who can edit ConfigMaps there can request IAM users. Disabled when empty.")
+	flag.StringVar(&scimAddr, "scim-bind-address", "",
+		"The address the SCIM endpoint binds to, for identity providers to provision Users. Disabled by default.")
+	flag.StringVar(&scimNamespace, "scim-namespace", "kuadra-system",
+		"The namespace of the Users and AwsGroups provisioned through SCIM.")
+	flag.StringVar(&scimTokenSecret, "scim-token-secret", "kuadra-scim-token",
+		"Name of the Secret in --scim-namespace holding the bearer token of the SCIM endpoint in its "+scim.TokenKey+" key.")
+	flag.StringVar(&scimCertFile, "scim-cert-file", "",
+		"TLS certificate the SCIM endpoint is served with. Renewed certificates are picked up without a restart.")
+	flag.StringVar(&scimKeyFile, "scim-key-file", "",
+		"Private key of --scim-cert-file.")
+	flag.BoolVar(&scimInsecure, "scim-insecure", false,
+		"Serve the SCIM endpoint over plain HTTP when no certificate is set, for a proxy in front of it that terminates TLS. "+
+			"The bearer token and user details then cross the network between the proxy and Kuadra unencrypted.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -66,6 +154,28 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if userPathPrefix != "" && (!strings.HasPrefix(userPathPrefix, "/") || !strings.HasSuffix(userPathPrefix, "/")) {
+		setupLog.Error(nil, "--iam-user-path-prefix must begin and end with /", "prefix", userPathPrefix)
+		os.Exit(1)
+	}
+	if scimAddr != "" && (scimCertFile == "") != (scimKeyFile == "") {
+		setupLog.Error(nil, "--scim-cert-file and --scim-key-file must be set together")
+		os.Exit(1)
+	}
+	if scimAddr != "" && dryRun {
+		setupLog.Error(nil, "the SCIM endpoint cannot run with --dry-run, identity providers expect their changes to be made")
+		os.Exit(1)
+	}
+	if scimAddr != "" && scimCertFile == "" && !scimInsecure {
+		setupLog.Error(nil, "the SCIM endpoint needs --scim-cert-file and --scim-key-file, or --scim-insecure to serve plain HTTP")
+		os.Exit(1)
+	}
+	parsedOrphanPolicy, err := controller.ParseOrphanPolicy(orphanPolicy)
+	if err != nil {
+		setupLog.Error(err, "invalid --orphan-policy")
+		os.Exit(1)
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
@@ -90,36 +200,156 @@ func main() {
 		os.Exit(1)
 	}
 
+	if clusterId == "" {
+		// The cache is not started yet, so the namespace is read straight from the API server
+		clusterId, err = controller.ClusterIdFromKubeSystem(context.Background(), mgr.GetAPIReader())
+		if err != nil {
+			setupLog.Error(err, "unable to derive the cluster identity, set --cluster-id")
+			os.Exit(1)
+		}
+	}
+	setupLog.Info("managing IAM users as cluster", "clusterId", clusterId)
+
+	auditLogger, err := newAuditLogger(auditLogPath, auditWebhookURL)
+	if err != nil {
+		setupLog.Error(err, "unable to open audit log")
+		os.Exit(1)
+	}
+	accountClient := mgr.GetClient()
+	if auditLogger != nil {
+		awsClientOptions.AuditLogger = auditLogger
+		accountClient = audit.NewClient(accountClient, auditLogger)
+	}
+
 	// Set up clients for IAM and (TODO) Route53
-	iamWrapper, err := aws.NewIamWrapper()
+	iamWrapper, err := aws.NewIamWrapper(awsRegion, awsClientOptions)
 	if err != nil {
 		setupLog.Error(err, "couldn't load AWS configuration")
 		os.Exit(1)
 	}
 
 	if err = (&controller.AwsAccountReconciler{
-		Client:     mgr.GetClient(),
-		Scheme:     mgr.GetScheme(),
-		IamWrapper: *iamWrapper,
+		Client:                           accountClient,
+		Scheme:                           mgr.GetScheme(),
+		IamWrapper:                       *iamWrapper,
+		APIReader:                        mgr.GetAPIReader(),
+		Region:                           awsRegion,
+		PermissionsBoundary:              permissionsBoundary,
+		AllowPermissionsBoundaryOverride: allowPermissionsBoundaryOverride,
+		UserPathPrefix:                   userPathPrefix,
+		TagKeys:                          splitList(tagKeys),
+		ClusterId:                        clusterId,
+		ResyncInterval:                   resyncInterval,
+		DriftReportOnly:                  driftReportOnly,
+		DryRun:                           dryRun,
+		Recorder:                         mgr.GetEventRecorderFor("awsaccount-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "AwsAccount")
 		os.Exit(1)
 	}
 	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
-		if err = (&kuadrav1.AwsAccount{}).SetupWebhookWithManager(mgr); err != nil {
+		validator := &kuadrav1.AwsAccountValidator{
+			AllowPermissionsBoundaryOverride: allowPermissionsBoundaryOverride,
+			ForbidUserRename:                 forbidUserRename,
+			AllowCrossNamespaceSecrets:       allowCrossNamespaceSecrets,
+		}
+		if err = (&kuadrav1.AwsAccount{}).SetupWebhookWithManager(mgr, validator); err != nil {
 			setupLog.Error(err, "unable to create webhook", "webhook", "CronJob")
 			os.Exit(1)
 		}
+		if err = (&kuadrav1.AwsGroup{}).SetupWebhookWithManager(mgr, &kuadrav1.AwsGroupValidator{Reader: mgr.GetClient()}); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "AwsGroup")
+			os.Exit(1)
+		}
 	}
 	if err = (&controller.UserReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
+		DryRun: dryRun,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "User")
 		os.Exit(1)
 	}
+	if err = (&controller.AwsGroupReconciler{
+		Client:         mgr.GetClient(),
+		Scheme:         mgr.GetScheme(),
+		IamWrapper:     *iamWrapper,
+		ClusterId:      clusterId,
+		ResyncInterval: resyncInterval,
+		DryRun:         dryRun,
+		Recorder:       mgr.GetEventRecorderFor("awsgroup-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "AwsGroup")
+		os.Exit(1)
+	}
+	if err = (&controller.TeamReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+		DryRun: dryRun,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Team")
+		os.Exit(1)
+	}
+	if namespaces := splitList(userConfigMapNamespaces); len(namespaces) > 0 {
+		if err = (&controller.UserConfigMapReconciler{
+			Client:     mgr.GetClient(),
+			APIReader:  mgr.GetAPIReader(),
+			Scheme:     mgr.GetScheme(),
+			Recorder:   mgr.GetEventRecorderFor("userconfigmap-controller"),
+			Namespaces: namespaces,
+			DryRun:     dryRun,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "UserConfigMap")
+			os.Exit(1)
+		}
+	}
+	if err = (&controller.LdapSourceReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("ldapsource-controller"),
+		DryRun:   dryRun,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "LdapSource")
+		os.Exit(1)
+	}
 	//+kubebuilder:scaffold:builder
 
+	if err := mgr.Add(&controller.OrphanCollector{
+		Client:         mgr.GetClient(),
+		IamWrapper:     *iamWrapper,
+		Recorder:       mgr.GetEventRecorderFor("orphan-collector"),
+		ClusterId:      clusterId,
+		UserPathPrefix: userPathPrefix,
+		Policy:         parsedOrphanPolicy,
+		GracePeriod:    orphanGracePeriod,
+		Interval:       orphanCollectionInterval,
+		DryRun:         dryRun,
+	}); err != nil {
+		setupLog.Error(err, "unable to set up orphan collector")
+		os.Exit(1)
+	}
+
+	if scimAddr != "" {
+		// Reads go to the API server, so that a resource is found right after it was created
+		scimClient, err := client.New(mgr.GetConfig(), client.Options{Scheme: mgr.GetScheme(), Mapper: mgr.GetRESTMapper()})
+		if err != nil {
+			setupLog.Error(err, "unable to create SCIM client")
+			os.Exit(1)
+		}
+		if err := mgr.Add(&scim.Server{
+			Client:      scimClient,
+			Namespace:   scimNamespace,
+			TokenSecret: types.NamespacedName{Name: scimTokenSecret, Namespace: scimNamespace},
+			BindAddress: scimAddr,
+			CertFile:    scimCertFile,
+			KeyFile:     scimKeyFile,
+			Insecure:    scimInsecure,
+		}); err != nil {
+			setupLog.Error(err, "unable to set up SCIM server")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
@@ -135,3 +365,37 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// splitList splits a comma separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// newAuditLogger returns a logger writing to the configured sinks, or nil when auditing is off
+func newAuditLogger(path string, webhookURL string) (*audit.Logger, error) {
+	var sinks []audit.Sink
+	switch path {
+	case "":
+	case "-":
+		sinks = append(sinks, audit.NewWriterSink(os.Stdout))
+	default:
+		sink, err := audit.NewFileSink(path)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if webhookURL != "" {
+		sinks = append(sinks, audit.NewWebhookSink(webhookURL))
+	}
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+	return audit.NewLogger(sinks...), nil
+}
@@ -5,14 +5,25 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/service/iam/types"
 	"github.com/aws/smithy-go/middleware"
+
+	kuadraaws "github.com/Kuadrant/kuadra/pkg/aws"
 )
 
 type IamWrapper interface {
+	GetUser(ctx context.Context, userName string) (*types.User, error)
 	IsExistingUser(ctx context.Context, userName string) (bool, error)
 	HasLoginProfile(ctx context.Context, userName string) (bool, error)
 	HasAccessKey(ctx context.Context, userName string) (bool, error)
 	ListGroupsForUser(ctx context.Context, userName string) ([]types.Group, error)
-	CreateUserIfNotExists(ctx context.Context, userName string) error
+	ListUsers(ctx context.Context, pathPrefix string, maxUsers int32) ([]types.User, error)
+	CreateUserIfNotExists(ctx context.Context, userName string, options kuadraaws.UserOptions) error
+	PutUserPermissionsBoundary(ctx context.Context, userName string, boundaryArn string) error
+	DeleteUserPermissionsBoundary(ctx context.Context, userName string) error
+	UpdateUserPath(ctx context.Context, userName string, path string) error
+	UpdateUserName(ctx context.Context, userName string, newUserName string) error
+	ListUserTags(ctx context.Context, userName string) (map[string]string, error)
+	TagUser(ctx context.Context, userName string, tags map[string]string) error
+	UntagUser(ctx context.Context, userName string, tagKeys []string) error
 	CreateLoginProfileIfNotExists(ctx context.Context, password string, userName string, passwordResetRequired bool) error
 	CreateAccessKeyPair(ctx context.Context, userName string) (*types.AccessKey, error)
 	AddUserToGroup(ctx context.Context, groupName string, userName string) (middleware.Metadata, error)
@@ -21,4 +32,23 @@ type IamWrapper interface {
 	DeleteLoginProfileIfExists(ctx context.Context, userName string) error
 	ListAccessKeys(ctx context.Context, userName string) ([]types.AccessKeyMetadata, error)
 	DeleteAccessKeyIfExists(ctx context.Context, userName string, keyId string) error
+	UpdateAccessKeyStatus(ctx context.Context, userName string, keyId string, status types.StatusType) error
+	ListAttachedUserPolicies(ctx context.Context, userName string) ([]types.AttachedPolicy, error)
+	AttachUserPolicy(ctx context.Context, userName string, policyArn string) error
+	DetachUserPolicyIfAttached(ctx context.Context, userName string, policyArn string) error
+	ListUserPolicies(ctx context.Context, userName string) ([]string, error)
+	GetUserPolicy(ctx context.Context, userName string, policyName string) (string, error)
+	PutUserPolicy(ctx context.Context, userName string, policyName string, policyDocument string) error
+	DeleteUserPolicyIfExists(ctx context.Context, userName string, policyName string) error
+	GetGroup(ctx context.Context, groupName string) (*types.Group, []types.User, error)
+	CreateGroupIfNotExists(ctx context.Context, groupName string, path string) error
+	UpdateGroupPath(ctx context.Context, groupName string, path string) error
+	DeleteGroupIfExists(ctx context.Context, groupName string) error
+	ListAttachedGroupPolicies(ctx context.Context, groupName string) ([]types.AttachedPolicy, error)
+	AttachGroupPolicy(ctx context.Context, groupName string, policyArn string) error
+	DetachGroupPolicyIfAttached(ctx context.Context, groupName string, policyArn string) error
+	ListGroupPolicies(ctx context.Context, groupName string) ([]string, error)
+	GetGroupPolicy(ctx context.Context, groupName string, policyName string) (string, error)
+	PutGroupPolicy(ctx context.Context, groupName string, policyName string, policyDocument string) error
+	DeleteGroupPolicyIfExists(ctx context.Context, groupName string, policyName string) error
 }
@@ -18,26 +18,40 @@ package controller
 
 import (
 	"context"
+	"fmt"
 	"reflect"
+	"strings"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
 	"github.com/sethvargo/go-password/password"
 
 	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
 	slice "github.com/Kuadrant/kuadra/pkg/_internal"
+	"github.com/Kuadrant/kuadra/pkg/audit"
+	kuadraaws "github.com/Kuadrant/kuadra/pkg/aws"
 )
 
 const (
 	AwsAccountFinalizer = "kuadra.kuadrant.io/aws-account"
+
+	awsAccountControllerName = "awsaccount"
 )
 
 // AwsAccountReconciler reconciles a AwsAccount object
@@ -45,6 +59,33 @@ type AwsAccountReconciler struct {
 	client.Client
 	Scheme     *runtime.Scheme
 	IamWrapper IamWrapper
+	// APIReader reads the ConfigMaps of inline policies from the API server, as only their
+	// metadata is cached. The Client is used when nil.
+	APIReader client.Reader
+	// Region is written into credentials Secrets that do not set their own
+	Region string
+	// PermissionsBoundary is set on every user unless its namespace configures another one
+	PermissionsBoundary string
+	// AllowPermissionsBoundaryOverride lets AwsAccounts choose their own permissions boundary
+	AllowPermissionsBoundaryOverride bool
+	// UserPathPrefix is followed by the AwsAccount's namespace to form the IAM path of its
+	// user. Paths are left alone when empty.
+	UserPathPrefix string
+	// TagKeys are the label and annotation keys copied to IAM user tags
+	TagKeys []string
+	// ClusterId is tagged onto the IAM users of this cluster, so that the orphan collector can
+	// find the ones left behind. Users are not tagged when empty.
+	ClusterId string
+	// ResyncInterval is how often AwsAccounts are reconciled to detect drift in IAM. Zero disables resyncs.
+	ResyncInterval time.Duration
+	// DriftReportOnly records drift without correcting it
+	DriftReportOnly bool
+	// DryRun plans the changes to every AwsAccount without making them, as DryRunAnnotation does for one
+	DryRun   bool
+	Recorder record.EventRecorder
+
+	// planning is set on the copy of the reconciler that works out a dry run plan
+	planning bool
 }
 
 //+kubebuilder:rbac:groups=kuadra.kuadrant.io,resources=awsaccounts,verbs=get;list;watch;create;update;patch;delete
@@ -52,6 +93,7 @@ type AwsAccountReconciler struct {
 //+kubebuilder:rbac:groups=kuadra.kuadrant.io,resources=awsaccounts/finalizers,verbs=update
 //+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -69,14 +111,48 @@ func (r *AwsAccountReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	if err := r.Get(ctx, req.NamespacedName, &awsAccount); err != nil {
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
+	ctx = audit.WithActor(ctx, audit.ActorOf("AwsAccount", &awsAccount))
+
+	if !r.planning && isDryRun(r.DryRun, &awsAccount) {
+		return r.planReconcile(ctx, req, &awsAccount)
+	}
 
 	if awsAccount.DeletionTimestamp != nil && !awsAccount.DeletionTimestamp.IsZero() {
-		if err := r.deleteNamespace(ctx, awsAccount.Spec.UserName); err != nil {
-			log.Error(err, "Failed to delete namespace", "namespace", awsAccount.Spec.UserName)
+		// The user has its applied name, and a rename that is not finished leaves Secrets in
+		// the namespace of its previous name
+		userName := awsAccount.AppliedUserName()
+		if awsAccount.Status.Phase != kuadrav1.AwsAccountPhaseDeleting {
+			awsAccount.Status.Phase = kuadrav1.AwsAccountPhaseDeleting
+			if err := r.Status().Update(ctx, &awsAccount); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		if awsAccount.Status.ManagedZone != "" {
+			deleted, err := r.deleteManagedZone(ctx, userName, awsAccount.Status.ManagedZone)
+			if err != nil {
+				log.Error(err, "Failed to delete managed zone", "managedZone", awsAccount.Status.ManagedZone)
+				return ctrl.Result{}, err
+			}
+			if !deleted {
+				// Keep the provider secret until Kuadrant has finished with the zone
+				return ctrl.Result{RequeueAfter: time.Second * 5}, nil
+			}
+		}
+		if err := r.deleteCredentialsSecrets(ctx, &awsAccount); err != nil {
+			log.Error(err, "Failed to delete credentials secrets")
 			return ctrl.Result{}, err
 		}
-		if err := r.deleteIamUser(ctx, awsAccount.Spec.UserName); err != nil {
-			log.Error(err, "Failed to delete IAM user", "userName", awsAccount.Spec.UserName)
+		for _, namespace := range []string{userName, awsAccount.Status.PreviousUserName} {
+			if namespace == "" {
+				continue
+			}
+			if err := r.deleteNamespace(ctx, namespace); err != nil {
+				log.Error(err, "Failed to delete namespace", "namespace", namespace)
+				return ctrl.Result{}, err
+			}
+		}
+		if err := r.deleteOwnedIamUser(ctx, &awsAccount, userName); err != nil {
+			log.Error(err, "Failed to delete IAM user", "userName", userName)
 			return ctrl.Result{}, err
 		}
 		controllerutil.RemoveFinalizer(&awsAccount, AwsAccountFinalizer)
@@ -94,109 +170,319 @@ func (r *AwsAccountReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		}
 	}
 
+	renamed, err := r.renameUser(ctx, &awsAccount)
+	if err != nil {
+		log.Error(err, "unable to rename IAM user")
+		return r.reconcileFailed(ctx, req, &awsAccount, err)
+	}
+	if !renamed {
+		// Wait for Kuadrant to finish with the ManagedZone in the old namespace
+		return ctrl.Result{RequeueAfter: time.Second * 5}, nil
+	}
+
+	if err := r.checkOwnership(ctx, &awsAccount, awsAccount.Spec.UserName); err != nil {
+		log.Error(err, "unable to check ownership of IAM user")
+		return r.reconcileFailed(ctx, req, &awsAccount, err)
+	}
+
+	stepStart := time.Now()
+	previousStatus := awsAccount.Status
 	refreshedStatus, err := r.getRefreshedStatus(ctx, awsAccount)
 	if err != nil {
 		log.Error(err, "unable to get refreshed status")
-		return ctrl.Result{}, err
+		return r.reconcileFailed(ctx, req, &awsAccount, err)
 	}
 	awsAccount.Status = *refreshedStatus
+	observeStep(awsAccountControllerName, "refresh_status", &stepStart)
+
+	drift := detectDrift(previousStatus, awsAccount.Status)
+	recordedDrift := r.recordDrift(&awsAccount, drift)
+	if r.DriftReportOnly && len(drift) > 0 {
+		log.Info("leaving drift uncorrected in report only mode", "drift", drift)
+		maskDrift(&awsAccount.Status, previousStatus)
+		if drift[0].Type == kuadrav1.DriftUserDeleted {
+			// Nothing else can be reconciled without the user
+			r.reportDrift(&awsAccount, recordedDrift)
+			return r.updateStatus(ctx, req, &awsAccount)
+		}
+	}
+
+	credentials := resolveCredentials(awsAccount, r.Region)
+	if err := r.moveCredentials(ctx, &awsAccount, credentials); err != nil {
+		log.Error(err, "unable to move credentials secrets")
+		return r.reconcileFailed(ctx, req, &awsAccount, err)
+	}
+	observeStep(awsAccountControllerName, "credentials", &stepStart)
 
 	if !awsAccount.Status.NamespaceCreated {
 		if err := r.createNamespaceIfNotExists(ctx, awsAccount.Spec.UserName); err != nil {
 			log.Error(err, "unable to create namespace")
-			return ctrl.Result{}, err
+			return r.reconcileFailed(ctx, req, &awsAccount, err)
 		}
 		log.V(1).Info("created namespace", "namespace", awsAccount.Spec.UserName)
 		awsAccount.Status.NamespaceCreated = true
 	}
 
+	permissionsBoundary, err := r.resolvePermissionsBoundary(ctx, &awsAccount)
+	if err != nil {
+		log.Error(err, "unable to resolve permissions boundary")
+		return r.reconcileFailed(ctx, req, &awsAccount, err)
+	}
+
 	if !awsAccount.Status.UserCreated {
-		if err := r.IamWrapper.CreateUserIfNotExists(ctx, awsAccount.Spec.UserName); err != nil {
+		options := kuadraaws.UserOptions{
+			PermissionsBoundary: permissionsBoundary,
+			Path:                r.userPath(&awsAccount),
+			Tags:                r.userTags(ctx, &awsAccount),
+		}
+		if err := r.IamWrapper.CreateUserIfNotExists(ctx, awsAccount.Spec.UserName, options); err != nil {
 			log.Error(err, "unable to create IAM user")
-			return ctrl.Result{}, err
+			return r.reconcileFailed(ctx, req, &awsAccount, err)
 		}
 		log.V(1).Info("created user", "userName", awsAccount.Spec.UserName)
 		awsAccount.Status.UserCreated = true
+		awsAccount.Status.PermissionsBoundary = permissionsBoundary
+		awsAccount.Status.Path = options.Path
 	}
+	awsAccount.Status.UserName = awsAccount.Spec.UserName
 
-	if !awsAccount.Status.LoginProfileCreated {
+	if err := r.reconcilePermissionsBoundary(ctx, &awsAccount, permissionsBoundary); err != nil {
+		log.Error(err, "unable to put permissions boundary")
+		return r.reconcileFailed(ctx, req, &awsAccount, err)
+	}
+
+	if err := r.reconcileUserMetadata(ctx, &awsAccount); err != nil {
+		log.Error(err, "unable to update user path and tags")
+		return r.reconcileFailed(ctx, req, &awsAccount, err)
+	}
+
+	if err := r.reconcileSuspension(ctx, &awsAccount); err != nil {
+		log.Error(err, "unable to suspend or resume IAM user")
+		return r.reconcileFailed(ctx, req, &awsAccount, err)
+	}
+	observeStep(awsAccountControllerName, "user", &stepStart)
+
+	if !awsAccount.Spec.Suspended && !awsAccount.Status.LoginProfileCreated {
 		pass, err := password.Generate(20, 3, 3, false, true)
 		if err != nil {
 			log.Error(err, "unable to generate password")
-			return ctrl.Result{}, err
+			return r.reconcileFailed(ctx, req, &awsAccount, err)
 		}
 		secretData := map[string]string{
 			"userName": awsAccount.Spec.UserName,
 			"password": pass,
 		}
-		if err := r.createSecretIfNotExists(ctx, secretData, "aws-login", awsAccount.Spec.UserName); err != nil {
+		loginSecretRef := credentials.LoginSecretRef
+		if err := r.createSecretIfNotExists(ctx, &awsAccount, secretData, *loginSecretRef, v1.SecretTypeOpaque); err != nil {
 			log.Error(err, "unable to create secret for AWS password")
-			return ctrl.Result{}, err
+			return r.reconcileFailed(ctx, req, &awsAccount, err)
 		}
 		// Use password value from retrieved secret so that possible creation errors do not cause incorrect password to be set
 		retrievedSecret := &v1.Secret{}
-		if err := r.Get(ctx, types.NamespacedName{Name: "aws-login", Namespace: awsAccount.Spec.UserName}, retrievedSecret); err != nil {
+		if err := r.Get(ctx, types.NamespacedName{Name: loginSecretRef.Name, Namespace: loginSecretRef.Namespace}, retrievedSecret); err != nil {
 			log.Error(err, "unable to get secret for AWS password")
-			return ctrl.Result{}, err
+			return r.reconcileFailed(ctx, req, &awsAccount, err)
 		}
 		if err := r.IamWrapper.CreateLoginProfileIfNotExists(ctx, string(retrievedSecret.Data["password"]), awsAccount.Spec.UserName, true); err != nil {
 			log.Error(err, "unable to create login profile")
-			return ctrl.Result{}, err
+			return r.reconcileFailed(ctx, req, &awsAccount, err)
 		}
 		log.V(1).Info("created login profile")
 		awsAccount.Status.LoginProfileCreated = true
 	}
+	observeStep(awsAccountControllerName, "login_profile", &stepStart)
 
-	if !awsAccount.Status.AccessKeyCreated {
+	if !awsAccount.Spec.Suspended && !awsAccount.Status.AccessKeyCreated {
 		accessKey, err := r.IamWrapper.CreateAccessKeyPair(ctx, awsAccount.Spec.UserName)
 		if err != nil {
 			log.Error(err, "unable to create access key")
-			return ctrl.Result{}, err
+			return r.reconcileFailed(ctx, req, &awsAccount, err)
 		}
-		secretData := map[string]string{
-			"AWS_ACCESS_KEY_ID":     *accessKey.AccessKeyId,
-			"AWS_SECRET_ACCESS_KEY": *accessKey.SecretAccessKey,
+		accountId, err := r.getAccountId(ctx, awsAccount.Spec.UserName)
+		if err != nil {
+			log.Error(err, "unable to look up AWS account ID")
+			return r.reconcileFailed(ctx, req, &awsAccount, err)
+		}
+		creds := AccessKeyCredentials{
+			AccessKeyId:     *accessKey.AccessKeyId,
+			SecretAccessKey: *accessKey.SecretAccessKey,
+			Region:          credentials.Region,
+			AccountId:       accountId,
 		}
-		if err := r.createSecretIfNotExists(ctx, secretData, "aws-credentials", awsAccount.Spec.UserName); err != nil {
+		secretData, err := renderCredentials(credentials, creds)
+		if err != nil {
+			log.Error(err, "unable to render AWS credentials")
+			return r.reconcileFailed(ctx, req, &awsAccount, err)
+		}
+		secretRef := credentials.SecretRef
+		// A new key replaces the one in the Secret, which was deleted when this is correcting drift
+		if err := r.createOrUpdateSecret(ctx, &awsAccount, secretData, *secretRef, credentialsSecretType(credentials.Format)); err != nil {
 			log.Error(err, "unable to create secret for AWS credentials")
-			return ctrl.Result{}, err
+			return r.reconcileFailed(ctx, req, &awsAccount, err)
+		}
+		if err := r.writeDNSProviderSecret(ctx, &awsAccount, credentials, creds); err != nil {
+			log.Error(err, "unable to create DNS provider secret")
+			return r.reconcileFailed(ctx, req, &awsAccount, err)
 		}
 		log.V(1).Info("created access key", "accessKeyId", accessKey.AccessKeyId)
 		awsAccount.Status.AccessKeyCreated = true
 	}
+	observeStep(awsAccountControllerName, "access_key", &stepStart)
+
+	if awsAccount.Status.AccessKeyCreated {
+		if err := r.reconcileManagedZone(ctx, &awsAccount, credentials); err != nil {
+			log.Error(err, "unable to reconcile managed zone")
+			return r.reconcileFailed(ctx, req, &awsAccount, err)
+		}
+	}
+	observeStep(awsAccountControllerName, "managed_zone", &stepStart)
 
-	groupsToAddUserTo := slice.GetLeftDifference(awsAccount.Spec.Groups, awsAccount.Status.UserGroups)
+	desiredGroups, err := r.resolveGroups(ctx, &awsAccount)
+	if err != nil {
+		log.Error(err, "unable to resolve group references")
+		return r.reconcileFailed(ctx, req, &awsAccount, err)
+	}
+
+	groupsToAddUserTo := slice.GetLeftDifference(desiredGroups, awsAccount.Status.UserGroups)
 	for _, group := range groupsToAddUserTo {
 		if _, err := r.IamWrapper.AddUserToGroup(ctx, group, awsAccount.Spec.UserName); err != nil {
 			log.Error(err, "unable to add user to group", "groupName", group)
-			return ctrl.Result{}, err
+			return r.reconcileFailed(ctx, req, &awsAccount, err)
 		}
 		log.V(1).Info("Added user to group", "group name:", group)
 		awsAccount.Status.UserGroups = append(awsAccount.Status.UserGroups, group)
 	}
 
-	groupsToRemoveUserFrom := slice.GetLeftDifference(awsAccount.Status.UserGroups, awsAccount.Spec.Groups)
+	groupsToRemoveUserFrom := slice.GetLeftDifference(awsAccount.Status.UserGroups, desiredGroups)
 	for _, group := range groupsToRemoveUserFrom {
 		if _, err := r.IamWrapper.RemoveUserFromGroup(ctx, group, awsAccount.Spec.UserName); err != nil {
 			log.Error(err, "unable to remove user from group", "groupName", group)
-			return ctrl.Result{}, err
+			return r.reconcileFailed(ctx, req, &awsAccount, err)
 		}
 		log.V(1).Info("removed user from group", "groupName", group)
 		awsAccount.Status.UserGroups = slice.Remove(awsAccount.Status.UserGroups, func(g string) bool { return g == group })
 	}
+	observeStep(awsAccountControllerName, "groups", &stepStart)
+
+	if err := r.reconcileUserPolicies(ctx, &awsAccount); err != nil {
+		log.Error(err, "unable to reconcile user policies")
+		return r.reconcileFailed(ctx, req, &awsAccount, err)
+	}
+	observeStep(awsAccountControllerName, "policies", &stepStart)
+
+	r.reportDrift(&awsAccount, recordedDrift)
+	return r.updateStatus(ctx, req, &awsAccount)
+}
+
+// planReconcile runs the reconcile with IAM and Kubernetes writes recorded instead of made,
+// and reports the planned changes in the AwsAccount's status and events
+func (r *AwsAccountReconciler) planReconcile(ctx context.Context, req ctrl.Request, awsAccount *kuadrav1.AwsAccount) (ctrl.Result, error) {
+	p := &plan{}
+	planner := *r
+	planner.planning = true
+	planner.Client = newDryRunClient(r.Client, p, awsAccount)
+	planner.IamWrapper = newDryRunIamWrapper(r.IamWrapper, p)
+	planner.Recorder = nil
+	_, planErr := planner.Reconcile(ctx, req)
+
+	var latest kuadrav1.AwsAccount
+	if err := r.Get(ctx, req.NamespacedName, &latest); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	previous := latest.Status.DeepCopy()
+	latest.Status.PlannedActions = p.actions
+	setDryRunCondition(&latest.Status.Conditions, latest.Generation, p, planErr)
+	if !reflect.DeepEqual(*previous, latest.Status) {
+		if err := r.Status().Update(ctx, &latest); err != nil {
+			log.FromContext(ctx).Error(err, "unable to update awsAccount status")
+			return ctrl.Result{RequeueAfter: time.Second * 3}, err
+		}
+	}
+	reportPlan(r.Recorder, &latest, previous.PlannedActions, p)
+
+	if planErr != nil && !isPermanentError(planErr) {
+		return ctrl.Result{}, planErr
+	}
+	return ctrl.Result{RequeueAfter: r.ResyncInterval}, nil
+}
+
+// updateStatus marks the AwsAccount ready, writes its status if it changed and schedules the next resync
+func (r *AwsAccountReconciler) updateStatus(ctx context.Context, req ctrl.Request, awsAccount *kuadrav1.AwsAccount) (ctrl.Result, error) {
+	awsAccount.Status.Phase = kuadrav1.AwsAccountPhaseReady
+	meta.RemoveStatusCondition(&awsAccount.Status.Conditions, kuadrav1.ConditionDryRun)
+	meta.SetStatusCondition(&awsAccount.Status.Conditions, metav1.Condition{
+		Type:               kuadrav1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             "UserReconciled",
+		ObservedGeneration: awsAccount.Generation,
+	})
 
 	var latest kuadrav1.AwsAccount
 	if err := r.Get(ctx, req.NamespacedName, &latest); err != nil {
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 	if !reflect.DeepEqual(latest.Status, awsAccount.Status) {
-		if err := r.Status().Update(ctx, &awsAccount); err != nil {
-			log.Error(err, "unable to update awsAccount status")
+		if err := r.Status().Update(ctx, awsAccount); err != nil {
+			log.FromContext(ctx).Error(err, "unable to update awsAccount status")
 			return ctrl.Result{RequeueAfter: time.Second * 3}, err
 		}
 	}
 
-	return ctrl.Result{}, nil
+	return ctrl.Result{RequeueAfter: r.ResyncInterval}, nil
+}
+
+// reconcileFailed records a failed reconcile in the phase and Ready condition of the AwsAccount,
+// together with the status of the steps that did succeed, so that the changes already made in
+// IAM are not taken for drift on the next reconcile. Permanent AWS errors are not returned, as
+// retrying them right away cannot succeed; they are tried again on the next resync or when
+// the AwsAccount changes. While planning a dry run the error is handed back to planReconcile.
+func (r *AwsAccountReconciler) reconcileFailed(ctx context.Context, req ctrl.Request, awsAccount *kuadrav1.AwsAccount, reconcileErr error) (ctrl.Result, error) {
+	if r.planning {
+		return ctrl.Result{}, reconcileErr
+	}
+	var latest kuadrav1.AwsAccount
+	if err := r.Get(ctx, req.NamespacedName, &latest); err != nil {
+		return ctrl.Result{}, reconcileErr
+	}
+	latest.Status = *awsAccount.Status.DeepCopy()
+	latest.Status.Phase = kuadrav1.AwsAccountPhaseFailed
+	latest.Status.PlannedActions = nil
+	meta.RemoveStatusCondition(&latest.Status.Conditions, kuadrav1.ConditionDryRun)
+	meta.SetStatusCondition(&latest.Status.Conditions, metav1.Condition{
+		Type:               kuadrav1.ConditionReady,
+		Status:             metav1.ConditionFalse,
+		Reason:             failureReason(reconcileErr),
+		Message:            reconcileErr.Error(),
+		ObservedGeneration: latest.Generation,
+	})
+	if err := r.Status().Update(ctx, &latest); err != nil {
+		log.FromContext(ctx).Error(err, "unable to update awsAccount status")
+	}
+	if isPermanentError(reconcileErr) {
+		log.FromContext(ctx).Error(reconcileErr, "permanent error reconciling awsAccount, waiting for a change or resync")
+		return ctrl.Result{RequeueAfter: r.ResyncInterval}, nil
+	}
+	return ctrl.Result{}, reconcileErr
+}
+
+// failureReason is the reason of the Ready condition of a resource that failed to reconcile
+func failureReason(err error) string {
+	if isOwnershipConflict(err) {
+		return "OwnershipConflict"
+	}
+	if isSecretNotOwned(err) {
+		return "SecretConflict"
+	}
+	if isForeignGroupMembers(err) {
+		return "ForeignMembers"
+	}
+	if isGroupNotOwned(err) {
+		return "GroupNotOwned"
+	}
+	if isPermanentError(err) {
+		return "PermanentError"
+	}
+	return "ReconcileError"
 }
 
 func (r *AwsAccountReconciler) isNamespace(ctx context.Context, namespace string) (bool, error) {
@@ -221,20 +507,215 @@ func (r *AwsAccountReconciler) createNamespaceIfNotExists(ctx context.Context, n
 	return client.IgnoreAlreadyExists(err)
 }
 
-func (r *AwsAccountReconciler) createSecretIfNotExists(ctx context.Context, data map[string]string, name string, namespace string) error {
+// createSecretIfNotExists writes the Secret unless it exists. An existing Secret the
+// AwsAccount does not own is a SecretNotOwnedError rather than being used as it is.
+func (r *AwsAccountReconciler) createSecretIfNotExists(ctx context.Context, awsAccount *kuadrav1.AwsAccount, data map[string]string, ref kuadrav1.SecretReference, secretType v1.SecretType) error {
 	secret := &v1.Secret{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "Secret",
 			APIVersion: "v1",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: namespace,
+			Name:        ref.Name,
+			Namespace:   ref.Namespace,
+			Annotations: map[string]string{SecretOwnerAnnotation: secretOwner(awsAccount)},
 		},
-		StringData: data,
+		Type: secretType,
+		Data: secretData(data),
 	}
 	err := r.Create(ctx, secret)
-	return client.IgnoreAlreadyExists(err)
+	if !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	existing := &v1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: ref.Namespace}, existing); err != nil {
+		return err
+	}
+	if !ownsSecret(awsAccount, existing) {
+		return &SecretNotOwnedError{Namespace: ref.Namespace, Name: ref.Name}
+	}
+	return nil
+}
+
+// createOrUpdateSecret writes the Secret, recreating it when its immutable type has to change.
+// An existing Secret the AwsAccount does not own is a SecretNotOwnedError and left untouched.
+func (r *AwsAccountReconciler) createOrUpdateSecret(ctx context.Context, awsAccount *kuadrav1.AwsAccount, data map[string]string, ref kuadrav1.SecretReference, secretType v1.SecretType) error {
+	existing := &v1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: ref.Namespace}, existing)
+	if client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	if err == nil && !ownsSecret(awsAccount, existing) {
+		return &SecretNotOwnedError{Namespace: ref.Namespace, Name: ref.Name}
+	}
+	if err == nil && existing.Type != secretType {
+		if err := r.Delete(ctx, existing); err != nil {
+			return err
+		}
+	}
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ref.Name,
+			Namespace: ref.Namespace,
+		},
+	}
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		if secret.Annotations == nil {
+			secret.Annotations = map[string]string{}
+		}
+		secret.Annotations[SecretOwnerAnnotation] = secretOwner(awsAccount)
+		secret.Type = secretType
+		secret.Data = secretData(data)
+		return nil
+	})
+	return err
+}
+
+// writeDNSProviderSecret writes the additional Kuadrant DNS provider Secret, if one is requested
+func (r *AwsAccountReconciler) writeDNSProviderSecret(ctx context.Context, awsAccount *kuadrav1.AwsAccount, credentials kuadrav1.CredentialsSpec, creds AccessKeyCredentials) error {
+	if credentials.DNSProviderSecretRef == nil {
+		return nil
+	}
+	providerCredentials := credentials
+	providerCredentials.Format = kuadrav1.CredentialsFormatKuadrant
+	data, err := renderCredentials(providerCredentials, creds)
+	if err != nil {
+		return err
+	}
+	return r.createOrUpdateSecret(ctx, awsAccount, data, *credentials.DNSProviderSecretRef, KuadrantAwsSecretType)
+}
+
+// deleteSecretIfExists removes the Secret if the AwsAccount owns it. A Secret it does
+// not own is left in place, as it was not written by Kuadra or belongs to another AwsAccount.
+func (r *AwsAccountReconciler) deleteSecretIfExists(ctx context.Context, awsAccount *kuadrav1.AwsAccount, ref kuadrav1.SecretReference) error {
+	secret := &v1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: ref.Namespace}, secret); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !ownsSecret(awsAccount, secret) {
+		log.FromContext(ctx).Info("not deleting secret owned by someone else", "secret", ref)
+		return nil
+	}
+	return client.IgnoreNotFound(r.Delete(ctx, secret))
+}
+
+func secretData(data map[string]string) map[string][]byte {
+	bytes := make(map[string][]byte, len(data))
+	for key, value := range data {
+		bytes[key] = []byte(value)
+	}
+	return bytes
+}
+
+// moveCredentials rewrites the existing login and access key Secrets when their target
+// or layout in the spec no longer matches what was last written, removing the old Secrets.
+// Credentials created afterwards are written straight to the desired target.
+func (r *AwsAccountReconciler) moveCredentials(ctx context.Context, awsAccount *kuadrav1.AwsAccount, desired kuadrav1.CredentialsSpec) error {
+	log := log.FromContext(ctx)
+
+	applied := awsAccount.Status.Credentials
+	if applied == nil {
+		legacy := legacyCredentials(awsAccount.Spec.UserName)
+		applied = &legacy
+	}
+	if reflect.DeepEqual(*applied, desired) {
+		awsAccount.Status.Credentials = desired.DeepCopy()
+		return nil
+	}
+
+	if awsAccount.Status.LoginProfileCreated && *applied.LoginSecretRef != *desired.LoginSecretRef {
+		oldSecret := &v1.Secret{}
+		if err := r.Get(ctx, types.NamespacedName{Name: applied.LoginSecretRef.Name, Namespace: applied.LoginSecretRef.Namespace}, oldSecret); err != nil {
+			return fmt.Errorf("unable to read password from %s/%s: %w", applied.LoginSecretRef.Namespace, applied.LoginSecretRef.Name, err)
+		}
+		loginData := map[string]string{}
+		for key, value := range oldSecret.Data {
+			loginData[key] = string(value)
+		}
+		if err := r.createOrUpdateSecret(ctx, awsAccount, loginData, *desired.LoginSecretRef, v1.SecretTypeOpaque); err != nil {
+			return err
+		}
+		if err := r.deleteSecretIfExists(ctx, awsAccount, *applied.LoginSecretRef); err != nil {
+			return err
+		}
+		log.V(1).Info("moved login secret", "from", applied.LoginSecretRef, "to", desired.LoginSecretRef)
+	}
+
+	if awsAccount.Status.AccessKeyCreated {
+		oldSecret := &v1.Secret{}
+		if err := r.Get(ctx, types.NamespacedName{Name: applied.SecretRef.Name, Namespace: applied.SecretRef.Namespace}, oldSecret); err != nil {
+			return fmt.Errorf("unable to read access key from %s/%s: %w", applied.SecretRef.Namespace, applied.SecretRef.Name, err)
+		}
+		creds, err := ParseCredentials(applied.Format, oldSecret.Data)
+		if err != nil {
+			return err
+		}
+		creds.Region = desired.Region
+		if creds.AccountId == "" {
+			if creds.AccountId, err = r.getAccountId(ctx, awsAccount.Spec.UserName); err != nil {
+				return err
+			}
+		}
+		data, err := renderCredentials(desired, creds)
+		if err != nil {
+			return err
+		}
+		if err := r.createOrUpdateSecret(ctx, awsAccount, data, *desired.SecretRef, credentialsSecretType(desired.Format)); err != nil {
+			return err
+		}
+		if *applied.SecretRef != *desired.SecretRef {
+			if err := r.deleteSecretIfExists(ctx, awsAccount, *applied.SecretRef); err != nil {
+				return err
+			}
+		}
+		log.V(1).Info("moved credentials secret", "from", applied.SecretRef, "to", desired.SecretRef, "format", desired.Format)
+
+		if err := r.writeDNSProviderSecret(ctx, awsAccount, desired, creds); err != nil {
+			return err
+		}
+	}
+
+	if applied.DNSProviderSecretRef != nil && (desired.DNSProviderSecretRef == nil || *applied.DNSProviderSecretRef != *desired.DNSProviderSecretRef) {
+		if err := r.deleteSecretIfExists(ctx, awsAccount, *applied.DNSProviderSecretRef); err != nil {
+			return err
+		}
+		log.V(1).Info("removed DNS provider secret", "secret", applied.DNSProviderSecretRef)
+	}
+
+	awsAccount.Status.Credentials = desired.DeepCopy()
+	return nil
+}
+
+// deleteCredentialsSecrets removes the Secrets recorded in status, which may live
+// outside of the user namespace
+func (r *AwsAccountReconciler) deleteCredentialsSecrets(ctx context.Context, awsAccount *kuadrav1.AwsAccount) error {
+	applied := awsAccount.Status.Credentials
+	if applied == nil {
+		return nil
+	}
+	for _, ref := range []*kuadrav1.SecretReference{applied.SecretRef, applied.LoginSecretRef, applied.DNSProviderSecretRef} {
+		if ref == nil {
+			continue
+		}
+		if err := r.deleteSecretIfExists(ctx, awsAccount, *ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getAccountId returns the ID of the AWS account the IAM user belongs to
+func (r *AwsAccountReconciler) getAccountId(ctx context.Context, userName string) (string, error) {
+	user, err := r.IamWrapper.GetUser(ctx, userName)
+	if err != nil || user == nil || user.Arn == nil {
+		return "", err
+	}
+	userArn, err := arn.Parse(*user.Arn)
+	if err != nil {
+		return "", err
+	}
+	return userArn.AccountID, nil
 }
 
 func (r *AwsAccountReconciler) getRefreshedStatus(ctx context.Context, awsAccount kuadrav1.AwsAccount) (*kuadrav1.AwsAccountStatus, error) {
@@ -245,16 +726,31 @@ func (r *AwsAccountReconciler) getRefreshedStatus(ctx context.Context, awsAccoun
 		return nil, err
 	}
 	status.NamespaceCreated = namespaceExists
-
-	userExists, err := r.IamWrapper.IsExistingUser(ctx, awsAccount.Spec.UserName)
+	status.UserName = awsAccount.Status.UserName
+	status.PreviousUserName = awsAccount.Status.PreviousUserName
+	status.Suspended = awsAccount.Status.Suspended
+	status.Credentials = awsAccount.Status.Credentials
+	status.ManagedZone = awsAccount.Status.ManagedZone
+	status.Conditions = awsAccount.Status.Conditions
+	status.Drift = awsAccount.Status.Drift
+	status.TagKeys = awsAccount.Status.TagKeys
+	status.Phase = awsAccount.Status.Phase
+
+	user, err := r.IamWrapper.GetUser(ctx, awsAccount.Spec.UserName)
 	if err != nil {
 		return nil, err
 	}
-	if !userExists {
+	if user == nil {
 		// Return struct with zero values
 		return &status, nil
 	}
 	status.UserCreated = true
+	if user.PermissionsBoundary != nil && user.PermissionsBoundary.PermissionsBoundaryArn != nil {
+		status.PermissionsBoundary = *user.PermissionsBoundary.PermissionsBoundaryArn
+	}
+	if user.Path != nil {
+		status.Path = *user.Path
+	}
 
 	loginProfileExists, err := r.IamWrapper.HasLoginProfile(ctx, awsAccount.Spec.UserName)
 	if err != nil {
@@ -262,11 +758,19 @@ func (r *AwsAccountReconciler) getRefreshedStatus(ctx context.Context, awsAccoun
 	}
 	status.LoginProfileCreated = loginProfileExists
 
-	accessKeyExists, err := r.IamWrapper.HasAccessKey(ctx, awsAccount.Spec.UserName)
+	accessKeys, err := r.IamWrapper.ListAccessKeys(ctx, awsAccount.Spec.UserName)
 	if err != nil {
 		return nil, err
 	}
-	status.AccessKeyCreated = accessKeyExists
+	status.AccessKeyCreated = len(accessKeys) > 0
+	for _, accessKey := range accessKeys {
+		if accessKey.CreateDate == nil {
+			continue
+		}
+		if status.AccessKeyCreationDate == nil || accessKey.CreateDate.Before(status.AccessKeyCreationDate.Time) {
+			status.AccessKeyCreationDate = &metav1.Time{Time: *accessKey.CreateDate}
+		}
+	}
 
 	groups, err := r.IamWrapper.ListGroupsForUser(ctx, awsAccount.Spec.UserName)
 	if err != nil {
@@ -276,9 +780,48 @@ func (r *AwsAccountReconciler) getRefreshedStatus(ctx context.Context, awsAccoun
 		status.UserGroups = append(status.UserGroups, *group.GroupName)
 	}
 
+	attachedPolicies, err := r.IamWrapper.ListAttachedUserPolicies(ctx, awsAccount.Spec.UserName)
+	if err != nil {
+		return nil, err
+	}
+	for _, policy := range attachedPolicies {
+		status.AttachedPolicyArns = append(status.AttachedPolicyArns, *policy.PolicyArn)
+	}
+
+	inlinePolicyNames, err := r.IamWrapper.ListUserPolicies(ctx, awsAccount.Spec.UserName)
+	if err != nil {
+		return nil, err
+	}
+	status.InlinePolicyNames = inlinePolicyNames
+
 	return &status, nil
 }
 
+// reconcileSuspension deletes the login profile and deactivates the access keys of a suspended
+// user. Once it is resumed the access keys are activated again, and the login profile is
+// created again like a missing one.
+func (r *AwsAccountReconciler) reconcileSuspension(ctx context.Context, awsAccount *kuadrav1.AwsAccount) error {
+	if awsAccount.Spec.Suspended {
+		if err := suspendIamUser(ctx, r.IamWrapper, awsAccount.Spec.UserName); err != nil {
+			return err
+		}
+		awsAccount.Status.LoginProfileCreated = false
+		awsAccount.Status.Suspended = true
+		return nil
+	}
+	if !awsAccount.Status.Suspended {
+		return nil
+	}
+	if err := resumeIamUser(ctx, r.IamWrapper, awsAccount.Spec.UserName); err != nil {
+		return err
+	}
+	if err := r.IamWrapper.UntagUser(ctx, awsAccount.Spec.UserName, []string{SuspendedTag}); err != nil {
+		return err
+	}
+	awsAccount.Status.Suspended = false
+	return nil
+}
+
 func (r *AwsAccountReconciler) deleteNamespace(ctx context.Context, namespace string) error {
 	ns := &v1.Namespace{}
 	if err := r.Get(ctx, types.NamespacedName{Name: namespace, Namespace: v1.NamespaceAll}, ns); err != nil {
@@ -287,8 +830,9 @@ func (r *AwsAccountReconciler) deleteNamespace(ctx context.Context, namespace st
 	return r.Delete(ctx, ns)
 }
 
-func (r *AwsAccountReconciler) deleteIamUser(ctx context.Context, userName string) error {
-	userExists, err := r.IamWrapper.IsExistingUser(ctx, userName)
+// deleteIamUser removes the user and everything IAM requires to be removed before it
+func deleteIamUser(ctx context.Context, iamWrapper IamWrapper, userName string) error {
+	userExists, err := iamWrapper.IsExistingUser(ctx, userName)
 	if err != nil {
 		return err
 	}
@@ -296,36 +840,128 @@ func (r *AwsAccountReconciler) deleteIamUser(ctx context.Context, userName strin
 		return nil
 	}
 
-	groups, err := r.IamWrapper.ListGroupsForUser(ctx, userName)
+	groups, err := iamWrapper.ListGroupsForUser(ctx, userName)
 	if err != nil {
 		return err
 	}
 	for _, group := range groups {
-		if _, err := r.IamWrapper.RemoveUserFromGroup(ctx, *group.GroupName, userName); err != nil {
+		if _, err := iamWrapper.RemoveUserFromGroup(ctx, *group.GroupName, userName); err != nil {
+			return err
+		}
+	}
+
+	// IAM refuses to delete a user that still has policies, including ones attached out-of-band
+	attachedPolicies, err := iamWrapper.ListAttachedUserPolicies(ctx, userName)
+	if err != nil {
+		return err
+	}
+	for _, policy := range attachedPolicies {
+		if err := iamWrapper.DetachUserPolicyIfAttached(ctx, userName, *policy.PolicyArn); err != nil {
+			return err
+		}
+	}
+
+	inlinePolicyNames, err := iamWrapper.ListUserPolicies(ctx, userName)
+	if err != nil {
+		return err
+	}
+	for _, policyName := range inlinePolicyNames {
+		if err := iamWrapper.DeleteUserPolicyIfExists(ctx, userName, policyName); err != nil {
 			return err
 		}
 	}
 
-	if err := r.IamWrapper.DeleteLoginProfileIfExists(ctx, userName); err != nil {
+	if err := iamWrapper.DeleteLoginProfileIfExists(ctx, userName); err != nil {
 		return err
 	}
 
-	accessKeys, err := r.IamWrapper.ListAccessKeys(ctx, userName)
+	accessKeys, err := iamWrapper.ListAccessKeys(ctx, userName)
 	if err != nil {
 		return err
 	}
 	for _, accessKey := range accessKeys {
-		if err := r.IamWrapper.DeleteAccessKeyIfExists(ctx, userName, *accessKey.AccessKeyId); err != nil {
+		if err := iamWrapper.DeleteAccessKeyIfExists(ctx, userName, *accessKey.AccessKeyId); err != nil {
 			return err
 		}
 	}
 
-	return r.IamWrapper.DeleteUser(ctx, userName)
+	return iamWrapper.DeleteUser(ctx, userName)
+}
+
+// resolveGroups returns the IAM groups the user should be in: spec.groups plus the groups
+// of every AwsGroup referenced by spec.groupRefs that exists. Missing references are
+// reported in the GroupsResolved condition.
+func (r *AwsAccountReconciler) resolveGroups(ctx context.Context, awsAccount *kuadrav1.AwsAccount) ([]string, error) {
+	groups := append([]string{}, awsAccount.Spec.Groups...)
+	if len(awsAccount.Spec.GroupRefs) == 0 {
+		meta.RemoveStatusCondition(&awsAccount.Status.Conditions, kuadrav1.ConditionGroupsResolved)
+		return groups, nil
+	}
+
+	var missing []string
+	for _, ref := range awsAccount.Spec.GroupRefs {
+		awsGroup := &kuadrav1.AwsGroup{}
+		if err := r.Get(ctx, types.NamespacedName{Name: ref, Namespace: awsAccount.Namespace}, awsGroup); err != nil {
+			if client.IgnoreNotFound(err) != nil {
+				return nil, err
+			}
+			missing = append(missing, ref)
+			continue
+		}
+		if !awsGroup.Status.GroupCreated || awsGroup.DeletionTimestamp != nil {
+			missing = append(missing, ref)
+			continue
+		}
+		if !slice.Contains(groups, awsGroup.Status.GroupName) {
+			groups = append(groups, awsGroup.Status.GroupName)
+		}
+	}
+
+	if len(missing) > 0 {
+		meta.SetStatusCondition(&awsAccount.Status.Conditions, metav1.Condition{
+			Type:               kuadrav1.ConditionGroupsResolved,
+			Status:             metav1.ConditionFalse,
+			Reason:             "GroupNotFound",
+			Message:            fmt.Sprintf("AwsGroups not found or not yet created: %s", strings.Join(missing, ", ")),
+			ObservedGeneration: awsAccount.Generation,
+		})
+	} else {
+		meta.SetStatusCondition(&awsAccount.Status.Conditions, metav1.Condition{
+			Type:               kuadrav1.ConditionGroupsResolved,
+			Status:             metav1.ConditionTrue,
+			Reason:             "GroupsFound",
+			ObservedGeneration: awsAccount.Generation,
+		})
+	}
+	return groups, nil
+}
+
+// awsAccountsForGroup maps an AwsGroup to the AwsAccounts in its namespace that reference it
+func (r *AwsAccountReconciler) awsAccountsForGroup(object client.Object) []reconcile.Request {
+	var awsAccounts kuadrav1.AwsAccountList
+	if err := r.List(context.Background(), &awsAccounts, client.InNamespace(object.GetNamespace())); err != nil {
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, awsAccount := range awsAccounts.Items {
+		if slice.Contains(awsAccount.Spec.GroupRefs, object.GetName()) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: awsAccount.Name, Namespace: awsAccount.Namespace},
+			})
+		}
+	}
+	return requests
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *AwsAccountReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	managedUsersCollector.setClient(mgr.GetClient())
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&kuadrav1.AwsAccount{}).
+		Watches(&source.Kind{Type: &kuadrav1.AwsGroup{}}, handler.EnqueueRequestsFromMapFunc(r.awsAccountsForGroup)).
+		// Only the metadata of ConfigMaps is needed to map them to AwsAccounts, which
+		// keeps the data of every ConfigMap in the cluster out of the cache
+		Watches(&source.Kind{Type: &v1.ConfigMap{}}, handler.EnqueueRequestsFromMapFunc(r.awsAccountsForConfigMap), builder.OnlyMetadata).
+		Watches(&source.Kind{Type: &kuadrav1.AwsPolicyTemplate{}}, handler.EnqueueRequestsFromMapFunc(r.awsAccountsForPolicyTemplate)).
 		Complete(r)
 }
@@ -2,20 +2,30 @@ package controller
 
 import (
 	"context"
-	"errors"
+	"strconv"
+	"strings"
 	"time"
 
 	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
 	slice "github.com/Kuadrant/kuadra/pkg/_internal"
+	kuadraaws "github.com/Kuadrant/kuadra/pkg/aws"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
 	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/smithy-go"
 	"github.com/aws/smithy-go/middleware"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	k8Types "k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
@@ -94,14 +104,27 @@ var _ = Describe("AwsAccount controller", func() {
 				if err != nil {
 					return createdAwsAccount.Status
 				}
-				return createdAwsAccount.Status
+				// Conditions carry transition times and are checked below
+				status := *createdAwsAccount.Status.DeepCopy()
+				status.Conditions = nil
+				return status
 			}, timeout, interval).Should(Equal(kuadrav1.AwsAccountStatus{
+				Phase:               kuadrav1.AwsAccountPhaseReady,
+				UserName:            awsController.Spec.UserName,
 				UserCreated:         true,
 				LoginProfileCreated: true,
 				AccessKeyCreated:    true,
 				UserGroups:          awsController.Spec.Groups,
 				NamespaceCreated:    true,
+				Credentials: &kuadrav1.CredentialsSpec{
+					SecretRef:      &kuadrav1.SecretReference{Name: "aws-credentials", Namespace: "ib-dns"},
+					LoginSecretRef: &kuadrav1.SecretReference{Name: "aws-login", Namespace: "ib-dns"},
+					Format:         kuadrav1.CredentialsFormatEnv,
+					Profile:        "default",
+				},
+				TagKeys: []string{NameTag, NamespaceTag},
 			}))
+			Expect(meta.IsStatusConditionTrue(createdAwsAccount.Status.Conditions, kuadrav1.ConditionReady)).Should(BeTrue())
 
 			By("By checking created user")
 			Expect(mockIam.Users).Should(Equal([]types.User{
@@ -116,23 +139,1268 @@ var _ = Describe("AwsAccount controller", func() {
 				PasswordResetRequired: true,
 			}))
 
-			By("By checking if user has access key")
-			Expect(mockIam.AccessKeys[awsController.Spec.UserName]).Should(Equal([]types.AccessKey{
-				{
-					AccessKeyId:     aws.String("AccessKeyId"),
-					SecretAccessKey: aws.String("SecretAccessKey"),
+			By("By checking if user has access key")
+			Expect(mockIam.AccessKeys[awsController.Spec.UserName]).Should(Equal([]types.AccessKey{
+				{
+					AccessKeyId:     aws.String("AccessKeyId"),
+					SecretAccessKey: aws.String("SecretAccessKey"),
+				},
+			}))
+
+			By("By checking if user has correct groups")
+			Expect(mockIam.Groups[awsController.Spec.UserName]).Should(Equal([]types.Group{
+				{
+					GroupName: &awsController.Spec.Groups[0],
+				},
+				{
+					GroupName: &awsController.Spec.Groups[1],
+				},
+			}))
+		})
+	})
+
+	Context("When changing the credentials target", func() {
+		It("Should move the access key Secret and delete the old one", func() {
+			req := reconcile.Request{
+				NamespacedName: awsAccountLookupKey,
+			}
+
+			client := fake.NewClientBuilder().Build()
+
+			mockIam := mockIamWrapper{
+				Users:        []types.User{},
+				LoginProfile: map[string]types.LoginProfile{},
+				AccessKeys:   map[string][]types.AccessKey{},
+				Groups:       map[string][]types.Group{},
+			}
+
+			awsAccount := awsController.DeepCopy()
+			awsAccount.ResourceVersion = ""
+			Expect(client.Create(ctx, awsAccount)).Should(Succeed())
+
+			r := &AwsAccountReconciler{
+				Client:     client,
+				Scheme:     scheme.Scheme,
+				IamWrapper: &mockIam,
+				Region:     "eu-west-1",
+			}
+
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+
+			By("By checking the default Secret was written")
+			defaultSecret := &corev1.Secret{}
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: "aws-credentials", Namespace: "ib-dns"}, defaultSecret)).Should(Succeed())
+			Expect(string(defaultSecret.Data["AWS_REGION"])).Should(Equal("eu-west-1"))
+
+			By("By pointing the credentials at a new Secret")
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			createdAwsAccount.Spec.Credentials = &kuadrav1.CredentialsSpec{
+				SecretRef: &kuadrav1.SecretReference{Name: "team-credentials", Namespace: AwsAccountNamespace},
+				Format:    kuadrav1.CredentialsFormatIni,
+				Profile:   "ib",
+			}
+			Expect(client.Update(ctx, createdAwsAccount)).Should(Succeed())
+
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+
+			By("By checking the Secret was moved")
+			movedSecret := &corev1.Secret{}
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: "team-credentials", Namespace: AwsAccountNamespace}, movedSecret)).Should(Succeed())
+			Expect(string(movedSecret.Data["credentials"])).Should(Equal("[ib]\n" +
+				"aws_access_key_id = AccessKeyId\n" +
+				"aws_secret_access_key = SecretAccessKey\n" +
+				"region = eu-west-1\n"))
+
+			err = client.Get(ctx, k8Types.NamespacedName{Name: "aws-credentials", Namespace: "ib-dns"}, defaultSecret)
+			Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+
+			By("By checking only one access key was issued")
+			Expect(mockIam.AccessKeys[awsController.Spec.UserName]).Should(HaveLen(1))
+			Expect(movedSecret.Annotations[SecretOwnerAnnotation]).Should(Equal(AwsAccountNamespace + "/" + AwsAccountName))
+		})
+
+		It("Should not write to a Secret it does not own", func() {
+			req := reconcile.Request{NamespacedName: awsAccountLookupKey}
+			client := fake.NewClientBuilder().Build()
+			mockIam := mockIamWrapper{
+				Users:        []types.User{},
+				LoginProfile: map[string]types.LoginProfile{},
+				AccessKeys:   map[string][]types.AccessKey{},
+				Groups:       map[string][]types.Group{},
+			}
+
+			foreign := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "db-password", Namespace: "kube-system"},
+				Data:       map[string][]byte{"password": []byte("hunter2")},
+			}
+			Expect(client.Create(ctx, foreign)).Should(Succeed())
+			awsAccount := awsController.DeepCopy()
+			awsAccount.ResourceVersion = ""
+			awsAccount.Spec.Credentials = &kuadrav1.CredentialsSpec{
+				LoginSecretRef: &kuadrav1.SecretReference{Name: "db-password", Namespace: "kube-system"},
+			}
+			Expect(client.Create(ctx, awsAccount)).Should(Succeed())
+
+			r := &AwsAccountReconciler{Client: client, Scheme: scheme.Scheme, IamWrapper: &mockIam}
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(createdAwsAccount.Status.Phase).Should(Equal(kuadrav1.AwsAccountPhaseFailed))
+			ready := meta.FindStatusCondition(createdAwsAccount.Status.Conditions, kuadrav1.ConditionReady)
+			Expect(ready.Reason).Should(Equal("SecretConflict"))
+			Expect(mockIam.LoginProfile).Should(BeEmpty())
+
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: "db-password", Namespace: "kube-system"}, foreign)).Should(Succeed())
+			Expect(foreign.Data).Should(Equal(map[string][]byte{"password": []byte("hunter2")}))
+			Expect(foreign.Annotations).ShouldNot(HaveKey(SecretOwnerAnnotation))
+		})
+
+		It("Should not delete an old Secret it no longer owns", func() {
+			req := reconcile.Request{NamespacedName: awsAccountLookupKey}
+			client := fake.NewClientBuilder().Build()
+			mockIam := mockIamWrapper{
+				Users:        []types.User{},
+				LoginProfile: map[string]types.LoginProfile{},
+				AccessKeys:   map[string][]types.AccessKey{},
+				Groups:       map[string][]types.Group{},
+			}
+
+			awsAccount := awsController.DeepCopy()
+			awsAccount.ResourceVersion = ""
+			awsAccount.Spec.Credentials = &kuadrav1.CredentialsSpec{
+				DNSProviderSecretRef: &kuadrav1.SecretReference{Name: "aws-dns-provider", Namespace: AwsAccountNamespace},
+			}
+			Expect(client.Create(ctx, awsAccount)).Should(Succeed())
+
+			r := &AwsAccountReconciler{Client: client, Scheme: scheme.Scheme, IamWrapper: &mockIam, Region: "us-east-1"}
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+
+			By("By handing the DNS provider Secret over to someone else")
+			providerKey := k8Types.NamespacedName{Name: "aws-dns-provider", Namespace: AwsAccountNamespace}
+			provider := &corev1.Secret{}
+			Expect(client.Get(ctx, providerKey, provider)).Should(Succeed())
+			delete(provider.Annotations, SecretOwnerAnnotation)
+			Expect(client.Update(ctx, provider)).Should(Succeed())
+
+			By("By no longer requesting a DNS provider Secret")
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			createdAwsAccount.Spec.Credentials = nil
+			Expect(client.Update(ctx, createdAwsAccount)).Should(Succeed())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+
+			Expect(client.Get(ctx, providerKey, provider)).Should(Succeed())
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(createdAwsAccount.Status.Credentials.DNSProviderSecretRef).Should(BeNil())
+		})
+	})
+
+	Context("When requesting a Kuadrant DNS provider Secret", func() {
+		It("Should write a kuadrant.io/aws Secret next to the access key Secret", func() {
+			client := fake.NewClientBuilder().Build()
+
+			mockIam := mockIamWrapper{
+				Users:        []types.User{},
+				LoginProfile: map[string]types.LoginProfile{},
+				AccessKeys:   map[string][]types.AccessKey{},
+				Groups:       map[string][]types.Group{},
+			}
+
+			awsAccount := awsController.DeepCopy()
+			awsAccount.ResourceVersion = ""
+			awsAccount.Spec.Credentials = &kuadrav1.CredentialsSpec{
+				DNSProviderSecretRef: &kuadrav1.SecretReference{Name: "aws-dns-provider"},
+			}
+			Expect(client.Create(ctx, awsAccount)).Should(Succeed())
+
+			r := &AwsAccountReconciler{
+				Client:     client,
+				Scheme:     scheme.Scheme,
+				IamWrapper: &mockIam,
+				Region:     "us-east-1",
+			}
+
+			_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: awsAccountLookupKey})
+			Expect(err).Should(BeNil())
+
+			providerSecret := &corev1.Secret{}
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: "aws-dns-provider", Namespace: "ib-dns"}, providerSecret)).Should(Succeed())
+			Expect(providerSecret.Type).Should(Equal(KuadrantAwsSecretType))
+			Expect(providerSecret.Data).Should(Equal(map[string][]byte{
+				"AWS_ACCESS_KEY_ID":     []byte("AccessKeyId"),
+				"AWS_SECRET_ACCESS_KEY": []byte("SecretAccessKey"),
+				"AWS_REGION":            []byte("us-east-1"),
+			}))
+
+			credentialsSecret := &corev1.Secret{}
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: "aws-credentials", Namespace: "ib-dns"}, credentialsSecret)).Should(Succeed())
+			Expect(credentialsSecret.Type).Should(Equal(corev1.SecretTypeOpaque))
+		})
+	})
+
+	Context("When referencing AwsGroups", func() {
+		It("Should report missing groups and add the user once they exist", func() {
+			req := reconcile.Request{
+				NamespacedName: awsAccountLookupKey,
+			}
+
+			client := fake.NewClientBuilder().Build()
+
+			mockIam := mockIamWrapper{
+				Users:        []types.User{},
+				LoginProfile: map[string]types.LoginProfile{},
+				AccessKeys:   map[string][]types.AccessKey{},
+				Groups:       map[string][]types.Group{},
+			}
+
+			awsAccount := awsController.DeepCopy()
+			awsAccount.ResourceVersion = ""
+			awsAccount.Spec.Groups = nil
+			awsAccount.Spec.GroupRefs = []string{"dns-admins"}
+			Expect(client.Create(ctx, awsAccount)).Should(Succeed())
+
+			r := &AwsAccountReconciler{
+				Client:     client,
+				Scheme:     scheme.Scheme,
+				IamWrapper: &mockIam,
+			}
+
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+
+			By("By checking the GroupsResolved condition")
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			condition := meta.FindStatusCondition(createdAwsAccount.Status.Conditions, kuadrav1.ConditionGroupsResolved)
+			Expect(condition).ShouldNot(BeNil())
+			Expect(condition.Status).Should(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).Should(Equal("GroupNotFound"))
+			Expect(mockIam.Groups[awsController.Spec.UserName]).Should(BeEmpty())
+
+			By("By creating the referenced AwsGroup")
+			awsGroup := &kuadrav1.AwsGroup{
+				ObjectMeta: metav1.ObjectMeta{Name: "dns-admins", Namespace: AwsAccountNamespace},
+				Spec:       kuadrav1.AwsGroupSpec{GroupName: "DnsAdmins"},
+			}
+			Expect(client.Create(ctx, awsGroup)).Should(Succeed())
+			groupReconciler := &AwsGroupReconciler{
+				Client:     client,
+				Scheme:     scheme.Scheme,
+				IamWrapper: &mockIam,
+			}
+			_, err = groupReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: k8Types.NamespacedName{Name: "dns-admins", Namespace: AwsAccountNamespace},
+			})
+			Expect(err).Should(BeNil())
+
+			Expect(r.awsAccountsForGroup(awsGroup)).Should(Equal([]reconcile.Request{req}))
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(meta.IsStatusConditionTrue(createdAwsAccount.Status.Conditions, kuadrav1.ConditionGroupsResolved)).Should(BeTrue())
+			Expect(createdAwsAccount.Status.UserGroups).Should(Equal([]string{"DnsAdmins"}))
+		})
+	})
+
+	Context("When assigning a hosted zone", func() {
+		It("Should create a ManagedZone referencing a DNS provider Secret", func() {
+			client := fake.NewClientBuilder().Build()
+
+			mockIam := mockIamWrapper{
+				Users:        []types.User{},
+				LoginProfile: map[string]types.LoginProfile{},
+				AccessKeys:   map[string][]types.AccessKey{},
+				Groups:       map[string][]types.Group{},
+			}
+
+			awsAccount := awsController.DeepCopy()
+			awsAccount.ResourceVersion = ""
+			awsAccount.Spec.HostedZone = &kuadrav1.HostedZoneSpec{
+				ID:                "Z0123456789",
+				DomainName:        "ib-dns.example.com",
+				CreateManagedZone: true,
+			}
+			Expect(client.Create(ctx, awsAccount)).Should(Succeed())
+
+			r := &AwsAccountReconciler{
+				Client:     client,
+				Scheme:     scheme.Scheme,
+				IamWrapper: &mockIam,
+				Region:     "us-east-1",
+			}
+
+			_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: awsAccountLookupKey})
+			Expect(err).Should(BeNil())
+
+			By("By checking the ManagedZone spec")
+			managedZone := &unstructured.Unstructured{}
+			managedZone.SetGroupVersionKind(ManagedZoneGVK)
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: "ib-dns.example.com", Namespace: "ib-dns"}, managedZone)).Should(Succeed())
+			spec, _, _ := unstructured.NestedMap(managedZone.Object, "spec")
+			Expect(spec["id"]).Should(Equal("Z0123456789"))
+			Expect(spec["domainName"]).Should(Equal("ib-dns.example.com"))
+			Expect(spec["dnsProviderSecretRef"]).Should(Equal(map[string]interface{}{"name": DefaultDNSProviderSecretName}))
+
+			By("By checking the provider Secret exists")
+			providerSecret := &corev1.Secret{}
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: DefaultDNSProviderSecretName, Namespace: "ib-dns"}, providerSecret)).Should(Succeed())
+			Expect(providerSecret.Type).Should(Equal(KuadrantAwsSecretType))
+
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(createdAwsAccount.Status.ManagedZone).Should(Equal("ib-dns.example.com"))
+		})
+	})
+
+	Context("When attaching policies to the user", func() {
+		It("Should attach, revert drift and clean up the user policies", func() {
+			req := reconcile.Request{NamespacedName: awsAccountLookupKey}
+			client := fake.NewClientBuilder().Build()
+
+			mockIam := mockIamWrapper{
+				Users:        []types.User{},
+				LoginProfile: map[string]types.LoginProfile{},
+				AccessKeys:   map[string][]types.AccessKey{},
+				Groups:       map[string][]types.Group{},
+			}
+
+			readOnlyArn := "arn:aws:iam::aws:policy/AmazonRoute53ReadOnlyAccess"
+			zoneDocument := `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"route53:ChangeResourceRecordSets","Resource":"arn:aws:route53:::hostedzone/Z0123456789"}]}`
+			configMap := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "policies", Namespace: AwsAccountNamespace},
+				Data:       map[string]string{"zone.json": zoneDocument},
+			}
+			Expect(client.Create(ctx, configMap)).Should(Succeed())
+
+			awsAccount := awsController.DeepCopy()
+			awsAccount.ResourceVersion = ""
+			awsAccount.Spec.ManagedPolicyArns = []string{readOnlyArn}
+			awsAccount.Spec.InlinePolicies = map[string]kuadrav1.InlinePolicy{
+				"zone": {ConfigMapKeyRef: &kuadrav1.ConfigMapKeyReference{Name: "policies", Key: "zone.json"}},
+			}
+			Expect(client.Create(ctx, awsAccount)).Should(Succeed())
+
+			r := &AwsAccountReconciler{
+				Client:     client,
+				Scheme:     scheme.Scheme,
+				IamWrapper: &mockIam,
+				APIReader:  client,
+			}
+
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+
+			userName := awsController.Spec.UserName
+			Expect(mockIam.UserPolicies[userName].AttachedPolicyArns).Should(Equal([]string{readOnlyArn}))
+			Expect(mockIam.UserPolicies[userName].InlinePolicies).Should(Equal(map[string]string{"zone": zoneDocument}))
+			Expect(r.awsAccountsForConfigMap(configMap)).Should(Equal([]reconcile.Request{req}))
+
+			By("By mapping ConfigMaps watched as metadata only")
+			configMapMetadata := &metav1.PartialObjectMetadata{ObjectMeta: configMap.ObjectMeta}
+			configMapMetadata.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("ConfigMap"))
+			Expect(r.awsAccountsForConfigMap(configMapMetadata)).Should(Equal([]reconcile.Request{req}))
+
+			By("By reverting policies changed out-of-band")
+			mockIam.UserPolicies[userName].AttachedPolicyArns = []string{"arn:aws:iam::aws:policy/AdministratorAccess"}
+			mockIam.UserPolicies[userName].InlinePolicies["extra"] = `{"Version":"2012-10-17","Statement":[]}`
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(mockIam.UserPolicies[userName].AttachedPolicyArns).Should(Equal([]string{readOnlyArn}))
+			Expect(mockIam.UserPolicies[userName].InlinePolicies).Should(Equal(map[string]string{"zone": zoneDocument}))
+
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(createdAwsAccount.Status.AttachedPolicyArns).Should(Equal([]string{readOnlyArn}))
+			Expect(createdAwsAccount.Status.InlinePolicyNames).Should(Equal([]string{"zone"}))
+
+			By("By removing the policies before deleting the user")
+			Expect(client.Delete(ctx, createdAwsAccount)).Should(Succeed())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(mockIam.UserPolicies[userName].AttachedPolicyArns).Should(BeEmpty())
+			Expect(mockIam.UserPolicies[userName].InlinePolicies).Should(BeEmpty())
+			Expect(mockIam.Users).Should(BeEmpty())
+		})
+	})
+
+	Context("When rendering inline policies from an AwsPolicyTemplate", func() {
+		It("Should substitute the user's variables and re-render when the template changes", func() {
+			req := reconcile.Request{NamespacedName: awsAccountLookupKey}
+			client := fake.NewClientBuilder().Build()
+
+			mockIam := mockIamWrapper{
+				Users:        []types.User{},
+				LoginProfile: map[string]types.LoginProfile{},
+				AccessKeys:   map[string][]types.AccessKey{},
+				Groups:       map[string][]types.Group{},
+			}
+
+			policyTemplate := &kuadrav1.AwsPolicyTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: "zone-records", Namespace: AwsAccountNamespace},
+				Spec: kuadrav1.AwsPolicyTemplateSpec{
+					Document: `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"s3:GetObject",` +
+						`"Resource":"arn:aws:s3:::{{ .Labels.team }}-${namespace}/${aws:username}/*"},` +
+						`{"Effect":"Allow","Action":"route53:ChangeResourceRecordSets","Resource":"arn:aws:route53:::hostedzone/${hostedZoneId}"}]}`,
+				},
+			}
+			Expect(client.Create(ctx, policyTemplate)).Should(Succeed())
+
+			awsAccount := awsController.DeepCopy()
+			awsAccount.ResourceVersion = ""
+			awsAccount.Labels = map[string]string{"team": "dns"}
+			awsAccount.Spec.HostedZone = &kuadrav1.HostedZoneSpec{ID: "Z0123456789", DomainName: "ib-dns.example.com"}
+			awsAccount.Spec.InlinePolicies = map[string]kuadrav1.InlinePolicy{
+				"zone": {TemplateRef: &kuadrav1.PolicyTemplateReference{Name: "zone-records"}},
+			}
+			Expect(client.Create(ctx, awsAccount)).Should(Succeed())
+
+			r := &AwsAccountReconciler{
+				Client:     client,
+				Scheme:     scheme.Scheme,
+				IamWrapper: &mockIam,
+			}
+
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+
+			userName := awsController.Spec.UserName
+			Expect(mockIam.UserPolicies[userName].InlinePolicies["zone"]).Should(Equal(
+				`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"s3:GetObject",` +
+					`"Resource":"arn:aws:s3:::dns-ib-dns/${aws:username}/*"},` +
+					`{"Effect":"Allow","Action":"route53:ChangeResourceRecordSets","Resource":"arn:aws:route53:::hostedzone/Z0123456789"}]}`))
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(meta.IsStatusConditionTrue(createdAwsAccount.Status.Conditions, kuadrav1.ConditionPoliciesResolved)).Should(BeTrue())
+
+			By("By re-rendering after the template changes")
+			Expect(r.awsAccountsForPolicyTemplate(policyTemplate)).Should(Equal([]reconcile.Request{req}))
+			policyTemplate.Spec.Document = `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"iam:GetUser","Resource":"arn:aws:iam::${accountId}:user/${userName}"}]}`
+			Expect(client.Update(ctx, policyTemplate)).Should(Succeed())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(mockIam.UserPolicies[userName].InlinePolicies["zone"]).Should(ContainSubstring(":user/ib-dns"))
+
+			By("By reporting a template that cannot be rendered")
+			policyTemplate.Spec.Document = `{"Resource":"${labels.missing}"}`
+			Expect(client.Update(ctx, policyTemplate)).Should(Succeed())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			condition := meta.FindStatusCondition(createdAwsAccount.Status.Conditions, kuadrav1.ConditionPoliciesResolved)
+			Expect(condition).ShouldNot(BeNil())
+			Expect(condition.Status).Should(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).Should(Equal("InvalidPolicy"))
+			Expect(mockIam.UserPolicies[userName].InlinePolicies["zone"]).Should(ContainSubstring(":user/ib-dns"))
+
+			By("By rejecting documents over the IAM size limit")
+			policyTemplate.Spec.Document = `{"Sid":"` + strings.Repeat("a", maxUserInlinePoliciesSize) + `"}`
+			Expect(client.Update(ctx, policyTemplate)).Should(Succeed())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			condition = meta.FindStatusCondition(createdAwsAccount.Status.Conditions, kuadrav1.ConditionPoliciesResolved)
+			Expect(condition.Message).Should(ContainSubstring("exceeding the IAM limit"))
+		})
+	})
+
+	Context("When a permissions boundary is configured", func() {
+		It("Should create the user with the boundary and put it back when it drifts", func() {
+			req := reconcile.Request{NamespacedName: awsAccountLookupKey}
+			client := fake.NewClientBuilder().Build()
+
+			mockIam := mockIamWrapper{
+				Users:        []types.User{},
+				LoginProfile: map[string]types.LoginProfile{},
+				AccessKeys:   map[string][]types.AccessKey{},
+				Groups:       map[string][]types.Group{},
+			}
+
+			clusterBoundary := "arn:aws:iam::123456789012:policy/kuadra-boundary"
+			teamBoundary := "arn:aws:iam::123456789012:policy/team-boundary"
+			Expect(client.Create(ctx, &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        AwsAccountNamespace,
+					Annotations: map[string]string{PermissionsBoundaryAnnotation: teamBoundary},
+				},
+			})).Should(Succeed())
+
+			awsAccount := awsController.DeepCopy()
+			awsAccount.ResourceVersion = ""
+			awsAccount.Spec.PermissionsBoundary = "arn:aws:iam::aws:policy/AdministratorAccess"
+			Expect(client.Create(ctx, awsAccount)).Should(Succeed())
+
+			r := &AwsAccountReconciler{
+				Client:              client,
+				Scheme:              scheme.Scheme,
+				IamWrapper:          &mockIam,
+				PermissionsBoundary: clusterBoundary,
+			}
+
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+
+			By("By using the namespace boundary and ignoring the spec")
+			Expect(*mockIam.Users[0].PermissionsBoundary.PermissionsBoundaryArn).Should(Equal(teamBoundary))
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(createdAwsAccount.Status.PermissionsBoundary).Should(Equal(teamBoundary))
+			condition := meta.FindStatusCondition(createdAwsAccount.Status.Conditions, kuadrav1.ConditionPermissionsBoundary)
+			Expect(condition).ShouldNot(BeNil())
+			Expect(condition.Status).Should(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).Should(Equal("OverrideNotAllowed"))
+
+			By("By putting the boundary back after it was changed out-of-band")
+			mockIam.Users[0].PermissionsBoundary.PermissionsBoundaryArn = aws.String(clusterBoundary)
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(*mockIam.Users[0].PermissionsBoundary.PermissionsBoundaryArn).Should(Equal(teamBoundary))
+
+			By("By honouring the spec once overrides are allowed")
+			r.AllowPermissionsBoundaryOverride = true
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(*mockIam.Users[0].PermissionsBoundary.PermissionsBoundaryArn).Should(Equal(awsAccount.Spec.PermissionsBoundary))
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(meta.IsStatusConditionTrue(createdAwsAccount.Status.Conditions, kuadrav1.ConditionPermissionsBoundary)).Should(BeTrue())
+
+			By("By removing the boundary once none is configured")
+			createdAwsAccount.Spec.PermissionsBoundary = ""
+			Expect(client.Update(ctx, createdAwsAccount)).Should(Succeed())
+			namespace := &corev1.Namespace{}
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: AwsAccountNamespace}, namespace)).Should(Succeed())
+			delete(namespace.Annotations, PermissionsBoundaryAnnotation)
+			Expect(client.Update(ctx, namespace)).Should(Succeed())
+			r.PermissionsBoundary = ""
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(mockIam.Users[0].PermissionsBoundary).Should(BeNil())
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(createdAwsAccount.Status.PermissionsBoundary).Should(BeEmpty())
+			Expect(meta.FindStatusCondition(createdAwsAccount.Status.Conditions, kuadrav1.ConditionPermissionsBoundary)).Should(BeNil())
+		})
+	})
+
+	Context("When an AwsAccount is suspended", func() {
+		It("Should lock the user out and let it back in once resumed", func() {
+			req := reconcile.Request{NamespacedName: awsAccountLookupKey}
+			client := fake.NewClientBuilder().Build()
+
+			mockIam := mockIamWrapper{
+				Users:        []types.User{},
+				LoginProfile: map[string]types.LoginProfile{},
+				AccessKeys:   map[string][]types.AccessKey{},
+				Groups:       map[string][]types.Group{},
+			}
+
+			awsAccount := awsController.DeepCopy()
+			awsAccount.ResourceVersion = ""
+			Expect(client.Create(ctx, awsAccount)).Should(Succeed())
+
+			r := &AwsAccountReconciler{
+				Client:     client,
+				Scheme:     scheme.Scheme,
+				IamWrapper: &mockIam,
+			}
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(mockIam.LoginProfile).Should(HaveKey(awsAccount.Spec.UserName))
+			Expect(mockIam.AccessKeys[awsAccount.Spec.UserName]).Should(HaveLen(1))
+
+			By("By deleting the login profile and deactivating the access key")
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			createdAwsAccount.Spec.Suspended = true
+			Expect(client.Update(ctx, createdAwsAccount)).Should(Succeed())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(mockIam.Users).Should(HaveLen(1))
+			Expect(mockIam.LoginProfile).ShouldNot(HaveKey(awsAccount.Spec.UserName))
+			Expect(mockIam.AccessKeys[awsAccount.Spec.UserName]).Should(HaveLen(1))
+			Expect(mockIam.AccessKeys[awsAccount.Spec.UserName][0].Status).Should(Equal(types.StatusTypeInactive))
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(createdAwsAccount.Status.Suspended).Should(BeTrue())
+			Expect(createdAwsAccount.Status.Drift).Should(BeEmpty())
+
+			By("By keeping it locked out on the next reconcile")
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(mockIam.LoginProfile).ShouldNot(HaveKey(awsAccount.Spec.UserName))
+			Expect(mockIam.AccessKeys[awsAccount.Spec.UserName]).Should(HaveLen(1))
+
+			By("By creating the login profile and activating the access key once resumed")
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			createdAwsAccount.Spec.Suspended = false
+			Expect(client.Update(ctx, createdAwsAccount)).Should(Succeed())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(mockIam.LoginProfile).Should(HaveKey(awsAccount.Spec.UserName))
+			Expect(mockIam.AccessKeys[awsAccount.Spec.UserName]).Should(HaveLen(1))
+			Expect(mockIam.AccessKeys[awsAccount.Spec.UserName][0].Status).Should(Equal(types.StatusTypeActive))
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(createdAwsAccount.Status.Suspended).Should(BeFalse())
+		})
+
+		It("Should resume a user the orphan collector suspended when taking it over", func() {
+			req := reconcile.Request{NamespacedName: awsAccountLookupKey}
+			client := fake.NewClientBuilder().Build()
+
+			mockIam := mockIamWrapper{
+				Users:        []types.User{},
+				LoginProfile: map[string]types.LoginProfile{},
+				AccessKeys:   map[string][]types.AccessKey{},
+				Groups:       map[string][]types.Group{},
+			}
+			userName := awsController.Spec.UserName
+			Expect(mockIam.CreateUserIfNotExists(ctx, userName, kuadraaws.UserOptions{Tags: map[string]string{SuspendedTag: "true"}})).Should(Succeed())
+			_, err := mockIam.CreateAccessKeyPair(ctx, userName)
+			Expect(err).ShouldNot(HaveOccurred())
+			mockIam.AccessKeys[userName][0].Status = types.StatusTypeInactive
+
+			awsAccount := awsController.DeepCopy()
+			awsAccount.ResourceVersion = ""
+			Expect(client.Create(ctx, awsAccount)).Should(Succeed())
+
+			r := &AwsAccountReconciler{
+				Client:     client,
+				Scheme:     scheme.Scheme,
+				IamWrapper: &mockIam,
+			}
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(mockIam.AccessKeys[userName][0].Status).Should(Equal(types.StatusTypeActive))
+			Expect(mockIam.LoginProfile).Should(HaveKey(userName))
+			Expect(mockIam.UserTags[userName]).ShouldNot(HaveKey(SuspendedTag))
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(createdAwsAccount.Status.Suspended).Should(BeFalse())
+		})
+	})
+
+	Context("When propagating AwsAccount metadata to the IAM user", func() {
+		It("Should set the user path and keep the allowed tags in sync", func() {
+			req := reconcile.Request{NamespacedName: awsAccountLookupKey}
+			client := fake.NewClientBuilder().Build()
+
+			mockIam := mockIamWrapper{
+				Users:        []types.User{},
+				LoginProfile: map[string]types.LoginProfile{},
+				AccessKeys:   map[string][]types.AccessKey{},
+				Groups:       map[string][]types.Group{},
+			}
+
+			awsAccount := awsController.DeepCopy()
+			awsAccount.ResourceVersion = ""
+			awsAccount.Labels = map[string]string{"team": "dns", "unrelated": "ignored"}
+			awsAccount.Annotations = map[string]string{"owner-email": "dns-team@example.com"}
+			Expect(client.Create(ctx, awsAccount)).Should(Succeed())
+
+			r := &AwsAccountReconciler{
+				Client:         client,
+				Scheme:         scheme.Scheme,
+				IamWrapper:     &mockIam,
+				UserPathPrefix: "/kuadra/",
+				TagKeys:        []string{"team", "cost-centre", "owner-email"},
+			}
+
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+
+			userName := awsController.Spec.UserName
+			Expect(*mockIam.Users[0].Path).Should(Equal("/kuadra/default/"))
+			Expect(mockIam.UserTags[userName]).Should(Equal(map[string]string{
+				NamespaceTag:  AwsAccountNamespace,
+				NameTag:       AwsAccountName,
+				"team":        "dns",
+				"owner-email": "dns-team@example.com",
+			}))
+
+			By("By updating tags when the metadata changes")
+			mockIam.UserTags[userName]["external"] = "kept"
+			mockIam.Users[0].Path = aws.String("/")
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			createdAwsAccount.Labels = map[string]string{"cost-centre": "1234"}
+			Expect(client.Update(ctx, createdAwsAccount)).Should(Succeed())
+
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(*mockIam.Users[0].Path).Should(Equal("/kuadra/default/"))
+			Expect(mockIam.UserTags[userName]).Should(Equal(map[string]string{
+				NamespaceTag:  AwsAccountNamespace,
+				NameTag:       AwsAccountName,
+				"cost-centre": "1234",
+				"owner-email": "dns-team@example.com",
+				"external":    "kept",
+			}))
+
+			By("By skipping values IAM does not accept")
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			createdAwsAccount.Annotations["owner-email"] = "dns-team@example.com, ops@example.com"
+			Expect(client.Update(ctx, createdAwsAccount)).Should(Succeed())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(mockIam.UserTags[userName]).ShouldNot(HaveKey("owner-email"))
+
+			By("By untagging keys that are no longer copied")
+			r.TagKeys = []string{"team"}
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(mockIam.UserTags[userName]).Should(Equal(map[string]string{
+				NamespaceTag: AwsAccountNamespace,
+				NameTag:      AwsAccountName,
+				"external":   "kept",
+			}))
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(createdAwsAccount.Status.TagKeys).Should(Equal([]string{NameTag, NamespaceTag}))
+		})
+	})
+
+	Context("When IAM is changed outside of Kuadra", func() {
+		It("Should record, report and correct the drift", func() {
+			req := reconcile.Request{NamespacedName: awsAccountLookupKey}
+			client := fake.NewClientBuilder().Build()
+
+			mockIam := mockIamWrapper{
+				Users:        []types.User{},
+				LoginProfile: map[string]types.LoginProfile{},
+				AccessKeys:   map[string][]types.AccessKey{},
+				Groups:       map[string][]types.Group{},
+			}
+
+			awsAccount := awsController.DeepCopy()
+			awsAccount.ResourceVersion = ""
+			Expect(client.Create(ctx, awsAccount)).Should(Succeed())
+
+			recorder := record.NewFakeRecorder(10)
+			r := &AwsAccountReconciler{
+				Client:         client,
+				Scheme:         scheme.Scheme,
+				IamWrapper:     &mockIam,
+				ResyncInterval: time.Minute,
+				Recorder:       recorder,
+			}
+
+			result, err := r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(result.RequeueAfter).Should(Equal(time.Minute))
+			Expect(recorder.Events).Should(BeEmpty())
+
+			By("By adding the user back to a group it was removed from")
+			userName := awsController.Spec.UserName
+			_, err = mockIam.RemoveUserFromGroup(ctx, "test-group", userName)
+			Expect(err).Should(BeNil())
+
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(mockIam.Groups[userName]).Should(HaveLen(2))
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(createdAwsAccount.Status.Drift).Should(HaveLen(1))
+			Expect(createdAwsAccount.Status.Drift[0].Type).Should(Equal(kuadrav1.DriftGroupRemoved))
+			Expect(createdAwsAccount.Status.Drift[0].Subject).Should(Equal("test-group"))
+			Expect(createdAwsAccount.Status.Drift[0].Corrected).Should(BeTrue())
+			Expect(recorder.Events).Should(Receive(ContainSubstring("Drift GroupRemoved test-group")))
+
+			By("By only reporting drift in report only mode")
+			r.DriftReportOnly = true
+			delete(mockIam.LoginProfile, userName)
+
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(mockIam.LoginProfile).ShouldNot(HaveKey(userName))
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(createdAwsAccount.Status.Drift).Should(HaveLen(2))
+			Expect(createdAwsAccount.Status.Drift[1].Type).Should(Equal(kuadrav1.DriftLoginProfileDeleted))
+			Expect(createdAwsAccount.Status.Drift[1].Corrected).Should(BeFalse())
+			Expect(recorder.Events).Should(Receive(ContainSubstring("not corrected")))
+			Expect(recorder.Events).ShouldNot(Receive())
+
+			By("By dropping reported drift once it is resolved")
+			mockIam.LoginProfile[userName] = types.LoginProfile{UserName: &userName}
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(createdAwsAccount.Status.Drift).Should(HaveLen(1))
+
+			By("By writing the key issued for a deleted access key to the Secret")
+			r.DriftReportOnly = false
+			secretKey := k8Types.NamespacedName{Name: "aws-credentials", Namespace: userName}
+			secret := &corev1.Secret{}
+			Expect(client.Get(ctx, secretKey, secret)).Should(Succeed())
+			secret.Data["AWS_ACCESS_KEY_ID"] = []byte("DeletedKeyId")
+			Expect(client.Update(ctx, secret)).Should(Succeed())
+			delete(mockIam.AccessKeys, userName)
+
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(mockIam.AccessKeys[userName]).Should(HaveLen(1))
+			Expect(client.Get(ctx, secretKey, secret)).Should(Succeed())
+			Expect(string(secret.Data["AWS_ACCESS_KEY_ID"])).Should(Equal("AccessKeyId"))
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(createdAwsAccount.Status.Drift).Should(HaveLen(2))
+			Expect(createdAwsAccount.Status.Drift[1].Type).Should(Equal(kuadrav1.DriftAccessKeyDeleted))
+			Expect(createdAwsAccount.Status.Drift[1].Corrected).Should(BeTrue())
+		})
+	})
+
+	Context("When collecting metrics", func() {
+		It("Should report the phase of each AwsAccount and the age of access keys", func() {
+			req := reconcile.Request{NamespacedName: awsAccountLookupKey}
+			client := fake.NewClientBuilder().Build()
+
+			userName := awsController.Spec.UserName
+			createDate := time.Now().Add(-48 * time.Hour)
+			mockIam := mockIamWrapper{
+				Users:        []types.User{{UserName: &userName}},
+				LoginProfile: map[string]types.LoginProfile{},
+				AccessKeys: map[string][]types.AccessKey{
+					userName: {{AccessKeyId: aws.String("AccessKeyId"), CreateDate: &createDate}},
+				},
+				Groups: map[string][]types.Group{},
+			}
+
+			awsAccount := awsController.DeepCopy()
+			awsAccount.ResourceVersion = ""
+			awsAccount.Spec.Credentials = &kuadrav1.CredentialsSpec{Format: "unsupported"}
+			Expect(client.Create(ctx, awsAccount)).Should(Succeed())
+			Expect(client.Create(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: DefaultCredentialsSecretName, Namespace: userName},
+				Data: map[string][]byte{
+					"AWS_ACCESS_KEY_ID":     []byte("AccessKeyId"),
+					"AWS_SECRET_ACCESS_KEY": []byte("SecretAccessKey"),
+				},
+			})).Should(Succeed())
+			pending := &kuadrav1.AwsAccount{ObjectMeta: metav1.ObjectMeta{Name: "pending", Namespace: AwsAccountNamespace}}
+			Expect(client.Create(ctx, pending)).Should(Succeed())
+
+			r := &AwsAccountReconciler{
+				Client:     client,
+				Scheme:     scheme.Scheme,
+				IamWrapper: &mockIam,
+			}
+			collector := &awsAccountCollector{}
+			Expect(testutil.CollectAndCount(collector)).Should(Equal(0))
+			collector.setClient(client)
+
+			By("By reporting a failed reconcile in the phase")
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).ShouldNot(BeNil())
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(createdAwsAccount.Status.Phase).Should(Equal(kuadrav1.AwsAccountPhaseFailed))
+			condition := meta.FindStatusCondition(createdAwsAccount.Status.Conditions, kuadrav1.ConditionReady)
+			Expect(condition).ShouldNot(BeNil())
+			Expect(condition.Message).Should(ContainSubstring("unsupported credentials format"))
+			Expect(testutil.CollectAndCompare(collector, strings.NewReader(`
+# HELP kuadra_managed_users Number of IAM users managed through AwsAccounts, by phase
+# TYPE kuadra_managed_users gauge
+kuadra_managed_users{phase="Deleting"} 0
+kuadra_managed_users{phase="Failed"} 1
+kuadra_managed_users{phase="Pending"} 1
+kuadra_managed_users{phase="Ready"} 0
+`), "kuadra_managed_users")).Should(Succeed())
+
+			By("By recording the access key creation date once reconciled")
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			createdAwsAccount.Spec.Credentials = nil
+			Expect(client.Update(ctx, createdAwsAccount)).Should(Succeed())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(createdAwsAccount.Status.Phase).Should(Equal(kuadrav1.AwsAccountPhaseReady))
+			Expect(createdAwsAccount.Status.AccessKeyCreationDate.Time.Unix()).Should(Equal(createDate.Unix()))
+			Expect(testutil.CollectAndCount(collector, "kuadra_access_key_age_seconds")).Should(Equal(1))
+		})
+	})
+
+	Context("When IAM rejects a request", func() {
+		It("Should retry transient errors and wait on permanent ones", func() {
+			req := reconcile.Request{NamespacedName: awsAccountLookupKey}
+			client := fake.NewClientBuilder().Build()
+
+			mockIam := mockIamWrapper{
+				Users:        []types.User{},
+				LoginProfile: map[string]types.LoginProfile{},
+				AccessKeys:   map[string][]types.AccessKey{},
+				Groups:       map[string][]types.Group{},
+				Errors:       map[string]error{},
+			}
+
+			awsAccount := awsController.DeepCopy()
+			awsAccount.ResourceVersion = ""
+			awsAccount.Spec.InlinePolicies = map[string]kuadrav1.InlinePolicy{
+				"zone": {Document: `{"Version":"2012-10-17","Statement":[]}`},
+			}
+			Expect(client.Create(ctx, awsAccount)).Should(Succeed())
+
+			r := &AwsAccountReconciler{
+				Client:         client,
+				Scheme:         scheme.Scheme,
+				IamWrapper:     &mockIam,
+				ResyncInterval: 10 * time.Minute,
+			}
+
+			By("By returning transient errors to be requeued with backoff")
+			mockIam.Errors["PutUserPolicy"] = &smithy.GenericAPIError{Code: "Throttling", Message: "Rate exceeded"}
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).ShouldNot(BeNil())
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			condition := meta.FindStatusCondition(createdAwsAccount.Status.Conditions, kuadrav1.ConditionReady)
+			Expect(condition).ShouldNot(BeNil())
+			Expect(condition.Reason).Should(Equal("ReconcileError"))
+
+			By("By keeping the steps that succeeded before the error in the status")
+			Expect(createdAwsAccount.Status.UserCreated).Should(BeTrue())
+			Expect(createdAwsAccount.Status.AccessKeyCreated).Should(BeTrue())
+			Expect(createdAwsAccount.Status.UserGroups).Should(Equal(awsController.Spec.Groups))
+			Expect(mockIam.AccessKeys[awsController.Spec.UserName]).Should(HaveLen(1))
+
+			By("By surfacing permanent errors in the Ready condition without requeueing")
+			mockIam.Errors["PutUserPolicy"] = &types.MalformedPolicyDocumentException{Message: aws.String("Syntax errors in policy.")}
+			result, err := r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(result.RequeueAfter).Should(Equal(r.ResyncInterval))
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(createdAwsAccount.Status.Phase).Should(Equal(kuadrav1.AwsAccountPhaseFailed))
+			condition = meta.FindStatusCondition(createdAwsAccount.Status.Conditions, kuadrav1.ConditionReady)
+			Expect(condition).ShouldNot(BeNil())
+			Expect(condition.Reason).Should(Equal("PermanentError"))
+			Expect(condition.Message).Should(ContainSubstring("MalformedPolicyDocument"))
+
+			By("By recovering once IAM accepts the request")
+			delete(mockIam.Errors, "PutUserPolicy")
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(createdAwsAccount.Status.Phase).Should(Equal(kuadrav1.AwsAccountPhaseReady))
+		})
+	})
+	Context("When IAM returns results over several pages", func() {
+		It("Should read every page before diffing against the spec", func() {
+			req := reconcile.Request{NamespacedName: awsAccountLookupKey}
+			client := fake.NewClientBuilder().Build()
+
+			userName := awsController.Spec.UserName
+			readOnlyArn := "arn:aws:iam::aws:policy/AmazonRoute53ReadOnlyAccess"
+			zoneArn := "arn:aws:iam::aws:policy/AmazonRoute53DomainsReadOnlyAccess"
+			pagedIam := &pagedIamClient{
+				User: types.User{UserName: aws.String(userName), Path: aws.String("/")},
+				Groups: []types.Group{
+					{GroupName: aws.String("legacy")},
+					{GroupName: aws.String("dns-management")},
+					{GroupName: aws.String("test-group")},
 				},
-			}))
-
-			By("By checking if user has correct groups")
-			Expect(mockIam.Groups[awsController.Spec.UserName]).Should(Equal([]types.Group{
-				{
-					GroupName: &awsController.Spec.Groups[0],
+				AccessKeys: []types.AccessKeyMetadata{
+					{AccessKeyId: aws.String("OldAccessKeyId"), CreateDate: aws.Time(time.Now().Add(-time.Hour))},
+					{AccessKeyId: aws.String("AccessKeyId"), CreateDate: aws.Time(time.Now())},
 				},
-				{
-					GroupName: &awsController.Spec.Groups[1],
+				AttachedPolicies: []types.AttachedPolicy{
+					{PolicyArn: aws.String(readOnlyArn)},
+					{PolicyArn: aws.String(zoneArn)},
 				},
+			}
+
+			awsAccount := awsController.DeepCopy()
+			awsAccount.ResourceVersion = ""
+			awsAccount.Spec.ManagedPolicyArns = []string{readOnlyArn, zoneArn}
+			Expect(client.Create(ctx, awsAccount)).Should(Succeed())
+			Expect(client.Create(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: DefaultCredentialsSecretName, Namespace: userName},
+				Data: map[string][]byte{
+					"AWS_ACCESS_KEY_ID":     []byte("AccessKeyId"),
+					"AWS_SECRET_ACCESS_KEY": []byte("SecretAccessKey"),
+				},
+			})).Should(Succeed())
+
+			r := &AwsAccountReconciler{
+				Client:     client,
+				Scheme:     scheme.Scheme,
+				IamWrapper: kuadraaws.NewIamWrapperFromClient(pagedIam),
+			}
+
+			status, err := r.getRefreshedStatus(ctx, *awsAccount)
+			Expect(err).Should(BeNil())
+			Expect(status.UserGroups).Should(Equal([]string{"legacy", "dns-management", "test-group"}))
+			Expect(status.AttachedPolicyArns).Should(Equal([]string{readOnlyArn, zoneArn}))
+			Expect(status.AccessKeyCreationDate.Time).Should(Equal(*pagedIam.AccessKeys[0].CreateDate))
+
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(pagedIam.AddedToGroups).Should(BeEmpty())
+			Expect(pagedIam.RemovedFromGroups).Should(Equal([]string{"legacy"}))
+			Expect(pagedIam.AttachedPolicyArns).Should(BeEmpty())
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(createdAwsAccount.Status.UserGroups).Should(ConsistOf("dns-management", "test-group"))
+			Expect(createdAwsAccount.Status.Phase).Should(Equal(kuadrav1.AwsAccountPhaseReady))
+		})
+	})
+
+	Context("When the AwsAccount is annotated for a dry run", func() {
+		It("Should plan the changes in status and events without making them", func() {
+			req := reconcile.Request{NamespacedName: awsAccountLookupKey}
+			client := fake.NewClientBuilder().Build()
+
+			mockIam := mockIamWrapper{
+				Users:        []types.User{},
+				LoginProfile: map[string]types.LoginProfile{},
+				AccessKeys:   map[string][]types.AccessKey{},
+				Groups:       map[string][]types.Group{},
+			}
+
+			awsAccount := awsController.DeepCopy()
+			awsAccount.ResourceVersion = ""
+			awsAccount.Annotations = map[string]string{DryRunAnnotation: "true"}
+			Expect(client.Create(ctx, awsAccount)).Should(Succeed())
+
+			recorder := record.NewFakeRecorder(20)
+			r := &AwsAccountReconciler{
+				Client:         client,
+				Scheme:         scheme.Scheme,
+				IamWrapper:     &mockIam,
+				ResyncInterval: time.Minute,
+				Recorder:       recorder,
+			}
+
+			result, err := r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(result.RequeueAfter).Should(Equal(time.Minute))
+
+			userName := awsController.Spec.UserName
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(createdAwsAccount.Status.PlannedActions).Should(Equal([]kuadrav1.PlannedAction{
+				{Action: "create Namespace", Target: userName},
+				{Action: "iam:CreateUser", Target: userName},
+				{Action: "create Secret", Target: userName + "/aws-login"},
+				{Action: "iam:CreateLoginProfile", Target: userName},
+				{Action: "iam:CreateAccessKey", Target: userName},
+				{Action: "create Secret", Target: userName + "/aws-credentials"},
+				{Action: "iam:AddUserToGroup", Target: "dns-management"},
+				{Action: "iam:AddUserToGroup", Target: "test-group"},
 			}))
+			Expect(meta.IsStatusConditionTrue(createdAwsAccount.Status.Conditions, kuadrav1.ConditionDryRun)).Should(BeTrue())
+			Expect(createdAwsAccount.Finalizers).Should(BeEmpty())
+			Expect(recorder.Events).Should(HaveLen(8))
+			Expect(recorder.Events).Should(Receive(ContainSubstring("DryRun Would create Namespace " + userName)))
+
+			By("By making no changes in IAM or Kubernetes")
+			Expect(mockIam.Users).Should(BeEmpty())
+			Expect(mockIam.LoginProfile).Should(BeEmpty())
+			Expect(mockIam.AccessKeys).Should(BeEmpty())
+			Expect(mockIam.Groups).Should(BeEmpty())
+			err = client.Get(ctx, k8Types.NamespacedName{Name: userName}, &corev1.Namespace{})
+			Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+
+			By("By not repeating the events of an unchanged plan")
+			for len(recorder.Events) > 0 {
+				<-recorder.Events
+			}
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(recorder.Events).Should(BeEmpty())
+
+			By("By applying the plan once the annotation is removed")
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			createdAwsAccount.Annotations = nil
+			Expect(client.Update(ctx, createdAwsAccount)).Should(Succeed())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(mockIam.Users).Should(HaveLen(1))
+			Expect(mockIam.Groups[userName]).Should(HaveLen(2))
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(createdAwsAccount.Status.PlannedActions).Should(BeEmpty())
+			Expect(meta.FindStatusCondition(createdAwsAccount.Status.Conditions, kuadrav1.ConditionDryRun)).Should(BeNil())
+		})
+	})
+
+	Context("When spec.userName is changed", func() {
+		It("Should rename the IAM user and move its namespace and Secrets", func() {
+			req := reconcile.Request{NamespacedName: awsAccountLookupKey}
+			client := fake.NewClientBuilder().Build()
+
+			mockIam := mockIamWrapper{
+				Users:        []types.User{},
+				LoginProfile: map[string]types.LoginProfile{},
+				AccessKeys:   map[string][]types.AccessKey{},
+				Groups:       map[string][]types.Group{},
+			}
+
+			awsAccount := awsController.DeepCopy()
+			awsAccount.ResourceVersion = ""
+			Expect(client.Create(ctx, awsAccount)).Should(Succeed())
+
+			r := &AwsAccountReconciler{
+				Client:     client,
+				Scheme:     scheme.Scheme,
+				IamWrapper: &mockIam,
+				ClusterId:  "cluster-a",
+			}
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			oldName := awsController.Spec.UserName
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(createdAwsAccount.Status.UserName).Should(Equal(oldName))
+
+			By("By renaming the user in place")
+			const newName = "ib-route53"
+			createdAwsAccount.Spec.UserName = newName
+			Expect(client.Update(ctx, createdAwsAccount)).Should(Succeed())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(mockIam.Users).Should(HaveLen(1))
+			Expect(*mockIam.Users[0].UserName).Should(Equal(newName))
+			Expect(mockIam.Groups[newName]).Should(HaveLen(2))
+			Expect(mockIam.Groups).ShouldNot(HaveKey(oldName))
+
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(createdAwsAccount.Status.UserName).Should(Equal(newName))
+			Expect(createdAwsAccount.Status.Credentials.SecretRef.Namespace).Should(Equal(newName))
+			Expect(createdAwsAccount.Status.Phase).Should(Equal(kuadrav1.AwsAccountPhaseReady))
+
+			By("By leaving nothing under the old name")
+			loginSecret := &corev1.Secret{}
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: DefaultLoginSecretName, Namespace: newName}, loginSecret)).Should(Succeed())
+			Expect(string(loginSecret.Data["userName"])).Should(Equal(newName))
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: DefaultCredentialsSecretName, Namespace: newName}, &corev1.Secret{})).Should(Succeed())
+			err = client.Get(ctx, k8Types.NamespacedName{Name: DefaultCredentialsSecretName, Namespace: oldName}, &corev1.Secret{})
+			Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+			err = client.Get(ctx, k8Types.NamespacedName{Name: oldName}, &corev1.Namespace{})
+			Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+
+			By("By refusing to take the name of another IAM user")
+			mockIam.Users = append(mockIam.Users, types.User{UserName: aws.String("ib-taken")})
+			createdAwsAccount.Spec.UserName = "ib-taken"
+			Expect(client.Update(ctx, createdAwsAccount)).Should(Succeed())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(*mockIam.Users[0].UserName).Should(Equal(newName))
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(createdAwsAccount.Status.UserName).Should(Equal(newName))
+			Expect(createdAwsAccount.Status.Phase).Should(Equal(kuadrav1.AwsAccountPhaseFailed))
+
+			By("By deleting the user under its applied name")
+			Expect(client.Delete(ctx, createdAwsAccount)).Should(Succeed())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(mockIam.Users).Should(HaveLen(1))
+			Expect(*mockIam.Users[0].UserName).Should(Equal("ib-taken"))
+		})
+
+		It("Should finish an interrupted rename before the name is changed back", func() {
+			req := reconcile.Request{NamespacedName: awsAccountLookupKey}
+			client := fake.NewClientBuilder().Build()
+
+			mockIam := mockIamWrapper{
+				Users:        []types.User{},
+				LoginProfile: map[string]types.LoginProfile{},
+				AccessKeys:   map[string][]types.AccessKey{},
+				Groups:       map[string][]types.Group{},
+			}
+
+			awsAccount := awsController.DeepCopy()
+			awsAccount.ResourceVersion = ""
+			Expect(client.Create(ctx, awsAccount)).Should(Succeed())
+
+			r := &AwsAccountReconciler{
+				Client:     client,
+				Scheme:     scheme.Scheme,
+				IamWrapper: &mockIam,
+			}
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			oldName := awsController.Spec.UserName
+
+			By("By stopping a rename once IAM has taken the new name")
+			const newName = "ib-route53"
+			Expect(mockIam.UpdateUserName(ctx, oldName, newName)).Should(Succeed())
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			createdAwsAccount.Status.UserName = newName
+			createdAwsAccount.Status.PreviousUserName = oldName
+			Expect(client.Status().Update(ctx, createdAwsAccount)).Should(Succeed())
+
+			By("By changing the name back")
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			createdAwsAccount.Spec.UserName = oldName
+			Expect(client.Update(ctx, createdAwsAccount)).Should(Succeed())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+
+			Expect(mockIam.Users).Should(HaveLen(1))
+			Expect(*mockIam.Users[0].UserName).Should(Equal(oldName))
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(createdAwsAccount.Status.UserName).Should(Equal(oldName))
+			Expect(createdAwsAccount.Status.PreviousUserName).Should(BeEmpty())
+			Expect(createdAwsAccount.Status.Phase).Should(Equal(kuadrav1.AwsAccountPhaseReady))
+			loginSecret := &corev1.Secret{}
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: DefaultLoginSecretName, Namespace: oldName}, loginSecret)).Should(Succeed())
+			Expect(string(loginSecret.Data["userName"])).Should(Equal(oldName))
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: DefaultCredentialsSecretName, Namespace: oldName}, &corev1.Secret{})).Should(Succeed())
+			err = client.Get(ctx, k8Types.NamespacedName{Name: newName}, &corev1.Namespace{})
+			Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+		})
+	})
+
+	Context("When the IAM user belongs to another cluster", func() {
+		It("Should leave it alone until it is taken over", func() {
+			req := reconcile.Request{NamespacedName: awsAccountLookupKey}
+			client := fake.NewClientBuilder().Build()
+
+			userName := awsController.Spec.UserName
+			mockIam := mockIamWrapper{
+				Users:        []types.User{{UserName: aws.String(userName)}},
+				LoginProfile: map[string]types.LoginProfile{},
+				AccessKeys:   map[string][]types.AccessKey{},
+				Groups:       map[string][]types.Group{},
+				UserTags:     map[string]map[string]string{userName: {ClusterTag: "cluster-a"}},
+			}
+
+			awsAccount := awsController.DeepCopy()
+			awsAccount.ResourceVersion = ""
+			Expect(client.Create(ctx, awsAccount)).Should(Succeed())
+
+			recorder := record.NewFakeRecorder(10)
+			r := &AwsAccountReconciler{
+				Client:         client,
+				Scheme:         scheme.Scheme,
+				IamWrapper:     &mockIam,
+				ClusterId:      "cluster-b",
+				ResyncInterval: time.Minute,
+				Recorder:       recorder,
+			}
+
+			By("By reporting the conflict without changing the user")
+			result, err := r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(result.RequeueAfter).Should(Equal(time.Minute))
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(createdAwsAccount.Status.Phase).Should(Equal(kuadrav1.AwsAccountPhaseFailed))
+			condition := meta.FindStatusCondition(createdAwsAccount.Status.Conditions, kuadrav1.ConditionReady)
+			Expect(condition).ShouldNot(BeNil())
+			Expect(condition.Reason).Should(Equal("OwnershipConflict"))
+			Expect(condition.Message).Should(ContainSubstring("cluster-a"))
+			Expect(recorder.Events).Should(Receive(ContainSubstring("OwnershipConflict")))
+			Expect(mockIam.LoginProfile).Should(BeEmpty())
+			Expect(mockIam.Groups).Should(BeEmpty())
+			Expect(mockIam.UserTags[userName]).Should(Equal(map[string]string{ClusterTag: "cluster-a"}))
+
+			By("By taking the user over once annotated with its owner")
+			createdAwsAccount.Annotations = map[string]string{TakeOverAnnotation: "cluster-a"}
+			Expect(client.Update(ctx, createdAwsAccount)).Should(Succeed())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(recorder.Events).Should(Receive(ContainSubstring("OwnershipTakenOver")))
+			Expect(mockIam.UserTags[userName][ClusterTag]).Should(Equal("cluster-b"))
+			Expect(mockIam.Groups[userName]).Should(HaveLen(2))
+			Expect(client.Get(ctx, awsAccountLookupKey, createdAwsAccount)).Should(Succeed())
+			Expect(createdAwsAccount.Status.Phase).Should(Equal(kuadrav1.AwsAccountPhaseReady))
+
+			By("By keeping the user when the former owner deletes its AwsAccount")
+			previousOwner := *r
+			previousOwner.ClusterId = "cluster-a"
+			previousOwner.Recorder = nil
+			Expect(client.Delete(ctx, createdAwsAccount)).Should(Succeed())
+			_, err = previousOwner.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(mockIam.Users).Should(HaveLen(1))
+			Expect(mockIam.Groups[userName]).Should(HaveLen(2))
+			err = client.Get(ctx, awsAccountLookupKey, createdAwsAccount)
+			Expect(apierrors.IsNotFound(err)).Should(BeTrue())
 		})
 	})
 })
@@ -142,21 +1410,37 @@ type mockIamWrapper struct {
 	LoginProfile map[string]types.LoginProfile
 	AccessKeys   map[string][]types.AccessKey
 	Groups       map[string][]types.Group
+	IamGroups    map[string]*mockIamGroup
+	UserPolicies map[string]*mockUserPolicies
+	UserTags     map[string]map[string]string
+	// Errors are returned by the named methods instead of calling through
+	Errors map[string]error
+}
+
+type mockUserPolicies struct {
+	AttachedPolicyArns []string
+	InlinePolicies     map[string]string
+}
+
+type mockIamGroup struct {
+	Group              types.Group
+	AttachedPolicyArns []string
+	InlinePolicies     map[string]string
 }
 
-func (c mockIamWrapper) GetUser(userName string) (*types.User, error) {
+func (c mockIamWrapper) GetUser(ctx context.Context, userName string) (*types.User, error) {
 	for _, user := range c.Users {
 		if *user.UserName == userName {
 			return &user, nil
 		}
 	}
-	return &types.User{}, errors.New("User does not exist")
+	return nil, nil
 }
 
 func (c mockIamWrapper) IsExistingUser(ctx context.Context, userName string) (bool, error) {
-	_, err := c.GetUser(userName)
-	if err != nil {
-		return false, nil
+	user, err := c.GetUser(ctx, userName)
+	if err != nil || user == nil {
+		return false, err
 	}
 	return true, nil
 }
@@ -190,16 +1474,54 @@ func (c *mockIamWrapper) CreateUser(ctx context.Context, userName string) (*type
 	return &user, nil
 }
 
-func (c *mockIamWrapper) CreateUserIfNotExists(ctx context.Context, userName string) error {
+func (c *mockIamWrapper) CreateUserIfNotExists(ctx context.Context, userName string, options kuadraaws.UserOptions) error {
 	c.CreateUser(ctx, userName)
+	if options.Path != "" {
+		c.UpdateUserPath(ctx, userName, options.Path)
+	}
+	c.TagUser(ctx, userName, options.Tags)
+	if options.PermissionsBoundary != "" {
+		return c.PutUserPermissionsBoundary(ctx, userName, options.PermissionsBoundary)
+	}
+	return nil
+}
+
+func (c *mockIamWrapper) PutUserPermissionsBoundary(ctx context.Context, userName string, boundaryArn string) error {
+	for i := range c.Users {
+		if *c.Users[i].UserName == userName {
+			c.Users[i].PermissionsBoundary = &types.AttachedPermissionsBoundary{
+				PermissionsBoundaryArn:  aws.String(boundaryArn),
+				PermissionsBoundaryType: types.PermissionsBoundaryAttachmentTypePolicy,
+			}
+		}
+	}
+	return nil
+}
+
+func (c *mockIamWrapper) DeleteUserPermissionsBoundary(ctx context.Context, userName string) error {
+	for i := range c.Users {
+		if *c.Users[i].UserName == userName {
+			c.Users[i].PermissionsBoundary = nil
+		}
+	}
 	return nil
 }
 
-func (c mockIamWrapper) ListUsers(ctx context.Context, maxUsers int32) ([]types.User, error) {
+func (c mockIamWrapper) ListUsers(ctx context.Context, pathPrefix string, maxUsers int32) ([]types.User, error) {
 	var users []types.User
 
-	for i := int32(0); i < maxUsers && i < int32(len(c.Users)); i++ {
-		users = append(users, c.Users[i])
+	for _, user := range c.Users {
+		if int32(len(users)) == maxUsers {
+			break
+		}
+		// IAM puts users without a path under /
+		path := "/"
+		if user.Path != nil {
+			path = *user.Path
+		}
+		if strings.HasPrefix(path, pathPrefix) {
+			users = append(users, user)
+		}
 	}
 	return users, nil
 }
@@ -237,12 +1559,12 @@ func (c mockIamWrapper) AddUserToGroup(ctx context.Context, groupName string, us
 }
 
 func (c *mockIamWrapper) RemoveUserFromGroup(ctx context.Context, groupName string, userName string) (middleware.Metadata, error) {
-	c.Groups[userName] = slice.Remove(c.Groups[userName], func(g types.Group) bool { return g == types.Group{GroupName: &groupName} })
+	c.Groups[userName] = slice.Remove(c.Groups[userName], func(g types.Group) bool { return *g.GroupName == groupName })
 	return middleware.Metadata{}, nil
 }
 
 func (c *mockIamWrapper) DeleteUser(ctx context.Context, userName string) error {
-	c.Users = slice.Remove(c.Users, func(u types.User) bool { return u.UserName == &userName })
+	c.Users = slice.Remove(c.Users, func(u types.User) bool { return *u.UserName == userName })
 	return nil
 }
 
@@ -256,6 +1578,8 @@ func (c *mockIamWrapper) ListAccessKeys(ctx context.Context, userName string) ([
 	for _, accessKey := range c.AccessKeys[userName] {
 		ak := types.AccessKeyMetadata{
 			AccessKeyId: accessKey.AccessKeyId,
+			CreateDate:  accessKey.CreateDate,
+			Status:      accessKey.Status,
 		}
 		accessKeys = append(accessKeys, ak)
 	}
@@ -266,3 +1590,293 @@ func (c *mockIamWrapper) DeleteAccessKeyIfExists(ctx context.Context, userName s
 	c.AccessKeys[userName] = slice.Remove(c.AccessKeys[userName], func(a types.AccessKey) bool { return a.AccessKeyId == &keyId })
 	return nil
 }
+
+func (c *mockIamWrapper) UpdateAccessKeyStatus(ctx context.Context, userName string, keyId string, status types.StatusType) error {
+	for i := range c.AccessKeys[userName] {
+		if *c.AccessKeys[userName][i].AccessKeyId == keyId {
+			c.AccessKeys[userName][i].Status = status
+		}
+	}
+	return nil
+}
+
+func (c *mockIamWrapper) GetGroup(ctx context.Context, groupName string) (*types.Group, []types.User, error) {
+	group, exists := c.IamGroups[groupName]
+	if !exists {
+		return nil, nil, nil
+	}
+	var members []types.User
+	for userName, groups := range c.Groups {
+		if slice.IndexOf(groups, func(g types.Group) bool { return *g.GroupName == groupName }) != -1 {
+			members = append(members, types.User{UserName: aws.String(userName)})
+		}
+	}
+	return &group.Group, members, nil
+}
+
+func (c *mockIamWrapper) CreateGroupIfNotExists(ctx context.Context, groupName string, path string) error {
+	if c.IamGroups == nil {
+		c.IamGroups = map[string]*mockIamGroup{}
+	}
+	if _, exists := c.IamGroups[groupName]; !exists {
+		c.IamGroups[groupName] = &mockIamGroup{
+			Group: types.Group{
+				GroupName: aws.String(groupName),
+				Path:      aws.String(path),
+				Arn:       aws.String("arn:aws:iam::123456789012:group" + path + groupName),
+			},
+			InlinePolicies: map[string]string{},
+		}
+	}
+	return nil
+}
+
+func (c *mockIamWrapper) UpdateGroupPath(ctx context.Context, groupName string, path string) error {
+	c.IamGroups[groupName].Group.Path = aws.String(path)
+	return nil
+}
+
+func (c *mockIamWrapper) DeleteGroupIfExists(ctx context.Context, groupName string) error {
+	delete(c.IamGroups, groupName)
+	return nil
+}
+
+func (c *mockIamWrapper) ListAttachedGroupPolicies(ctx context.Context, groupName string) ([]types.AttachedPolicy, error) {
+	var policies []types.AttachedPolicy
+	for _, policyArn := range c.IamGroups[groupName].AttachedPolicyArns {
+		policies = append(policies, types.AttachedPolicy{PolicyArn: aws.String(policyArn)})
+	}
+	return policies, nil
+}
+
+func (c *mockIamWrapper) AttachGroupPolicy(ctx context.Context, groupName string, policyArn string) error {
+	c.IamGroups[groupName].AttachedPolicyArns = append(c.IamGroups[groupName].AttachedPolicyArns, policyArn)
+	return nil
+}
+
+func (c *mockIamWrapper) DetachGroupPolicyIfAttached(ctx context.Context, groupName string, policyArn string) error {
+	c.IamGroups[groupName].AttachedPolicyArns = slice.Remove(c.IamGroups[groupName].AttachedPolicyArns, func(p string) bool { return p == policyArn })
+	return nil
+}
+
+func (c *mockIamWrapper) ListGroupPolicies(ctx context.Context, groupName string) ([]string, error) {
+	var policyNames []string
+	for policyName := range c.IamGroups[groupName].InlinePolicies {
+		policyNames = append(policyNames, policyName)
+	}
+	return policyNames, nil
+}
+
+func (c *mockIamWrapper) GetGroupPolicy(ctx context.Context, groupName string, policyName string) (string, error) {
+	return c.IamGroups[groupName].InlinePolicies[policyName], nil
+}
+
+func (c *mockIamWrapper) PutGroupPolicy(ctx context.Context, groupName string, policyName string, policyDocument string) error {
+	c.IamGroups[groupName].InlinePolicies[policyName] = policyDocument
+	return nil
+}
+
+func (c *mockIamWrapper) DeleteGroupPolicyIfExists(ctx context.Context, groupName string, policyName string) error {
+	delete(c.IamGroups[groupName].InlinePolicies, policyName)
+	return nil
+}
+
+func (c *mockIamWrapper) userPolicies(userName string) *mockUserPolicies {
+	if c.UserPolicies == nil {
+		c.UserPolicies = map[string]*mockUserPolicies{}
+	}
+	if _, exists := c.UserPolicies[userName]; !exists {
+		c.UserPolicies[userName] = &mockUserPolicies{InlinePolicies: map[string]string{}}
+	}
+	return c.UserPolicies[userName]
+}
+
+func (c *mockIamWrapper) ListAttachedUserPolicies(ctx context.Context, userName string) ([]types.AttachedPolicy, error) {
+	var policies []types.AttachedPolicy
+	for _, policyArn := range c.userPolicies(userName).AttachedPolicyArns {
+		policies = append(policies, types.AttachedPolicy{PolicyArn: aws.String(policyArn)})
+	}
+	return policies, nil
+}
+
+func (c *mockIamWrapper) AttachUserPolicy(ctx context.Context, userName string, policyArn string) error {
+	policies := c.userPolicies(userName)
+	policies.AttachedPolicyArns = append(policies.AttachedPolicyArns, policyArn)
+	return nil
+}
+
+func (c *mockIamWrapper) DetachUserPolicyIfAttached(ctx context.Context, userName string, policyArn string) error {
+	policies := c.userPolicies(userName)
+	policies.AttachedPolicyArns = slice.Remove(policies.AttachedPolicyArns, func(p string) bool { return p == policyArn })
+	return nil
+}
+
+func (c *mockIamWrapper) ListUserPolicies(ctx context.Context, userName string) ([]string, error) {
+	return sortedKeys(c.userPolicies(userName).InlinePolicies), nil
+}
+
+func (c *mockIamWrapper) GetUserPolicy(ctx context.Context, userName string, policyName string) (string, error) {
+	return c.userPolicies(userName).InlinePolicies[policyName], nil
+}
+
+func (c *mockIamWrapper) PutUserPolicy(ctx context.Context, userName string, policyName string, policyDocument string) error {
+	if err := c.Errors["PutUserPolicy"]; err != nil {
+		return err
+	}
+	c.userPolicies(userName).InlinePolicies[policyName] = policyDocument
+	return nil
+}
+
+func (c *mockIamWrapper) DeleteUserPolicyIfExists(ctx context.Context, userName string, policyName string) error {
+	delete(c.userPolicies(userName).InlinePolicies, policyName)
+	return nil
+}
+
+func (c *mockIamWrapper) UpdateUserPath(ctx context.Context, userName string, path string) error {
+	for i := range c.Users {
+		if *c.Users[i].UserName == userName {
+			c.Users[i].Path = aws.String(path)
+		}
+	}
+	return nil
+}
+
+func (c *mockIamWrapper) UpdateUserName(ctx context.Context, userName string, newUserName string) error {
+	for i := range c.Users {
+		if *c.Users[i].UserName == userName {
+			c.Users[i].UserName = aws.String(newUserName)
+		}
+	}
+	if loginProfile, exists := c.LoginProfile[userName]; exists {
+		c.LoginProfile[newUserName] = loginProfile
+		delete(c.LoginProfile, userName)
+	}
+	if accessKeys, exists := c.AccessKeys[userName]; exists {
+		c.AccessKeys[newUserName] = accessKeys
+		delete(c.AccessKeys, userName)
+	}
+	if groups, exists := c.Groups[userName]; exists {
+		c.Groups[newUserName] = groups
+		delete(c.Groups, userName)
+	}
+	if policies, exists := c.UserPolicies[userName]; exists {
+		c.UserPolicies[newUserName] = policies
+		delete(c.UserPolicies, userName)
+	}
+	if tags, exists := c.UserTags[userName]; exists {
+		c.UserTags[newUserName] = tags
+		delete(c.UserTags, userName)
+	}
+	return nil
+}
+
+func (c *mockIamWrapper) ListUserTags(ctx context.Context, userName string) (map[string]string, error) {
+	if err := c.Errors["ListUserTags/"+userName]; err != nil {
+		return nil, err
+	}
+	tags := map[string]string{}
+	for key, value := range c.UserTags[userName] {
+		tags[key] = value
+	}
+	return tags, nil
+}
+
+func (c *mockIamWrapper) TagUser(ctx context.Context, userName string, tags map[string]string) error {
+	if c.UserTags == nil {
+		c.UserTags = map[string]map[string]string{}
+	}
+	if _, exists := c.UserTags[userName]; !exists {
+		c.UserTags[userName] = map[string]string{}
+	}
+	for key, value := range tags {
+		c.UserTags[userName][key] = value
+	}
+	return nil
+}
+
+func (c *mockIamWrapper) UntagUser(ctx context.Context, userName string, tagKeys []string) error {
+	for _, key := range tagKeys {
+		delete(c.UserTags[userName], key)
+	}
+	return nil
+}
+
+// pagedIamClient stands in for the IAM API underneath the real wrapper. It holds a single
+// user and returns every list one item per page, so that only callers following the
+// markers see the whole list. Calls it does not implement panic on the nil IamAPI.
+type pagedIamClient struct {
+	kuadraaws.IamAPI
+	User             types.User
+	Groups           []types.Group
+	AccessKeys       []types.AccessKeyMetadata
+	AttachedPolicies []types.AttachedPolicy
+	Tags             []types.Tag
+
+	AddedToGroups      []string
+	RemovedFromGroups  []string
+	AttachedPolicyArns []string
+}
+
+// nextPage returns the page of items starting at marker and the marker of the page after it
+func nextPage[T any](items []T, marker *string) ([]T, *string) {
+	start := 0
+	if marker != nil {
+		start, _ = strconv.Atoi(*marker)
+	}
+	if start+1 >= len(items) {
+		return items[start:], nil
+	}
+	return items[start : start+1], aws.String(strconv.Itoa(start + 1))
+}
+
+func (c *pagedIamClient) GetUser(ctx context.Context, params *iam.GetUserInput, optFns ...func(*iam.Options)) (*iam.GetUserOutput, error) {
+	return &iam.GetUserOutput{User: &c.User}, nil
+}
+
+func (c *pagedIamClient) GetLoginProfile(ctx context.Context, params *iam.GetLoginProfileInput, optFns ...func(*iam.Options)) (*iam.GetLoginProfileOutput, error) {
+	return &iam.GetLoginProfileOutput{LoginProfile: &types.LoginProfile{UserName: params.UserName}}, nil
+}
+
+func (c *pagedIamClient) ListAccessKeys(ctx context.Context, params *iam.ListAccessKeysInput, optFns ...func(*iam.Options)) (*iam.ListAccessKeysOutput, error) {
+	page, marker := nextPage(c.AccessKeys, params.Marker)
+	return &iam.ListAccessKeysOutput{AccessKeyMetadata: page, Marker: marker, IsTruncated: marker != nil}, nil
+}
+
+func (c *pagedIamClient) ListGroupsForUser(ctx context.Context, params *iam.ListGroupsForUserInput, optFns ...func(*iam.Options)) (*iam.ListGroupsForUserOutput, error) {
+	page, marker := nextPage(c.Groups, params.Marker)
+	return &iam.ListGroupsForUserOutput{Groups: page, Marker: marker, IsTruncated: marker != nil}, nil
+}
+
+func (c *pagedIamClient) ListAttachedUserPolicies(ctx context.Context, params *iam.ListAttachedUserPoliciesInput, optFns ...func(*iam.Options)) (*iam.ListAttachedUserPoliciesOutput, error) {
+	page, marker := nextPage(c.AttachedPolicies, params.Marker)
+	return &iam.ListAttachedUserPoliciesOutput{AttachedPolicies: page, Marker: marker, IsTruncated: marker != nil}, nil
+}
+
+func (c *pagedIamClient) ListUserPolicies(ctx context.Context, params *iam.ListUserPoliciesInput, optFns ...func(*iam.Options)) (*iam.ListUserPoliciesOutput, error) {
+	return &iam.ListUserPoliciesOutput{}, nil
+}
+
+func (c *pagedIamClient) ListUserTags(ctx context.Context, params *iam.ListUserTagsInput, optFns ...func(*iam.Options)) (*iam.ListUserTagsOutput, error) {
+	page, marker := nextPage(c.Tags, params.Marker)
+	return &iam.ListUserTagsOutput{Tags: page, Marker: marker, IsTruncated: marker != nil}, nil
+}
+
+func (c *pagedIamClient) TagUser(ctx context.Context, params *iam.TagUserInput, optFns ...func(*iam.Options)) (*iam.TagUserOutput, error) {
+	c.Tags = append(c.Tags, params.Tags...)
+	return &iam.TagUserOutput{}, nil
+}
+
+func (c *pagedIamClient) AddUserToGroup(ctx context.Context, params *iam.AddUserToGroupInput, optFns ...func(*iam.Options)) (*iam.AddUserToGroupOutput, error) {
+	c.AddedToGroups = append(c.AddedToGroups, *params.GroupName)
+	return &iam.AddUserToGroupOutput{}, nil
+}
+
+func (c *pagedIamClient) RemoveUserFromGroup(ctx context.Context, params *iam.RemoveUserFromGroupInput, optFns ...func(*iam.Options)) (*iam.RemoveUserFromGroupOutput, error) {
+	c.RemovedFromGroups = append(c.RemovedFromGroups, *params.GroupName)
+	return &iam.RemoveUserFromGroupOutput{}, nil
+}
+
+func (c *pagedIamClient) AttachUserPolicy(ctx context.Context, params *iam.AttachUserPolicyInput, optFns ...func(*iam.Options)) (*iam.AttachUserPolicyOutput, error) {
+	c.AttachedPolicyArns = append(c.AttachedPolicyArns, *params.PolicyArn)
+	return &iam.AttachUserPolicyOutput{}, nil
+}
@@ -0,0 +1,401 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+	slice "github.com/Kuadrant/kuadra/pkg/_internal"
+	"github.com/Kuadrant/kuadra/pkg/audit"
+)
+
+const (
+	AwsGroupFinalizer = "kuadra.kuadrant.io/aws-group"
+	// TakeOverGroupAnnotation on an AwsGroup names an existing IAM group, not created by Kuadra,
+	// that the AwsGroup may take over. Its policies are then replaced by those of the spec, and
+	// deleting the AwsGroup deletes it.
+	TakeOverGroupAnnotation = "kuadra.kuadrant.io/take-over-group"
+)
+
+// AwsGroupReconciler reconciles a AwsGroup object
+type AwsGroupReconciler struct {
+	client.Client
+	Scheme     *runtime.Scheme
+	IamWrapper IamWrapper
+	// ClusterId is the identity of this cluster. Members of a deleted group that belong to
+	// another cluster are left in it, and the group with them.
+	ClusterId string
+	// ResyncInterval is how often AwsGroups are reconciled to revert changes made in IAM. Zero disables resyncs.
+	ResyncInterval time.Duration
+	// DryRun plans the changes to every AwsGroup without making them, as DryRunAnnotation does for one
+	DryRun   bool
+	Recorder record.EventRecorder
+
+	// planning is set on the copy of the reconciler that works out a dry run plan
+	planning bool
+}
+
+//+kubebuilder:rbac:groups=kuadra.kuadrant.io,resources=awsgroups,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=kuadra.kuadrant.io,resources=awsgroups/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=kuadra.kuadrant.io,resources=awsgroups/finalizers,verbs=update
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+// Reconcile creates the IAM group described by an AwsGroup and keeps its path and
+// policies in line with the spec. Deleting the AwsGroup empties and deletes the group.
+func (r *AwsGroupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	var awsGroup kuadrav1.AwsGroup
+	if err := r.Get(ctx, req.NamespacedName, &awsGroup); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	ctx = audit.WithActor(ctx, audit.ActorOf("AwsGroup", &awsGroup))
+
+	if !r.planning && isDryRun(r.DryRun, &awsGroup) {
+		return r.planReconcile(ctx, req, &awsGroup)
+	}
+
+	if awsGroup.DeletionTimestamp != nil && !awsGroup.DeletionTimestamp.IsZero() {
+		groupName := awsGroup.Status.GroupName
+		if groupName == "" {
+			groupName = awsGroup.IamGroupName()
+		}
+		if !awsGroup.Status.GroupCreated {
+			log.Info("leaving IAM group the AwsGroup does not own in place", "groupName", groupName)
+		} else if err := r.deleteIamGroup(ctx, groupName); err != nil {
+			log.Error(err, "Failed to delete IAM group", "groupName", groupName)
+			if r.planning || !isPermanentError(err) {
+				return ctrl.Result{}, err
+			}
+			// Retrying cannot help until the members are removed, so wait for the next resync
+			r.event(&awsGroup, v1.EventTypeWarning, "DeleteBlocked", err.Error())
+			meta.SetStatusCondition(&awsGroup.Status.Conditions, metav1.Condition{
+				Type:               kuadrav1.ConditionReady,
+				Status:             metav1.ConditionFalse,
+				Reason:             failureReason(err),
+				Message:            err.Error(),
+				ObservedGeneration: awsGroup.Generation,
+			})
+			if err := r.Status().Update(ctx, &awsGroup); err != nil {
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{RequeueAfter: r.ResyncInterval}, nil
+		}
+		controllerutil.RemoveFinalizer(&awsGroup, AwsGroupFinalizer)
+
+		if err := r.Update(ctx, &awsGroup); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if !controllerutil.ContainsFinalizer(&awsGroup, AwsGroupFinalizer) {
+		controllerutil.AddFinalizer(&awsGroup, AwsGroupFinalizer)
+		if err := r.Update(ctx, &awsGroup); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	stepStart := time.Now()
+	status := awsGroup.Status.DeepCopy()
+	status.PlannedActions = nil
+	meta.RemoveStatusCondition(&status.Conditions, kuadrav1.ConditionDryRun)
+	reconcileErr := r.reconcileIamGroup(ctx, &awsGroup, status)
+	observeStep("awsgroup", "reconcile_group", &stepStart)
+	if reconcileErr != nil {
+		log.Error(reconcileErr, "unable to reconcile IAM group", "groupName", awsGroup.IamGroupName())
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               kuadrav1.ConditionReady,
+			Status:             metav1.ConditionFalse,
+			Reason:             failureReason(reconcileErr),
+			Message:            reconcileErr.Error(),
+			ObservedGeneration: awsGroup.Generation,
+		})
+	} else {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               kuadrav1.ConditionReady,
+			Status:             metav1.ConditionTrue,
+			Reason:             "GroupReconciled",
+			ObservedGeneration: awsGroup.Generation,
+		})
+	}
+
+	if !reflect.DeepEqual(awsGroup.Status, *status) {
+		awsGroup.Status = *status
+		if err := r.Status().Update(ctx, &awsGroup); err != nil {
+			log.Error(err, "unable to update awsGroup status")
+			return ctrl.Result{RequeueAfter: time.Second * 3}, err
+		}
+	}
+
+	if reconcileErr != nil && (r.planning || !isPermanentError(reconcileErr)) {
+		return ctrl.Result{}, reconcileErr
+	}
+	return ctrl.Result{RequeueAfter: r.ResyncInterval}, nil
+}
+
+// planReconcile runs the reconcile with IAM and Kubernetes writes recorded instead of made,
+// and reports the planned changes in the AwsGroup's status and events
+func (r *AwsGroupReconciler) planReconcile(ctx context.Context, req ctrl.Request, awsGroup *kuadrav1.AwsGroup) (ctrl.Result, error) {
+	p := &plan{}
+	planner := *r
+	planner.planning = true
+	planner.Client = newDryRunClient(r.Client, p, awsGroup)
+	planner.IamWrapper = newDryRunIamWrapper(r.IamWrapper, p)
+	_, planErr := planner.Reconcile(ctx, req)
+
+	var latest kuadrav1.AwsGroup
+	if err := r.Get(ctx, req.NamespacedName, &latest); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	previous := latest.Status.DeepCopy()
+	latest.Status.PlannedActions = p.actions
+	setDryRunCondition(&latest.Status.Conditions, latest.Generation, p, planErr)
+	if !reflect.DeepEqual(*previous, latest.Status) {
+		if err := r.Status().Update(ctx, &latest); err != nil {
+			log.FromContext(ctx).Error(err, "unable to update awsGroup status")
+			return ctrl.Result{RequeueAfter: time.Second * 3}, err
+		}
+	}
+	reportPlan(r.Recorder, &latest, previous.PlannedActions, p)
+
+	if planErr != nil && !isPermanentError(planErr) {
+		return ctrl.Result{}, planErr
+	}
+	return ctrl.Result{RequeueAfter: r.ResyncInterval}, nil
+}
+
+// reconcileIamGroup converges the IAM group on the spec, recording what it observed in status.
+// Only a group the AwsGroup created, or one named in TakeOverGroupAnnotation, is changed.
+func (r *AwsGroupReconciler) reconcileIamGroup(ctx context.Context, awsGroup *kuadrav1.AwsGroup, status *kuadrav1.AwsGroupStatus) error {
+	log := log.FromContext(ctx)
+	groupName := awsGroup.IamGroupName()
+
+	// A renamed group is removed entirely before the new one is created
+	if status.GroupName != "" && status.GroupName != groupName {
+		if status.GroupCreated {
+			if err := r.deleteIamGroup(ctx, status.GroupName); err != nil {
+				return err
+			}
+			log.V(1).Info("deleted renamed group", "groupName", status.GroupName)
+		}
+		*status = kuadrav1.AwsGroupStatus{Conditions: status.Conditions}
+	}
+	status.GroupName = groupName
+
+	path := awsGroup.Spec.Path
+	if path == "" {
+		path = "/"
+	}
+
+	group, members, err := r.IamWrapper.GetGroup(ctx, groupName)
+	if err != nil {
+		return err
+	}
+	if group == nil {
+		if err := r.IamWrapper.CreateGroupIfNotExists(ctx, groupName, path); err != nil {
+			return err
+		}
+		log.V(1).Info("created group", "groupName", groupName)
+		// The group is claimed right away, as a later reconcile would not take it over otherwise
+		if err := r.recordGroupCreated(ctx, awsGroup, groupName); err != nil {
+			return err
+		}
+		if group, members, err = r.IamWrapper.GetGroup(ctx, groupName); err != nil {
+			return err
+		}
+	} else {
+		if !status.GroupCreated {
+			if awsGroup.Annotations[TakeOverGroupAnnotation] != groupName {
+				notOwned := &GroupNotOwnedError{GroupName: groupName}
+				r.event(awsGroup, v1.EventTypeWarning, "GroupNotOwned", notOwned.Error())
+				return notOwned
+			}
+			log.Info("took over IAM group", "groupName", groupName)
+			r.event(awsGroup, v1.EventTypeNormal, "GroupTakenOver", fmt.Sprintf("Took over IAM group %s", groupName))
+		}
+		if group.Path != nil && *group.Path != path {
+			if err := r.IamWrapper.UpdateGroupPath(ctx, groupName, path); err != nil {
+				return err
+			}
+			log.V(1).Info("updated group path", "groupName", groupName, "path", path)
+		}
+	}
+	status.GroupCreated = group != nil
+	if group != nil && group.Arn != nil {
+		status.Arn = *group.Arn
+	}
+	status.Members = nil
+	for _, member := range members {
+		status.Members = append(status.Members, *member.UserName)
+	}
+
+	attachedPolicies, err := r.IamWrapper.ListAttachedGroupPolicies(ctx, groupName)
+	if err != nil {
+		return err
+	}
+	var attachedPolicyArns []string
+	for _, policy := range attachedPolicies {
+		attachedPolicyArns = append(attachedPolicyArns, *policy.PolicyArn)
+	}
+	for _, policyArn := range slice.GetLeftDifference(awsGroup.Spec.ManagedPolicyArns, attachedPolicyArns) {
+		if err := r.IamWrapper.AttachGroupPolicy(ctx, groupName, policyArn); err != nil {
+			return err
+		}
+		log.V(1).Info("attached group policy", "groupName", groupName, "policyArn", policyArn)
+		attachedPolicyArns = append(attachedPolicyArns, policyArn)
+	}
+	for _, policyArn := range slice.GetLeftDifference(attachedPolicyArns, awsGroup.Spec.ManagedPolicyArns) {
+		if err := r.IamWrapper.DetachGroupPolicyIfAttached(ctx, groupName, policyArn); err != nil {
+			return err
+		}
+		log.V(1).Info("detached group policy", "groupName", groupName, "policyArn", policyArn)
+		attachedPolicyArns = slice.Remove(attachedPolicyArns, func(p string) bool { return p == policyArn })
+	}
+	status.AttachedPolicyArns = attachedPolicyArns
+
+	inlinePolicyNames, err := r.IamWrapper.ListGroupPolicies(ctx, groupName)
+	if err != nil {
+		return err
+	}
+	for _, policyName := range sortedKeys(awsGroup.Spec.InlinePolicies) {
+		document := awsGroup.Spec.InlinePolicies[policyName]
+		current, err := r.IamWrapper.GetGroupPolicy(ctx, groupName, policyName)
+		if err != nil {
+			return err
+		}
+		if current != "" && policyDocumentsEqual(current, document) {
+			continue
+		}
+		if err := r.IamWrapper.PutGroupPolicy(ctx, groupName, policyName, document); err != nil {
+			return err
+		}
+		log.V(1).Info("put group policy", "groupName", groupName, "policyName", policyName)
+		if !slice.Contains(inlinePolicyNames, policyName) {
+			inlinePolicyNames = append(inlinePolicyNames, policyName)
+		}
+	}
+	for _, policyName := range slice.GetLeftDifference(inlinePolicyNames, sortedKeys(awsGroup.Spec.InlinePolicies)) {
+		if err := r.IamWrapper.DeleteGroupPolicyIfExists(ctx, groupName, policyName); err != nil {
+			return err
+		}
+		log.V(1).Info("deleted group policy", "groupName", groupName, "policyName", policyName)
+		inlinePolicyNames = slice.Remove(inlinePolicyNames, func(p string) bool { return p == policyName })
+	}
+	status.InlinePolicyNames = inlinePolicyNames
+
+	return nil
+}
+
+// recordGroupCreated marks the group as created by the AwsGroup in its status. A merge patch
+// is used, so that a concurrent change to the AwsGroup cannot make the record fail.
+func (r *AwsGroupReconciler) recordGroupCreated(ctx context.Context, awsGroup *kuadrav1.AwsGroup, groupName string) error {
+	patch := client.MergeFrom(awsGroup.DeepCopy())
+	awsGroup.Status.GroupName = groupName
+	awsGroup.Status.GroupCreated = true
+	return r.Status().Patch(ctx, awsGroup, patch)
+}
+
+// deleteIamGroup removes members and policies from the group, which IAM requires before DeleteGroup
+func (r *AwsGroupReconciler) deleteIamGroup(ctx context.Context, groupName string) error {
+	group, members, err := r.IamWrapper.GetGroup(ctx, groupName)
+	if err != nil {
+		return err
+	}
+	if group == nil {
+		return nil
+	}
+
+	var foreignMembers []string
+	for _, member := range members {
+		if r.ClusterId != "" {
+			owner, err := userOwner(ctx, r.IamWrapper, *member.UserName)
+			if err != nil {
+				return err
+			}
+			if owner != "" && owner != r.ClusterId {
+				foreignMembers = append(foreignMembers, *member.UserName+" of cluster "+owner)
+				continue
+			}
+		}
+		if _, err := r.IamWrapper.RemoveUserFromGroup(ctx, groupName, *member.UserName); err != nil {
+			return err
+		}
+	}
+	if len(foreignMembers) > 0 {
+		return &ForeignGroupMembersError{GroupName: groupName, Members: foreignMembers}
+	}
+
+	attachedPolicies, err := r.IamWrapper.ListAttachedGroupPolicies(ctx, groupName)
+	if err != nil {
+		return err
+	}
+	for _, policy := range attachedPolicies {
+		if err := r.IamWrapper.DetachGroupPolicyIfAttached(ctx, groupName, *policy.PolicyArn); err != nil {
+			return err
+		}
+	}
+
+	inlinePolicyNames, err := r.IamWrapper.ListGroupPolicies(ctx, groupName)
+	if err != nil {
+		return err
+	}
+	for _, policyName := range inlinePolicyNames {
+		if err := r.IamWrapper.DeleteGroupPolicyIfExists(ctx, groupName, policyName); err != nil {
+			return err
+		}
+	}
+
+	return r.IamWrapper.DeleteGroupIfExists(ctx, groupName)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (r *AwsGroupReconciler) event(awsGroup *kuadrav1.AwsGroup, eventType string, reason string, message string) {
+	if r.Recorder != nil {
+		r.Recorder.Event(awsGroup, eventType, reason, message)
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *AwsGroupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&kuadrav1.AwsGroup{}).
+		Complete(r)
+}
@@ -0,0 +1,223 @@
+package controller
+
+import (
+	"context"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+	kuadraaws "github.com/Kuadrant/kuadra/pkg/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8Types "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var _ = Describe("AwsGroup controller", func() {
+
+	const (
+		AwsGroupName      = "dns-management"
+		AwsGroupNamespace = "default"
+	)
+
+	ctx := context.Background()
+
+	awsGroupLookupKey := k8Types.NamespacedName{Name: AwsGroupName, Namespace: AwsGroupNamespace}
+
+	Context("When reconciling an AwsGroup", func() {
+		It("Should create the IAM group with its policies and clean it up on deletion", func() {
+			req := reconcile.Request{
+				NamespacedName: awsGroupLookupKey,
+			}
+
+			client := fake.NewClientBuilder().Build()
+
+			mockIam := mockIamWrapper{
+				Users:        []types.User{},
+				LoginProfile: map[string]types.LoginProfile{},
+				AccessKeys:   map[string][]types.AccessKey{},
+				Groups:       map[string][]types.Group{},
+			}
+
+			awsGroup := &kuadrav1.AwsGroup{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        AwsGroupName,
+					Namespace:   AwsGroupNamespace,
+					Annotations: map[string]string{TakeOverGroupAnnotation: AwsGroupName},
+				},
+				Spec: kuadrav1.AwsGroupSpec{
+					Path:              "/kuadra/",
+					ManagedPolicyArns: []string{"arn:aws:iam::aws:policy/AmazonRoute53ReadOnlyAccess"},
+					InlinePolicies: map[string]string{
+						"change-records": `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"route53:ChangeResourceRecordSets","Resource":"*"}]}`,
+					},
+				},
+			}
+			Expect(client.Create(ctx, awsGroup)).Should(Succeed())
+
+			By("By taking over a group with a policy attached out-of-band")
+			Expect(mockIam.CreateGroupIfNotExists(ctx, AwsGroupName, "/")).Should(Succeed())
+			Expect(mockIam.AttachGroupPolicy(ctx, AwsGroupName, "arn:aws:iam::aws:policy/AdministratorAccess")).Should(Succeed())
+
+			r := &AwsGroupReconciler{
+				Client:     client,
+				Scheme:     scheme.Scheme,
+				IamWrapper: &mockIam,
+			}
+
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+
+			By("By checking the IAM group")
+			iamGroup := mockIam.IamGroups[AwsGroupName]
+			Expect(*iamGroup.Group.Path).Should(Equal("/kuadra/"))
+			Expect(iamGroup.AttachedPolicyArns).Should(Equal(awsGroup.Spec.ManagedPolicyArns))
+			Expect(iamGroup.InlinePolicies).Should(Equal(awsGroup.Spec.InlinePolicies))
+
+			By("By checking the AwsGroup status")
+			created := &kuadrav1.AwsGroup{}
+			Expect(client.Get(ctx, awsGroupLookupKey, created)).Should(Succeed())
+			Expect(created.Status.GroupName).Should(Equal(AwsGroupName))
+			Expect(created.Status.GroupCreated).Should(BeTrue())
+			Expect(created.Status.AttachedPolicyArns).Should(Equal(awsGroup.Spec.ManagedPolicyArns))
+			Expect(created.Status.InlinePolicyNames).Should(Equal([]string{"change-records"}))
+			Expect(meta.IsStatusConditionTrue(created.Status.Conditions, kuadrav1.ConditionReady)).Should(BeTrue())
+
+			By("By adding a member and deleting the AwsGroup")
+			_, err = mockIam.AddUserToGroup(ctx, AwsGroupName, "ib-dns")
+			Expect(err).Should(BeNil())
+			Expect(client.Delete(ctx, created)).Should(Succeed())
+
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+
+			Expect(mockIam.IamGroups).ShouldNot(HaveKey(AwsGroupName))
+			Expect(mockIam.Groups["ib-dns"]).Should(BeEmpty())
+			err = client.Get(ctx, awsGroupLookupKey, created)
+			Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+		})
+
+		It("Should wait for members of other clusters to leave before deleting the group", func() {
+			req := reconcile.Request{
+				NamespacedName: awsGroupLookupKey,
+			}
+
+			client := fake.NewClientBuilder().Build()
+
+			mockIam := mockIamWrapper{
+				Users:        []types.User{},
+				LoginProfile: map[string]types.LoginProfile{},
+				AccessKeys:   map[string][]types.AccessKey{},
+				Groups:       map[string][]types.Group{},
+			}
+			Expect(mockIam.CreateUserIfNotExists(ctx, "ef-dns", kuadraaws.UserOptions{Tags: map[string]string{ClusterTag: "cluster-b"}})).Should(Succeed())
+
+			awsGroup := &kuadrav1.AwsGroup{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      AwsGroupName,
+					Namespace: AwsGroupNamespace,
+				},
+			}
+			Expect(client.Create(ctx, awsGroup)).Should(Succeed())
+
+			recorder := record.NewFakeRecorder(10)
+			r := &AwsGroupReconciler{
+				Client:     client,
+				Scheme:     scheme.Scheme,
+				IamWrapper: &mockIam,
+				Recorder:   recorder,
+				ClusterId:  "cluster-a",
+			}
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+
+			_, err = mockIam.AddUserToGroup(ctx, AwsGroupName, "ef-dns")
+			Expect(err).Should(BeNil())
+			created := &kuadrav1.AwsGroup{}
+			Expect(client.Get(ctx, awsGroupLookupKey, created)).Should(Succeed())
+			Expect(client.Delete(ctx, created)).Should(Succeed())
+
+			By("By reporting the members instead of retrying")
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(mockIam.IamGroups).Should(HaveKey(AwsGroupName))
+			Expect(client.Get(ctx, awsGroupLookupKey, created)).Should(Succeed())
+			condition := meta.FindStatusCondition(created.Status.Conditions, kuadrav1.ConditionReady)
+			Expect(condition.Status).Should(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).Should(Equal("ForeignMembers"))
+			Expect(recorder.Events).Should(Receive(ContainSubstring("DeleteBlocked group dns-management cannot be deleted, it has members managed by other clusters: ef-dns of cluster cluster-b")))
+
+			By("By deleting the group once they have left")
+			_, err = mockIam.RemoveUserFromGroup(ctx, AwsGroupName, "ef-dns")
+			Expect(err).Should(BeNil())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(mockIam.IamGroups).ShouldNot(HaveKey(AwsGroupName))
+		})
+
+		It("Should leave groups it did not create alone", func() {
+			req := reconcile.Request{
+				NamespacedName: awsGroupLookupKey,
+			}
+
+			client := fake.NewClientBuilder().Build()
+
+			mockIam := mockIamWrapper{
+				Users:        []types.User{},
+				LoginProfile: map[string]types.LoginProfile{},
+				AccessKeys:   map[string][]types.AccessKey{},
+				Groups:       map[string][]types.Group{},
+			}
+			Expect(mockIam.CreateGroupIfNotExists(ctx, "Admins", "/")).Should(Succeed())
+			Expect(mockIam.AttachGroupPolicy(ctx, "Admins", "arn:aws:iam::aws:policy/AdministratorAccess")).Should(Succeed())
+			_, err := mockIam.AddUserToGroup(ctx, "Admins", "root-admin")
+			Expect(err).Should(BeNil())
+
+			awsGroup := &kuadrav1.AwsGroup{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      AwsGroupName,
+					Namespace: AwsGroupNamespace,
+				},
+				Spec: kuadrav1.AwsGroupSpec{
+					GroupName: "Admins",
+				},
+			}
+			Expect(client.Create(ctx, awsGroup)).Should(Succeed())
+
+			recorder := record.NewFakeRecorder(10)
+			r := &AwsGroupReconciler{
+				Client:     client,
+				Scheme:     scheme.Scheme,
+				IamWrapper: &mockIam,
+				Recorder:   recorder,
+			}
+
+			By("By refusing to change the group")
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(mockIam.IamGroups["Admins"].AttachedPolicyArns).Should(Equal([]string{"arn:aws:iam::aws:policy/AdministratorAccess"}))
+			created := &kuadrav1.AwsGroup{}
+			Expect(client.Get(ctx, awsGroupLookupKey, created)).Should(Succeed())
+			Expect(created.Status.GroupCreated).Should(BeFalse())
+			condition := meta.FindStatusCondition(created.Status.Conditions, kuadrav1.ConditionReady)
+			Expect(condition.Status).Should(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).Should(Equal("GroupNotOwned"))
+			Expect(recorder.Events).Should(Receive(ContainSubstring("GroupNotOwned IAM group Admins was not created by Kuadra")))
+
+			By("By keeping the group when the AwsGroup is deleted")
+			Expect(client.Delete(ctx, created)).Should(Succeed())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(mockIam.IamGroups).Should(HaveKey("Admins"))
+			Expect(mockIam.Groups["root-admin"]).Should(HaveLen(1))
+			err = client.Get(ctx, awsGroupLookupKey, created)
+			Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,259 @@
+package controller
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+)
+
+const (
+	DefaultCredentialsSecretName = "aws-credentials"
+	DefaultLoginSecretName       = "aws-login"
+	DefaultDNSProviderSecretName = "aws-dns-provider"
+	DefaultCredentialsProfile    = "default"
+
+	credentialsIniKey  = "credentials"
+	credentialsConfKey = "config"
+	credentialsJsonKey = "credentials.json"
+
+	// KuadrantAwsSecretType is the Secret type the Kuadrant DNS operator reads AWS credentials from
+	KuadrantAwsSecretType v1.SecretType = "kuadrant.io/aws"
+
+	// SecretOwnerAnnotation on a Secret names the AwsAccount that wrote it, as namespace/name
+	SecretOwnerAnnotation = "kuadra.kuadrant.io/aws-account"
+)
+
+// SecretNotOwnedError is returned for a credentials Secret that exists outside the user
+// namespace and was not written for the AwsAccount, which Kuadra leaves alone
+type SecretNotOwnedError struct {
+	Namespace string
+	Name      string
+}
+
+func (e *SecretNotOwnedError) Error() string {
+	return fmt.Sprintf("Secret %s/%s already exists and was not written for this AwsAccount, choose another Secret or annotate it with %s",
+		e.Namespace, e.Name, SecretOwnerAnnotation)
+}
+
+// secretOwner is the value of SecretOwnerAnnotation on the Secrets of the AwsAccount
+func secretOwner(awsAccount *kuadrav1.AwsAccount) string {
+	return awsAccount.Namespace + "/" + awsAccount.Name
+}
+
+// ownsSecret reports whether Kuadra may write or delete an existing Secret for the AwsAccount:
+// Secrets in the namespace of its user, which Kuadra creates, and Secrets annotated with the
+// AwsAccount. Any other Secret belongs to someone else.
+func ownsSecret(awsAccount *kuadrav1.AwsAccount, secret *v1.Secret) bool {
+	if secret.Namespace == awsAccount.Spec.UserName || secret.Namespace == awsAccount.AppliedUserName() ||
+		(awsAccount.Status.PreviousUserName != "" && secret.Namespace == awsAccount.Status.PreviousUserName) {
+		return true
+	}
+	return secret.Annotations[SecretOwnerAnnotation] == secretOwner(awsAccount)
+}
+
+// AccessKeyCredentials is the content written into the access key Secret
+type AccessKeyCredentials struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	Region          string
+	AccountId       string
+}
+
+// credentialsProcessDocument mirrors the output expected from an AWS credential_process
+type credentialsProcessDocument struct {
+	Version         int    `json:"Version"`
+	AccessKeyId     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Region          string `json:"Region,omitempty"`
+	AccountId       string `json:"AccountId,omitempty"`
+}
+
+// resolveCredentials returns the credentials spec of the AwsAccount with all defaults filled in
+func resolveCredentials(awsAccount kuadrav1.AwsAccount, defaultRegion string) kuadrav1.CredentialsSpec {
+	resolved := kuadrav1.CredentialsSpec{}
+	if awsAccount.Spec.Credentials != nil {
+		resolved = *awsAccount.Spec.Credentials.DeepCopy()
+	}
+	resolved.SecretRef = resolveSecretReference(resolved.SecretRef, DefaultCredentialsSecretName, awsAccount.Spec.UserName)
+	resolved.LoginSecretRef = resolveSecretReference(resolved.LoginSecretRef, DefaultLoginSecretName, awsAccount.Spec.UserName)
+	if resolved.DNSProviderSecretRef != nil {
+		resolved.DNSProviderSecretRef = resolveSecretReference(resolved.DNSProviderSecretRef, "", awsAccount.Spec.UserName)
+	}
+	if resolved.Format == "" {
+		resolved.Format = kuadrav1.CredentialsFormatEnv
+	}
+	if resolved.Region == "" {
+		resolved.Region = defaultRegion
+	}
+	if resolved.Profile == "" {
+		resolved.Profile = DefaultCredentialsProfile
+	}
+	// A managed zone needs a DNS provider Secret next to it in the user namespace
+	if hostedZone := awsAccount.Spec.HostedZone; hostedZone != nil && hostedZone.CreateManagedZone && resolved.DNSProviderSecretRef == nil &&
+		!(resolved.Format == kuadrav1.CredentialsFormatKuadrant && resolved.SecretRef.Namespace == awsAccount.Spec.UserName) {
+		resolved.DNSProviderSecretRef = &kuadrav1.SecretReference{Name: DefaultDNSProviderSecretName, Namespace: awsAccount.Spec.UserName}
+	}
+	return resolved
+}
+
+// legacyCredentials describes where credentials were written before the target was configurable
+func legacyCredentials(userName string) kuadrav1.CredentialsSpec {
+	return kuadrav1.CredentialsSpec{
+		SecretRef:      &kuadrav1.SecretReference{Name: DefaultCredentialsSecretName, Namespace: userName},
+		LoginSecretRef: &kuadrav1.SecretReference{Name: DefaultLoginSecretName, Namespace: userName},
+		Format:         kuadrav1.CredentialsFormatEnv,
+		Profile:        DefaultCredentialsProfile,
+	}
+}
+
+func resolveSecretReference(ref *kuadrav1.SecretReference, defaultName string, defaultNamespace string) *kuadrav1.SecretReference {
+	resolved := kuadrav1.SecretReference{Name: defaultName, Namespace: defaultNamespace}
+	if ref != nil {
+		if ref.Name != "" {
+			resolved.Name = ref.Name
+		}
+		if ref.Namespace != "" {
+			resolved.Namespace = ref.Namespace
+		}
+	}
+	return &resolved
+}
+
+// credentialsSecretType returns the type of the Secret the format is written to
+func credentialsSecretType(format kuadrav1.CredentialsFormat) v1.SecretType {
+	if format == kuadrav1.CredentialsFormatKuadrant {
+		return KuadrantAwsSecretType
+	}
+	return v1.SecretTypeOpaque
+}
+
+// renderCredentials lays out the access key according to the requested format
+func renderCredentials(spec kuadrav1.CredentialsSpec, creds AccessKeyCredentials) (map[string]string, error) {
+	switch spec.Format {
+	case kuadrav1.CredentialsFormatKuadrant:
+		return map[string]string{
+			"AWS_ACCESS_KEY_ID":     creds.AccessKeyId,
+			"AWS_SECRET_ACCESS_KEY": creds.SecretAccessKey,
+			"AWS_REGION":            creds.Region,
+		}, nil
+	case kuadrav1.CredentialsFormatEnv, "":
+		data := map[string]string{
+			"AWS_ACCESS_KEY_ID":     creds.AccessKeyId,
+			"AWS_SECRET_ACCESS_KEY": creds.SecretAccessKey,
+		}
+		if creds.Region != "" {
+			data["AWS_REGION"] = creds.Region
+		}
+		if creds.AccountId != "" {
+			data["AWS_ACCOUNT_ID"] = creds.AccountId
+		}
+		return data, nil
+	case kuadrav1.CredentialsFormatIni:
+		return map[string]string{
+			credentialsIniKey: renderIniSection(spec.Profile, creds),
+		}, nil
+	case kuadrav1.CredentialsFormatConfig:
+		section := "profile " + spec.Profile
+		if spec.Profile == DefaultCredentialsProfile {
+			section = DefaultCredentialsProfile
+		}
+		return map[string]string{
+			credentialsConfKey: renderIniSection(section, creds),
+		}, nil
+	case kuadrav1.CredentialsFormatJson:
+		document, err := json.Marshal(credentialsProcessDocument{
+			Version:         1,
+			AccessKeyId:     creds.AccessKeyId,
+			SecretAccessKey: creds.SecretAccessKey,
+			Region:          creds.Region,
+			AccountId:       creds.AccountId,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{
+			credentialsJsonKey: string(document),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported credentials format %q", spec.Format)
+	}
+}
+
+func renderIniSection(section string, creds AccessKeyCredentials) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s]\n", section)
+	fmt.Fprintf(&b, "aws_access_key_id = %s\n", creds.AccessKeyId)
+	fmt.Fprintf(&b, "aws_secret_access_key = %s\n", creds.SecretAccessKey)
+	if creds.Region != "" {
+		fmt.Fprintf(&b, "region = %s\n", creds.Region)
+	}
+	if creds.AccountId != "" {
+		fmt.Fprintf(&b, "aws_account_id = %s\n", creds.AccountId)
+	}
+	return b.String()
+}
+
+// ParseCredentials reads the access key back out of a Secret written by renderCredentials.
+// kubectl-kuadra uses it to copy the access key into a local profile.
+func ParseCredentials(format kuadrav1.CredentialsFormat, data map[string][]byte) (AccessKeyCredentials, error) {
+	var creds AccessKeyCredentials
+	switch format {
+	case kuadrav1.CredentialsFormatEnv, kuadrav1.CredentialsFormatKuadrant, "":
+		creds = AccessKeyCredentials{
+			AccessKeyId:     string(data["AWS_ACCESS_KEY_ID"]),
+			SecretAccessKey: string(data["AWS_SECRET_ACCESS_KEY"]),
+			Region:          string(data["AWS_REGION"]),
+			AccountId:       string(data["AWS_ACCOUNT_ID"]),
+		}
+	case kuadrav1.CredentialsFormatIni:
+		creds = parseIniSection(string(data[credentialsIniKey]))
+	case kuadrav1.CredentialsFormatConfig:
+		creds = parseIniSection(string(data[credentialsConfKey]))
+	case kuadrav1.CredentialsFormatJson:
+		var document credentialsProcessDocument
+		if err := json.Unmarshal(data[credentialsJsonKey], &document); err != nil {
+			return creds, err
+		}
+		creds = AccessKeyCredentials{
+			AccessKeyId:     document.AccessKeyId,
+			SecretAccessKey: document.SecretAccessKey,
+			Region:          document.Region,
+			AccountId:       document.AccountId,
+		}
+	default:
+		return creds, fmt.Errorf("unsupported credentials format %q", format)
+	}
+	if creds.AccessKeyId == "" || creds.SecretAccessKey == "" {
+		return creds, fmt.Errorf("no access key found in %s formatted secret", format)
+	}
+	return creds, nil
+}
+
+// parseIniSection reads the first profile of a file written by renderIniSection
+func parseIniSection(content string) AccessKeyCredentials {
+	var creds AccessKeyCredentials
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), "=")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "aws_access_key_id":
+			creds.AccessKeyId = value
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = value
+		case "region":
+			creds.Region = value
+		case "aws_account_id":
+			creds.AccountId = value
+		}
+	}
+	return creds
+}
@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+	slice "github.com/Kuadrant/kuadra/pkg/_internal"
+)
+
+// maxDriftItems is how many drift items are kept in the status of an AwsAccount
+const maxDriftItems = 10
+
+// detectDrift compares the status recorded by the last reconcile with the state just read
+// back from IAM. As the controller records every change it makes, any difference was made
+// out-of-band.
+func detectDrift(previous kuadrav1.AwsAccountStatus, observed kuadrav1.AwsAccountStatus) []kuadrav1.DriftItem {
+	if !previous.UserCreated {
+		return nil
+	}
+	if !observed.UserCreated {
+		return []kuadrav1.DriftItem{{Type: kuadrav1.DriftUserDeleted}}
+	}
+
+	var drift []kuadrav1.DriftItem
+	if previous.LoginProfileCreated && !observed.LoginProfileCreated {
+		drift = append(drift, kuadrav1.DriftItem{Type: kuadrav1.DriftLoginProfileDeleted})
+	}
+	if previous.AccessKeyCreated && !observed.AccessKeyCreated {
+		drift = append(drift, kuadrav1.DriftItem{Type: kuadrav1.DriftAccessKeyDeleted})
+	}
+	drift = append(drift, listDrift(previous.UserGroups, observed.UserGroups, kuadrav1.DriftGroupRemoved, kuadrav1.DriftGroupAdded)...)
+	drift = append(drift, listDrift(previous.AttachedPolicyArns, observed.AttachedPolicyArns, kuadrav1.DriftPolicyDetached, kuadrav1.DriftPolicyAttached)...)
+	drift = append(drift, listDrift(previous.InlinePolicyNames, observed.InlinePolicyNames, kuadrav1.DriftInlinePolicyDeleted, kuadrav1.DriftInlinePolicyAdded)...)
+	// Empty values were not recorded by older versions of the controller
+	if previous.PermissionsBoundary != "" && previous.PermissionsBoundary != observed.PermissionsBoundary {
+		drift = append(drift, kuadrav1.DriftItem{Type: kuadrav1.DriftPermissionsBoundaryChanged, Subject: observed.PermissionsBoundary})
+	}
+	if previous.Path != "" && previous.Path != observed.Path {
+		drift = append(drift, kuadrav1.DriftItem{Type: kuadrav1.DriftPathChanged, Subject: observed.Path})
+	}
+	return drift
+}
+
+func listDrift(previous []string, observed []string, removed kuadrav1.DriftType, added kuadrav1.DriftType) []kuadrav1.DriftItem {
+	var drift []kuadrav1.DriftItem
+	for _, item := range slice.GetLeftDifference(previous, observed) {
+		drift = append(drift, kuadrav1.DriftItem{Type: removed, Subject: item})
+	}
+	for _, item := range slice.GetLeftDifference(observed, previous) {
+		drift = append(drift, kuadrav1.DriftItem{Type: added, Subject: item})
+	}
+	return drift
+}
+
+// maskDrift puts back the previously recorded state so that the rest of the reconcile
+// leaves drift in place, for report only mode
+func maskDrift(status *kuadrav1.AwsAccountStatus, previous kuadrav1.AwsAccountStatus) {
+	status.UserCreated = previous.UserCreated
+	status.LoginProfileCreated = previous.LoginProfileCreated
+	status.AccessKeyCreated = previous.AccessKeyCreated
+	status.UserGroups = previous.UserGroups
+	status.AttachedPolicyArns = previous.AttachedPolicyArns
+	status.InlinePolicyNames = previous.InlinePolicyNames
+	status.PermissionsBoundary = previous.PermissionsBoundary
+	status.Path = previous.Path
+}
+
+// recordDrift adds the detected drift to the status and returns the items not recorded before.
+// Reported but uncorrected drift is recorded once and dropped when it is no longer detected.
+func (r *AwsAccountReconciler) recordDrift(awsAccount *kuadrav1.AwsAccount, detected []kuadrav1.DriftItem) []kuadrav1.DriftItem {
+	now := metav1.Now()
+	sameDrift := func(a kuadrav1.DriftItem, b kuadrav1.DriftItem) bool {
+		return a.Type == b.Type && a.Subject == b.Subject
+	}
+
+	var kept []kuadrav1.DriftItem
+	for _, item := range awsAccount.Status.Drift {
+		if item.Corrected || slice.IndexOf(detected, func(d kuadrav1.DriftItem) bool { return sameDrift(d, item) }) != -1 {
+			kept = append(kept, item)
+		}
+	}
+	awsAccount.Status.Drift = kept
+
+	var recorded []kuadrav1.DriftItem
+	for _, item := range detected {
+		if slice.IndexOf(awsAccount.Status.Drift, func(d kuadrav1.DriftItem) bool { return !d.Corrected && sameDrift(d, item) }) != -1 {
+			continue
+		}
+		item.DetectedAt = now
+		item.Corrected = !r.DriftReportOnly
+		awsAccount.Status.Drift = append(awsAccount.Status.Drift, item)
+		recorded = append(recorded, item)
+	}
+	if excess := len(awsAccount.Status.Drift) - maxDriftItems; excess > 0 {
+		awsAccount.Status.Drift = awsAccount.Status.Drift[excess:]
+	}
+	return recorded
+}
+
+// reportDrift emits an event and updates the drift metrics for newly recorded drift
+func (r *AwsAccountReconciler) reportDrift(awsAccount *kuadrav1.AwsAccount, recorded []kuadrav1.DriftItem) {
+	for _, item := range recorded {
+		driftDetectedTotal.WithLabelValues(string(item.Type)).Inc()
+		message := fmt.Sprintf("%s detected on IAM user %s", item.Type, awsAccount.Spec.UserName)
+		if item.Subject != "" {
+			message = fmt.Sprintf("%s %s detected on IAM user %s", item.Type, item.Subject, awsAccount.Spec.UserName)
+		}
+		if item.Corrected {
+			driftCorrectionsTotal.WithLabelValues(string(item.Type)).Inc()
+			message += ", corrected"
+		} else {
+			message += ", not corrected in report only mode"
+		}
+		if r.Recorder != nil {
+			r.Recorder.Event(awsAccount, v1.EventTypeWarning, "Drift", message)
+		}
+	}
+}
@@ -0,0 +1,418 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/smithy-go/middleware"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+	kuadraaws "github.com/Kuadrant/kuadra/pkg/aws"
+)
+
+// DryRunAnnotation set to "true" on an AwsAccount or AwsGroup reconciles it in dry run mode:
+// the changes needed are worked out and written to status.plannedActions, but not made
+const DryRunAnnotation = "kuadra.kuadrant.io/dry-run"
+
+// dryRunAccessKeyId stands in for the access key a dry run would have created
+const dryRunAccessKeyId = "DRYRUN"
+
+func isDryRun(dryRun bool, object client.Object) bool {
+	return dryRun || object.GetAnnotations()[DryRunAnnotation] == "true"
+}
+
+// plan collects the changes of a dry run reconcile, in the order they would have been made
+type plan struct {
+	actions []kuadrav1.PlannedAction
+}
+
+func (p *plan) add(action string, target string) {
+	planned := kuadrav1.PlannedAction{Action: action, Target: target}
+	for _, existing := range p.actions {
+		if existing == planned {
+			return
+		}
+	}
+	p.actions = append(p.actions, planned)
+}
+
+// setDryRunCondition records the outcome of a dry run reconcile in conditions
+func setDryRunCondition(conditions *[]metav1.Condition, generation int64, p *plan, planErr error) {
+	condition := metav1.Condition{
+		Type:               kuadrav1.ConditionDryRun,
+		Status:             metav1.ConditionTrue,
+		Reason:             "ChangesPlanned",
+		Message:            fmt.Sprintf("%d changes planned, none made", len(p.actions)),
+		ObservedGeneration: generation,
+	}
+	if len(p.actions) == 0 {
+		condition.Reason = "UpToDate"
+		condition.Message = "No changes needed"
+	}
+	if planErr != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "PlanFailed"
+		condition.Message = fmt.Sprintf("planning stopped after %d changes: %v", len(p.actions), planErr)
+	}
+	meta.SetStatusCondition(conditions, condition)
+}
+
+// reportPlan emits an event for each planned change when the plan differs from the previous one
+func reportPlan(recorder record.EventRecorder, object runtime.Object, previous []kuadrav1.PlannedAction, p *plan) {
+	if recorder == nil || reflect.DeepEqual(previous, p.actions) {
+		return
+	}
+	for _, action := range p.actions {
+		recorder.Eventf(object, v1.EventTypeNormal, "DryRun", "Would %s %s", action.Action, action.Target)
+	}
+}
+
+// dryRunIamWrapper reads from IAM and records every write in the plan instead of making it.
+// Users and groups it would have created read as they would be right after their creation.
+// It does not embed the IamWrapper it reads from, so that a method added to IamWrapper does
+// not compile until the dry run handles it, rather than reaching IAM.
+type dryRunIamWrapper struct {
+	iam  IamWrapper
+	plan *plan
+	// users maps the users that would have been created to their tags
+	users  map[string]map[string]string
+	groups map[string]bool
+}
+
+func newDryRunIamWrapper(iamWrapper IamWrapper, p *plan) *dryRunIamWrapper {
+	return &dryRunIamWrapper{iam: iamWrapper, plan: p, users: map[string]map[string]string{}, groups: map[string]bool{}}
+}
+
+var _ IamWrapper = &dryRunIamWrapper{}
+
+func (w *dryRunIamWrapper) GetUser(ctx context.Context, userName string) (*types.User, error) {
+	return w.iam.GetUser(ctx, userName)
+}
+
+func (w *dryRunIamWrapper) IsExistingUser(ctx context.Context, userName string) (bool, error) {
+	return w.iam.IsExistingUser(ctx, userName)
+}
+
+func (w *dryRunIamWrapper) HasLoginProfile(ctx context.Context, userName string) (bool, error) {
+	return w.iam.HasLoginProfile(ctx, userName)
+}
+
+func (w *dryRunIamWrapper) HasAccessKey(ctx context.Context, userName string) (bool, error) {
+	return w.iam.HasAccessKey(ctx, userName)
+}
+
+func (w *dryRunIamWrapper) ListGroupsForUser(ctx context.Context, userName string) ([]types.Group, error) {
+	return w.iam.ListGroupsForUser(ctx, userName)
+}
+
+func (w *dryRunIamWrapper) ListUsers(ctx context.Context, pathPrefix string, maxUsers int32) ([]types.User, error) {
+	return w.iam.ListUsers(ctx, pathPrefix, maxUsers)
+}
+
+func (w *dryRunIamWrapper) ListAccessKeys(ctx context.Context, userName string) ([]types.AccessKeyMetadata, error) {
+	return w.iam.ListAccessKeys(ctx, userName)
+}
+
+func (w *dryRunIamWrapper) ListAttachedUserPolicies(ctx context.Context, userName string) ([]types.AttachedPolicy, error) {
+	return w.iam.ListAttachedUserPolicies(ctx, userName)
+}
+
+func (w *dryRunIamWrapper) ListUserPolicies(ctx context.Context, userName string) ([]string, error) {
+	return w.iam.ListUserPolicies(ctx, userName)
+}
+
+func (w *dryRunIamWrapper) GetGroup(ctx context.Context, groupName string) (*types.Group, []types.User, error) {
+	return w.iam.GetGroup(ctx, groupName)
+}
+
+func (w *dryRunIamWrapper) CreateUserIfNotExists(ctx context.Context, userName string, options kuadraaws.UserOptions) error {
+	w.plan.add("iam:CreateUser", userName)
+	w.users[userName] = options.Tags
+	return nil
+}
+
+func (w *dryRunIamWrapper) ListUserTags(ctx context.Context, userName string) (map[string]string, error) {
+	if tags, created := w.users[userName]; created {
+		return tags, nil
+	}
+	return w.iam.ListUserTags(ctx, userName)
+}
+
+func (w *dryRunIamWrapper) GetUserPolicy(ctx context.Context, userName string, policyName string) (string, error) {
+	if _, created := w.users[userName]; created {
+		return "", nil
+	}
+	return w.iam.GetUserPolicy(ctx, userName, policyName)
+}
+
+func (w *dryRunIamWrapper) PutUserPermissionsBoundary(ctx context.Context, userName string, boundaryArn string) error {
+	w.plan.add("iam:PutUserPermissionsBoundary", boundaryArn)
+	return nil
+}
+
+func (w *dryRunIamWrapper) DeleteUserPermissionsBoundary(ctx context.Context, userName string) error {
+	w.plan.add("iam:DeleteUserPermissionsBoundary", userName)
+	return nil
+}
+
+func (w *dryRunIamWrapper) UpdateUserPath(ctx context.Context, userName string, path string) error {
+	w.plan.add("iam:UpdateUser", path)
+	return nil
+}
+
+func (w *dryRunIamWrapper) UpdateUserName(ctx context.Context, userName string, newUserName string) error {
+	w.plan.add("iam:UpdateUser", newUserName)
+	return nil
+}
+
+func (w *dryRunIamWrapper) TagUser(ctx context.Context, userName string, tags map[string]string) error {
+	for _, key := range sortedKeys(tags) {
+		w.plan.add("iam:TagUser", key+"="+tags[key])
+	}
+	return nil
+}
+
+func (w *dryRunIamWrapper) UntagUser(ctx context.Context, userName string, tagKeys []string) error {
+	for _, key := range tagKeys {
+		w.plan.add("iam:UntagUser", key)
+	}
+	return nil
+}
+
+func (w *dryRunIamWrapper) CreateLoginProfileIfNotExists(ctx context.Context, password string, userName string, passwordResetRequired bool) error {
+	w.plan.add("iam:CreateLoginProfile", userName)
+	return nil
+}
+
+func (w *dryRunIamWrapper) CreateAccessKeyPair(ctx context.Context, userName string) (*types.AccessKey, error) {
+	w.plan.add("iam:CreateAccessKey", userName)
+	return &types.AccessKey{
+		UserName:        aws.String(userName),
+		AccessKeyId:     aws.String(dryRunAccessKeyId),
+		SecretAccessKey: aws.String(dryRunAccessKeyId),
+	}, nil
+}
+
+func (w *dryRunIamWrapper) AddUserToGroup(ctx context.Context, groupName string, userName string) (middleware.Metadata, error) {
+	w.plan.add("iam:AddUserToGroup", groupName)
+	return middleware.Metadata{}, nil
+}
+
+func (w *dryRunIamWrapper) RemoveUserFromGroup(ctx context.Context, groupName string, userName string) (middleware.Metadata, error) {
+	w.plan.add("iam:RemoveUserFromGroup", groupName)
+	return middleware.Metadata{}, nil
+}
+
+func (w *dryRunIamWrapper) DeleteUser(ctx context.Context, userName string) error {
+	w.plan.add("iam:DeleteUser", userName)
+	return nil
+}
+
+func (w *dryRunIamWrapper) DeleteLoginProfileIfExists(ctx context.Context, userName string) error {
+	w.plan.add("iam:DeleteLoginProfile", userName)
+	return nil
+}
+
+func (w *dryRunIamWrapper) DeleteAccessKeyIfExists(ctx context.Context, userName string, keyId string) error {
+	w.plan.add("iam:DeleteAccessKey", keyId)
+	return nil
+}
+
+func (w *dryRunIamWrapper) UpdateAccessKeyStatus(ctx context.Context, userName string, keyId string, status types.StatusType) error {
+	w.plan.add("iam:UpdateAccessKey", keyId)
+	return nil
+}
+
+func (w *dryRunIamWrapper) AttachUserPolicy(ctx context.Context, userName string, policyArn string) error {
+	w.plan.add("iam:AttachUserPolicy", policyArn)
+	return nil
+}
+
+func (w *dryRunIamWrapper) DetachUserPolicyIfAttached(ctx context.Context, userName string, policyArn string) error {
+	w.plan.add("iam:DetachUserPolicy", policyArn)
+	return nil
+}
+
+func (w *dryRunIamWrapper) PutUserPolicy(ctx context.Context, userName string, policyName string, policyDocument string) error {
+	w.plan.add("iam:PutUserPolicy", policyName)
+	return nil
+}
+
+func (w *dryRunIamWrapper) DeleteUserPolicyIfExists(ctx context.Context, userName string, policyName string) error {
+	w.plan.add("iam:DeleteUserPolicy", policyName)
+	return nil
+}
+
+func (w *dryRunIamWrapper) CreateGroupIfNotExists(ctx context.Context, groupName string, path string) error {
+	w.plan.add("iam:CreateGroup", groupName)
+	w.groups[groupName] = true
+	return nil
+}
+
+func (w *dryRunIamWrapper) ListAttachedGroupPolicies(ctx context.Context, groupName string) ([]types.AttachedPolicy, error) {
+	if w.groups[groupName] {
+		return nil, nil
+	}
+	return w.iam.ListAttachedGroupPolicies(ctx, groupName)
+}
+
+func (w *dryRunIamWrapper) ListGroupPolicies(ctx context.Context, groupName string) ([]string, error) {
+	if w.groups[groupName] {
+		return nil, nil
+	}
+	return w.iam.ListGroupPolicies(ctx, groupName)
+}
+
+func (w *dryRunIamWrapper) GetGroupPolicy(ctx context.Context, groupName string, policyName string) (string, error) {
+	if w.groups[groupName] {
+		return "", nil
+	}
+	return w.iam.GetGroupPolicy(ctx, groupName, policyName)
+}
+
+func (w *dryRunIamWrapper) UpdateGroupPath(ctx context.Context, groupName string, path string) error {
+	w.plan.add("iam:UpdateGroup", path)
+	return nil
+}
+
+func (w *dryRunIamWrapper) DeleteGroupIfExists(ctx context.Context, groupName string) error {
+	w.plan.add("iam:DeleteGroup", groupName)
+	return nil
+}
+
+func (w *dryRunIamWrapper) AttachGroupPolicy(ctx context.Context, groupName string, policyArn string) error {
+	w.plan.add("iam:AttachGroupPolicy", policyArn)
+	return nil
+}
+
+func (w *dryRunIamWrapper) DetachGroupPolicyIfAttached(ctx context.Context, groupName string, policyArn string) error {
+	w.plan.add("iam:DetachGroupPolicy", policyArn)
+	return nil
+}
+
+func (w *dryRunIamWrapper) PutGroupPolicy(ctx context.Context, groupName string, policyName string, policyDocument string) error {
+	w.plan.add("iam:PutGroupPolicy", policyName)
+	return nil
+}
+
+func (w *dryRunIamWrapper) DeleteGroupPolicyIfExists(ctx context.Context, groupName string, policyName string) error {
+	w.plan.add("iam:DeleteGroupPolicy", policyName)
+	return nil
+}
+
+// dryRunClient reads from Kubernetes and records every write in the plan instead of making
+// it. Objects it would have created or updated are returned by Get, so that later steps
+// see them as a real reconcile would. Writes to the object being planned, such as adding
+// its finalizer, are dropped without being recorded.
+type dryRunClient struct {
+	client.Client
+	plan    *plan
+	planned string
+	written map[string]client.Object
+}
+
+func newDryRunClient(c client.Client, p *plan, planned client.Object) *dryRunClient {
+	dryRunClient := &dryRunClient{Client: c, plan: p, written: map[string]client.Object{}}
+	dryRunClient.planned = dryRunClient.key(planned)
+	return dryRunClient
+}
+
+// describe returns the kind of obj and its namespaced name
+func (c *dryRunClient) describe(obj client.Object) (string, string) {
+	kind := reflect.TypeOf(obj).Elem().Name()
+	if gvk, err := apiutil.GVKForObject(obj, c.Scheme()); err == nil {
+		kind = gvk.Kind
+	}
+	if obj.GetNamespace() == "" {
+		return kind, obj.GetName()
+	}
+	return kind, client.ObjectKeyFromObject(obj).String()
+}
+
+// key identifies obj among the objects written during the plan
+func (c *dryRunClient) key(obj client.Object) string {
+	kind, name := c.describe(obj)
+	return kind + "/" + name
+}
+
+func (c *dryRunClient) record(verb string, obj client.Object) {
+	if c.key(obj) == c.planned {
+		return
+	}
+	kind, name := c.describe(obj)
+	c.plan.add(verb+" "+kind, name)
+}
+
+func (c *dryRunClient) remember(obj client.Object) {
+	c.written[c.key(obj)] = obj.DeepCopyObject().(client.Object)
+}
+
+func (c *dryRunClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	kind, _ := c.describe(obj)
+	name := key.Name
+	if key.Namespace != "" {
+		name = key.String()
+	}
+	if written, ok := c.written[kind+"/"+name]; ok && reflect.TypeOf(written) == reflect.TypeOf(obj) {
+		reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(written.DeepCopyObject()).Elem())
+		return nil
+	}
+	return c.Client.Get(ctx, key, obj, opts...)
+}
+
+func (c *dryRunClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	c.record("create", obj)
+	c.remember(obj)
+	return nil
+}
+
+func (c *dryRunClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	c.record("update", obj)
+	c.remember(obj)
+	return nil
+}
+
+func (c *dryRunClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	c.record("patch", obj)
+	return nil
+}
+
+func (c *dryRunClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	c.record("delete", obj)
+	return nil
+}
+
+func (c *dryRunClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	c.record("delete all", obj)
+	return nil
+}
+
+func (c *dryRunClient) Status() client.StatusWriter {
+	return dryRunStatusWriter{}
+}
+
+// dryRunStatusWriter drops status writes. Only the planned object's status is written during
+// a dry run, and that is done with the real client once planning is over.
+type dryRunStatusWriter struct{}
+
+func (dryRunStatusWriter) Create(ctx context.Context, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+	return nil
+}
+
+func (dryRunStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+	return nil
+}
+
+func (dryRunStatusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+	return nil
+}
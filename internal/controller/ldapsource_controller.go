@@ -0,0 +1,373 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+	slice "github.com/Kuadrant/kuadra/pkg/_internal"
+	"github.com/Kuadrant/kuadra/pkg/ldap"
+)
+
+// LdapSourceLabel on a User names the LdapSource it was generated from
+const LdapSourceLabel = "kuadra.kuadrant.io/ldap-source"
+
+// Keys of the bind Secret of an LdapSource
+const (
+	LdapBindDNKey   = "bindDN"
+	LdapPasswordKey = "password"
+	LdapCAKey       = "ca.crt"
+)
+
+const (
+	// defaultLdapSyncInterval is used when the spec leaves the interval unset
+	defaultLdapSyncInterval = 10 * time.Minute
+	// ldapSyncTimeout bounds connecting, binding and searching in one sync
+	ldapSyncTimeout = time.Minute
+	// maxLdapSourceErrors bounds the entry errors kept in the status
+	maxLdapSourceErrors = 20
+	// defaultLdapMaxPrunePercent is used when the spec leaves the prune limit unset
+	defaultLdapMaxPrunePercent = 20
+)
+
+// LdapSourceReconciler reconciles a LdapSource object
+type LdapSourceReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// DryRun logs the changes to the Users of every LdapSource without making them, as
+	// DryRunAnnotation does for one
+	DryRun bool
+}
+
+//+kubebuilder:rbac:groups=kuadra.kuadrant.io,resources=ldapsources,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=kuadra.kuadrant.io,resources=ldapsources/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=kuadra.kuadrant.io,resources=ldapsources/finalizers,verbs=update
+//+kubebuilder:rbac:groups=kuadra.kuadrant.io,resources=users,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+// Reconcile searches the directory of the LdapSource and creates, updates and deletes its
+// Users to match the entries found, then waits for the sync interval. A failed search
+// leaves the Users as they are, and so does one that would delete more of them than the
+// LdapSource allows.
+func (r *LdapSourceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	var source kuadrav1.LdapSource
+	if err := r.Get(ctx, req.NamespacedName, &source); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	interval := source.Spec.SyncInterval.Duration
+	if interval <= 0 {
+		interval = defaultLdapSyncInterval
+	}
+
+	status := source.Status.DeepCopy()
+	now := metav1.Now()
+	status.LastSyncTime = &now
+
+	entries, err := r.search(ctx, &source)
+	if err != nil {
+		log.Error(err, "unable to search directory")
+		return ctrl.Result{}, r.syncFailed(ctx, &source, status, err)
+	}
+	users, entryErrors := usersFromLdapEntries(&source.Spec, entries)
+
+	c := r.Client
+	dryRun := isDryRun(r.DryRun, &source)
+	if dryRun {
+		p := &plan{}
+		c = newDryRunClient(r.Client, p, &source)
+		defer func() { log.Info("dry run, changes not made", "plannedActions", p.actions) }()
+	}
+	result, err := syncUsers(ctx, c, r.Scheme, &source, LdapSourceLabel, users, true, ldapMaxPrunePercent(&source.Spec))
+	if err != nil {
+		log.Error(err, "unable to sync Users of LdapSource")
+		return ctrl.Result{}, r.syncFailed(ctx, &source, status, err)
+	}
+	if dryRun {
+		return ctrl.Result{RequeueAfter: interval}, nil
+	}
+
+	for _, conflict := range result.Conflicts {
+		entryErrors = append(entryErrors, fmt.Sprintf("User %s already exists and was not generated from this LdapSource", conflict))
+	}
+	if len(result.Conflicts) > 0 {
+		r.event(&source, corev1.EventTypeWarning, "UserConflict",
+			fmt.Sprintf("Users %s already exist and were not generated from this LdapSource", strings.Join(result.Conflicts, ", ")))
+	}
+	if result.Applied > 0 || result.Deleted > 0 {
+		r.event(&source, corev1.EventTypeNormal, "UsersSynced",
+			fmt.Sprintf("Applied %d and deleted %d Users", result.Applied, result.Deleted))
+	}
+	pruneRefused := ""
+	if result.PruneRefused > 0 {
+		pruneRefused = fmt.Sprintf("Kept %d Users whose entries are gone, as deleting them exceeds maxPrunePercent %d or removes every User; set allowMassPrune to delete them",
+			result.PruneRefused, ldapMaxPrunePercent(&source.Spec))
+		r.event(&source, corev1.EventTypeWarning, "PruneRefused", pruneRefused)
+	}
+
+	valid := 0
+	for _, user := range users {
+		if !user.Invalid {
+			valid++
+		}
+	}
+	status.LastSuccessfulSyncTime = &now
+	status.Entries = len(entries)
+	status.Users = valid - len(result.Conflicts)
+	status.Applied = result.Applied
+	status.Deleted = result.Deleted
+	status.PruneRefused = result.PruneRefused
+	status.Errors = entryErrors
+	if len(status.Errors) > maxLdapSourceErrors {
+		status.Errors = append(status.Errors[:maxLdapSourceErrors],
+			fmt.Sprintf("and %d more", len(entryErrors)-maxLdapSourceErrors))
+	}
+	message := fmt.Sprintf("Synced %d Users from %d entries", status.Users, status.Entries)
+	if len(entryErrors) > 0 {
+		message += fmt.Sprintf(", %d entries could not be synced", len(entryErrors))
+	}
+	condition := metav1.Condition{
+		Type:               kuadrav1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Synced",
+		Message:            message,
+		ObservedGeneration: source.Generation,
+	}
+	if pruneRefused != "" {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "PruneRefused"
+		condition.Message = message + ". " + pruneRefused
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+	if err := r.updateStatus(ctx, &source, status); err != nil {
+		log.Error(err, "unable to update LdapSource status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: interval}, nil
+}
+
+// syncFailed records a failed sync in the status and returns err, so that the sync is
+// retried with backoff
+func (r *LdapSourceReconciler) syncFailed(ctx context.Context, source *kuadrav1.LdapSource, status *kuadrav1.LdapSourceStatus, err error) error {
+	r.event(source, corev1.EventTypeWarning, "SyncFailed", err.Error())
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               kuadrav1.ConditionReady,
+		Status:             metav1.ConditionFalse,
+		Reason:             "SyncFailed",
+		Message:            err.Error(),
+		ObservedGeneration: source.Generation,
+	})
+	if updateErr := r.updateStatus(ctx, source, status); updateErr != nil {
+		log.FromContext(ctx).Error(updateErr, "unable to update LdapSource status")
+	}
+	return err
+}
+
+func (r *LdapSourceReconciler) updateStatus(ctx context.Context, source *kuadrav1.LdapSource, status *kuadrav1.LdapSourceStatus) error {
+	if reflect.DeepEqual(source.Status, *status) {
+		return nil
+	}
+	source.Status = *status
+	return r.Status().Update(ctx, source)
+}
+
+// search binds to the directory with the bind Secret and returns the user entries
+func (r *LdapSourceReconciler) search(ctx context.Context, source *kuadrav1.LdapSource) ([]*ldap.Entry, error) {
+	bindDN, password := "", ""
+	var tlsConfig *tls.Config
+	if source.Spec.BindSecret != "" {
+		secret := &corev1.Secret{}
+		if err := r.Get(ctx, types.NamespacedName{Name: source.Spec.BindSecret, Namespace: source.Namespace}, secret); err != nil {
+			return nil, fmt.Errorf("unable to read bind secret %s: %w", source.Spec.BindSecret, err)
+		}
+		bindDN, password = string(secret.Data[LdapBindDNKey]), string(secret.Data[LdapPasswordKey])
+		if ca, ok := secret.Data[LdapCAKey]; ok {
+			roots := x509.NewCertPool()
+			if !roots.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("%s of bind secret %s holds no PEM certificate", LdapCAKey, source.Spec.BindSecret)
+			}
+			tlsConfig = &tls.Config{RootCAs: roots}
+		}
+	}
+
+	encrypted := strings.HasPrefix(source.Spec.URL, "ldaps://") || source.Spec.StartTLS
+	if password != "" && !encrypted {
+		return nil, fmt.Errorf("refusing to send the password of %q unencrypted to %s, use an ldaps:// URL or startTLS", bindDN, source.Spec.URL)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ldapSyncTimeout)
+	defer cancel()
+	conn, err := ldap.Dial(ctx, source.Spec.URL, tlsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to %s: %w", source.Spec.URL, err)
+	}
+	defer conn.Close()
+	if source.Spec.StartTLS && strings.HasPrefix(source.Spec.URL, "ldap://") {
+		if err := conn.StartTLS(ctx, tlsConfig); err != nil {
+			return nil, fmt.Errorf("unable to start TLS with %s: %w", source.Spec.URL, err)
+		}
+	}
+	if err := conn.Bind(ctx, bindDN, password); err != nil {
+		return nil, fmt.Errorf("unable to bind as %q: %w", bindDN, err)
+	}
+
+	userNameAttribute, emailAttribute, groupAttribute := ldapAttributes(&source.Spec)
+	filter := source.Spec.UserFilter
+	if filter == "" {
+		filter = "(objectClass=person)"
+	}
+	entries, err := conn.Search(ctx, ldap.SearchRequest{
+		BaseDN:     source.Spec.BaseDN,
+		Scope:      ldap.ScopeWholeSubtree,
+		Filter:     filter,
+		Attributes: []string{userNameAttribute, emailAttribute, groupAttribute},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to search %s: %w", source.Spec.BaseDN, err)
+	}
+	return entries, nil
+}
+
+// ldapMaxPrunePercent returns the share of its Users a sync of the spec may delete, or zero
+// for no limit when mass pruning is allowed
+func ldapMaxPrunePercent(spec *kuadrav1.LdapSourceSpec) int {
+	if spec.AllowMassPrune {
+		return 0
+	}
+	if spec.MaxPrunePercent <= 0 {
+		return defaultLdapMaxPrunePercent
+	}
+	return spec.MaxPrunePercent
+}
+
+// ldapAttributes returns the user name, email and group attributes of the spec, defaulted
+func ldapAttributes(spec *kuadrav1.LdapSourceSpec) (string, string, string) {
+	userName, email, group := spec.UserNameAttribute, spec.EmailAttribute, spec.GroupAttribute
+	if userName == "" {
+		userName = "uid"
+	}
+	if email == "" {
+		email = "mail"
+	}
+	if group == "" {
+		group = "memberOf"
+	}
+	return userName, email, group
+}
+
+// usersFromLdapEntries turns user entries into Users, named after their lower-cased user
+// name attribute and added to the groups mapped from their directory groups. Entries that
+// cannot become a User are explained in the returned errors, and those with a user name are
+// returned as invalid, so that their User is kept rather than pruned.
+func usersFromLdapEntries(spec *kuadrav1.LdapSourceSpec, entries []*ldap.Entry) ([]sourceUser, []string) {
+	userNameAttribute, emailAttribute, groupAttribute := ldapAttributes(spec)
+
+	var users []sourceUser
+	var errs []string
+	seen := map[string]string{}
+	for _, entry := range entries {
+		value := entry.Value(userNameAttribute)
+		if value == "" {
+			errs = append(errs, fmt.Sprintf("entry %s has no %s", entry.DN, userNameAttribute))
+			continue
+		}
+		name := strings.ToLower(value)
+		if dn, found := seen[name]; found {
+			errs = append(errs, fmt.Sprintf("entry %s has the same user name %s as entry %s", entry.DN, name, dn))
+			continue
+		}
+
+		memberships := map[string]bool{}
+		for _, groupDN := range entry.Values(groupAttribute) {
+			memberships[normalizeDN(groupDN)] = true
+		}
+		accountSpec := kuadrav1.AwsAccountSpec{UserName: name}
+		for _, mapping := range spec.GroupMappings {
+			if memberships[normalizeDN(mapping.GroupDN)] {
+				accountSpec.Groups = appendUnique(accountSpec.Groups, mapping.Groups...)
+				accountSpec.GroupRefs = appendUnique(accountSpec.GroupRefs, mapping.GroupRefs...)
+			}
+		}
+		user := UserConfigEntry{Name: name, Email: entry.Value(emailAttribute), AwsAccountSpec: accountSpec}
+		if fieldErrs := ValidateUserConfigEntry(nil, user); len(fieldErrs) > 0 {
+			errs = append(errs, fmt.Sprintf("entry %s: %s", entry.DN, fieldErrs.ToAggregate().Error()))
+			users = append(users, sourceUser{Name: name, Invalid: true})
+			continue
+		}
+		seen[name] = entry.DN
+		users = append(users, sourceUser{Name: name, Email: user.Email, Spec: accountSpec})
+	}
+	return users, errs
+}
+
+// normalizeDN lower-cases a DN and removes the spaces around its separators, so that
+// equivalent spellings of the same DN compare equal
+func normalizeDN(dn string) string {
+	parts := strings.Split(dn, ",")
+	for i, part := range parts {
+		attribute, value, _ := strings.Cut(part, "=")
+		parts[i] = strings.TrimSpace(attribute) + "=" + strings.TrimSpace(value)
+	}
+	return strings.ToLower(strings.Join(parts, ","))
+}
+
+// appendUnique appends the values not in values yet
+func appendUnique(values []string, more ...string) []string {
+	for _, value := range more {
+		if !slice.Contains(values, value) {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func (r *LdapSourceReconciler) event(source *kuadrav1.LdapSource, eventType string, reason string, message string) {
+	if r.Recorder != nil {
+		r.Recorder.Event(source, eventType, reason, message)
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager. Only spec changes trigger a
+// sync besides the interval, so that status updates and User changes do not search the
+// directory again.
+func (r *LdapSourceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&kuadrav1.LdapSource{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(r)
+}
@@ -0,0 +1,255 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+	"github.com/Kuadrant/kuadra/pkg/ldap"
+	"github.com/Kuadrant/kuadra/pkg/ldap/ldapfake"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8Types "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var _ = Describe("LdapSource controller", func() {
+
+	const (
+		LdapSourceName      = "corp"
+		LdapSourceNamespace = "default"
+		BaseDN              = "dc=example,dc=org"
+		BindDN              = "cn=kuadra,dc=example,dc=org"
+		DNSGroupDN          = "cn=dns,ou=groups,dc=example,dc=org"
+	)
+
+	ctx := context.Background()
+
+	ldapSourceLookupKey := k8Types.NamespacedName{Name: LdapSourceName, Namespace: LdapSourceNamespace}
+
+	var server *ldapfake.Server
+	var ca []byte
+
+	BeforeEach(func() {
+		server = ldapfake.NewServer()
+		DeferCleanup(server.Close)
+		ca = server.EnableStartTLS()
+		server.SetPassword(BindDN, "secret")
+		server.AddEntry(BaseDN, map[string][]string{"objectClass": {"domain"}})
+		server.AddEntry("uid=alice,ou=people,"+BaseDN, map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {"Alice"},
+			"mail":        {"alice@example.org"},
+			"memberOf":    {"CN=dns, ou=groups, dc=example, dc=org"},
+		})
+		server.AddEntry("uid=bob,ou=people,"+BaseDN, map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {"bob"},
+		})
+		server.AddEntry("uid=bad_name,ou=people,"+BaseDN, map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {"bad_name"},
+		})
+		server.AddEntry("cn=printer,"+BaseDN, map[string][]string{
+			"objectClass": {"device"},
+			"uid":         {"printer"},
+		})
+	})
+
+	newLdapSource := func(url string) *kuadrav1.LdapSource {
+		return &kuadrav1.LdapSource{
+			ObjectMeta: metav1.ObjectMeta{Name: LdapSourceName, Namespace: LdapSourceNamespace},
+			Spec: kuadrav1.LdapSourceSpec{
+				URL:        url,
+				StartTLS:   true,
+				BindSecret: "ldap-bind",
+				BaseDN:     BaseDN,
+				GroupMappings: []kuadrav1.LdapGroupMapping{{
+					GroupDN:   DNSGroupDN,
+					Groups:    []string{"dns-management"},
+					GroupRefs: []string{"dns-admins"},
+				}},
+				SyncInterval:    metav1.Duration{Duration: 5 * time.Minute},
+				MaxPrunePercent: 50,
+			},
+		}
+	}
+
+	newBindSecret := func(password string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "ldap-bind", Namespace: LdapSourceNamespace},
+			Data: map[string][]byte{
+				LdapBindDNKey:   []byte(BindDN),
+				LdapPasswordKey: []byte(password),
+				LdapCAKey:       ca,
+			},
+		}
+	}
+
+	Context("When reconciling an LdapSource", func() {
+		It("Should generate, update and prune Users from the directory", func() {
+			req := reconcile.Request{NamespacedName: ldapSourceLookupKey}
+			client := fake.NewClientBuilder().Build()
+			recorder := record.NewFakeRecorder(10)
+			Expect(client.Create(ctx, newBindSecret("secret"))).Should(Succeed())
+			source := newLdapSource(server.URL())
+			Expect(client.Create(ctx, source)).Should(Succeed())
+
+			r := &LdapSourceReconciler{Client: client, Scheme: scheme.Scheme, Recorder: recorder}
+			result, err := r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(result.RequeueAfter).Should(Equal(5 * time.Minute))
+			Expect(server.PlainBinds()).Should(Equal(0))
+
+			By("By creating a User for every valid person entry")
+			alice := &kuadrav1.User{}
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: "alice", Namespace: LdapSourceNamespace}, alice)).Should(Succeed())
+			Expect(alice.Labels[LdapSourceLabel]).Should(Equal(LdapSourceName))
+			Expect(alice.Annotations[kuadrav1.EmailAnnotation]).Should(Equal("alice@example.org"))
+			Expect(alice.OwnerReferences).Should(HaveLen(1))
+			Expect(alice.OwnerReferences[0].Kind).Should(Equal("LdapSource"))
+			Expect(alice.Spec.AwsAccount.Spec.User).Should(Equal(kuadrav1.AwsAccountSpec{
+				UserName:  "alice",
+				Groups:    []string{"dns-management"},
+				GroupRefs: []string{"dns-admins"},
+			}))
+			bob := &kuadrav1.User{}
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: "bob", Namespace: LdapSourceNamespace}, bob)).Should(Succeed())
+			Expect(bob.Spec.AwsAccount.Spec.User.Groups).Should(BeEmpty())
+			Expect(recorder.Events).Should(Receive(Equal("Normal UsersSynced Applied 2 and deleted 0 Users")))
+
+			By("By reporting the sync in the status")
+			Expect(client.Get(ctx, ldapSourceLookupKey, source)).Should(Succeed())
+			Expect(source.Status.LastSyncTime).ShouldNot(BeNil())
+			Expect(source.Status.LastSuccessfulSyncTime).ShouldNot(BeNil())
+			Expect(source.Status.Entries).Should(Equal(3))
+			Expect(source.Status.Users).Should(Equal(2))
+			Expect(source.Status.Applied).Should(Equal(2))
+			Expect(source.Status.Errors).Should(HaveLen(1))
+			Expect(source.Status.Errors[0]).Should(ContainSubstring("entry uid=bad_name,ou=people," + BaseDN))
+			ready := meta.FindStatusCondition(source.Status.Conditions, kuadrav1.ConditionReady)
+			Expect(ready.Status).Should(Equal(metav1.ConditionTrue))
+			Expect(ready.Message).Should(Equal("Synced 2 Users from 3 entries, 1 entries could not be synced"))
+
+			By("By deleting the Users of entries gone from the directory")
+			server.DeleteEntry("uid=bob,ou=people," + BaseDN)
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			err = client.Get(ctx, k8Types.NamespacedName{Name: "bob", Namespace: LdapSourceNamespace}, bob)
+			Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+			Expect(recorder.Events).Should(Receive(Equal("Normal UsersSynced Applied 0 and deleted 1 Users")))
+
+			By("By leaving the Users alone when the directory cannot be searched")
+			server.FailSearches(ldap.ResultUnavailable)
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(HaveOccurred())
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: "alice", Namespace: LdapSourceNamespace}, alice)).Should(Succeed())
+			Expect(recorder.Events).Should(Receive(ContainSubstring("Warning SyncFailed unable to search " + BaseDN)))
+			Expect(client.Get(ctx, ldapSourceLookupKey, source)).Should(Succeed())
+			ready = meta.FindStatusCondition(source.Status.Conditions, kuadrav1.ConditionReady)
+			Expect(ready.Status).Should(Equal(metav1.ConditionFalse))
+			Expect(ready.Reason).Should(Equal("SyncFailed"))
+			Expect(source.Status.Users).Should(Equal(1))
+		})
+
+		It("Should fail without touching Users when the bind is rejected", func() {
+			req := reconcile.Request{NamespacedName: ldapSourceLookupKey}
+			client := fake.NewClientBuilder().Build()
+			recorder := record.NewFakeRecorder(10)
+			Expect(client.Create(ctx, newBindSecret("wrong"))).Should(Succeed())
+			Expect(client.Create(ctx, newLdapSource(server.URL()))).Should(Succeed())
+
+			r := &LdapSourceReconciler{Client: client, Scheme: scheme.Scheme, Recorder: recorder}
+			_, err := r.Reconcile(ctx, req)
+			Expect(ldap.IsResultCode(err, ldap.ResultInvalidCredentials)).Should(BeTrue())
+			Expect(server.Searches()).Should(Equal(0))
+
+			var users kuadrav1.UserList
+			Expect(client.List(ctx, &users)).Should(Succeed())
+			Expect(users.Items).Should(BeEmpty())
+			Expect(recorder.Events).Should(Receive(ContainSubstring(`Warning SyncFailed unable to bind as "` + BindDN + `"`)))
+		})
+
+		It("Should not send the bind password unencrypted", func() {
+			req := reconcile.Request{NamespacedName: ldapSourceLookupKey}
+			client := fake.NewClientBuilder().Build()
+			Expect(client.Create(ctx, newBindSecret("secret"))).Should(Succeed())
+			source := newLdapSource(server.URL())
+			source.Spec.StartTLS = false
+			Expect(client.Create(ctx, source)).Should(Succeed())
+
+			r := &LdapSourceReconciler{Client: client, Scheme: scheme.Scheme}
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).Should(MatchError(ContainSubstring("refusing to send the password")))
+			Expect(server.PlainBinds()).Should(Equal(0))
+			Expect(server.Searches()).Should(Equal(0))
+		})
+
+		It("Should keep Users when too many entries are gone and those of unreadable entries", func() {
+			req := reconcile.Request{NamespacedName: ldapSourceLookupKey}
+			client := fake.NewClientBuilder().Build()
+			recorder := record.NewFakeRecorder(10)
+			Expect(client.Create(ctx, newBindSecret("secret"))).Should(Succeed())
+			source := newLdapSource(server.URL())
+			Expect(client.Create(ctx, source)).Should(Succeed())
+
+			// A User generated before its entry stopped being valid
+			Expect(client.Create(ctx, &kuadrav1.User{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "carol.smith",
+					Namespace: LdapSourceNamespace,
+					Labels:    map[string]string{LdapSourceLabel: LdapSourceName},
+				},
+			})).Should(Succeed())
+			server.AddEntry("uid=carol,ou=people,"+BaseDN, map[string][]string{
+				"objectClass": {"person"},
+				"uid":         {"carol.smith"},
+			})
+
+			r := &LdapSourceReconciler{Client: client, Scheme: scheme.Scheme, Recorder: recorder}
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(recorder.Events).Should(Receive(Equal("Normal UsersSynced Applied 2 and deleted 0 Users")))
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: "carol.smith", Namespace: LdapSourceNamespace}, &kuadrav1.User{})).Should(Succeed())
+			Expect(client.Get(ctx, ldapSourceLookupKey, source)).Should(Succeed())
+			Expect(source.Status.Users).Should(Equal(2))
+			Expect(source.Status.Errors).Should(HaveLen(2))
+
+			By("By refusing to delete more Users than allowed")
+			server.DeleteEntry("uid=alice,ou=people," + BaseDN)
+			server.DeleteEntry("uid=bob,ou=people," + BaseDN)
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			var users kuadrav1.UserList
+			Expect(client.List(ctx, &users)).Should(Succeed())
+			Expect(users.Items).Should(HaveLen(3))
+			Expect(recorder.Events).Should(Receive(HavePrefix("Warning PruneRefused Kept 2 Users whose entries are gone")))
+			Expect(client.Get(ctx, ldapSourceLookupKey, source)).Should(Succeed())
+			Expect(source.Status.PruneRefused).Should(Equal(2))
+			ready := meta.FindStatusCondition(source.Status.Conditions, kuadrav1.ConditionReady)
+			Expect(ready.Status).Should(Equal(metav1.ConditionFalse))
+			Expect(ready.Reason).Should(Equal("PruneRefused"))
+
+			By("By deleting them once allowed, still keeping the User of the invalid entry")
+			source.Spec.AllowMassPrune = true
+			Expect(client.Update(ctx, source)).Should(Succeed())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(recorder.Events).Should(Receive(Equal("Normal UsersSynced Applied 0 and deleted 2 Users")))
+			Expect(client.List(ctx, &users)).Should(Succeed())
+			Expect(users.Items).Should(HaveLen(1))
+			Expect(users.Items[0].Name).Should(Equal("carol.smith"))
+			Expect(client.Get(ctx, ldapSourceLookupKey, source)).Should(Succeed())
+			Expect(source.Status.PruneRefused).Should(Equal(0))
+			Expect(meta.IsStatusConditionTrue(source.Status.Conditions, kuadrav1.ConditionReady)).Should(BeTrue())
+		})
+	})
+})
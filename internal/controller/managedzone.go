@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+)
+
+// ManagedZoneGVK identifies the Kuadrant ManagedZone kind. It is handled as unstructured
+// so that Kuadrant's API module is not a dependency.
+var ManagedZoneGVK = schema.GroupVersionKind{
+	Group:   "kuadrant.io",
+	Version: "v1alpha1",
+	Kind:    "ManagedZone",
+}
+
+//+kubebuilder:rbac:groups=kuadrant.io,resources=managedzones,verbs=get;list;watch;create;update;patch;delete
+
+// managedZoneProviderSecret returns the name of the kuadrant.io/aws Secret in the
+// user namespace that the ManagedZone should reference
+func managedZoneProviderSecret(credentials kuadrav1.CredentialsSpec, namespace string) (string, error) {
+	if credentials.Format == kuadrav1.CredentialsFormatKuadrant && credentials.SecretRef.Namespace == namespace {
+		return credentials.SecretRef.Name, nil
+	}
+	if ref := credentials.DNSProviderSecretRef; ref != nil && ref.Namespace == namespace {
+		return ref.Name, nil
+	}
+	return "", fmt.Errorf("the DNS provider secret of a managed zone must be in namespace %s", namespace)
+}
+
+// reconcileManagedZone creates or updates the ManagedZone for the user's hosted zone and
+// removes a previously created one that is no longer wanted
+func (r *AwsAccountReconciler) reconcileManagedZone(ctx context.Context, awsAccount *kuadrav1.AwsAccount, credentials kuadrav1.CredentialsSpec) error {
+	log := log.FromContext(ctx)
+	namespace := awsAccount.Spec.UserName
+
+	hostedZone := awsAccount.Spec.HostedZone
+	desiredName := ""
+	if hostedZone != nil && hostedZone.CreateManagedZone {
+		desiredName = hostedZone.DomainName
+	}
+
+	if applied := awsAccount.Status.ManagedZone; applied != "" && applied != desiredName {
+		if _, err := r.deleteManagedZone(ctx, namespace, applied); err != nil {
+			return err
+		}
+		log.V(1).Info("deleted managed zone", "managedZone", applied)
+		awsAccount.Status.ManagedZone = ""
+	}
+	if desiredName == "" {
+		return nil
+	}
+
+	providerSecret, err := managedZoneProviderSecret(credentials, namespace)
+	if err != nil {
+		return err
+	}
+
+	managedZone := &unstructured.Unstructured{}
+	managedZone.SetGroupVersionKind(ManagedZoneGVK)
+	managedZone.SetName(desiredName)
+	managedZone.SetNamespace(namespace)
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, managedZone, func() error {
+		return unstructured.SetNestedMap(managedZone.Object, map[string]interface{}{
+			"id":          hostedZone.ID,
+			"domainName":  hostedZone.DomainName,
+			"description": fmt.Sprintf("Hosted zone of AWS user %s", awsAccount.Spec.UserName),
+			"dnsProviderSecretRef": map[string]interface{}{
+				"name": providerSecret,
+			},
+		}, "spec")
+	})
+	if err != nil {
+		return err
+	}
+	awsAccount.Status.ManagedZone = desiredName
+	return nil
+}
+
+// deleteManagedZone deletes the ManagedZone and reports whether it is gone. Kuadrant
+// may hold it with a finalizer while it cleans up records with the provider Secret.
+func (r *AwsAccountReconciler) deleteManagedZone(ctx context.Context, namespace string, name string) (bool, error) {
+	managedZone := &unstructured.Unstructured{}
+	managedZone.SetGroupVersionKind(ManagedZoneGVK)
+	if err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, managedZone); err != nil {
+		return true, client.IgnoreNotFound(err)
+	}
+	if managedZone.GetDeletionTimestamp() == nil {
+		if err := r.Delete(ctx, managedZone); err != nil {
+			return false, client.IgnoreNotFound(err)
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+)
+
+var (
+	driftDetectedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kuadra_drift_detected_total",
+		Help: "Number of out-of-band changes detected on IAM users, by drift type",
+	}, []string{"type"})
+
+	driftCorrectionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kuadra_drift_corrections_total",
+		Help: "Number of out-of-band changes to IAM users reverted by the controller, by drift type",
+	}, []string{"type"})
+
+	reconcileStepDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kuadra_reconcile_step_duration_seconds",
+		Help:    "Time taken by each step of a reconcile",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"controller", "step"})
+
+	orphanedUsers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kuadra_orphaned_iam_users",
+		Help: "Number of IAM users tagged for this cluster that no AwsAccount manages, as of the last collection",
+	})
+
+	managedUsersDesc = prometheus.NewDesc("kuadra_managed_users",
+		"Number of IAM users managed through AwsAccounts, by phase", []string{"phase"}, nil)
+
+	accessKeyAgeDesc = prometheus.NewDesc("kuadra_access_key_age_seconds",
+		"Age of the oldest access key of each managed IAM user", nil, nil)
+
+	// managedUsersCollector is registered once and reads the AwsAccounts through the client
+	// of the manager the AwsAccountReconciler is set up with
+	managedUsersCollector = &awsAccountCollector{}
+
+	// accessKeyAgeBuckets go from a day to a year, covering the usual key rotation policies
+	accessKeyAgeBuckets = []float64{
+		(24 * time.Hour).Seconds(),
+		(7 * 24 * time.Hour).Seconds(),
+		(30 * 24 * time.Hour).Seconds(),
+		(60 * 24 * time.Hour).Seconds(),
+		(90 * 24 * time.Hour).Seconds(),
+		(180 * 24 * time.Hour).Seconds(),
+		(365 * 24 * time.Hour).Seconds(),
+	}
+)
+
+func init() {
+	metrics.Registry.MustRegister(driftDetectedTotal, driftCorrectionsTotal, reconcileStepDuration, orphanedUsers, managedUsersCollector)
+}
+
+// observeStep records the time since start as the duration of a reconcile step and restarts the clock for the next one
+func observeStep(controller string, step string, start *time.Time) {
+	now := time.Now()
+	reconcileStepDuration.WithLabelValues(controller, step).Observe(now.Sub(*start).Seconds())
+	*start = now
+}
+
+// awsAccountCollector computes metrics from the AwsAccounts in the cache when scraped.
+// Nothing is collected until it has a client.
+type awsAccountCollector struct {
+	mu     sync.RWMutex
+	client client.Reader
+}
+
+func (c *awsAccountCollector) setClient(reader client.Reader) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.client = reader
+}
+
+func (c *awsAccountCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- managedUsersDesc
+	ch <- accessKeyAgeDesc
+}
+
+func (c *awsAccountCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.RLock()
+	reader := c.client
+	c.mu.RUnlock()
+	if reader == nil {
+		return
+	}
+
+	var awsAccounts kuadrav1.AwsAccountList
+	if err := reader.List(context.Background(), &awsAccounts); err != nil {
+		log.Log.Error(err, "unable to list AwsAccounts for metrics")
+		return
+	}
+
+	phases := map[kuadrav1.AwsAccountPhase]float64{
+		kuadrav1.AwsAccountPhasePending:  0,
+		kuadrav1.AwsAccountPhaseReady:    0,
+		kuadrav1.AwsAccountPhaseFailed:   0,
+		kuadrav1.AwsAccountPhaseDeleting: 0,
+	}
+	buckets := map[float64]uint64{}
+	var count uint64
+	var sum float64
+	now := time.Now()
+	for _, awsAccount := range awsAccounts.Items {
+		phase := awsAccount.Status.Phase
+		if phase == "" {
+			phase = kuadrav1.AwsAccountPhasePending
+		}
+		phases[phase]++
+
+		if createdAt := awsAccount.Status.AccessKeyCreationDate; createdAt != nil {
+			age := now.Sub(createdAt.Time).Seconds()
+			count++
+			sum += age
+			for _, bucket := range accessKeyAgeBuckets {
+				if age <= bucket {
+					buckets[bucket]++
+				}
+			}
+		}
+	}
+
+	for phase, users := range phases {
+		ch <- prometheus.MustNewConstMetric(managedUsersDesc, prometheus.GaugeValue, users, string(phase))
+	}
+	ch <- prometheus.MustNewConstHistogram(accessKeyAgeDesc, count, sum, buckets)
+}
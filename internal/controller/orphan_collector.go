@@ -0,0 +1,339 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+	"github.com/Kuadrant/kuadra/pkg/audit"
+	kuadraaws "github.com/Kuadrant/kuadra/pkg/aws"
+)
+
+// OrphanPolicy is what the orphan collector does with an orphaned IAM user once its grace period has passed
+type OrphanPolicy string
+
+const (
+	// OrphanPolicyReport only reports orphaned users
+	OrphanPolicyReport OrphanPolicy = "report"
+	// OrphanPolicySuspend deletes the login profile and deactivates the access keys of orphaned users
+	OrphanPolicySuspend OrphanPolicy = "suspend"
+	// OrphanPolicyDelete deletes orphaned users
+	OrphanPolicyDelete OrphanPolicy = "delete"
+)
+
+// ParseOrphanPolicy validates the value of the --orphan-policy flag
+func ParseOrphanPolicy(value string) (OrphanPolicy, error) {
+	switch policy := OrphanPolicy(value); policy {
+	case OrphanPolicyReport, OrphanPolicySuspend, OrphanPolicyDelete:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown orphan policy %q, expected report, suspend or delete", value)
+}
+
+//+kubebuilder:rbac:groups=kuadra.kuadrant.io,resources=kuadraclusters,verbs=get;list;watch;create;update;patch
+//+kubebuilder:rbac:groups=kuadra.kuadrant.io,resources=kuadraclusters/status,verbs=get;update;patch
+
+// OrphanCollector looks for IAM users tagged with the cluster identity that no AwsAccount
+// manages any more, for example because the finalizer of their AwsAccount was removed or the
+// cluster was rebuilt. They are listed in the status of the KuadraCluster and, once the grace
+// period has passed, handled according to the policy.
+type OrphanCollector struct {
+	client.Client
+	IamWrapper IamWrapper
+	Recorder   record.EventRecorder
+	// ClusterId is the value of the cluster tag on the users of this cluster
+	ClusterId string
+	// UserPathPrefix limits the search to the users under the IAM path Kuadra puts its users in
+	UserPathPrefix string
+	Policy         OrphanPolicy
+	// GracePeriod is how long a user stays orphaned before the policy is applied
+	GracePeriod time.Duration
+	// Interval is the time between two searches of IAM
+	Interval time.Duration
+	// DryRun reports orphans without applying the policy
+	DryRun bool
+}
+
+// NeedLeaderElection makes only the leader collect orphans, so that replicas do not race to delete them
+func (c *OrphanCollector) NeedLeaderElection() bool {
+	return true
+}
+
+// Start collects orphans every Interval until ctx is done
+func (c *OrphanCollector) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("orphan-collector")
+	ctx = log.IntoContext(ctx, logger)
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		if err := c.Collect(ctx); err != nil {
+			logger.Error(err, "unable to collect orphaned IAM users")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Collect searches IAM for orphaned users once, applies the policy to the ones past their
+// grace period and records the result in the KuadraCluster
+func (c *OrphanCollector) Collect(ctx context.Context) error {
+	log := log.FromContext(ctx)
+
+	cluster, err := c.kuadraCluster(ctx)
+	if err != nil {
+		return err
+	}
+	ctx = audit.WithActor(ctx, audit.ActorOf("KuadraCluster", cluster))
+
+	found, unchecked, err := c.findOrphans(ctx)
+	if err != nil {
+		c.event(cluster, v1.EventTypeWarning, "CollectionFailed", err.Error())
+		meta.SetStatusCondition(&cluster.Status.Conditions, metav1.Condition{
+			Type:               kuadrav1.ConditionOrphansCollected,
+			Status:             metav1.ConditionFalse,
+			Reason:             failureReason(err),
+			Message:            err.Error(),
+			ObservedGeneration: cluster.Generation,
+		})
+		if updateErr := c.Status().Update(ctx, cluster); updateErr != nil {
+			log.Error(updateErr, "unable to update KuadraCluster status")
+		}
+		return err
+	}
+
+	previous := map[string]kuadrav1.OrphanedUser{}
+	for _, orphan := range cluster.Status.OrphanedUsers {
+		previous[orphan.UserName] = orphan
+	}
+	now := metav1.Now()
+	var orphans []kuadrav1.OrphanedUser
+	var policyErr error
+	// Orphans whose tags could not be read this time stay listed until they can be checked
+	var checkErr error
+	for _, userName := range sortedErrorKeys(unchecked) {
+		err := unchecked[userName]
+		c.event(cluster, v1.EventTypeWarning, "OrphanCheckFailed", fmt.Sprintf("Unable to read the tags of IAM user %s: %v", userName, err))
+		if checkErr == nil {
+			checkErr = err
+		}
+		if orphan, ok := previous[userName]; ok {
+			orphans = append(orphans, orphan)
+		}
+	}
+	for _, orphan := range found {
+		if seen, ok := previous[orphan.UserName]; ok {
+			orphan.FirstSeen = seen.FirstSeen
+			orphan.Suspended = seen.Suspended
+		} else {
+			orphan.FirstSeen = now
+			c.event(cluster, v1.EventTypeWarning, "OrphanFound", fmt.Sprintf("IAM user %s has no AwsAccount%s", orphan.UserName, formerAwsAccount(orphan)))
+		}
+
+		deleted, err := c.applyPolicy(ctx, cluster, &orphan, now.Time)
+		if err != nil {
+			c.event(cluster, v1.EventTypeWarning, "OrphanPolicyFailed", fmt.Sprintf("Unable to %s IAM user %s: %v", c.Policy, orphan.UserName, err))
+			if policyErr == nil {
+				policyErr = err
+			}
+		}
+		if !deleted {
+			orphans = append(orphans, orphan)
+		}
+	}
+	orphanedUsers.Set(float64(len(orphans)))
+
+	cluster.Status.ClusterId = c.ClusterId
+	cluster.Status.OrphanedUsers = orphans
+	cluster.Status.OrphanCount = len(orphans)
+	cluster.Status.LastCollectionTime = &now
+	condition := metav1.Condition{
+		Type:               kuadrav1.ConditionOrphansCollected,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Collected",
+		Message:            fmt.Sprintf("%d orphaned IAM users", len(orphans)),
+		ObservedGeneration: cluster.Generation,
+	}
+	if len(unchecked) > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "PartiallyCollected"
+		condition.Message = fmt.Sprintf("%d orphaned IAM users, %d users could not be checked", len(orphans), len(unchecked))
+	}
+	meta.SetStatusCondition(&cluster.Status.Conditions, condition)
+	if err := c.Status().Update(ctx, cluster); err != nil {
+		return err
+	}
+	if checkErr != nil {
+		return checkErr
+	}
+	return policyErr
+}
+
+// applyPolicy handles an orphan whose grace period has passed, returning whether it was deleted
+func (c *OrphanCollector) applyPolicy(ctx context.Context, cluster *kuadrav1.KuadraCluster, orphan *kuadrav1.OrphanedUser, now time.Time) (bool, error) {
+	if c.DryRun || now.Sub(orphan.FirstSeen.Time) < c.GracePeriod {
+		return false, nil
+	}
+	switch c.Policy {
+	case OrphanPolicySuspend:
+		if orphan.Suspended {
+			return false, nil
+		}
+		// The tag goes first, so that a user taken over after a partial suspension is resumed too
+		if err := c.IamWrapper.TagUser(ctx, orphan.UserName, map[string]string{SuspendedTag: "true"}); err != nil {
+			return false, err
+		}
+		if err := suspendIamUser(ctx, c.IamWrapper, orphan.UserName); err != nil {
+			return false, err
+		}
+		orphan.Suspended = true
+		c.event(cluster, v1.EventTypeNormal, "OrphanSuspended", fmt.Sprintf("Suspended IAM user %s", orphan.UserName))
+	case OrphanPolicyDelete:
+		if err := deleteIamUser(ctx, c.IamWrapper, orphan.UserName); err != nil {
+			return false, err
+		}
+		c.event(cluster, v1.EventTypeNormal, "OrphanDeleted", fmt.Sprintf("Deleted IAM user %s", orphan.UserName))
+		return true, nil
+	}
+	return false, nil
+}
+
+// findOrphans lists the IAM users tagged with the cluster identity that no AwsAccount manages,
+// and the errors for the users whose tags could not be read
+func (c *OrphanCollector) findOrphans(ctx context.Context) ([]kuadrav1.OrphanedUser, map[string]error, error) {
+	// AwsAccounts being deleted still count, their finalizer removes the user. So do the old
+	// names of users being renamed.
+	var awsAccounts kuadrav1.AwsAccountList
+	if err := c.List(ctx, &awsAccounts); err != nil {
+		return nil, nil, err
+	}
+	managed := map[string]bool{}
+	for _, awsAccount := range awsAccounts.Items {
+		managed[awsAccount.Spec.UserName] = true
+		if awsAccount.Status.UserName != "" {
+			managed[awsAccount.Status.UserName] = true
+		}
+	}
+
+	users, err := c.IamWrapper.ListUsers(ctx, c.UserPathPrefix, math.MaxInt32)
+	if err != nil {
+		return nil, nil, err
+	}
+	var orphans []kuadrav1.OrphanedUser
+	unchecked := map[string]error{}
+	for _, user := range users {
+		userName := *user.UserName
+		if managed[userName] {
+			continue
+		}
+		// ListUsers does not return tags. A user deleted since it was listed is no orphan, and
+		// one user that cannot be read does not stop the others from being checked.
+		tags, err := c.IamWrapper.ListUserTags(ctx, userName)
+		if kuadraaws.IsNoSuchEntityError(err) {
+			continue
+		}
+		if err != nil {
+			unchecked[userName] = err
+			continue
+		}
+		if tags[ClusterTag] != c.ClusterId {
+			continue
+		}
+		orphan := kuadrav1.OrphanedUser{UserName: userName}
+		if tags[NamespaceTag] != "" && tags[NameTag] != "" {
+			orphan.AwsAccount = tags[NamespaceTag] + "/" + tags[NameTag]
+		}
+		orphans = append(orphans, orphan)
+	}
+	return orphans, unchecked, nil
+}
+
+// kuadraCluster returns the KuadraCluster, creating it the first time
+func (c *OrphanCollector) kuadraCluster(ctx context.Context) (*kuadrav1.KuadraCluster, error) {
+	cluster := &kuadrav1.KuadraCluster{}
+	err := c.Get(ctx, client.ObjectKey{Name: kuadrav1.KuadraClusterName}, cluster)
+	if apierrors.IsNotFound(err) {
+		cluster = &kuadrav1.KuadraCluster{ObjectMeta: metav1.ObjectMeta{Name: kuadrav1.KuadraClusterName}}
+		err = c.Create(ctx, cluster)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cluster, nil
+}
+
+func (c *OrphanCollector) event(cluster *kuadrav1.KuadraCluster, eventType string, reason string, message string) {
+	if c.Recorder != nil {
+		c.Recorder.Event(cluster, eventType, reason, message)
+	}
+}
+
+func formerAwsAccount(orphan kuadrav1.OrphanedUser) string {
+	if orphan.AwsAccount == "" {
+		return ""
+	}
+	return ", it was created for " + orphan.AwsAccount
+}
+
+// suspendIamUser locks the user out without deleting it: its login profile is deleted and its
+// access keys deactivated, so that access can be restored by reactivating a key
+func suspendIamUser(ctx context.Context, iamWrapper IamWrapper, userName string) error {
+	if err := iamWrapper.DeleteLoginProfileIfExists(ctx, userName); err != nil {
+		return err
+	}
+	accessKeys, err := iamWrapper.ListAccessKeys(ctx, userName)
+	if err != nil {
+		return err
+	}
+	for _, accessKey := range accessKeys {
+		if accessKey.Status == types.StatusTypeInactive {
+			continue
+		}
+		if err := iamWrapper.UpdateAccessKeyStatus(ctx, userName, *accessKey.AccessKeyId, types.StatusTypeInactive); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resumeIamUser activates the access keys of a user suspended by suspendIamUser. The login
+// profile is left to the caller, which knows the password to create it with.
+func resumeIamUser(ctx context.Context, iamWrapper IamWrapper, userName string) error {
+	accessKeys, err := iamWrapper.ListAccessKeys(ctx, userName)
+	if err != nil {
+		return err
+	}
+	for _, accessKey := range accessKeys {
+		if accessKey.Status != types.StatusTypeInactive {
+			continue
+		}
+		if err := iamWrapper.UpdateAccessKeyStatus(ctx, userName, *accessKey.AccessKeyId, types.StatusTypeActive); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortedErrorKeys returns the keys of errs in order, so events are recorded in a stable order
+func sortedErrorKeys(errs map[string]error) []string {
+	keys := make([]string, 0, len(errs))
+	for key := range errs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
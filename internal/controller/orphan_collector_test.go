@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/smithy-go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+	kuadraaws "github.com/Kuadrant/kuadra/pkg/aws"
+)
+
+var _ = Describe("Orphan collector", func() {
+	ctx := context.Background()
+
+	var k8sClient client.Client
+	var mockIam *mockIamWrapper
+	var recorder *record.FakeRecorder
+	var collector *OrphanCollector
+
+	BeforeEach(func() {
+		k8sClient = fake.NewClientBuilder().WithObjects(&kuadrav1.AwsAccount{
+			ObjectMeta: metav1.ObjectMeta{Name: "ib-dns", Namespace: "default"},
+			Spec:       kuadrav1.AwsAccountSpec{UserName: "ib-dns"},
+		}).Build()
+		mockIam = &mockIamWrapper{
+			Users:        []types.User{},
+			LoginProfile: map[string]types.LoginProfile{},
+			AccessKeys:   map[string][]types.AccessKey{},
+			Groups:       map[string][]types.Group{},
+		}
+		clusterTags := func(name string) map[string]string {
+			return map[string]string{ClusterTag: "cluster-a", NamespaceTag: "default", NameTag: name}
+		}
+		Expect(mockIam.CreateUserIfNotExists(ctx, "ib-dns", kuadraaws.UserOptions{Tags: clusterTags("ib-dns")})).Should(Succeed())
+		Expect(mockIam.CreateUserIfNotExists(ctx, "ef-dns", kuadraaws.UserOptions{Tags: clusterTags("ef-dns")})).Should(Succeed())
+		Expect(mockIam.CreateLoginProfileIfNotExists(ctx, "password", "ef-dns", true)).Should(Succeed())
+		_, err := mockIam.CreateAccessKeyPair(ctx, "ef-dns")
+		Expect(err).ShouldNot(HaveOccurred())
+		mockIam.AccessKeys["ef-dns"][0].Status = types.StatusTypeActive
+		Expect(mockIam.CreateUserIfNotExists(ctx, "other-cluster", kuadraaws.UserOptions{Tags: map[string]string{ClusterTag: "cluster-b"}})).Should(Succeed())
+		Expect(mockIam.CreateUserIfNotExists(ctx, "unmanaged", kuadraaws.UserOptions{})).Should(Succeed())
+
+		recorder = record.NewFakeRecorder(10)
+		collector = &OrphanCollector{
+			Client:      k8sClient,
+			IamWrapper:  mockIam,
+			Recorder:    recorder,
+			ClusterId:   "cluster-a",
+			Policy:      OrphanPolicyReport,
+			GracePeriod: time.Hour,
+		}
+	})
+
+	kuadraCluster := func() kuadrav1.KuadraCluster {
+		var cluster kuadrav1.KuadraCluster
+		Expect(k8sClient.Get(ctx, client.ObjectKey{Name: kuadrav1.KuadraClusterName}, &cluster)).Should(Succeed())
+		return cluster
+	}
+
+	// expireGracePeriod makes the orphans look as if they were found before the grace period
+	expireGracePeriod := func() {
+		cluster := kuadraCluster()
+		for i := range cluster.Status.OrphanedUsers {
+			cluster.Status.OrphanedUsers[i].FirstSeen = metav1.NewTime(time.Now().Add(-2 * time.Hour))
+		}
+		Expect(k8sClient.Status().Update(ctx, &cluster)).Should(Succeed())
+	}
+
+	It("Should report the users of the cluster without an AwsAccount", func() {
+		Expect(collector.Collect(ctx)).Should(Succeed())
+
+		cluster := kuadraCluster()
+		Expect(cluster.Status.ClusterId).Should(Equal("cluster-a"))
+		Expect(cluster.Status.OrphanCount).Should(Equal(1))
+		Expect(cluster.Status.OrphanedUsers).Should(HaveLen(1))
+		Expect(cluster.Status.OrphanedUsers[0].UserName).Should(Equal("ef-dns"))
+		Expect(cluster.Status.OrphanedUsers[0].AwsAccount).Should(Equal("default/ef-dns"))
+		Expect(meta.IsStatusConditionTrue(cluster.Status.Conditions, kuadrav1.ConditionOrphansCollected)).Should(BeTrue())
+		Expect(testutil.ToFloat64(orphanedUsers)).Should(Equal(1.0))
+		Expect(recorder.Events).Should(Receive(Equal("Warning OrphanFound IAM user ef-dns has no AwsAccount, it was created for default/ef-dns")))
+
+		By("By keeping the time the orphan was first seen")
+		firstSeen := cluster.Status.OrphanedUsers[0].FirstSeen
+		Expect(collector.Collect(ctx)).Should(Succeed())
+		Expect(kuadraCluster().Status.OrphanedUsers[0].FirstSeen.Unix()).Should(Equal(firstSeen.Unix()))
+		Expect(recorder.Events).ShouldNot(Receive())
+
+		By("By reporting only after the grace period")
+		expireGracePeriod()
+		Expect(collector.Collect(ctx)).Should(Succeed())
+		Expect(mockIam.GetUser(ctx, "ef-dns")).ShouldNot(BeNil())
+	})
+
+	It("Should suspend orphans after the grace period", func() {
+		collector.Policy = OrphanPolicySuspend
+		Expect(collector.Collect(ctx)).Should(Succeed())
+		Expect(mockIam.LoginProfile).Should(HaveKey("ef-dns"))
+
+		expireGracePeriod()
+		Expect(collector.Collect(ctx)).Should(Succeed())
+		Expect(mockIam.LoginProfile).ShouldNot(HaveKey("ef-dns"))
+		Expect(mockIam.AccessKeys["ef-dns"][0].Status).Should(Equal(types.StatusTypeInactive))
+		Expect(mockIam.UserTags["ef-dns"]).Should(HaveKeyWithValue(SuspendedTag, "true"))
+		Expect(kuadraCluster().Status.OrphanedUsers[0].Suspended).Should(BeTrue())
+	})
+
+	It("Should delete orphans after the grace period", func() {
+		collector.Policy = OrphanPolicyDelete
+		Expect(collector.Collect(ctx)).Should(Succeed())
+		expireGracePeriod()
+		Expect(collector.Collect(ctx)).Should(Succeed())
+
+		Expect(mockIam.GetUser(ctx, "ef-dns")).Should(BeNil())
+		Expect(mockIam.GetUser(ctx, "other-cluster")).ShouldNot(BeNil())
+		Expect(mockIam.GetUser(ctx, "unmanaged")).ShouldNot(BeNil())
+		Expect(mockIam.GetUser(ctx, "ib-dns")).ShouldNot(BeNil())
+		Expect(kuadraCluster().Status.OrphanCount).Should(Equal(0))
+	})
+
+	It("Should keep checking the other users when the tags of one cannot be read", func() {
+		Expect(collector.Collect(ctx)).Should(Succeed())
+		Expect(recorder.Events).Should(Receive())
+
+		mockIam.Errors = map[string]error{
+			"ListUserTags/unmanaged": &smithy.GenericAPIError{Code: "NoSuchEntity", Message: "The user cannot be found."},
+			"ListUserTags/ef-dns":    &smithy.GenericAPIError{Code: "Throttling", Message: "Rate exceeded"},
+		}
+		Expect(mockIam.CreateUserIfNotExists(ctx, "gh-dns", kuadraaws.UserOptions{Tags: map[string]string{ClusterTag: "cluster-a"}})).Should(Succeed())
+		Expect(collector.Collect(ctx)).Should(MatchError(ContainSubstring("Rate exceeded")))
+
+		cluster := kuadraCluster()
+		Expect(cluster.Status.OrphanedUsers).Should(HaveLen(2))
+		Expect(cluster.Status.OrphanedUsers[0].UserName).Should(Equal("ef-dns"))
+		Expect(cluster.Status.OrphanedUsers[1].UserName).Should(Equal("gh-dns"))
+		condition := meta.FindStatusCondition(cluster.Status.Conditions, kuadrav1.ConditionOrphansCollected)
+		Expect(condition.Status).Should(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).Should(Equal("PartiallyCollected"))
+		Expect(recorder.Events).Should(Receive(ContainSubstring("OrphanCheckFailed Unable to read the tags of IAM user ef-dns")))
+	})
+
+	It("Should only search the users under the path prefix", func() {
+		collector.UserPathPrefix = "/kuadra/"
+		Expect(collector.Collect(ctx)).Should(Succeed())
+		Expect(kuadraCluster().Status.OrphanCount).Should(Equal(0))
+
+		Expect(mockIam.UpdateUserPath(ctx, "ef-dns", "/kuadra/default/")).Should(Succeed())
+		Expect(collector.Collect(ctx)).Should(Succeed())
+		Expect(kuadraCluster().Status.OrphanCount).Should(Equal(1))
+	})
+
+	It("Should not apply the policy in dry run mode", func() {
+		collector.Policy = OrphanPolicyDelete
+		collector.DryRun = true
+		Expect(collector.Collect(ctx)).Should(Succeed())
+		expireGracePeriod()
+		Expect(collector.Collect(ctx)).Should(Succeed())
+		Expect(mockIam.GetUser(ctx, "ef-dns")).ShouldNot(BeNil())
+	})
+})
@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+	kuadraaws "github.com/Kuadrant/kuadra/pkg/aws"
+)
+
+// TakeOverAnnotation on an AwsAccount names the cluster its IAM user is taken over from.
+// A user tagged with that cluster identity is tagged with this cluster's instead, after
+// which the other cluster leaves it alone.
+const TakeOverAnnotation = "kuadra.kuadrant.io/take-over-from"
+
+// OwnershipConflictError is returned for an IAM user tagged with the identity of another cluster
+type OwnershipConflictError struct {
+	UserName string
+	Owner    string
+}
+
+func (e *OwnershipConflictError) Error() string {
+	return fmt.Sprintf("IAM user %s is managed by cluster %s, annotate the AwsAccount with %s=%s to take it over",
+		e.UserName, e.Owner, TakeOverAnnotation, e.Owner)
+}
+
+func isOwnershipConflict(err error) bool {
+	var conflict *OwnershipConflictError
+	return errors.As(err, &conflict)
+}
+
+// ForeignGroupMembersError is returned for an IAM group that cannot be deleted because
+// users managed by other clusters are still members of it
+type ForeignGroupMembersError struct {
+	GroupName string
+	Members   []string
+}
+
+func (e *ForeignGroupMembersError) Error() string {
+	return fmt.Sprintf("group %s cannot be deleted, it has members managed by other clusters: %s",
+		e.GroupName, strings.Join(e.Members, ", "))
+}
+
+func isForeignGroupMembers(err error) bool {
+	var foreign *ForeignGroupMembersError
+	return errors.As(err, &foreign)
+}
+
+// GroupNotOwnedError is returned for an existing IAM group that the AwsGroup neither created
+// nor was told to take over
+type GroupNotOwnedError struct {
+	GroupName string
+}
+
+func (e *GroupNotOwnedError) Error() string {
+	return fmt.Sprintf("IAM group %s was not created by Kuadra, annotate the AwsGroup with %s=%s to take it over",
+		e.GroupName, TakeOverGroupAnnotation, e.GroupName)
+}
+
+func isGroupNotOwned(err error) bool {
+	var notOwned *GroupNotOwnedError
+	return errors.As(err, &notOwned)
+}
+
+func isSecretNotOwned(err error) bool {
+	var notOwned *SecretNotOwnedError
+	return errors.As(err, &notOwned)
+}
+
+// isPermanentError reports errors that retrying cannot fix until something changes in IAM or the spec
+func isPermanentError(err error) bool {
+	var taken *UserNameTakenError
+	return kuadraaws.IsPermanentError(err) || isOwnershipConflict(err) || isSecretNotOwned(err) ||
+		isForeignGroupMembers(err) || isGroupNotOwned(err) || errors.As(err, &taken)
+}
+
+// ClusterIdFromKubeSystem derives a cluster identity from the UID of the kube-system
+// namespace, which lives as long as the cluster
+func ClusterIdFromKubeSystem(ctx context.Context, reader client.Reader) (string, error) {
+	namespace := &v1.Namespace{}
+	if err := reader.Get(ctx, client.ObjectKey{Name: "kube-system"}, namespace); err != nil {
+		return "", err
+	}
+	return string(namespace.UID), nil
+}
+
+// userOwner returns the cluster identity tagged on the user, or "" for a missing or untagged user
+func userOwner(ctx context.Context, iamWrapper IamWrapper, userName string) (string, error) {
+	user, err := iamWrapper.GetUser(ctx, userName)
+	if err != nil || user == nil {
+		return "", err
+	}
+	tags, err := iamWrapper.ListUserTags(ctx, userName)
+	if err != nil {
+		return "", err
+	}
+	return tags[ClusterTag], nil
+}
+
+// checkOwnership returns an OwnershipConflictError when the named user of the AwsAccount belongs
+// to another cluster. Untagged users are claimed by the tags reconcileUserMetadata puts on them,
+// and a user owned by the cluster named in TakeOverAnnotation is retagged right away.
+func (r *AwsAccountReconciler) checkOwnership(ctx context.Context, awsAccount *kuadrav1.AwsAccount, userName string) error {
+	if r.ClusterId == "" {
+		return nil
+	}
+	owner, err := userOwner(ctx, r.IamWrapper, userName)
+	if err != nil {
+		return err
+	}
+	if owner == "" || owner == r.ClusterId {
+		return nil
+	}
+	if awsAccount.Annotations[TakeOverAnnotation] != owner {
+		conflict := &OwnershipConflictError{UserName: userName, Owner: owner}
+		r.event(awsAccount, v1.EventTypeWarning, "OwnershipConflict", conflict.Error())
+		return conflict
+	}
+	if err := r.IamWrapper.TagUser(ctx, userName, map[string]string{ClusterTag: r.ClusterId}); err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("took over IAM user", "userName", userName, "previousOwner", owner)
+	r.event(awsAccount, v1.EventTypeNormal, "OwnershipTakenOver", fmt.Sprintf("Took over IAM user %s from cluster %s", userName, owner))
+	return nil
+}
+
+// deleteOwnedIamUser deletes the user of a deleted AwsAccount unless another cluster owns it,
+// in which case only the Kubernetes side of the AwsAccount goes
+func (r *AwsAccountReconciler) deleteOwnedIamUser(ctx context.Context, awsAccount *kuadrav1.AwsAccount, userName string) error {
+	err := r.checkOwnership(ctx, awsAccount, userName)
+	if isOwnershipConflict(err) {
+		log.FromContext(ctx).Info("leaving IAM user of another cluster in place", "userName", userName)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return deleteIamUser(ctx, r.IamWrapper, userName)
+}
+
+func (r *AwsAccountReconciler) event(awsAccount *kuadrav1.AwsAccount, eventType string, reason string, message string) {
+	if r.Recorder != nil {
+		r.Recorder.Event(awsAccount, eventType, reason, message)
+	}
+}
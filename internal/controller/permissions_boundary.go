@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+)
+
+// PermissionsBoundaryAnnotation on a namespace sets the permissions boundary of the users
+// of the AwsAccounts in it, replacing the one configured for the cluster
+const PermissionsBoundaryAnnotation = "kuadra.kuadrant.io/permissions-boundary"
+
+// resolvePermissionsBoundary returns the permissions boundary the user should carry. The
+// spec only wins over the namespace and cluster configuration when overrides are allowed.
+func (r *AwsAccountReconciler) resolvePermissionsBoundary(ctx context.Context, awsAccount *kuadrav1.AwsAccount) (string, error) {
+	boundary := r.PermissionsBoundary
+
+	namespace := &v1.Namespace{}
+	if err := r.Get(ctx, types.NamespacedName{Name: awsAccount.Namespace}, namespace); client.IgnoreNotFound(err) != nil {
+		return "", err
+	}
+	if annotation, found := namespace.Annotations[PermissionsBoundaryAnnotation]; found && annotation != "" {
+		boundary = annotation
+	}
+
+	requested := awsAccount.Spec.PermissionsBoundary
+	switch {
+	case requested != "" && !r.AllowPermissionsBoundaryOverride:
+		meta.SetStatusCondition(&awsAccount.Status.Conditions, metav1.Condition{
+			Type:               kuadrav1.ConditionPermissionsBoundary,
+			Status:             metav1.ConditionFalse,
+			Reason:             "OverrideNotAllowed",
+			Message:            fmt.Sprintf("spec.permissionsBoundary is ignored, the cluster does not allow overrides; using %q", boundary),
+			ObservedGeneration: awsAccount.Generation,
+		})
+		return boundary, nil
+	case requested != "":
+		boundary = requested
+	}
+
+	if boundary == "" {
+		meta.RemoveStatusCondition(&awsAccount.Status.Conditions, kuadrav1.ConditionPermissionsBoundary)
+	} else {
+		meta.SetStatusCondition(&awsAccount.Status.Conditions, metav1.Condition{
+			Type:               kuadrav1.ConditionPermissionsBoundary,
+			Status:             metav1.ConditionTrue,
+			Reason:             "BoundaryApplied",
+			Message:            fmt.Sprintf("permissions boundary %q", boundary),
+			ObservedGeneration: awsAccount.Generation,
+		})
+	}
+	return boundary, nil
+}
+
+// reconcilePermissionsBoundary puts the boundary back on users where it was changed or removed
+// out-of-band, and removes it once none is configured any more
+func (r *AwsAccountReconciler) reconcilePermissionsBoundary(ctx context.Context, awsAccount *kuadrav1.AwsAccount, boundary string) error {
+	if awsAccount.Status.PermissionsBoundary == boundary {
+		return nil
+	}
+	if boundary == "" {
+		if err := r.IamWrapper.DeleteUserPermissionsBoundary(ctx, awsAccount.Spec.UserName); err != nil {
+			return err
+		}
+		log.FromContext(ctx).V(1).Info("deleted permissions boundary", "permissionsBoundary", awsAccount.Status.PermissionsBoundary)
+		awsAccount.Status.PermissionsBoundary = ""
+		return nil
+	}
+	if err := r.IamWrapper.PutUserPermissionsBoundary(ctx, awsAccount.Spec.UserName, boundary); err != nil {
+		return err
+	}
+	log.FromContext(ctx).V(1).Info("put permissions boundary", "permissionsBoundary", boundary)
+	awsAccount.Status.PermissionsBoundary = boundary
+	return nil
+}
@@ -0,0 +1,239 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+	slice "github.com/Kuadrant/kuadra/pkg/_internal"
+)
+
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch
+//+kubebuilder:rbac:groups=kuadra.kuadrant.io,resources=awspolicytemplates,verbs=get;list;watch
+
+// policyDocumentsEqual compares two JSON policy documents ignoring formatting and key order
+func policyDocumentsEqual(a string, b string) bool {
+	var left, right interface{}
+	if err := json.Unmarshal([]byte(a), &left); err != nil {
+		return a == b
+	}
+	if err := json.Unmarshal([]byte(b), &right); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(left, right)
+}
+
+// reconcileUserPolicies attaches and puts the policies in the spec and removes any other
+// policy found on the user, so that policies changed out-of-band are reverted
+func (r *AwsAccountReconciler) reconcileUserPolicies(ctx context.Context, awsAccount *kuadrav1.AwsAccount) error {
+	log := log.FromContext(ctx)
+	userName := awsAccount.Spec.UserName
+
+	for _, policyArn := range slice.GetLeftDifference(awsAccount.Spec.ManagedPolicyArns, awsAccount.Status.AttachedPolicyArns) {
+		if err := r.IamWrapper.AttachUserPolicy(ctx, userName, policyArn); err != nil {
+			return err
+		}
+		log.V(1).Info("attached user policy", "policyArn", policyArn)
+		awsAccount.Status.AttachedPolicyArns = append(awsAccount.Status.AttachedPolicyArns, policyArn)
+	}
+	for _, policyArn := range slice.GetLeftDifference(awsAccount.Status.AttachedPolicyArns, awsAccount.Spec.ManagedPolicyArns) {
+		if err := r.IamWrapper.DetachUserPolicyIfAttached(ctx, userName, policyArn); err != nil {
+			return err
+		}
+		log.V(1).Info("detached user policy", "policyArn", policyArn)
+		awsAccount.Status.AttachedPolicyArns = slice.Remove(awsAccount.Status.AttachedPolicyArns, func(p string) bool { return p == policyArn })
+	}
+
+	documents, err := r.resolveInlinePolicies(ctx, awsAccount)
+	var invalid *invalidPolicyError
+	if errors.As(err, &invalid) {
+		// Leave the inline policies as they are until the spec, ConfigMap or template is fixed
+		meta.SetStatusCondition(&awsAccount.Status.Conditions, metav1.Condition{
+			Type:               kuadrav1.ConditionPoliciesResolved,
+			Status:             metav1.ConditionFalse,
+			Reason:             "InvalidPolicy",
+			Message:            err.Error(),
+			ObservedGeneration: awsAccount.Generation,
+		})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(documents) == 0 {
+		meta.RemoveStatusCondition(&awsAccount.Status.Conditions, kuadrav1.ConditionPoliciesResolved)
+	} else {
+		meta.SetStatusCondition(&awsAccount.Status.Conditions, metav1.Condition{
+			Type:               kuadrav1.ConditionPoliciesResolved,
+			Status:             metav1.ConditionTrue,
+			Reason:             "PoliciesValid",
+			ObservedGeneration: awsAccount.Generation,
+		})
+	}
+	policyNames := sortedKeys(documents)
+	for _, policyName := range policyNames {
+		current, err := r.IamWrapper.GetUserPolicy(ctx, userName, policyName)
+		if err != nil {
+			return err
+		}
+		if current != "" && policyDocumentsEqual(current, documents[policyName]) {
+			continue
+		}
+		if err := r.IamWrapper.PutUserPolicy(ctx, userName, policyName, documents[policyName]); err != nil {
+			return err
+		}
+		log.V(1).Info("put user policy", "policyName", policyName)
+		if !slice.Contains(awsAccount.Status.InlinePolicyNames, policyName) {
+			awsAccount.Status.InlinePolicyNames = append(awsAccount.Status.InlinePolicyNames, policyName)
+		}
+	}
+	for _, policyName := range slice.GetLeftDifference(awsAccount.Status.InlinePolicyNames, policyNames) {
+		if err := r.IamWrapper.DeleteUserPolicyIfExists(ctx, userName, policyName); err != nil {
+			return err
+		}
+		log.V(1).Info("deleted user policy", "policyName", policyName)
+		awsAccount.Status.InlinePolicyNames = slice.Remove(awsAccount.Status.InlinePolicyNames, func(p string) bool { return p == policyName })
+	}
+	return nil
+}
+
+// invalidPolicyError reports an inline policy that cannot be applied until the resources
+// it is read from change
+type invalidPolicyError struct {
+	policyName string
+	err        error
+}
+
+func (e *invalidPolicyError) Error() string {
+	if e.policyName == "" {
+		return e.err.Error()
+	}
+	return fmt.Sprintf("inline policy %s: %s", e.policyName, e.err)
+}
+
+// resolveInlinePolicies returns the inline policy documents of the AwsAccount, reading
+// those that reference a ConfigMap and rendering those that reference an AwsPolicyTemplate
+func (r *AwsAccountReconciler) resolveInlinePolicies(ctx context.Context, awsAccount *kuadrav1.AwsAccount) (map[string]string, error) {
+	documents := map[string]string{}
+	var variables *policyTemplateVariables
+	size := 0
+	for _, policyName := range sortedKeys(awsAccount.Spec.InlinePolicies) {
+		policy := awsAccount.Spec.InlinePolicies[policyName]
+		document := policy.Document
+		switch {
+		case policy.ConfigMapKeyRef != nil:
+			ref := policy.ConfigMapKeyRef
+			configMap := &v1.ConfigMap{}
+			if err := r.configMapReader().Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: awsAccount.Namespace}, configMap); err != nil {
+				if apierrors.IsNotFound(err) {
+					return nil, &invalidPolicyError{policyName, fmt.Errorf("ConfigMap %s not found", ref.Name)}
+				}
+				return nil, err
+			}
+			var found bool
+			if document, found = configMap.Data[ref.Key]; !found {
+				return nil, &invalidPolicyError{policyName, fmt.Errorf("key %s not found in ConfigMap %s", ref.Key, ref.Name)}
+			}
+		case policy.TemplateRef != nil:
+			policyTemplate := &kuadrav1.AwsPolicyTemplate{}
+			if err := r.Get(ctx, types.NamespacedName{Name: policy.TemplateRef.Name, Namespace: awsAccount.Namespace}, policyTemplate); err != nil {
+				if apierrors.IsNotFound(err) {
+					return nil, &invalidPolicyError{policyName, fmt.Errorf("AwsPolicyTemplate %s not found", policy.TemplateRef.Name)}
+				}
+				return nil, err
+			}
+			if variables == nil {
+				var err error
+				if variables, err = r.policyTemplateVariables(ctx, awsAccount); err != nil {
+					return nil, err
+				}
+			}
+			var err error
+			if document, err = renderPolicyTemplate(policyTemplate.Spec.Document, *variables); err != nil {
+				return nil, &invalidPolicyError{policyName, fmt.Errorf("unable to render AwsPolicyTemplate %s: %w", policyTemplate.Name, err)}
+			}
+		}
+		if !json.Valid([]byte(document)) {
+			return nil, &invalidPolicyError{policyName, fmt.Errorf("not a valid JSON document")}
+		}
+		size += policySize(document)
+		documents[policyName] = document
+	}
+	if size > maxUserInlinePoliciesSize {
+		return nil, &invalidPolicyError{err: fmt.Errorf("inline policies total %d characters, exceeding the IAM limit of %d per user", size, maxUserInlinePoliciesSize)}
+	}
+	return documents, nil
+}
+
+// policyTemplateVariables collects the values AwsPolicyTemplates are rendered with
+func (r *AwsAccountReconciler) policyTemplateVariables(ctx context.Context, awsAccount *kuadrav1.AwsAccount) (*policyTemplateVariables, error) {
+	accountId, err := r.getAccountId(ctx, awsAccount.Spec.UserName)
+	if err != nil {
+		return nil, err
+	}
+	variables := &policyTemplateVariables{
+		UserName:  awsAccount.Spec.UserName,
+		Namespace: awsAccount.Spec.UserName,
+		AccountId: accountId,
+		Labels:    awsAccount.Labels,
+	}
+	if awsAccount.Spec.HostedZone != nil {
+		variables.HostedZoneId = awsAccount.Spec.HostedZone.ID
+	}
+	return variables, nil
+}
+
+// configMapReader returns the reader for ConfigMaps, which are not cached in full
+func (r *AwsAccountReconciler) configMapReader() client.Reader {
+	if r.APIReader != nil {
+		return r.APIReader
+	}
+	return r.Client
+}
+
+// awsAccountsForConfigMap maps a ConfigMap to the AwsAccounts in its namespace that read
+// inline policies from it
+func (r *AwsAccountReconciler) awsAccountsForConfigMap(object client.Object) []reconcile.Request {
+	return r.awsAccountsForInlinePolicy(object.GetNamespace(), func(policy kuadrav1.InlinePolicy) bool {
+		return policy.ConfigMapKeyRef != nil && policy.ConfigMapKeyRef.Name == object.GetName()
+	})
+}
+
+// awsAccountsForPolicyTemplate maps an AwsPolicyTemplate to the AwsAccounts in its namespace
+// that render inline policies from it
+func (r *AwsAccountReconciler) awsAccountsForPolicyTemplate(object client.Object) []reconcile.Request {
+	return r.awsAccountsForInlinePolicy(object.GetNamespace(), func(policy kuadrav1.InlinePolicy) bool {
+		return policy.TemplateRef != nil && policy.TemplateRef.Name == object.GetName()
+	})
+}
+
+func (r *AwsAccountReconciler) awsAccountsForInlinePolicy(namespace string, references func(kuadrav1.InlinePolicy) bool) []reconcile.Request {
+	var awsAccounts kuadrav1.AwsAccountList
+	if err := r.List(context.Background(), &awsAccounts, client.InNamespace(namespace)); err != nil {
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, awsAccount := range awsAccounts.Items {
+		for _, policy := range awsAccount.Spec.InlinePolicies {
+			if references(policy) {
+				requests = append(requests, reconcile.Request{
+					NamespacedName: types.NamespacedName{Name: awsAccount.Name, Namespace: awsAccount.Namespace},
+				})
+				break
+			}
+		}
+	}
+	return requests
+}
@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// maxUserInlinePoliciesSize is the IAM limit on the aggregate size of a user's inline
+// policies, counted without whitespace
+const maxUserInlinePoliciesSize = 2048
+
+// placeholderPattern matches ${...} placeholders, including IAM policy variables such as ${aws:username}
+var placeholderPattern = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// policyTemplateVariables are the values available to an AwsPolicyTemplate
+type policyTemplateVariables struct {
+	UserName     string
+	Namespace    string
+	AccountId    string
+	HostedZoneId string
+	Labels       map[string]string
+}
+
+// lookup returns the value of a ${...} placeholder. IAM policy variables are not ours to
+// replace and are reported as not found.
+func (v policyTemplateVariables) lookup(name string) (string, bool, error) {
+	switch name {
+	case "userName":
+		return v.UserName, true, nil
+	case "namespace":
+		return v.Namespace, true, nil
+	case "accountId":
+		return v.AccountId, true, nil
+	case "hostedZoneId":
+		if v.HostedZoneId == "" {
+			return "", false, fmt.Errorf("${hostedZoneId} used but no hosted zone is assigned")
+		}
+		return v.HostedZoneId, true, nil
+	}
+	if strings.HasPrefix(name, "labels.") {
+		key := strings.TrimPrefix(name, "labels.")
+		value, exists := v.Labels[key]
+		if !exists {
+			return "", false, fmt.Errorf("${%s} used but the AwsAccount has no label %q", name, key)
+		}
+		return value, true, nil
+	}
+	// IAM variables are namespaced (aws:, s3:, ...) or one of the special characters ${*}, ${?} and ${$}
+	if strings.Contains(name, ":") || name == "*" || name == "?" || name == "$" {
+		return "", false, nil
+	}
+	return "", false, fmt.Errorf("unknown placeholder ${%s}", name)
+}
+
+// renderPolicyTemplate replaces the ${...} placeholders and Go template actions of the
+// document with the variables of a user
+func renderPolicyTemplate(document string, variables policyTemplateVariables) (string, error) {
+	var lookupErr error
+	substituted := placeholderPattern.ReplaceAllStringFunc(document, func(placeholder string) string {
+		value, found, err := variables.lookup(placeholderPattern.FindStringSubmatch(placeholder)[1])
+		if err != nil && lookupErr == nil {
+			lookupErr = err
+		}
+		if !found {
+			return placeholder
+		}
+		return value
+	})
+	if lookupErr != nil {
+		return "", lookupErr
+	}
+
+	tmpl, err := template.New("policy").Option("missingkey=error").Parse(substituted)
+	if err != nil {
+		return "", err
+	}
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, variables); err != nil {
+		return "", err
+	}
+	return rendered.String(), nil
+}
+
+// policySize returns the size of a policy document as counted by IAM
+func policySize(document string) int {
+	size := 0
+	for _, r := range document {
+		if !unicode.IsSpace(r) {
+			size++
+		}
+	}
+	return size
+}
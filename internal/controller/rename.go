@@ -0,0 +1,145 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+)
+
+// UserNameTakenError is returned when spec.userName is changed to the name of another IAM user
+type UserNameTakenError struct {
+	From string
+	To   string
+}
+
+func (e *UserNameTakenError) Error() string {
+	return fmt.Sprintf("cannot rename IAM user %s to %s, an IAM user named %s already exists", e.From, e.To, e.To)
+}
+
+// renameUser moves the IAM user, its namespace and its Secrets to a changed spec.userName.
+// IAM renames the user in place, keeping its groups, policies, keys and login profile.
+// The new name is written to status as soon as IAM has taken it, together with the old
+// one in PreviousUserName, so that an interrupted rename is finished rather than started
+// over, and changing spec.userName again renames the user from the name it really has.
+// Namespaces cannot be renamed, so the Secrets are then moved to a namespace with the new
+// name and the old namespace is deleted with everything in it. It returns false while the
+// ManagedZone in the old namespace is being removed.
+func (r *AwsAccountReconciler) renameUser(ctx context.Context, awsAccount *kuadrav1.AwsAccount) (bool, error) {
+	log := log.FromContext(ctx)
+	if previous := awsAccount.Status.PreviousUserName; previous != "" {
+		if err := r.moveUserNamespace(ctx, awsAccount, previous, awsAccount.Status.UserName); err != nil {
+			return false, err
+		}
+	}
+
+	from, to := awsAccount.Status.UserName, awsAccount.Spec.UserName
+	if from == "" || from == to {
+		return true, nil
+	}
+
+	if err := r.checkOwnership(ctx, awsAccount, from); err != nil {
+		return false, err
+	}
+
+	// The ManagedZone references the DNS provider Secret in the old namespace, it is created
+	// again in the new one
+	if zone := awsAccount.Status.ManagedZone; zone != "" {
+		deleted, err := r.deleteManagedZone(ctx, from, zone)
+		if err != nil || !deleted {
+			return false, err
+		}
+		awsAccount.Status.ManagedZone = ""
+	}
+
+	oldUser, err := r.IamWrapper.GetUser(ctx, from)
+	if err != nil {
+		return false, err
+	}
+	newUser, err := r.IamWrapper.GetUser(ctx, to)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case oldUser != nil && newUser != nil:
+		return false, &UserNameTakenError{From: from, To: to}
+	case oldUser != nil:
+		if err := r.IamWrapper.UpdateUserName(ctx, from, to); err != nil {
+			return false, err
+		}
+		log.Info("renamed IAM user", "from", from, "to", to)
+	}
+
+	if awsAccount.Status.Credentials == nil {
+		legacy := legacyCredentials(from)
+		awsAccount.Status.Credentials = &legacy
+	}
+	awsAccount.Status.UserName = to
+	awsAccount.Status.PreviousUserName = from
+	if err := r.Status().Update(ctx, awsAccount); err != nil {
+		return false, err
+	}
+	r.event(awsAccount, v1.EventTypeNormal, "UserRenamed", fmt.Sprintf("Renamed IAM user %s to %s", from, to))
+
+	if err := r.moveUserNamespace(ctx, awsAccount, from, to); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// moveUserNamespace moves the Secrets of a renamed user from the namespace of its old name to
+// the namespace of its new one, deletes the old namespace and clears PreviousUserName
+func (r *AwsAccountReconciler) moveUserNamespace(ctx context.Context, awsAccount *kuadrav1.AwsAccount, from string, to string) error {
+	if awsAccount.Status.Credentials == nil {
+		legacy := legacyCredentials(from)
+		awsAccount.Status.Credentials = &legacy
+	}
+	if awsAccount.Status.LoginProfileCreated {
+		if err := r.renameLoginSecret(ctx, awsAccount, *awsAccount.Status.Credentials.LoginSecretRef, to); err != nil {
+			return err
+		}
+	}
+	if err := r.createNamespaceIfNotExists(ctx, to); err != nil {
+		return err
+	}
+	// Credentials follow the name IAM has, which the spec may already have moved on from
+	target := awsAccount.DeepCopy()
+	target.Spec.UserName = to
+	if err := r.moveCredentials(ctx, awsAccount, resolveCredentials(*target, r.Region)); err != nil {
+		return err
+	}
+	if err := r.deleteNamespace(ctx, from); err != nil {
+		return err
+	}
+
+	awsAccount.Status.PreviousUserName = ""
+	return r.Status().Update(ctx, awsAccount)
+}
+
+// renameLoginSecret writes the new user name into the login Secret, which is then moved
+// with the other Secrets
+func (r *AwsAccountReconciler) renameLoginSecret(ctx context.Context, awsAccount *kuadrav1.AwsAccount, ref kuadrav1.SecretReference, userName string) error {
+	secret := &v1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: ref.Namespace}, secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !ownsSecret(awsAccount, secret) {
+		return &SecretNotOwnedError{Namespace: ref.Namespace, Name: ref.Name}
+	}
+	if string(secret.Data["userName"]) == userName {
+		return nil
+	}
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data["userName"] = []byte(userName)
+	return r.Update(ctx, secret)
+}
@@ -0,0 +1,362 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+)
+
+const (
+	// defaultNamespaceTemplate names the users of a team after its members
+	defaultNamespaceTemplate = "${member}"
+	// TeamFinalizer releases the Users of a deleted Team with the Orphan deletion policy
+	// before garbage collection would delete them with the Team
+	TeamFinalizer = "kuadra.kuadrant.io/team"
+)
+
+// TeamReconciler reconciles a Team object
+type TeamReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// DryRun logs the changes to the Users of every Team without making them, as
+	// DryRunAnnotation does for one
+	DryRun bool
+}
+
+//+kubebuilder:rbac:groups=kuadra.kuadrant.io,resources=teams,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=kuadra.kuadrant.io,resources=teams/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=kuadra.kuadrant.io,resources=teams/finalizers,verbs=update
+
+// Reconcile creates or updates a User for every member of the Team, removes the Users of
+// members who left according to the deletion policy and counts the members whose
+// AwsAccount is ready
+func (r *TeamReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	var team kuadrav1.Team
+	if err := r.Get(ctx, req.NamespacedName, &team); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if isDryRun(r.DryRun, &team) {
+		p := &plan{}
+		planner := *r
+		planner.DryRun = false
+		planner.Client = newDryRunClient(r.Client, p, &team)
+		if _, err := planner.reconcileMembers(ctx, &team); err != nil {
+			return ctrl.Result{}, err
+		}
+		log.Info("dry run, changes not made", "plannedActions", p.actions)
+		return ctrl.Result{}, nil
+	}
+
+	if team.DeletionTimestamp != nil && !team.DeletionTimestamp.IsZero() {
+		if team.Spec.DeletionPolicy == kuadrav1.TeamDeletionPolicyOrphan {
+			if err := r.releaseUsers(ctx, &team, map[string]bool{}); err != nil {
+				log.Error(err, "unable to release the Users of the team")
+				return ctrl.Result{}, err
+			}
+		}
+		controllerutil.RemoveFinalizer(&team, TeamFinalizer)
+		if err := r.Update(ctx, &team); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if !controllerutil.ContainsFinalizer(&team, TeamFinalizer) {
+		controllerutil.AddFinalizer(&team, TeamFinalizer)
+		if err := r.Update(ctx, &team); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	members, err := r.reconcileMembers(ctx, &team)
+	if err != nil {
+		log.Error(err, "unable to reconcile team members")
+		return ctrl.Result{}, err
+	}
+	if err := r.updateStatus(ctx, &team, members); err != nil {
+		log.Error(err, "unable to update team status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// reconcileMembers brings the Users of the team in line with its members and returns the
+// state of each member
+func (r *TeamReconciler) reconcileMembers(ctx context.Context, team *kuadrav1.Team) ([]kuadrav1.TeamMemberStatus, error) {
+	var members []kuadrav1.TeamMemberStatus
+	wanted := map[string]bool{}
+	for _, member := range team.Spec.Members {
+		status := kuadrav1.TeamMemberStatus{Name: member.Name}
+		userName, err := memberUserName(team, member)
+		if err != nil {
+			status.Message = err.Error()
+			members = append(members, status)
+			continue
+		}
+		status.UserName = userName
+		if wanted[userName] {
+			status.Message = fmt.Sprintf("another member already has the user name %s", userName)
+			members = append(members, status)
+			continue
+		}
+		wanted[userName] = true
+
+		if status.Message, err = r.reconcileUser(ctx, team, member, userName); err != nil {
+			return nil, err
+		}
+		if status.Message == "" {
+			if status.Phase, err = r.memberPhase(ctx, team.Namespace, userName); err != nil {
+				return nil, err
+			}
+		}
+		members = append(members, status)
+	}
+
+	if err := r.releaseUsers(ctx, team, wanted); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// memberUserName renders the namespace template of the team for a member
+func memberUserName(team *kuadrav1.Team, member kuadrav1.TeamMember) (string, error) {
+	template := team.Spec.Defaults.NamespaceTemplate
+	if template == "" {
+		template = defaultNamespaceTemplate
+	}
+	var unknown []string
+	userName := placeholderPattern.ReplaceAllStringFunc(template, func(placeholder string) string {
+		switch name := placeholderPattern.FindStringSubmatch(placeholder)[1]; name {
+		case "team":
+			return team.Name
+		case "member":
+			return member.Name
+		default:
+			unknown = append(unknown, placeholder)
+			return placeholder
+		}
+	})
+	if len(unknown) > 0 {
+		return "", fmt.Errorf("unknown placeholder %s in namespaceTemplate, expected ${team} or ${member}", unknown[0])
+	}
+	if errs := validation.IsDNS1123Label(userName); len(errs) > 0 {
+		return "", fmt.Errorf("%q cannot be used as a namespace name: %s", userName, strings.Join(errs, ", "))
+	}
+	return userName, nil
+}
+
+// memberSpec is the AwsAccount spec of a member: the team defaults with the member's overrides.
+// The hosted zone of the team is a parent domain, each member gets the subdomain named after
+// their user in it.
+func memberSpec(team *kuadrav1.Team, member kuadrav1.TeamMember, userName string) kuadrav1.AwsAccountSpec {
+	defaults := team.Spec.Defaults
+	spec := kuadrav1.AwsAccountSpec{
+		UserName:          userName,
+		Groups:            defaults.Groups,
+		GroupRefs:         defaults.GroupRefs,
+		ManagedPolicyArns: defaults.ManagedPolicyArns,
+		InlinePolicies:    defaults.InlinePolicies,
+	}
+	if parent := defaults.HostedZone; parent != nil {
+		spec.HostedZone = &kuadrav1.HostedZoneSpec{
+			ID:                parent.ID,
+			DomainName:        userName + "." + parent.DomainName,
+			CreateManagedZone: parent.CreateManagedZone,
+		}
+	}
+	if member.Groups != nil {
+		spec.Groups = member.Groups
+	}
+	if member.GroupRefs != nil {
+		spec.GroupRefs = member.GroupRefs
+	}
+	if member.ManagedPolicyArns != nil {
+		spec.ManagedPolicyArns = member.ManagedPolicyArns
+	}
+	if member.InlinePolicies != nil {
+		spec.InlinePolicies = member.InlinePolicies
+	}
+	if member.HostedZone != nil {
+		spec.HostedZone = member.HostedZone
+	}
+	return *spec.DeepCopy()
+}
+
+// reconcileUser creates or updates the User of a member. A User of the same name that the
+// team does not own is left alone and explained in the returned message.
+func (r *TeamReconciler) reconcileUser(ctx context.Context, team *kuadrav1.Team, member kuadrav1.TeamMember, userName string) (string, error) {
+	existing := &kuadrav1.User{}
+	err := r.Get(ctx, types.NamespacedName{Name: userName, Namespace: team.Namespace}, existing)
+	if client.IgnoreNotFound(err) != nil {
+		return "", err
+	}
+	if err == nil && !metav1.IsControlledBy(existing, team) {
+		return fmt.Sprintf("User %s already exists and does not belong to the team", userName), nil
+	}
+
+	user := &kuadrav1.User{ObjectMeta: metav1.ObjectMeta{Name: userName, Namespace: team.Namespace}}
+	result, err := controllerutil.CreateOrUpdate(ctx, r.Client, user, func() error {
+		if user.Labels == nil {
+			user.Labels = map[string]string{}
+		}
+		user.Labels[kuadrav1.TeamLabel] = team.Name
+		if member.Email != "" {
+			if user.Annotations == nil {
+				user.Annotations = map[string]string{}
+			}
+			user.Annotations[kuadrav1.EmailAnnotation] = member.Email
+		} else {
+			delete(user.Annotations, kuadrav1.EmailAnnotation)
+		}
+		user.Spec.AwsAccount = &kuadrav1.AwsAccountNestedSpec{
+			Spec: kuadrav1.AwsSpec{User: memberSpec(team, member, userName)},
+		}
+		return controllerutil.SetControllerReference(team, user, r.Scheme)
+	})
+	if err != nil {
+		return "", err
+	}
+	if result != controllerutil.OperationResultNone {
+		log.FromContext(ctx).V(1).Info("reconciled team member", "user", userName, "result", result)
+	}
+	return "", nil
+}
+
+// memberPhase is the phase of the AwsAccount the User of a member created
+func (r *TeamReconciler) memberPhase(ctx context.Context, namespace string, userName string) (kuadrav1.AwsAccountPhase, error) {
+	awsAccount := &kuadrav1.AwsAccount{}
+	if err := r.Get(ctx, types.NamespacedName{Name: userName, Namespace: namespace}, awsAccount); err != nil {
+		return kuadrav1.AwsAccountPhasePending, client.IgnoreNotFound(err)
+	}
+	if awsAccount.Status.Phase == "" {
+		return kuadrav1.AwsAccountPhasePending, nil
+	}
+	return awsAccount.Status.Phase, nil
+}
+
+// releaseUsers deletes or releases the Users of the team that no member wants any more,
+// according to the deletion policy
+func (r *TeamReconciler) releaseUsers(ctx context.Context, team *kuadrav1.Team, wanted map[string]bool) error {
+	log := log.FromContext(ctx)
+
+	var users kuadrav1.UserList
+	if err := r.List(ctx, &users, client.InNamespace(team.Namespace), client.MatchingLabels{kuadrav1.TeamLabel: team.Name}); err != nil {
+		return err
+	}
+	for i := range users.Items {
+		user := &users.Items[i]
+		if wanted[user.Name] || !metav1.IsControlledBy(user, team) {
+			continue
+		}
+		if team.Spec.DeletionPolicy == kuadrav1.TeamDeletionPolicyOrphan {
+			delete(user.Labels, kuadrav1.TeamLabel)
+			var owners []metav1.OwnerReference
+			for _, owner := range user.OwnerReferences {
+				if owner.UID != team.UID || owner.Kind != "Team" {
+					owners = append(owners, owner)
+				}
+			}
+			user.OwnerReferences = owners
+			if err := r.Update(ctx, user); err != nil {
+				return err
+			}
+			log.Info("released User of departed team member", "user", user.Name)
+			continue
+		}
+		if err := r.Delete(ctx, user); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		log.Info("deleted User of departed team member", "user", user.Name)
+	}
+	return nil
+}
+
+// updateStatus records the members and how many of them are ready
+func (r *TeamReconciler) updateStatus(ctx context.Context, team *kuadrav1.Team, members []kuadrav1.TeamMemberStatus) error {
+	status := team.Status.DeepCopy()
+	status.Members = members
+	status.TotalMembers = len(members)
+	status.ReadyMembers = 0
+	var notReady []string
+	for _, member := range members {
+		if member.Phase == kuadrav1.AwsAccountPhaseReady {
+			status.ReadyMembers++
+		} else {
+			notReady = append(notReady, member.Name)
+		}
+	}
+	status.Ready = fmt.Sprintf("%d/%d", status.ReadyMembers, status.TotalMembers)
+
+	condition := metav1.Condition{
+		Type:               kuadrav1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             "MembersReady",
+		Message:            fmt.Sprintf("%s members ready", status.Ready),
+		ObservedGeneration: team.Generation,
+	}
+	if len(notReady) > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "MembersNotReady"
+		condition.Message = fmt.Sprintf("%s members ready, waiting for %s", status.Ready, strings.Join(notReady, ", "))
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+
+	if reflect.DeepEqual(team.Status, *status) {
+		return nil
+	}
+	team.Status = *status
+	return r.Status().Update(ctx, team)
+}
+
+// teamForAwsAccount maps an AwsAccount to the Team of the User that created it
+func (r *TeamReconciler) teamForAwsAccount(obj client.Object) []reconcile.Request {
+	team, found := obj.GetLabels()[kuadrav1.TeamLabel]
+	if !found {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: team, Namespace: obj.GetNamespace()}}}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *TeamReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&kuadrav1.Team{}).
+		Owns(&kuadrav1.User{}).
+		Watches(&source.Kind{Type: &kuadrav1.AwsAccount{}}, handler.EnqueueRequestsFromMapFunc(r.teamForAwsAccount)).
+		Complete(r)
+}
@@ -0,0 +1,198 @@
+package controller
+
+import (
+	"context"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8Types "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var _ = Describe("Team controller", func() {
+
+	const (
+		TeamName      = "dns"
+		TeamNamespace = "default"
+	)
+
+	ctx := context.Background()
+
+	teamLookupKey := k8Types.NamespacedName{Name: TeamName, Namespace: TeamNamespace}
+
+	newTeam := func(policy kuadrav1.TeamDeletionPolicy) *kuadrav1.Team {
+		return &kuadrav1.Team{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      TeamName,
+				Namespace: TeamNamespace,
+			},
+			Spec: kuadrav1.TeamSpec{
+				Defaults: kuadrav1.TeamDefaults{
+					NamespaceTemplate: "${team}-${member}",
+					Groups:            []string{"dns-management"},
+					ManagedPolicyArns: []string{"arn:aws:iam::aws:policy/AmazonRoute53ReadOnlyAccess"},
+					HostedZone:        &kuadrav1.HostedZoneSpec{ID: "Z0123", DomainName: "dns.example.com", CreateManagedZone: true},
+				},
+				Members: []kuadrav1.TeamMember{
+					{Name: "alice", Email: "alice@example.com"},
+					{Name: "bob", Groups: []string{"dns-management", "dns-admin"}},
+				},
+				DeletionPolicy: policy,
+			},
+		}
+	}
+
+	Context("When reconciling a Team", func() {
+		It("Should create a User per member and count the ready ones", func() {
+			req := reconcile.Request{NamespacedName: teamLookupKey}
+			client := fake.NewClientBuilder().Build()
+			Expect(client.Create(ctx, newTeam(kuadrav1.TeamDeletionPolicyDelete))).Should(Succeed())
+
+			r := &TeamReconciler{Client: client, Scheme: scheme.Scheme}
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+
+			By("By merging the team defaults with the member overrides")
+			alice := &kuadrav1.User{}
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: "dns-alice", Namespace: TeamNamespace}, alice)).Should(Succeed())
+			Expect(alice.Labels[kuadrav1.TeamLabel]).Should(Equal(TeamName))
+			Expect(alice.Annotations[kuadrav1.EmailAnnotation]).Should(Equal("alice@example.com"))
+			Expect(alice.OwnerReferences).Should(HaveLen(1))
+			Expect(alice.OwnerReferences[0].Kind).Should(Equal("Team"))
+			Expect(alice.Spec.AwsAccount.Spec.User.UserName).Should(Equal("dns-alice"))
+			Expect(alice.Spec.AwsAccount.Spec.User.Groups).Should(Equal([]string{"dns-management"}))
+			Expect(alice.Spec.AwsAccount.Spec.User.HostedZone).Should(Equal(&kuadrav1.HostedZoneSpec{
+				ID: "Z0123", DomainName: "dns-alice.dns.example.com", CreateManagedZone: true,
+			}))
+
+			bob := &kuadrav1.User{}
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: "dns-bob", Namespace: TeamNamespace}, bob)).Should(Succeed())
+			Expect(bob.Spec.AwsAccount.Spec.User.Groups).Should(Equal([]string{"dns-management", "dns-admin"}))
+			Expect(bob.Spec.AwsAccount.Spec.User.ManagedPolicyArns).Should(Equal([]string{"arn:aws:iam::aws:policy/AmazonRoute53ReadOnlyAccess"}))
+
+			team := &kuadrav1.Team{}
+			Expect(client.Get(ctx, teamLookupKey, team)).Should(Succeed())
+			Expect(team.Status.Ready).Should(Equal("0/2"))
+			Expect(meta.IsStatusConditionFalse(team.Status.Conditions, kuadrav1.ConditionReady)).Should(BeTrue())
+
+			By("By counting members whose AwsAccount is ready")
+			awsAccount := &kuadrav1.AwsAccount{
+				ObjectMeta: metav1.ObjectMeta{Name: "dns-alice", Namespace: TeamNamespace},
+				Spec:       alice.Spec.AwsAccount.Spec.User,
+				Status:     kuadrav1.AwsAccountStatus{Phase: kuadrav1.AwsAccountPhaseReady},
+			}
+			Expect(client.Create(ctx, awsAccount)).Should(Succeed())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(client.Get(ctx, teamLookupKey, team)).Should(Succeed())
+			Expect(team.Status.Ready).Should(Equal("1/2"))
+			Expect(team.Status.Members).Should(Equal([]kuadrav1.TeamMemberStatus{
+				{Name: "alice", UserName: "dns-alice", Phase: kuadrav1.AwsAccountPhaseReady},
+				{Name: "bob", UserName: "dns-bob", Phase: kuadrav1.AwsAccountPhasePending},
+			}))
+
+			By("By reporting members that cannot be given a User")
+			team.Spec.Members = append(team.Spec.Members, kuadrav1.TeamMember{Name: "Carol"})
+			Expect(client.Update(ctx, team)).Should(Succeed())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(client.Get(ctx, teamLookupKey, team)).Should(Succeed())
+			Expect(team.Status.Ready).Should(Equal("1/3"))
+			Expect(team.Status.Members[2].Message).Should(ContainSubstring("cannot be used as a namespace name"))
+
+			By("By deleting the User of a departed member")
+			team.Spec.Members = team.Spec.Members[:1]
+			Expect(client.Update(ctx, team)).Should(Succeed())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			err = client.Get(ctx, k8Types.NamespacedName{Name: "dns-bob", Namespace: TeamNamespace}, &kuadrav1.User{})
+			Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+			Expect(client.Get(ctx, teamLookupKey, team)).Should(Succeed())
+			Expect(team.Status.Ready).Should(Equal("1/1"))
+			Expect(meta.IsStatusConditionTrue(team.Status.Conditions, kuadrav1.ConditionReady)).Should(BeTrue())
+		})
+
+		It("Should keep the User of a departed member with the Orphan policy", func() {
+			req := reconcile.Request{NamespacedName: teamLookupKey}
+			client := fake.NewClientBuilder().Build()
+			Expect(client.Create(ctx, newTeam(kuadrav1.TeamDeletionPolicyOrphan))).Should(Succeed())
+
+			r := &TeamReconciler{Client: client, Scheme: scheme.Scheme}
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+
+			team := &kuadrav1.Team{}
+			Expect(client.Get(ctx, teamLookupKey, team)).Should(Succeed())
+			team.Spec.Members = team.Spec.Members[:1]
+			Expect(client.Update(ctx, team)).Should(Succeed())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+
+			bob := &kuadrav1.User{}
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: "dns-bob", Namespace: TeamNamespace}, bob)).Should(Succeed())
+			Expect(bob.OwnerReferences).Should(BeEmpty())
+			Expect(bob.Labels).ShouldNot(HaveKey(kuadrav1.TeamLabel))
+
+			By("By keeping the Users of the remaining members when the Team is deleted")
+			Expect(client.Get(ctx, teamLookupKey, team)).Should(Succeed())
+			Expect(team.Finalizers).Should(ContainElement(TeamFinalizer))
+			Expect(client.Delete(ctx, team)).Should(Succeed())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			err = client.Get(ctx, teamLookupKey, team)
+			Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+			alice := &kuadrav1.User{}
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: "dns-alice", Namespace: TeamNamespace}, alice)).Should(Succeed())
+			Expect(alice.OwnerReferences).Should(BeEmpty())
+		})
+
+		It("Should copy only the team and email of a User to its AwsAccount", func() {
+			client := fake.NewClientBuilder().Build()
+			user := &kuadrav1.User{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "dns-alice",
+					Namespace:   TeamNamespace,
+					Labels:      map[string]string{kuadrav1.TeamLabel: TeamName, "cost-centre": "42"},
+					Annotations: map[string]string{kuadrav1.EmailAnnotation: "alice@example.com", TakeOverAnnotation: "cluster-b"},
+				},
+				Spec: kuadrav1.UserSpec{AwsAccount: &kuadrav1.AwsAccountNestedSpec{
+					Spec: kuadrav1.AwsSpec{User: kuadrav1.AwsAccountSpec{UserName: "dns-alice"}},
+				}},
+			}
+			Expect(client.Create(ctx, user)).Should(Succeed())
+
+			r := &UserReconciler{Client: client, Scheme: scheme.Scheme}
+			_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: k8Types.NamespacedName{Name: "dns-alice", Namespace: TeamNamespace}})
+			Expect(err).Should(BeNil())
+
+			awsAccount := &kuadrav1.AwsAccount{}
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: "dns-alice", Namespace: TeamNamespace}, awsAccount)).Should(Succeed())
+			Expect(awsAccount.Labels).Should(Equal(map[string]string{kuadrav1.TeamLabel: TeamName}))
+			Expect(awsAccount.Annotations).Should(Equal(map[string]string{kuadrav1.EmailAnnotation: "alice@example.com"}))
+
+			By("By keeping the finalizer and other annotations of the AwsAccount on update")
+			controllerutil.AddFinalizer(awsAccount, AwsAccountFinalizer)
+			awsAccount.Annotations[DryRunAnnotation] = "true"
+			Expect(client.Update(ctx, awsAccount)).Should(Succeed())
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: "dns-alice", Namespace: TeamNamespace}, user)).Should(Succeed())
+			delete(user.Annotations, kuadrav1.EmailAnnotation)
+			user.Spec.AwsAccount.Spec.User.Groups = []string{"dns"}
+			Expect(client.Update(ctx, user)).Should(Succeed())
+			_, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: k8Types.NamespacedName{Name: "dns-alice", Namespace: TeamNamespace}})
+			Expect(err).Should(BeNil())
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: "dns-alice", Namespace: TeamNamespace}, awsAccount)).Should(Succeed())
+			Expect(awsAccount.Spec.Groups).Should(Equal([]string{"dns"}))
+			Expect(awsAccount.Finalizers).Should(ContainElement(AwsAccountFinalizer))
+			Expect(awsAccount.Annotations).Should(Equal(map[string]string{DryRunAnnotation: "true"}))
+			Expect(awsAccount.OwnerReferences).Should(HaveLen(1))
+		})
+	})
+})
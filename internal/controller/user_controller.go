@@ -21,7 +21,6 @@ import (
 
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
@@ -35,6 +34,9 @@ import (
 type UserReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// DryRun logs the changes to the AwsAccount of every User without making them, as
+	// DryRunAnnotation does for one
+	DryRun bool
 }
 
 //+kubebuilder:rbac:groups=kuadra.kuadrant.io,resources=users,verbs=get;list;watch;create;update;patch;delete
@@ -59,75 +61,76 @@ func (r *UserReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	awsAccount := r.createAwsAccountScheme(&user, req.Namespace)
-
-	if err := controllerutil.SetControllerReference(&user, awsAccount, r.Scheme); err != nil {
-		log.Error(err, "Failed to set owner reference for AwsAccount")
-		return reconcile.Result{}, err
-	}
-
-	existingAwsAccount, err := r.getExistingAwsAccount(ctx, awsAccount)
-	if err != nil {
-		return reconcile.Result{}, err
-	}
-	if existingAwsAccount == nil {
-		err := r.createAwsAccount(ctx, awsAccount)
-		if err != nil {
-			return reconcile.Result{}, err
-		}
-	} else {
-		err := r.updateAwsAccount(ctx, awsAccount, existingAwsAccount)
-		if err != nil {
+	if isDryRun(r.DryRun, &user) {
+		p := &plan{}
+		planner := *r
+		planner.DryRun = false
+		planner.Client = newDryRunClient(r.Client, p, &user)
+		if err := planner.reconcileAwsAccount(ctx, &user, req.Namespace); err != nil {
 			return reconcile.Result{}, err
 		}
+		log.Info("dry run, changes not made", "plannedActions", p.actions)
+		return ctrl.Result{}, nil
 	}
 
+	if err := r.reconcileAwsAccount(ctx, &user, req.Namespace); err != nil {
+		return reconcile.Result{}, err
+	}
 	return ctrl.Result{}, nil
 }
 
-func (r *UserReconciler) createAwsAccountScheme(user *kuadrav1.User, namespace string) *kuadrav1.AwsAccount {
-	return &kuadrav1.AwsAccount{
-		TypeMeta: v1.TypeMeta{},
+// reconcileAwsAccount creates or updates the AwsAccount of the User. Only the spec, the owner
+// reference and the copied labels and annotations are set, so that the finalizer and the
+// metadata added to the AwsAccount by others are kept.
+func (r *UserReconciler) reconcileAwsAccount(ctx context.Context, user *kuadrav1.User, namespace string) error {
+	log := log.FromContext(ctx)
+
+	awsAccount := &kuadrav1.AwsAccount{
 		ObjectMeta: v1.ObjectMeta{
 			Name:      user.Spec.AwsAccount.Spec.User.UserName,
 			Namespace: namespace,
 		},
-		Spec: kuadrav1.AwsAccountSpec{
-			UserName: user.Spec.AwsAccount.Spec.User.UserName,
-			Groups:   user.Spec.AwsAccount.Spec.User.Groups,
-		},
 	}
-}
-
-// getExistingAwsAccount retrieves the existing AwsAccount object, if it exists.
-func (r *UserReconciler) getExistingAwsAccount(ctx context.Context, awsAccount *kuadrav1.AwsAccount) (*kuadrav1.AwsAccount, error) {
-	existingAwsAccount := &kuadrav1.AwsAccount{}
-	err := r.Get(ctx, types.NamespacedName{Name: awsAccount.Spec.UserName, Namespace: awsAccount.Namespace}, existingAwsAccount)
+	result, err := controllerutil.CreateOrUpdate(ctx, r.Client, awsAccount, func() error {
+		awsAccount.Spec = *user.Spec.AwsAccount.Spec.User.DeepCopy()
+		awsAccount.Labels = copyKeys(awsAccount.Labels, user.Labels, copiedLabels)
+		awsAccount.Annotations = copyKeys(awsAccount.Annotations, user.Annotations, copiedAnnotations)
+		return controllerutil.SetControllerReference(user, awsAccount, r.Scheme)
+	})
 	if err != nil {
-		return nil, client.IgnoreNotFound(err)
-	}
-	return existingAwsAccount, nil
-}
-
-// updateAwsAccount updates the existing AwsAccount object.
-func (r *UserReconciler) updateAwsAccount(ctx context.Context, awsAccount, existingAwsAccount *kuadrav1.AwsAccount) error {
-	awsAccount.ObjectMeta.ResourceVersion = existingAwsAccount.ObjectMeta.ResourceVersion
-	err := r.Update(ctx, awsAccount)
-	if err != nil {
-		log.Log.Error(err, "Failed to update AwsAccount")
+		log.Error(err, "Failed to reconcile AwsAccount")
 		return err
 	}
+	if result != controllerutil.OperationResultNone {
+		log.V(1).Info("reconciled AwsAccount", "awsAccount", awsAccount.Name, "result", result)
+	}
 	return nil
 }
 
-// createAwsAccount creates a new AwsAccount object.
-func (r *UserReconciler) createAwsAccount(ctx context.Context, awsAccount *kuadrav1.AwsAccount) error {
-	err := r.Create(ctx, awsAccount)
-	if err != nil && client.IgnoreAlreadyExists(err) != nil {
-		log.Log.Error(err, "Failed to create AwsAccount")
-		return err
+// copiedLabels and copiedAnnotations are the metadata of a User copied to its AwsAccount:
+// the Team, which maps the AwsAccount back to it, and the email of a Team member, which can
+// be tagged on the IAM user. Anything else could change how the AwsAccount is reconciled,
+// such as the take over and dry run annotations, and is left on the User.
+var (
+	copiedLabels      = []string{kuadrav1.TeamLabel}
+	copiedAnnotations = []string{kuadrav1.EmailAnnotation}
+)
+
+// copyKeys sets keys in to to their values in from, removing those from does not have, and
+// returns to. Other keys of to are kept.
+func copyKeys(to map[string]string, from map[string]string, keys []string) map[string]string {
+	for _, key := range keys {
+		value, found := from[key]
+		if !found {
+			delete(to, key)
+			continue
+		}
+		if to == nil {
+			to = map[string]string{}
+		}
+		to[key] = value
 	}
-	return nil
+	return to
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+	slice "github.com/Kuadrant/kuadra/pkg/_internal"
+)
+
+const (
+	// NamespaceTag and NameTag on an IAM user identify the AwsAccount it was created for
+	NamespaceTag = "kuadra.kuadrant.io/namespace"
+	NameTag      = "kuadra.kuadrant.io/name"
+	// ClusterTag on an IAM user holds the identity of the cluster managing it
+	ClusterTag = "kuadra.kuadrant.io/cluster"
+	// SuspendedTag on an IAM user marks it as suspended by the orphan collector, so that the
+	// AwsAccount taking it over later resumes it
+	SuspendedTag = "kuadra.kuadrant.io/suspended"
+
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+)
+
+// tagPattern matches the characters IAM allows in tag keys and values
+var tagPattern = regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+\-@]*$`)
+
+// validateTag checks a tag against the limits IAM puts on tag keys and values
+func validateTag(key string, value string) error {
+	switch {
+	case key == "" || len(key) > maxTagKeyLength:
+		return fmt.Errorf("tag key must be 1 to %d characters", maxTagKeyLength)
+	case strings.HasPrefix(strings.ToLower(key), "aws:"):
+		return fmt.Errorf("tag keys beginning with aws: are reserved")
+	case !tagPattern.MatchString(key):
+		return fmt.Errorf("tag key may only contain letters, numbers, spaces and _.:/=+-@")
+	case len(value) > maxTagValueLength:
+		return fmt.Errorf("tag value must be at most %d characters", maxTagValueLength)
+	case !tagPattern.MatchString(value):
+		return fmt.Errorf("tag value may only contain letters, numbers, spaces and _.:/=+-@")
+	}
+	return nil
+}
+
+// userPath returns the IAM path of the user, or "" when paths are not managed
+func (r *AwsAccountReconciler) userPath(awsAccount *kuadrav1.AwsAccount) string {
+	if r.UserPathPrefix == "" {
+		return ""
+	}
+	return r.UserPathPrefix + awsAccount.Namespace + "/"
+}
+
+// managedTagKeys are the tag keys the controller adds and removes; tags with other keys are left alone
+func (r *AwsAccountReconciler) managedTagKeys() []string {
+	keys := []string{NamespaceTag, NameTag}
+	if r.ClusterId != "" {
+		keys = append(keys, ClusterTag)
+	}
+	return append(keys, r.TagKeys...)
+}
+
+// userTags returns the tags of the user: the AwsAccount's namespace and name, the cluster
+// identity when set, plus the labels and annotations allowed by TagKeys. Labels win over
+// annotations with the same key, and values IAM would reject are skipped.
+func (r *AwsAccountReconciler) userTags(ctx context.Context, awsAccount *kuadrav1.AwsAccount) map[string]string {
+	tags := map[string]string{
+		NamespaceTag: awsAccount.Namespace,
+		NameTag:      awsAccount.Name,
+	}
+	if r.ClusterId != "" {
+		tags[ClusterTag] = r.ClusterId
+	}
+	for _, key := range r.TagKeys {
+		value, found := awsAccount.Labels[key]
+		if !found {
+			value, found = awsAccount.Annotations[key]
+		}
+		if !found {
+			continue
+		}
+		if err := validateTag(key, value); err != nil {
+			log.FromContext(ctx).Info("skipping tag, it is not valid in IAM", "key", key, "reason", err.Error())
+			continue
+		}
+		tags[key] = value
+	}
+	return tags
+}
+
+// reconcileUserMetadata moves the user to its path and brings its managed tags in line with the AwsAccount
+func (r *AwsAccountReconciler) reconcileUserMetadata(ctx context.Context, awsAccount *kuadrav1.AwsAccount) error {
+	log := log.FromContext(ctx)
+	userName := awsAccount.Spec.UserName
+
+	if path := r.userPath(awsAccount); path != "" && awsAccount.Status.Path != path {
+		if err := r.IamWrapper.UpdateUserPath(ctx, userName, path); err != nil {
+			return err
+		}
+		log.V(1).Info("updated user path", "path", path)
+		awsAccount.Status.Path = path
+	}
+
+	current, err := r.IamWrapper.ListUserTags(ctx, userName)
+	if err != nil {
+		return err
+	}
+	// An orphan suspended by the collector is resumed by reconcileSuspension like a suspended AwsAccount
+	if _, found := current[SuspendedTag]; found {
+		awsAccount.Status.Suspended = true
+	}
+	desired := r.userTags(ctx, awsAccount)
+
+	changed := map[string]string{}
+	for key, value := range desired {
+		if currentValue, found := current[key]; !found || currentValue != value {
+			changed[key] = value
+		}
+	}
+	if len(changed) > 0 {
+		if err := r.IamWrapper.TagUser(ctx, userName, changed); err != nil {
+			return err
+		}
+		log.V(1).Info("tagged user", "tags", sortedKeys(changed))
+	}
+
+	// Keys tagged before are removed too, in case they were dropped from TagKeys since
+	var removed []string
+	for _, key := range append(r.managedTagKeys(), awsAccount.Status.TagKeys...) {
+		_, found := current[key]
+		_, keep := desired[key]
+		if found && !keep && !slice.Contains(removed, key) {
+			removed = append(removed, key)
+		}
+	}
+	if len(removed) > 0 {
+		if err := r.IamWrapper.UntagUser(ctx, userName, removed); err != nil {
+			return err
+		}
+		log.V(1).Info("untagged user", "tagKeys", removed)
+	}
+	awsAccount.Status.TagKeys = sortedKeys(desired)
+	return nil
+}
@@ -0,0 +1,281 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"sigs.k8s.io/yaml"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+)
+
+const (
+	// UserConfigLabel set to "true" on a ConfigMap makes it a source of Users
+	UserConfigLabel = "kuadra.kuadrant.io/users"
+	// ConfigMapLabel on a User names the ConfigMap it was generated from
+	ConfigMapLabel = "kuadra.kuadrant.io/configmap"
+	// PruneAnnotation set to "true" on a user ConfigMap deletes the Users of entries removed
+	// from it, and all of its Users when it is deleted
+	PruneAnnotation = "kuadra.kuadrant.io/prune"
+)
+
+// UserConfig is the schema of each data key of a ConfigMap labelled with UserConfigLabel,
+// written as YAML or JSON
+type UserConfig struct {
+	Users []UserConfigEntry `json:"users"`
+}
+
+// UserConfigEntry describes one User. Next to its name and email it takes the fields of an
+// AwsAccount spec, userName defaulting to the name of the entry.
+type UserConfigEntry struct {
+	Name                    string `json:"name"`
+	Email                   string `json:"email,omitempty"`
+	kuadrav1.AwsAccountSpec `json:",inline"`
+}
+
+// ParseUserConfig reads a user list, rejecting unknown fields so that typos are not ignored
+func ParseUserConfig(data []byte) (UserConfig, error) {
+	config := UserConfig{}
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return config, err
+	}
+	for i := range config.Users {
+		if config.Users[i].UserName == "" {
+			config.Users[i].UserName = config.Users[i].Name
+		}
+	}
+	return config, nil
+}
+
+// ValidateUserConfigEntry checks an entry against the rules the AwsAccount webhook and the
+// controllers apply to the User and AwsAccount it becomes. Entries cannot choose their own
+// permissions boundary, whether or not the webhook allows AwsAccounts to.
+func ValidateUserConfigEntry(path *field.Path, entry UserConfigEntry) field.ErrorList {
+	var errs field.ErrorList
+	if entry.Name == "" {
+		errs = append(errs, field.Required(path.Child("name"), "every user needs a name"))
+	} else {
+		for _, msg := range validation.IsDNS1123Subdomain(entry.Name) {
+			errs = append(errs, field.Invalid(path.Child("name"), entry.Name, msg))
+		}
+	}
+	if entry.UserName != "" && entry.UserName != entry.Name {
+		// The name of the User is also the name of its AwsAccount and namespace
+		for _, msg := range validation.IsDNS1123Label(entry.UserName) {
+			errs = append(errs, field.Invalid(path.Child("userName"), entry.UserName, msg))
+		}
+	} else if entry.Name != "" {
+		for _, msg := range validation.IsDNS1123Label(entry.Name) {
+			errs = append(errs, field.Invalid(path.Child("name"), entry.Name, "the name is also the user name: "+msg))
+		}
+	}
+	awsAccount := &kuadrav1.AwsAccount{ObjectMeta: metav1.ObjectMeta{Name: entry.UserName}, Spec: entry.AwsAccountSpec}
+	if err := (&kuadrav1.AwsAccountValidator{}).ValidateCreate(context.Background(), awsAccount); err != nil {
+		errs = append(errs, field.Forbidden(path.Child("permissionsBoundary"), err.Error()))
+	}
+	return errs
+}
+
+// validateUserConfig validates every entry and rejects names used twice. Anyone who can
+// edit a ConfigMap in the namespace can write the entries, so they can only be given access
+// through groupRefs to the AwsGroups of the namespace, not with IAM groups or policies.
+func validateUserConfig(path *field.Path, config UserConfig, seen map[string]bool) field.ErrorList {
+	var errs field.ErrorList
+	for i, entry := range config.Users {
+		entryPath := path.Child("users").Index(i)
+		errs = append(errs, ValidateUserConfigEntry(entryPath, entry)...)
+		if entry.Groups != nil {
+			errs = append(errs, field.Forbidden(entryPath.Child("groups"), "use groupRefs to AwsGroups instead"))
+		}
+		if entry.ManagedPolicyArns != nil {
+			errs = append(errs, field.Forbidden(entryPath.Child("managedPolicyArns"), "use groupRefs to AwsGroups instead"))
+		}
+		if entry.InlinePolicies != nil {
+			errs = append(errs, field.Forbidden(entryPath.Child("inlinePolicies"), "use groupRefs to AwsGroups instead"))
+		}
+		if entry.Name == "" {
+			continue
+		}
+		if seen[entry.Name] {
+			errs = append(errs, field.Duplicate(entryPath.Child("name"), entry.Name))
+		}
+		seen[entry.Name] = true
+	}
+	return errs
+}
+
+// UserConfigMapReconciler generates Users from ConfigMaps labelled with UserConfigLabel
+type UserConfigMapReconciler struct {
+	client.Client
+	// APIReader reads ConfigMaps, which are only cached as metadata. The Client is used when it is nil.
+	APIReader client.Reader
+	Scheme    *runtime.Scheme
+	Recorder  record.EventRecorder
+	// Namespaces are the namespaces whose ConfigMaps are read, those elsewhere are ignored
+	Namespaces []string
+	// DryRun logs the changes to the Users of every ConfigMap without making them, as
+	// DryRunAnnotation does for one
+	DryRun bool
+}
+
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch
+//+kubebuilder:rbac:groups=kuadra.kuadrant.io,resources=users,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+// Reconcile parses the user lists of a ConfigMap and creates and updates its Users to match,
+// deleting those of removed entries when the ConfigMap has PruneAnnotation. A ConfigMap that
+// does not parse or validate leaves its Users as they are and gets a warning event saying why.
+func (r *UserConfigMapReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	if !r.readsNamespace(req.Namespace) {
+		return ctrl.Result{}, nil
+	}
+	var configMap corev1.ConfigMap
+	if err := r.reader().Get(ctx, req.NamespacedName, &configMap); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if configMap.Labels[UserConfigLabel] != "true" {
+		// Users of a ConfigMap that stops being a source stay until it is deleted
+		return ctrl.Result{}, nil
+	}
+
+	users, err := usersFromConfigMap(&configMap)
+	if err != nil {
+		log.Info("invalid user configuration", "error", err.Error())
+		r.event(&configMap, corev1.EventTypeWarning, "InvalidUserConfig", err.Error())
+		return ctrl.Result{}, nil
+	}
+
+	c := r.Client
+	if isDryRun(r.DryRun, &configMap) {
+		p := &plan{}
+		c = newDryRunClient(r.Client, p, &configMap)
+		defer func() { log.Info("dry run, changes not made", "plannedActions", p.actions) }()
+	}
+
+	prune := configMap.Annotations[PruneAnnotation] == "true"
+	result, err := syncUsers(ctx, c, r.Scheme, &configMap, ConfigMapLabel, users, prune, 0)
+	if err != nil {
+		log.Error(err, "unable to sync Users of ConfigMap")
+		return ctrl.Result{}, err
+	}
+	if len(result.Conflicts) > 0 {
+		r.event(&configMap, corev1.EventTypeWarning, "UserConflict",
+			fmt.Sprintf("Users %s already exist and were not generated from this ConfigMap", strings.Join(result.Conflicts, ", ")))
+	}
+	if result.Applied > 0 || result.Deleted > 0 {
+		r.event(&configMap, corev1.EventTypeNormal, "UsersSynced",
+			fmt.Sprintf("Applied %d and deleted %d Users", result.Applied, result.Deleted))
+	}
+	return ctrl.Result{}, nil
+}
+
+// usersFromConfigMap parses and validates every data key of the ConfigMap, in key order
+func usersFromConfigMap(configMap *corev1.ConfigMap) ([]sourceUser, error) {
+	keys := make([]string, 0, len(configMap.Data))
+	for key := range configMap.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var users []sourceUser
+	var errs field.ErrorList
+	seen := map[string]bool{}
+	for _, key := range keys {
+		path := field.NewPath("data").Key(key)
+		config, err := ParseUserConfig([]byte(configMap.Data[key]))
+		if err != nil {
+			errs = append(errs, field.Invalid(path, "", err.Error()))
+			continue
+		}
+		errs = append(errs, validateUserConfig(path, config, seen)...)
+		for _, entry := range config.Users {
+			users = append(users, sourceUser{Name: entry.Name, Email: entry.Email, Spec: entry.AwsAccountSpec})
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs.ToAggregate()
+	}
+	return users, nil
+}
+
+func (r *UserConfigMapReconciler) event(configMap *corev1.ConfigMap, eventType string, reason string, message string) {
+	if r.Recorder != nil {
+		r.Recorder.Event(configMap, eventType, reason, message)
+	}
+}
+
+// reader returns the reader ConfigMaps are read with
+func (r *UserConfigMapReconciler) reader() client.Reader {
+	if r.APIReader != nil {
+		return r.APIReader
+	}
+	return r.Client
+}
+
+// readsNamespace reports whether the ConfigMaps of namespace are a source of Users
+func (r *UserConfigMapReconciler) readsNamespace(namespace string) bool {
+	for _, allowed := range r.Namespaces {
+		if allowed == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// configMapForUser maps a User to the ConfigMap it was generated from, which also covers
+// Users the ConfigMap does not control
+func (r *UserConfigMapReconciler) configMapForUser(obj client.Object) []reconcile.Request {
+	name, found := obj.GetLabels()[ConfigMapLabel]
+	if !found {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: name, Namespace: obj.GetNamespace()}}}
+}
+
+// SetupWithManager sets up the controller with the Manager. ConfigMaps are watched as
+// metadata only, so that their data is not cached.
+func (r *UserConfigMapReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	isSource := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetLabels()[UserConfigLabel] == "true" && r.readsNamespace(obj.GetNamespace())
+	})
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("userconfigmap").
+		For(&corev1.ConfigMap{}, builder.OnlyMetadata, builder.WithPredicates(isSource)).
+		Watches(&source.Kind{Type: &kuadrav1.User{}}, handler.EnqueueRequestsFromMapFunc(r.configMapForUser)).
+		Complete(r)
+}
@@ -0,0 +1,227 @@
+package controller
+
+import (
+	"context"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8Types "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var _ = Describe("UserConfigMap controller", func() {
+
+	const (
+		ConfigMapName      = "dns-users"
+		ConfigMapNamespace = "default"
+	)
+
+	ctx := context.Background()
+
+	configMapLookupKey := k8Types.NamespacedName{Name: ConfigMapName, Namespace: ConfigMapNamespace}
+
+	Context("When reconciling a labelled ConfigMap", func() {
+		It("Should generate, update and prune Users from its user list", func() {
+			req := reconcile.Request{NamespacedName: configMapLookupKey}
+			client := fake.NewClientBuilder().Build()
+			recorder := record.NewFakeRecorder(10)
+			configMap := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        ConfigMapName,
+					Namespace:   ConfigMapNamespace,
+					Labels:      map[string]string{UserConfigLabel: "true"},
+					Annotations: map[string]string{PruneAnnotation: "true"},
+				},
+				Data: map[string]string{
+					"users.yaml": `
+users:
+  - name: alice
+    email: alice@example.com
+    groupRefs:
+      - dns-management
+  - name: bob
+    userName: bob-dns
+`,
+				},
+			}
+			Expect(client.Create(ctx, configMap)).Should(Succeed())
+
+			r := &UserConfigMapReconciler{Client: client, Scheme: scheme.Scheme, Recorder: recorder, Namespaces: []string{ConfigMapNamespace}}
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+
+			By("By creating a User for every entry")
+			alice := &kuadrav1.User{}
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: "alice", Namespace: ConfigMapNamespace}, alice)).Should(Succeed())
+			Expect(alice.Labels[ConfigMapLabel]).Should(Equal(ConfigMapName))
+			Expect(alice.Annotations[kuadrav1.EmailAnnotation]).Should(Equal("alice@example.com"))
+			Expect(alice.OwnerReferences).Should(HaveLen(1))
+			Expect(alice.OwnerReferences[0].Kind).Should(Equal("ConfigMap"))
+			Expect(alice.Spec.AwsAccount.Spec.User).Should(Equal(kuadrav1.AwsAccountSpec{
+				UserName:  "alice",
+				GroupRefs: []string{"dns-management"},
+			}))
+			bob := &kuadrav1.User{}
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: "bob", Namespace: ConfigMapNamespace}, bob)).Should(Succeed())
+			Expect(bob.Spec.AwsAccount.Spec.User.UserName).Should(Equal("bob-dns"))
+			Expect(recorder.Events).Should(Receive(Equal("Normal UsersSynced Applied 2 and deleted 0 Users")))
+
+			By("By leaving the Users alone when the ConfigMap is invalid")
+			Expect(client.Get(ctx, configMapLookupKey, configMap)).Should(Succeed())
+			configMap.Data["users.yaml"] = `
+users:
+  - name: alice
+    group: dns-management
+`
+			Expect(client.Update(ctx, configMap)).Should(Succeed())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			var event string
+			Expect(recorder.Events).Should(Receive(&event))
+			Expect(event).Should(ContainSubstring("Warning InvalidUserConfig"))
+			Expect(event).Should(ContainSubstring(`unknown field "group"`))
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: "bob", Namespace: ConfigMapNamespace}, bob)).Should(Succeed())
+
+			By("By reporting duplicate names")
+			configMap.Data["users.yaml"] = `
+users:
+  - name: alice
+  - name: alice
+`
+			Expect(client.Update(ctx, configMap)).Should(Succeed())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(recorder.Events).Should(Receive(&event))
+			Expect(event).Should(ContainSubstring(`data[users.yaml].users[1].name: Duplicate value: "alice"`))
+
+			By("By deleting the Users of removed entries")
+			configMap.Data["users.yaml"] = `{"users": [{"name": "alice", "email": "alice@example.com"}]}`
+			Expect(client.Update(ctx, configMap)).Should(Succeed())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: "alice", Namespace: ConfigMapNamespace}, alice)).Should(Succeed())
+			Expect(alice.Spec.AwsAccount.Spec.User.GroupRefs).Should(BeEmpty())
+			err = client.Get(ctx, k8Types.NamespacedName{Name: "bob", Namespace: ConfigMapNamespace}, bob)
+			Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+			Expect(recorder.Events).Should(Receive(Equal("Normal UsersSynced Applied 1 and deleted 1 Users")))
+		})
+
+		It("Should not take over Users it did not generate", func() {
+			req := reconcile.Request{NamespacedName: configMapLookupKey}
+			client := fake.NewClientBuilder().Build()
+			recorder := record.NewFakeRecorder(10)
+			Expect(client.Create(ctx, &kuadrav1.User{
+				ObjectMeta: metav1.ObjectMeta{Name: "alice", Namespace: ConfigMapNamespace},
+			})).Should(Succeed())
+			Expect(client.Create(ctx, &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      ConfigMapName,
+					Namespace: ConfigMapNamespace,
+					Labels:    map[string]string{UserConfigLabel: "true"},
+				},
+				Data: map[string]string{"users.yaml": "users:\n  - name: alice\n"},
+			})).Should(Succeed())
+
+			r := &UserConfigMapReconciler{Client: client, Scheme: scheme.Scheme, Recorder: recorder, Namespaces: []string{ConfigMapNamespace}}
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+
+			alice := &kuadrav1.User{}
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: "alice", Namespace: ConfigMapNamespace}, alice)).Should(Succeed())
+			Expect(alice.OwnerReferences).Should(BeEmpty())
+			Expect(alice.Spec.AwsAccount).Should(BeNil())
+			Expect(recorder.Events).Should(Receive(ContainSubstring("Warning UserConflict Users alice already exist")))
+		})
+
+		It("Should keep the Users of removed entries unless pruning is asked for", func() {
+			req := reconcile.Request{NamespacedName: configMapLookupKey}
+			client := fake.NewClientBuilder().Build()
+			recorder := record.NewFakeRecorder(10)
+			configMap := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      ConfigMapName,
+					Namespace: ConfigMapNamespace,
+					Labels:    map[string]string{UserConfigLabel: "true"},
+				},
+				Data: map[string]string{"users.yaml": "users:\n  - name: alice\n  - name: bob\n"},
+			}
+			Expect(client.Create(ctx, configMap)).Should(Succeed())
+
+			r := &UserConfigMapReconciler{Client: client, Scheme: scheme.Scheme, Recorder: recorder, Namespaces: []string{ConfigMapNamespace}}
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+
+			By("By not making the ConfigMap the owner of its Users")
+			bob := &kuadrav1.User{}
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: "bob", Namespace: ConfigMapNamespace}, bob)).Should(Succeed())
+			Expect(bob.OwnerReferences).Should(BeEmpty())
+			Expect(bob.Labels[ConfigMapLabel]).Should(Equal(ConfigMapName))
+
+			By("By keeping the User of a removed entry")
+			configMap.Data["users.yaml"] = "users:\n  - name: alice\n"
+			Expect(client.Update(ctx, configMap)).Should(Succeed())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: "bob", Namespace: ConfigMapNamespace}, bob)).Should(Succeed())
+
+			By("By deleting it once pruning is asked for")
+			configMap.Annotations = map[string]string{PruneAnnotation: "true"}
+			Expect(client.Update(ctx, configMap)).Should(Succeed())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			err = client.Get(ctx, k8Types.NamespacedName{Name: "bob", Namespace: ConfigMapNamespace}, bob)
+			Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+			alice := &kuadrav1.User{}
+			Expect(client.Get(ctx, k8Types.NamespacedName{Name: "alice", Namespace: ConfigMapNamespace}, alice)).Should(Succeed())
+			Expect(alice.OwnerReferences).Should(HaveLen(1))
+		})
+
+		It("Should not let ConfigMaps grant IAM groups or policies", func() {
+			req := reconcile.Request{NamespacedName: configMapLookupKey}
+			client := fake.NewClientBuilder().Build()
+			recorder := record.NewFakeRecorder(10)
+			Expect(client.Create(ctx, &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      ConfigMapName,
+					Namespace: ConfigMapNamespace,
+					Labels:    map[string]string{UserConfigLabel: "true"},
+				},
+				Data: map[string]string{"users.yaml": `
+users:
+  - name: alice
+    groups: [admins]
+    managedPolicyArns: ["arn:aws:iam::aws:policy/AdministratorAccess"]
+    inlinePolicies:
+      all:
+        document: '{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"*","Resource":"*"}]}'
+`},
+			})).Should(Succeed())
+
+			By("By ignoring ConfigMaps outside the allowed namespaces")
+			r := &UserConfigMapReconciler{Client: client, Scheme: scheme.Scheme, Recorder: recorder, Namespaces: []string{"kuadra-users"}}
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			Expect(recorder.Events).ShouldNot(Receive())
+
+			By("By rejecting the entry")
+			r.Namespaces = []string{ConfigMapNamespace}
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).Should(BeNil())
+			var event string
+			Expect(recorder.Events).Should(Receive(&event))
+			Expect(event).Should(ContainSubstring("users[0].groups: Forbidden"))
+			Expect(event).Should(ContainSubstring("users[0].managedPolicyArns: Forbidden"))
+			Expect(event).Should(ContainSubstring("users[0].inlinePolicies: Forbidden"))
+			err = client.Get(ctx, k8Types.NamespacedName{Name: "alice", Namespace: ConfigMapNamespace}, &kuadrav1.User{})
+			Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+		})
+	})
+})
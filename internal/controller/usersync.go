@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+)
+
+// sourceUser is a User described by a source of user configuration other than a User manifest
+type sourceUser struct {
+	Name  string
+	Email string
+	Spec  kuadrav1.AwsAccountSpec
+	// Invalid entries are still in the source but cannot become a User, so their User, if
+	// any, is left as it is rather than deleted
+	Invalid bool
+}
+
+// userSyncResult counts what syncUsers changed
+type userSyncResult struct {
+	// Applied is the number of Users created or updated
+	Applied int
+	// Deleted is the number of Users removed from the source and deleted
+	Deleted int
+	// PruneRefused is the number of Users removed from the source and kept because deleting
+	// them would have exceeded maxPrunePercent
+	PruneRefused int
+	// Conflicts name Users of the source that exist but belong to something else
+	Conflicts []string
+}
+
+// syncUsers makes the Users in the namespace of source that carry sourceLabel with its name
+// match users. A User is created or updated for each entry. With prune, the Users are
+// controlled by source, so they are deleted with it, and the Users of entries no longer there
+// are deleted, which removes their IAM users. When maxPrunePercent is above zero none are
+// deleted if that would be more than maxPrunePercent of the Users of source, or all of them.
+// Without prune, Users only carry the label and stay when their entry or source goes. A User
+// of the same name that source did not generate is left alone and reported as a conflict.
+func syncUsers(ctx context.Context, c client.Client, scheme *runtime.Scheme, source client.Object, sourceLabel string, users []sourceUser, prune bool, maxPrunePercent int) (userSyncResult, error) {
+	log := log.FromContext(ctx)
+	result := userSyncResult{}
+
+	wanted := map[string]bool{}
+	created := 0
+	for _, desired := range users {
+		wanted[desired.Name] = true
+		if desired.Invalid {
+			continue
+		}
+
+		existing := &kuadrav1.User{}
+		err := c.Get(ctx, types.NamespacedName{Name: desired.Name, Namespace: source.GetNamespace()}, existing)
+		if client.IgnoreNotFound(err) != nil {
+			return result, err
+		}
+		if err == nil && !generatedFrom(existing, source, sourceLabel) {
+			result.Conflicts = append(result.Conflicts, desired.Name)
+			continue
+		}
+
+		user := &kuadrav1.User{ObjectMeta: metav1.ObjectMeta{Name: desired.Name, Namespace: source.GetNamespace()}}
+		op, err := controllerutil.CreateOrUpdate(ctx, c, user, func() error {
+			if user.Labels == nil {
+				user.Labels = map[string]string{}
+			}
+			user.Labels[sourceLabel] = source.GetName()
+			if desired.Email != "" {
+				if user.Annotations == nil {
+					user.Annotations = map[string]string{}
+				}
+				user.Annotations[kuadrav1.EmailAnnotation] = desired.Email
+			} else {
+				delete(user.Annotations, kuadrav1.EmailAnnotation)
+			}
+			user.Spec.AwsAccount = &kuadrav1.AwsAccountNestedSpec{
+				Spec: kuadrav1.AwsSpec{User: *desired.Spec.DeepCopy()},
+			}
+			if prune {
+				return controllerutil.SetControllerReference(source, user, scheme)
+			}
+			var owners []metav1.OwnerReference
+			for _, owner := range user.OwnerReferences {
+				if owner.UID != source.GetUID() {
+					owners = append(owners, owner)
+				}
+			}
+			user.OwnerReferences = owners
+			return nil
+		})
+		if err != nil {
+			return result, fmt.Errorf("unable to apply User %s: %w", desired.Name, err)
+		}
+		if op != controllerutil.OperationResultNone {
+			log.Info("applied User", "user", desired.Name, "result", op)
+			result.Applied++
+		}
+		if op == controllerutil.OperationResultCreated {
+			created++
+		}
+	}
+
+	var existing kuadrav1.UserList
+	if err := c.List(ctx, &existing, client.InNamespace(source.GetNamespace()), client.MatchingLabels{sourceLabel: source.GetName()}); err != nil {
+		return result, err
+	}
+	generated := 0
+	var removed []*kuadrav1.User
+	for i := range existing.Items {
+		user := &existing.Items[i]
+		if !generatedFrom(user, source, sourceLabel) {
+			continue
+		}
+		generated++
+		if !wanted[user.Name] {
+			removed = append(removed, user)
+		}
+	}
+	if !prune {
+		for _, user := range removed {
+			log.V(1).Info("keeping User removed from its source", "user", user.Name)
+		}
+		return result, nil
+	}
+	// The Users created by this sync were not there to be deleted
+	if previous := generated - created; maxPrunePercent > 0 && len(removed) > 0 &&
+		(len(removed) == previous || len(removed)*100 > maxPrunePercent*previous) {
+		log.Info("refusing to delete Users removed from their source", "removed", len(removed), "users", previous, "maxPrunePercent", maxPrunePercent)
+		result.PruneRefused = len(removed)
+		return result, nil
+	}
+	for _, user := range removed {
+		if err := c.Delete(ctx, user); client.IgnoreNotFound(err) != nil {
+			return result, err
+		}
+		log.Info("deleted User removed from its source", "user", user.Name)
+		result.Deleted++
+	}
+	return result, nil
+}
+
+// generatedFrom reports whether syncUsers generated the User from source: it is controlled by
+// source, or carries the label of source and no other controller
+func generatedFrom(user *kuadrav1.User, source client.Object, sourceLabel string) bool {
+	if metav1.IsControlledBy(user, source) {
+		return true
+	}
+	return metav1.GetControllerOf(user) == nil && user.Labels[sourceLabel] == source.GetName()
+}
@@ -0,0 +1,111 @@
+package scim
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// filterPattern matches the filters identity providers send to find a resource before
+// creating it, such as userName eq "alice@example.com"
+var filterPattern = regexp.MustCompile(`^\s*([A-Za-z][\w.]*)\s+(?i:eq)\s+"((?:[^"\\]|\\.)*)"\s*$`)
+
+// filter is an equality filter on one attribute
+type filter struct {
+	attribute string
+	value     string
+}
+
+// parseFilter parses the filter query parameter. Only "attribute eq value" is supported.
+func parseFilter(expression string) (*filter, error) {
+	if strings.TrimSpace(expression) == "" {
+		return nil, nil
+	}
+	match := filterPattern.FindStringSubmatch(expression)
+	if match == nil {
+		return nil, badRequest("invalidFilter", "unsupported filter %q, only attribute eq \"value\" is supported", expression)
+	}
+	var value string
+	if err := json.Unmarshal([]byte(`"`+match[2]+`"`), &value); err != nil {
+		return nil, badRequest("invalidFilter", "invalid value in filter %q", expression)
+	}
+	return &filter{attribute: match[1], value: value}, nil
+}
+
+// matches compares the filtered attribute of a resource, given by attributes, case
+// insensitively as SCIM does for userName and displayName
+func (f *filter) matches(attributes map[string][]string) (bool, error) {
+	if f == nil {
+		return true, nil
+	}
+	for name, values := range attributes {
+		if !strings.EqualFold(name, f.attribute) {
+			continue
+		}
+		for _, value := range values {
+			if strings.EqualFold(value, f.value) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, badRequest("invalidFilter", "filtering on %s is not supported", f.attribute)
+}
+
+// patchRequest is the body of a PATCH request, see RFC 7644 section 3.5.2
+type patchRequest struct {
+	Operations []patchOperation `json:"Operations"`
+}
+
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+func parsePatch(data []byte) ([]patchOperation, error) {
+	request := patchRequest{}
+	if err := json.Unmarshal(data, &request); err != nil {
+		return nil, badRequest("invalidSyntax", "unable to parse the patch: %s", err)
+	}
+	for i, operation := range request.Operations {
+		op := strings.ToLower(operation.Op)
+		if op != "add" && op != "replace" && op != "remove" {
+			return nil, badRequest("invalidSyntax", "unsupported patch operation %q", operation.Op)
+		}
+		request.Operations[i].Op = op
+	}
+	return request.Operations, nil
+}
+
+// valueFilterPattern matches paths selecting values of a multi-valued attribute, such as
+// members[value eq "alice"]
+var valueFilterPattern = regexp.MustCompile(`^([A-Za-z]\w*)\[(.*)\](?:\.(\w+))?$`)
+
+// splitPath splits a patch path into its attribute, the filter selecting values of the
+// attribute if any, and the sub-attribute
+func splitPath(path string) (string, *filter, string, error) {
+	match := valueFilterPattern.FindStringSubmatch(path)
+	if match == nil {
+		attribute, subAttribute, _ := strings.Cut(path, ".")
+		return attribute, nil, subAttribute, nil
+	}
+	f, err := parseFilter(match[2])
+	if err != nil {
+		return "", nil, "", badRequest("invalidPath", "unsupported path %q", path)
+	}
+	return match[1], f, match[3], nil
+}
+
+// patchValue decodes an operation's value. An operation without a path holds an object
+// of attributes, which are applied one by one.
+func patchValue(operation patchOperation) (map[string]json.RawMessage, error) {
+	if operation.Path != "" {
+		return map[string]json.RawMessage{operation.Path: operation.Value}, nil
+	}
+	values := map[string]json.RawMessage{}
+	if err := json.Unmarshal(operation.Value, &values); err != nil {
+		return nil, badRequest("invalidValue", "a patch operation without a path needs an object value")
+	}
+	return values, nil
+}
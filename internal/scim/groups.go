@@ -0,0 +1,404 @@
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+)
+
+// Group is the SCIM representation of an AwsGroup. Its members are the SCIM users whose
+// User references the AwsGroup in spec.groupRefs.
+type Group struct {
+	Schemas     []string    `json:"schemas"`
+	Id          string      `json:"id,omitempty"`
+	ExternalId  string      `json:"externalId,omitempty"`
+	DisplayName string      `json:"displayName"`
+	Members     []Reference `json:"members,omitempty"`
+	Meta        *meta       `json:"meta,omitempty"`
+}
+
+func (s *Server) toGroup(awsGroup *kuadrav1.AwsGroup, users []kuadrav1.User) *Group {
+	group := &Group{
+		Schemas:     []string{groupSchema},
+		Id:          awsGroup.Name,
+		ExternalId:  awsGroup.Annotations[ExternalIdAnnotation],
+		DisplayName: awsGroup.Annotations[DisplayNameAnnotation],
+		Meta: &meta{
+			ResourceType: "Group",
+			Created:      awsGroup.CreationTimestamp.UTC().Format(time.RFC3339),
+			Location:     BasePath + "/Groups/" + awsGroup.Name,
+			Version:      `W/"` + awsGroup.ResourceVersion + `"`,
+		},
+	}
+	if group.DisplayName == "" {
+		group.DisplayName = awsGroup.Name
+	}
+	for _, name := range members(awsGroup.Name, users) {
+		group.Members = append(group.Members, Reference{Value: name, Ref: BasePath + "/Users/" + name})
+	}
+	return group
+}
+
+// members are the names of the Users that reference the group, in order
+func members(group string, users []kuadrav1.User) []string {
+	var names []string
+	for _, user := range users {
+		if hasGroupRef(&user, group) {
+			names = append(names, user.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func hasGroupRef(user *kuadrav1.User, group string) bool {
+	if user.Spec.AwsAccount == nil {
+		return false
+	}
+	for _, ref := range user.Spec.AwsAccount.Spec.User.GroupRefs {
+		if ref == group {
+			return true
+		}
+	}
+	return false
+}
+
+// listGroups lists the AwsGroups created through SCIM. Those made by the cluster
+// administrator are not offered to identity providers, which could otherwise add members
+// to groups with any policies.
+func (s *Server) listGroups(ctx context.Context, f *filter) ([]interface{}, error) {
+	var awsGroups kuadrav1.AwsGroupList
+	if err := s.Client.List(ctx, &awsGroups, client.InNamespace(s.Namespace), client.MatchingLabels{ManagedLabel: "true"}); err != nil {
+		return nil, err
+	}
+	users, err := s.scimUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resources := []interface{}{}
+	for i := range awsGroups.Items {
+		group := s.toGroup(&awsGroups.Items[i], users)
+		match, err := f.matches(map[string][]string{
+			"id":          {group.Id},
+			"displayName": {group.DisplayName},
+			"externalId":  {group.ExternalId},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			resources = append(resources, group)
+		}
+	}
+	return resources, nil
+}
+
+func (s *Server) getAwsGroup(ctx context.Context, id string) (*kuadrav1.AwsGroup, error) {
+	awsGroup := &kuadrav1.AwsGroup{}
+	err := s.Client.Get(ctx, types.NamespacedName{Name: id, Namespace: s.Namespace}, awsGroup)
+	if apierrors.IsNotFound(err) || (err == nil && awsGroup.Labels[ManagedLabel] != "true") {
+		return nil, notFound("Group", id)
+	}
+	return awsGroup, err
+}
+
+func (s *Server) getGroup(ctx context.Context, id string) (interface{}, error) {
+	awsGroup, err := s.getAwsGroup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	users, err := s.scimUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.toGroup(awsGroup, users), nil
+}
+
+func decodeGroup(data []byte) (*Group, error) {
+	group := &Group{}
+	if err := json.Unmarshal(data, group); err != nil {
+		return nil, badRequest("invalidValue", "unable to read the group: %s", err)
+	}
+	if group.DisplayName == "" {
+		return nil, badRequest("invalidValue", "displayName is required")
+	}
+	return group, nil
+}
+
+// createGroup creates an AwsGroup, which creates an IAM group without policies. The policies
+// are given to the AwsGroup by the cluster administrator.
+func (s *Server) createGroup(ctx context.Context, data []byte) (interface{}, error) {
+	group, err := decodeGroup(data)
+	if err != nil {
+		return nil, err
+	}
+	name, err := resourceName(group.DisplayName)
+	if err != nil {
+		return nil, err
+	}
+	users, err := s.scimUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	memberNames, err := memberIds(group.Members, users)
+	if err != nil {
+		return nil, err
+	}
+
+	awsGroup := &kuadrav1.AwsGroup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: s.Namespace,
+			Labels:    map[string]string{ManagedLabel: "true"},
+		},
+	}
+	setGroupAnnotations(awsGroup, group)
+	if err := s.Client.Create(ctx, awsGroup); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			return nil, conflict("displayName %s is taken, AwsGroup %s already exists", group.DisplayName, name)
+		}
+		return nil, err
+	}
+	log.FromContext(ctx).Info("created AwsGroup", "awsGroup", name, "displayName", group.DisplayName)
+	return s.saveMembers(ctx, awsGroup, users, memberNames)
+}
+
+func setGroupAnnotations(awsGroup *kuadrav1.AwsGroup, group *Group) {
+	if awsGroup.Annotations == nil {
+		awsGroup.Annotations = map[string]string{}
+	}
+	for key, value := range map[string]string{ExternalIdAnnotation: group.ExternalId, DisplayNameAnnotation: group.DisplayName} {
+		if value == "" {
+			delete(awsGroup.Annotations, key)
+		} else {
+			awsGroup.Annotations[key] = value
+		}
+	}
+}
+
+// memberIds checks that the members are SCIM users and returns their names
+func memberIds(references []Reference, users []kuadrav1.User) ([]string, error) {
+	known := map[string]bool{}
+	for _, user := range users {
+		known[user.Name] = true
+	}
+	var names []string
+	for _, reference := range references {
+		if !known[reference.Value] {
+			return nil, badRequest("invalidValue", "member %s is not a SCIM user", reference.Value)
+		}
+		names = append(names, reference.Value)
+	}
+	return names, nil
+}
+
+func (s *Server) replaceGroup(ctx context.Context, id string, data []byte) (interface{}, error) {
+	awsGroup, err := s.getAwsGroup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	group, err := decodeGroup(data)
+	if err != nil {
+		return nil, err
+	}
+	users, err := s.scimUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	memberNames, err := memberIds(group.Members, users)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.updateGroupAnnotations(ctx, awsGroup, group); err != nil {
+		return nil, err
+	}
+	return s.saveMembers(ctx, awsGroup, users, memberNames)
+}
+
+func (s *Server) patchGroup(ctx context.Context, id string, data []byte) (interface{}, error) {
+	awsGroup, err := s.getAwsGroup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	operations, err := parsePatch(data)
+	if err != nil {
+		return nil, err
+	}
+	users, err := s.scimUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	group := s.toGroup(awsGroup, users)
+	memberSet := map[string]bool{}
+	for _, member := range group.Members {
+		memberSet[member.Value] = true
+	}
+	for _, operation := range operations {
+		if err := patchGroupAttributes(group, memberSet, users, operation); err != nil {
+			return nil, err
+		}
+	}
+	if group.DisplayName == "" {
+		return nil, badRequest("mutability", "displayName cannot be removed")
+	}
+
+	if err := s.updateGroupAnnotations(ctx, awsGroup, group); err != nil {
+		return nil, err
+	}
+	var memberNames []string
+	for name := range memberSet {
+		memberNames = append(memberNames, name)
+	}
+	return s.saveMembers(ctx, awsGroup, users, memberNames)
+}
+
+// patchGroupAttributes applies an operation to the display name, external id and members
+// of a group
+func patchGroupAttributes(group *Group, memberSet map[string]bool, users []kuadrav1.User, operation patchOperation) error {
+	values, err := patchValue(operation)
+	if err != nil {
+		return err
+	}
+	for path, value := range values {
+		attribute, valueFilter, _, err := splitPath(path)
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(attribute) {
+		case "displayname", "externalid":
+			target := &group.DisplayName
+			if strings.ToLower(attribute) == "externalid" {
+				target = &group.ExternalId
+			}
+			if operation.Op == "remove" {
+				*target = ""
+			} else if err := json.Unmarshal(value, target); err != nil {
+				return badRequest("invalidValue", "%s needs a string value", path)
+			}
+		case "members":
+			var references []Reference
+			if len(value) > 0 {
+				if err := json.Unmarshal(value, &references); err != nil {
+					return badRequest("invalidValue", "members needs a list of members")
+				}
+			}
+			if valueFilter != nil {
+				if !strings.EqualFold(valueFilter.attribute, "value") {
+					return badRequest("invalidPath", "unsupported path %q", path)
+				}
+				references = append(references, Reference{Value: valueFilter.value})
+			}
+			switch operation.Op {
+			case "replace":
+				for name := range memberSet {
+					delete(memberSet, name)
+				}
+				fallthrough
+			case "add":
+				names, err := memberIds(references, users)
+				if err != nil {
+					return err
+				}
+				for _, name := range names {
+					memberSet[name] = true
+				}
+			case "remove":
+				if len(references) == 0 {
+					for name := range memberSet {
+						delete(memberSet, name)
+					}
+				}
+				for _, reference := range references {
+					delete(memberSet, reference.Value)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Server) updateGroupAnnotations(ctx context.Context, awsGroup *kuadrav1.AwsGroup, group *Group) error {
+	before := awsGroup.DeepCopy()
+	setGroupAnnotations(awsGroup, group)
+	if equalAnnotations(before.Annotations, awsGroup.Annotations) {
+		return nil
+	}
+	return s.Client.Update(ctx, awsGroup)
+}
+
+func equalAnnotations(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if b[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// saveMembers adds the group to the spec.groupRefs of the SCIM users named and removes it
+// from the others
+func (s *Server) saveMembers(ctx context.Context, awsGroup *kuadrav1.AwsGroup, users []kuadrav1.User, memberNames []string) (interface{}, error) {
+	wanted := map[string]bool{}
+	for _, name := range memberNames {
+		wanted[name] = true
+	}
+	for i := range users {
+		user := &users[i]
+		if wanted[user.Name] == hasGroupRef(user, awsGroup.Name) {
+			continue
+		}
+		if user.Spec.AwsAccount == nil {
+			user.Spec.AwsAccount = &kuadrav1.AwsAccountNestedSpec{Spec: kuadrav1.AwsSpec{User: kuadrav1.AwsAccountSpec{UserName: user.Name}}}
+		}
+		spec := &user.Spec.AwsAccount.Spec.User
+		if wanted[user.Name] {
+			spec.GroupRefs = append(spec.GroupRefs, awsGroup.Name)
+		} else {
+			var refs []string
+			for _, ref := range spec.GroupRefs {
+				if ref != awsGroup.Name {
+					refs = append(refs, ref)
+				}
+			}
+			spec.GroupRefs = refs
+		}
+		if err := s.Client.Update(ctx, user); err != nil {
+			return nil, err
+		}
+	}
+	return s.toGroup(awsGroup, users), nil
+}
+
+// deleteGroup removes the group from its members and deletes its AwsGroup
+func (s *Server) deleteGroup(ctx context.Context, id string) error {
+	awsGroup, err := s.getAwsGroup(ctx, id)
+	if err != nil {
+		return err
+	}
+	users, err := s.scimUsers(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := s.saveMembers(ctx, awsGroup, users, nil); err != nil {
+		return err
+	}
+	if err := s.Client.Delete(ctx, awsGroup); client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("deleted AwsGroup", "awsGroup", awsGroup.Name)
+	return nil
+}
@@ -0,0 +1,409 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package scim serves a SCIM 2.0 endpoint through which identity providers provision Users.
+// SCIM users become Users and SCIM groups become AwsGroups, whose members reference them in
+// spec.groupRefs. Deactivating a user in the identity provider suspends its User, and deleting
+// it deletes the User, which removes the IAM user.
+package scim
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// BasePath is where the SCIM resources are served
+	BasePath = "/scim/v2"
+	// TokenKey is the key of the bearer token in the token Secret
+	TokenKey = "token"
+
+	// ManagedLabel marks the Users and AwsGroups created through SCIM
+	ManagedLabel = "kuadra.kuadrant.io/scim"
+	// UserNameAnnotation holds the SCIM userName of a User
+	UserNameAnnotation = "kuadra.kuadrant.io/scim-user-name"
+	// ExternalIdAnnotation holds the id the identity provider gave a User or AwsGroup
+	ExternalIdAnnotation = "kuadra.kuadrant.io/scim-external-id"
+	// DisplayNameAnnotation holds the SCIM displayName of a User or AwsGroup
+	DisplayNameAnnotation = "kuadra.kuadrant.io/display-name"
+
+	contentType = "application/scim+json"
+
+	userSchema         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	groupSchema        = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	listSchema         = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	errorSchema        = "urn:ietf:params:scim:api:messages:2.0:Error"
+	providerSchema     = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	defaultMaxResults  = 200
+	shutdownTimeout    = 10 * time.Second
+	readHeaderTimeout  = 10 * time.Second
+	maxRequestBodySize = 1 << 20
+)
+
+//+kubebuilder:rbac:groups=kuadra.kuadrant.io,resources=users,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=kuadra.kuadrant.io,resources=awsgroups,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+
+// Server serves the SCIM Users and Groups of one namespace
+type Server struct {
+	Client client.Client
+	// Namespace of the Users and AwsGroups managed through SCIM
+	Namespace string
+	// TokenSecret holds the bearer token identity providers authenticate with, in TokenKey.
+	// It is read on every request so that the token can be rotated.
+	TokenSecret types.NamespacedName
+	// BindAddress is the address the server listens on
+	BindAddress string
+	// CertFile and KeyFile are the TLS certificate and key the server is served with. They
+	// are read again when they change, so that the certificate can be renewed.
+	CertFile string
+	KeyFile  string
+	// Insecure serves plain HTTP when no certificate is set, for a proxy in front of the
+	// server that terminates TLS. Otherwise the server refuses to start without one.
+	Insecure bool
+}
+
+// scimError is an error response as defined by RFC 7644 section 3.12
+type scimError struct {
+	status   int
+	scimType string
+	detail   string
+}
+
+func (e *scimError) Error() string {
+	return e.detail
+}
+
+func badRequest(scimType string, format string, args ...interface{}) *scimError {
+	return &scimError{status: http.StatusBadRequest, scimType: scimType, detail: fmt.Sprintf(format, args...)}
+}
+
+func notFound(resource string, id string) *scimError {
+	return &scimError{status: http.StatusNotFound, detail: fmt.Sprintf("%s %s not found", resource, id)}
+}
+
+func conflict(format string, args ...interface{}) *scimError {
+	return &scimError{status: http.StatusConflict, scimType: "uniqueness", detail: fmt.Sprintf(format, args...)}
+}
+
+// meta is the meta attribute of a resource
+type meta struct {
+	ResourceType string `json:"resourceType"`
+	Created      string `json:"created,omitempty"`
+	Location     string `json:"location,omitempty"`
+	Version      string `json:"version,omitempty"`
+}
+
+type listResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+// NeedLeaderElection lets every replica serve requests, the changes go through the API server
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// Start serves requests until ctx is done. Requests are served over TLS unless the server
+// is explicitly Insecure, as they carry the bearer token and the details of users.
+func (s *Server) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("scim")
+	server := &http.Server{
+		Addr:              s.BindAddress,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: readHeaderTimeout,
+		BaseContext: func(_ net.Listener) context.Context {
+			return log.IntoContext(context.Background(), logger)
+		},
+	}
+	serve := server.ListenAndServe
+	switch {
+	case s.CertFile != "" && s.KeyFile != "":
+		watcher, err := certwatcher.New(s.CertFile, s.KeyFile)
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := watcher.Start(ctx); err != nil {
+				logger.Error(err, "certificate watcher stopped")
+			}
+		}()
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: watcher.GetCertificate}
+		serve = func() error { return server.ListenAndServeTLS("", "") }
+	case s.CertFile != "" || s.KeyFile != "":
+		return errors.New("the SCIM server needs both a certificate and a key file")
+	case !s.Insecure:
+		return errors.New("the SCIM server needs a TLS certificate and key, or to be explicitly allowed to serve plain HTTP")
+	}
+	errs := make(chan error, 1)
+	go func() {
+		logger.Info("serving SCIM", "address", s.BindAddress, "namespace", s.Namespace, "tls", server.TLSConfig != nil)
+		errs <- serve()
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	}
+}
+
+// Handler routes the SCIM endpoints, all of which need the bearer token
+func (s *Server) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := s.authenticate(r); err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+
+		path := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, BasePath), "/")
+		resource, id, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+		var status int
+		var body interface{}
+		var err error
+		switch {
+		case resource == "ServiceProviderConfig" && id == "" && r.Method == http.MethodGet:
+			status, body = http.StatusOK, serviceProviderConfig()
+		case resource == "Users" && id == "":
+			status, body, err = s.serveCollection(r, s.listUsers, s.createUser)
+		case resource == "Users":
+			status, body, err = s.serveResource(r, id, s.getUser, s.replaceUser, s.patchUser, s.deleteUser)
+		case resource == "Groups" && id == "":
+			status, body, err = s.serveCollection(r, s.listGroups, s.createGroup)
+		case resource == "Groups":
+			status, body, err = s.serveResource(r, id, s.getGroup, s.replaceGroup, s.patchGroup, s.deleteGroup)
+		default:
+			err = &scimError{status: http.StatusNotFound, detail: fmt.Sprintf("no SCIM endpoint %s %s", r.Method, r.URL.Path)}
+		}
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		s.write(w, status, body)
+	})
+}
+
+type listFunc func(ctx context.Context, filter *filter) ([]interface{}, error)
+type createFunc func(ctx context.Context, data []byte) (interface{}, error)
+type getFunc func(ctx context.Context, id string) (interface{}, error)
+type updateFunc func(ctx context.Context, id string, data []byte) (interface{}, error)
+type deleteFunc func(ctx context.Context, id string) error
+
+func (s *Server) serveCollection(r *http.Request, list listFunc, create createFunc) (int, interface{}, error) {
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		f, err := parseFilter(query.Get("filter"))
+		if err != nil {
+			return 0, nil, err
+		}
+		resources, err := list(r.Context(), f)
+		if err != nil {
+			return 0, nil, err
+		}
+		if excluded := query.Get("excludedAttributes"); excluded != "" {
+			resources = excludeAttributes(resources, strings.Split(excluded, ","))
+		}
+		page, err := paginate(resources, query.Get("startIndex"), query.Get("count"))
+		return http.StatusOK, page, err
+	case http.MethodPost:
+		data, err := readBody(r)
+		if err != nil {
+			return 0, nil, err
+		}
+		resource, err := create(r.Context(), data)
+		return http.StatusCreated, resource, err
+	}
+	return 0, nil, &scimError{status: http.StatusMethodNotAllowed, detail: fmt.Sprintf("method %s is not supported", r.Method)}
+}
+
+func (s *Server) serveResource(r *http.Request, id string, get getFunc, replace updateFunc, patch updateFunc, remove deleteFunc) (int, interface{}, error) {
+	switch r.Method {
+	case http.MethodGet:
+		resource, err := get(r.Context(), id)
+		return http.StatusOK, resource, err
+	case http.MethodPut, http.MethodPatch:
+		data, err := readBody(r)
+		if err != nil {
+			return 0, nil, err
+		}
+		update := replace
+		if r.Method == http.MethodPatch {
+			update = patch
+		}
+		resource, err := update(r.Context(), id, data)
+		return http.StatusOK, resource, err
+	case http.MethodDelete:
+		return http.StatusNoContent, nil, remove(r.Context(), id)
+	}
+	return 0, nil, &scimError{status: http.StatusMethodNotAllowed, detail: fmt.Sprintf("method %s is not supported", r.Method)}
+}
+
+// authenticate compares the bearer token of the request with the one in the token Secret
+func (s *Server) authenticate(r *http.Request) error {
+	unauthorized := &scimError{status: http.StatusUnauthorized, detail: "a valid bearer token is required"}
+	header := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(header, "Bearer ")
+	if token == header || token == "" {
+		return unauthorized
+	}
+	secret := &v1.Secret{}
+	if err := s.Client.Get(r.Context(), s.TokenSecret, secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			log.FromContext(r.Context()).Info("SCIM token Secret not found, rejecting requests", "secret", s.TokenSecret.String())
+			return unauthorized
+		}
+		return err
+	}
+	expected := secret.Data[TokenKey]
+	if len(expected) == 0 || subtle.ConstantTimeCompare([]byte(token), expected) != 1 {
+		return unauthorized
+	}
+	return nil
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	var data json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		return nil, badRequest("invalidSyntax", "unable to parse the request body: %s", err)
+	}
+	return data, nil
+}
+
+func (s *Server) write(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	if body != nil {
+		_ = json.NewEncoder(w).Encode(body)
+	}
+}
+
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var scimErr *scimError
+	if !errors.As(err, &scimErr) {
+		log.FromContext(r.Context()).Error(err, "unable to serve SCIM request", "method", r.Method, "path", r.URL.Path)
+		scimErr = &scimError{status: http.StatusInternalServerError, detail: err.Error()}
+		if apierrors.IsConflict(err) {
+			// Another request changed the resource first, the identity provider retries
+			scimErr.status = http.StatusConflict
+		}
+	}
+	body := map[string]interface{}{
+		"schemas": []string{errorSchema},
+		"status":  strconv.Itoa(scimErr.status),
+		"detail":  scimErr.detail,
+	}
+	if scimErr.scimType != "" {
+		body["scimType"] = scimErr.scimType
+	}
+	s.write(w, scimErr.status, body)
+}
+
+func serviceProviderConfig() map[string]interface{} {
+	unsupported := map[string]bool{"supported": false}
+	return map[string]interface{}{
+		"schemas":        []string{providerSchema},
+		"patch":          map[string]bool{"supported": true},
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": defaultMaxResults},
+		"changePassword": unsupported,
+		"sort":           unsupported,
+		"etag":           unsupported,
+		"authenticationSchemes": []map[string]interface{}{{
+			"type":        "oauthbearertoken",
+			"name":        "OAuth Bearer Token",
+			"description": "Authentication with the token in the SCIM token Secret",
+			"primary":     true,
+		}},
+	}
+}
+
+// paginate returns the page of resources selected by the 1-based startIndex and count parameters
+func paginate(resources []interface{}, startIndex string, count string) (listResponse, error) {
+	start, limit := 1, defaultMaxResults
+	if startIndex != "" {
+		value, err := strconv.Atoi(startIndex)
+		if err != nil {
+			return listResponse{}, badRequest("invalidValue", "startIndex %q is not a number", startIndex)
+		}
+		if value > 1 {
+			start = value
+		}
+	}
+	if count != "" {
+		value, err := strconv.Atoi(count)
+		if err != nil {
+			return listResponse{}, badRequest("invalidValue", "count %q is not a number", count)
+		}
+		if value < 0 {
+			value = 0
+		}
+		if value < limit {
+			limit = value
+		}
+	}
+
+	page := []interface{}{}
+	if start <= len(resources) {
+		page = resources[start-1:]
+	}
+	if len(page) > limit {
+		page = page[:limit]
+	}
+	return listResponse{
+		Schemas:      []string{listSchema},
+		TotalResults: len(resources),
+		StartIndex:   start,
+		ItemsPerPage: len(page),
+		Resources:    page,
+	}, nil
+}
+
+// excludeAttributes drops attributes, such as the members of groups, from listed resources
+func excludeAttributes(resources []interface{}, attributes []string) []interface{} {
+	for _, resource := range resources {
+		if group, ok := resource.(*Group); ok {
+			for _, attribute := range attributes {
+				if strings.EqualFold(strings.TrimSpace(attribute), "members") {
+					group.Members = nil
+				}
+			}
+		}
+	}
+	return resources
+}
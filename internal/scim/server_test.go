@@ -0,0 +1,293 @@
+package scim
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+)
+
+var _ = Describe("SCIM server", func() {
+	const (
+		Namespace = "scim"
+		Token     = "s3cr3t"
+	)
+
+	ctx := context.Background()
+
+	var httpServer *httptest.Server
+
+	// request sends a SCIM request with the bearer token and decodes the response body
+	request := func(method string, path string, body string) (int, map[string]interface{}) {
+		req, err := http.NewRequest(method, httpServer.URL+BasePath+path, strings.NewReader(body))
+		Expect(err).ShouldNot(HaveOccurred())
+		req.Header.Set("Authorization", "Bearer "+Token)
+		req.Header.Set("Content-Type", contentType)
+		resp, err := http.DefaultClient.Do(req)
+		Expect(err).ShouldNot(HaveOccurred())
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		Expect(err).ShouldNot(HaveOccurred())
+		response := map[string]interface{}{}
+		if len(data) > 0 {
+			Expect(json.Unmarshal(data, &response)).Should(Succeed())
+		}
+		return resp.StatusCode, response
+	}
+
+	BeforeEach(func() {
+		namespace := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: Namespace}}
+		if err := k8sClient.Create(ctx, namespace); !apierrors.IsAlreadyExists(err) {
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "scim-token", Namespace: Namespace},
+			Data:       map[string][]byte{TokenKey: []byte(Token)},
+		}
+		if err := k8sClient.Create(ctx, secret); !apierrors.IsAlreadyExists(err) {
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+
+		server := &Server{
+			Client:      k8sClient,
+			Namespace:   Namespace,
+			TokenSecret: types.NamespacedName{Name: "scim-token", Namespace: Namespace},
+		}
+		httpServer = httptest.NewServer(server.Handler())
+		DeferCleanup(httpServer.Close)
+	})
+
+	It("Should reject requests without the token", func() {
+		resp, err := http.Get(httpServer.URL + BasePath + "/Users")
+		Expect(err).ShouldNot(HaveOccurred())
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).Should(Equal(http.StatusUnauthorized))
+
+		req, err := http.NewRequest(http.MethodGet, httpServer.URL+BasePath+"/Users", nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		req.Header.Set("Authorization", "Bearer wrong")
+		resp, err = http.DefaultClient.Do(req)
+		Expect(err).ShouldNot(HaveOccurred())
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).Should(Equal(http.StatusUnauthorized))
+	})
+
+	It("Should provision and deprovision users and groups", func() {
+		By("By creating a User for a SCIM user")
+		status, body := request(http.MethodPost, "/Users", `{
+			"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+			"userName": "Alice.Smith@example.com",
+			"externalId": "00u1",
+			"name": {"givenName": "Alice", "familyName": "Smith"},
+			"emails": [{"value": "alice.smith@example.com", "type": "work", "primary": true}],
+			"active": true
+		}`)
+		Expect(status).Should(Equal(http.StatusCreated))
+		Expect(body["id"]).Should(MatchRegexp(`^alice-smith-[0-9a-f]{8}$`))
+		Expect(body["active"]).Should(Equal(true))
+		id := body["id"].(string)
+
+		user := &kuadrav1.User{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: id, Namespace: Namespace}, user)).Should(Succeed())
+		Expect(user.Labels).Should(HaveKeyWithValue(ManagedLabel, "true"))
+		Expect(user.Annotations).Should(HaveKeyWithValue(UserNameAnnotation, "Alice.Smith@example.com"))
+		Expect(user.Annotations).Should(HaveKeyWithValue(ExternalIdAnnotation, "00u1"))
+		Expect(user.Annotations).Should(HaveKeyWithValue(kuadrav1.EmailAnnotation, "alice.smith@example.com"))
+		Expect(user.Spec.AwsAccount.Spec.User.UserName).Should(Equal(id))
+		Expect(user.Spec.AwsAccount.Spec.User.Suspended).Should(BeFalse())
+
+		status, body = request(http.MethodPost, "/Users", `{"userName": "ALICE.SMITH@example.com"}`)
+		Expect(status).Should(Equal(http.StatusConflict))
+		Expect(body["scimType"]).Should(Equal("uniqueness"))
+
+		By("By giving a user whose name differs only in the domain a User of its own")
+		status, body = request(http.MethodPost, "/Users", `{"userName": "alice.smith@example.org", "active": false}`)
+		Expect(status).Should(Equal(http.StatusCreated))
+		Expect(body["id"]).Should(MatchRegexp(`^alice-smith-[0-9a-f]{8}$`))
+		Expect(body["id"]).ShouldNot(Equal(id))
+		Expect(body["active"]).Should(Equal(false))
+		other := &kuadrav1.User{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: body["id"].(string), Namespace: Namespace}, other)).Should(Succeed())
+		Expect(other.Spec.AwsAccount.Spec.User.Suspended).Should(BeTrue())
+
+		By("By finding the user with a filter")
+		status, body = request(http.MethodGet, "/Users?filter="+url.QueryEscape(`userName eq "alice.smith@EXAMPLE.com"`), "")
+		Expect(status).Should(Equal(http.StatusOK))
+		Expect(body["totalResults"]).Should(BeEquivalentTo(1))
+		status, body = request(http.MethodGet, "/Users?filter="+url.QueryEscape(`userName sw "alice"`), "")
+		Expect(status).Should(Equal(http.StatusBadRequest))
+		Expect(body["scimType"]).Should(Equal("invalidFilter"))
+
+		By("By adding the members of a SCIM group to an AwsGroup")
+		status, body = request(http.MethodPost, "/Groups", `{
+			"schemas": ["urn:ietf:params:scim:schemas:core:2.0:Group"],
+			"displayName": "DNS Admins",
+			"members": [{"value": "`+id+`"}]
+		}`)
+		Expect(status).Should(Equal(http.StatusCreated))
+		Expect(body["id"]).Should(MatchRegexp(`^dns-admins-[0-9a-f]{8}$`))
+		Expect(body["members"]).Should(HaveLen(1))
+		groupId := body["id"].(string)
+		awsGroup := &kuadrav1.AwsGroup{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: groupId, Namespace: Namespace}, awsGroup)).Should(Succeed())
+		Expect(awsGroup.Annotations).Should(HaveKeyWithValue(DisplayNameAnnotation, "DNS Admins"))
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: id, Namespace: Namespace}, user)).Should(Succeed())
+		Expect(user.Spec.AwsAccount.Spec.User.GroupRefs).Should(Equal([]string{groupId}))
+
+		status, body = request(http.MethodGet, "/Groups?excludedAttributes=members&filter="+url.QueryEscape(`displayName eq "DNS Admins"`), "")
+		Expect(status).Should(Equal(http.StatusOK))
+		Expect(body["totalResults"]).Should(BeEquivalentTo(1))
+		Expect(body["Resources"].([]interface{})[0]).ShouldNot(HaveKey("members"))
+
+		status, _ = request(http.MethodPatch, "/Groups/"+groupId, `{
+			"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+			"Operations": [{"op": "remove", "path": "members[value eq \"`+id+`\"]"}]
+		}`)
+		Expect(status).Should(Equal(http.StatusOK))
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: id, Namespace: Namespace}, user)).Should(Succeed())
+		Expect(user.Spec.AwsAccount.Spec.User.GroupRefs).Should(BeEmpty())
+
+		By("By hiding the AwsGroups made by the cluster administrator")
+		adminGroup := &kuadrav1.AwsGroup{ObjectMeta: metav1.ObjectMeta{Name: "admins", Namespace: Namespace}}
+		Expect(k8sClient.Create(ctx, adminGroup)).Should(Succeed())
+		status, body = request(http.MethodGet, "/Groups", "")
+		Expect(status).Should(Equal(http.StatusOK))
+		Expect(body["totalResults"]).Should(BeEquivalentTo(1))
+		status, _ = request(http.MethodPatch, "/Groups/admins", `{
+			"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+			"Operations": [{"op": "add", "path": "members", "value": [{"value": "`+id+`"}]}]
+		}`)
+		Expect(status).Should(Equal(http.StatusNotFound))
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: id, Namespace: Namespace}, user)).Should(Succeed())
+		Expect(user.Spec.AwsAccount.Spec.User.GroupRefs).Should(BeEmpty())
+
+		By("By updating the attributes of the user")
+		status, body = request(http.MethodPatch, "/Users/"+id, `{
+			"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+			"Operations": [{"op": "Replace", "path": "emails[type eq \"work\"].value", "value": "alice@example.com"}]
+		}`)
+		Expect(status).Should(Equal(http.StatusOK))
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: id, Namespace: Namespace}, user)).Should(Succeed())
+		Expect(user.Annotations).Should(HaveKeyWithValue(kuadrav1.EmailAnnotation, "alice@example.com"))
+
+		By("By suspending the User of a deactivated user")
+		status, body = request(http.MethodPatch, "/Users/"+id, `{
+			"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+			"Operations": [{"op": "Replace", "value": {"active": "False"}}]
+		}`)
+		Expect(status).Should(Equal(http.StatusOK))
+		Expect(body["active"]).Should(Equal(false))
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: id, Namespace: Namespace}, user)).Should(Succeed())
+		Expect(user.Spec.AwsAccount.Spec.User.Suspended).Should(BeTrue())
+		status, body = request(http.MethodGet, "/Users/"+id, "")
+		Expect(status).Should(Equal(http.StatusOK))
+		Expect(body["active"]).Should(Equal(false))
+
+		By("By resuming it once reactivated")
+		status, body = request(http.MethodPatch, "/Users/"+id, `{
+			"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+			"Operations": [{"op": "replace", "path": "active", "value": true}]
+		}`)
+		Expect(status).Should(Equal(http.StatusOK))
+		Expect(body["active"]).Should(Equal(true))
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: id, Namespace: Namespace}, user)).Should(Succeed())
+		Expect(user.Spec.AwsAccount.Spec.User.Suspended).Should(BeFalse())
+
+		By("By deleting the User of a deleted user")
+		status, _ = request(http.MethodDelete, "/Users/"+id, "")
+		Expect(status).Should(Equal(http.StatusNoContent))
+		err := k8sClient.Get(ctx, types.NamespacedName{Name: id, Namespace: Namespace}, user)
+		Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+		status, _ = request(http.MethodGet, "/Users/"+id, "")
+		Expect(status).Should(Equal(http.StatusNotFound))
+
+		By("By deleting the AwsGroup of a deleted group")
+		status, _ = request(http.MethodDelete, "/Groups/"+groupId, "")
+		Expect(status).Should(Equal(http.StatusNoContent))
+		err = k8sClient.Get(ctx, types.NamespacedName{Name: groupId, Namespace: Namespace}, awsGroup)
+		Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+	})
+})
+
+var _ = Describe("SCIM server transport", func() {
+	It("Should refuse to serve plain HTTP unless allowed", func() {
+		server := &Server{Client: k8sClient, BindAddress: "127.0.0.1:0"}
+		Expect(server.Start(context.Background())).Should(MatchError(ContainSubstring("needs a TLS certificate and key")))
+	})
+
+	It("Should serve over TLS with the certificate and key files", func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).ShouldNot(HaveOccurred())
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "kuadra-scim"},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+			IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		Expect(err).ShouldNot(HaveOccurred())
+		keyDer, err := x509.MarshalECPrivateKey(key)
+		Expect(err).ShouldNot(HaveOccurred())
+		dir := GinkgoT().TempDir()
+		certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+		Expect(os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)).Should(Succeed())
+		Expect(os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600)).Should(Succeed())
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ShouldNot(HaveOccurred())
+		address := listener.Addr().String()
+		Expect(listener.Close()).Should(Succeed())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		server := &Server{Client: k8sClient, BindAddress: address, CertFile: certFile, KeyFile: keyFile}
+		errs := make(chan error, 1)
+		go func() { errs <- server.Start(ctx) }()
+		DeferCleanup(func() {
+			cancel()
+			Eventually(errs).Should(Receive(BeNil()))
+		})
+
+		roots := x509.NewCertPool()
+		parsed, err := x509.ParseCertificate(der)
+		Expect(err).ShouldNot(HaveOccurred())
+		roots.AddCert(parsed)
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+		Eventually(func() (int, error) {
+			resp, err := httpClient.Get("https://" + address + BasePath + "/Users")
+			if err != nil {
+				return 0, err
+			}
+			defer resp.Body.Close()
+			return resp.StatusCode, nil
+		}).Should(Equal(http.StatusUnauthorized))
+	})
+})
@@ -0,0 +1,387 @@
+package scim
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+)
+
+// User is the SCIM representation of a User. Attributes not listed here, such as name or
+// the enterprise extension, are accepted and not stored.
+type User struct {
+	Schemas     []string    `json:"schemas"`
+	Id          string      `json:"id,omitempty"`
+	ExternalId  string      `json:"externalId,omitempty"`
+	UserName    string      `json:"userName"`
+	DisplayName string      `json:"displayName,omitempty"`
+	Active      *flexBool   `json:"active,omitempty"`
+	Emails      []Email     `json:"emails,omitempty"`
+	Groups      []Reference `json:"groups,omitempty"`
+	Meta        *meta       `json:"meta,omitempty"`
+}
+
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// Reference is a member of a group or a group of a user
+type Reference struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// flexBool reads booleans also when they are sent as strings, as some identity providers do
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch value := value.(type) {
+	case bool:
+		*b = flexBool(value)
+		return nil
+	case string:
+		switch strings.ToLower(value) {
+		case "true":
+			*b = true
+			return nil
+		case "false":
+			*b = false
+			return nil
+		}
+	}
+	return badRequest("invalidValue", "%s is not a boolean", string(data))
+}
+
+func (u *User) active() bool {
+	return u.Active == nil || bool(*u.Active)
+}
+
+// primaryEmail is the address kept on the User, the primary one or else the first
+func (u *User) primaryEmail() string {
+	for _, email := range u.Emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+	if len(u.Emails) > 0 {
+		return u.Emails[0].Value
+	}
+	return ""
+}
+
+// invalidNameCharacters are replaced when a SCIM name is turned into a resource name
+var invalidNameCharacters = regexp.MustCompile(`[^a-z0-9]+`)
+
+// nameHashLength is the length of the hash appended to names that lost characters
+const nameHashLength = 8
+
+// resourceName derives the name of a User or AwsGroup from a SCIM userName or displayName.
+// Of an email address only the part before the @ is used. When that drops or replaces
+// characters a hash of the whole name is appended, so that alice@example.com and
+// alice@example.org, or "DNS Admins" and "dns-admins", get resources of their own.
+func resourceName(name string) (string, error) {
+	lower := strings.ToLower(name)
+	local, _, _ := strings.Cut(lower, "@")
+	resource := strings.Trim(invalidNameCharacters.ReplaceAllString(local, "-"), "-")
+	if resource != lower && resource != "" {
+		sum := sha256.Sum256([]byte(lower))
+		if maxLength := validation.DNS1123LabelMaxLength - nameHashLength - 1; len(resource) > maxLength {
+			resource = strings.TrimRight(resource[:maxLength], "-")
+		}
+		resource += "-" + hex.EncodeToString(sum[:])[:nameHashLength]
+	}
+	// The name of a User is also the name of its IAM user and namespace
+	if msgs := validation.IsDNS1123Label(resource); len(msgs) > 0 {
+		return "", badRequest("invalidValue", "%q cannot be used as a name: %s", name, strings.Join(msgs, ", "))
+	}
+	return resource, nil
+}
+
+func (s *Server) toUser(user *kuadrav1.User) *User {
+	active := flexBool(user.Spec.AwsAccount == nil || !user.Spec.AwsAccount.Spec.User.Suspended)
+	scimUser := &User{
+		Schemas:     []string{userSchema},
+		Id:          user.Name,
+		ExternalId:  user.Annotations[ExternalIdAnnotation],
+		UserName:    user.Annotations[UserNameAnnotation],
+		DisplayName: user.Annotations[DisplayNameAnnotation],
+		Active:      &active,
+		Meta: &meta{
+			ResourceType: "User",
+			Created:      user.CreationTimestamp.UTC().Format(time.RFC3339),
+			Location:     BasePath + "/Users/" + user.Name,
+			Version:      `W/"` + user.ResourceVersion + `"`,
+		},
+	}
+	if scimUser.UserName == "" {
+		scimUser.UserName = user.Name
+	}
+	if email := user.Annotations[kuadrav1.EmailAnnotation]; email != "" {
+		scimUser.Emails = []Email{{Value: email, Type: "work", Primary: true}}
+	}
+	if user.Spec.AwsAccount != nil {
+		for _, group := range user.Spec.AwsAccount.Spec.User.GroupRefs {
+			scimUser.Groups = append(scimUser.Groups, Reference{Value: group, Ref: BasePath + "/Groups/" + group})
+		}
+	}
+	return scimUser
+}
+
+// scimUsers lists the Users created through SCIM
+func (s *Server) scimUsers(ctx context.Context) ([]kuadrav1.User, error) {
+	var users kuadrav1.UserList
+	if err := s.Client.List(ctx, &users, client.InNamespace(s.Namespace), client.MatchingLabels{ManagedLabel: "true"}); err != nil {
+		return nil, err
+	}
+	return users.Items, nil
+}
+
+func (s *Server) listUsers(ctx context.Context, f *filter) ([]interface{}, error) {
+	users, err := s.scimUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resources := []interface{}{}
+	for i := range users {
+		scimUser := s.toUser(&users[i])
+		var emails []string
+		for _, email := range scimUser.Emails {
+			emails = append(emails, email.Value)
+		}
+		match, err := f.matches(map[string][]string{
+			"id":           {scimUser.Id},
+			"userName":     {scimUser.UserName},
+			"externalId":   {scimUser.ExternalId},
+			"displayName":  {scimUser.DisplayName},
+			"emails":       emails,
+			"emails.value": emails,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			resources = append(resources, scimUser)
+		}
+	}
+	return resources, nil
+}
+
+func (s *Server) getScimUser(ctx context.Context, id string) (*kuadrav1.User, error) {
+	user := &kuadrav1.User{}
+	err := s.Client.Get(ctx, types.NamespacedName{Name: id, Namespace: s.Namespace}, user)
+	if apierrors.IsNotFound(err) || (err == nil && user.Labels[ManagedLabel] != "true") {
+		return nil, notFound("User", id)
+	}
+	return user, err
+}
+
+func (s *Server) getUser(ctx context.Context, id string) (interface{}, error) {
+	user, err := s.getScimUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.toUser(user), nil
+}
+
+func decodeUser(data []byte) (*User, error) {
+	scimUser := &User{}
+	if err := json.Unmarshal(data, scimUser); err != nil {
+		return nil, badRequest("invalidValue", "unable to read the user: %s", err)
+	}
+	if scimUser.UserName == "" {
+		return nil, badRequest("invalidValue", "userName is required")
+	}
+	return scimUser, nil
+}
+
+// createUser creates a User for a SCIM user, suspended if the user is inactive
+func (s *Server) createUser(ctx context.Context, data []byte) (interface{}, error) {
+	scimUser, err := decodeUser(data)
+	if err != nil {
+		return nil, err
+	}
+	name, err := resourceName(scimUser.UserName)
+	if err != nil {
+		return nil, err
+	}
+	scimUser.Schemas = []string{userSchema}
+	scimUser.Id = name
+	scimUser.Groups = nil
+
+	user := &kuadrav1.User{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: s.Namespace,
+			Labels:    map[string]string{ManagedLabel: "true"},
+		},
+		Spec: kuadrav1.UserSpec{AwsAccount: &kuadrav1.AwsAccountNestedSpec{
+			Spec: kuadrav1.AwsSpec{User: kuadrav1.AwsAccountSpec{UserName: name, Groups: []string{}, Suspended: !scimUser.active()}},
+		}},
+	}
+	setUserAnnotations(user, scimUser)
+	if err := s.Client.Create(ctx, user); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			return nil, conflict("userName %s is taken, User %s already exists", scimUser.UserName, name)
+		}
+		return nil, err
+	}
+	log.FromContext(ctx).Info("created User", "user", name, "userName", scimUser.UserName)
+	return s.toUser(user), nil
+}
+
+func setUserAnnotations(user *kuadrav1.User, scimUser *User) {
+	if user.Annotations == nil {
+		user.Annotations = map[string]string{}
+	}
+	annotations := map[string]string{
+		UserNameAnnotation:       scimUser.UserName,
+		ExternalIdAnnotation:     scimUser.ExternalId,
+		DisplayNameAnnotation:    scimUser.DisplayName,
+		kuadrav1.EmailAnnotation: scimUser.primaryEmail(),
+	}
+	for key, value := range annotations {
+		if value == "" {
+			delete(user.Annotations, key)
+		} else {
+			user.Annotations[key] = value
+		}
+	}
+}
+
+func (s *Server) replaceUser(ctx context.Context, id string, data []byte) (interface{}, error) {
+	user, err := s.getScimUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	scimUser, err := decodeUser(data)
+	if err != nil {
+		return nil, err
+	}
+	return s.saveUser(ctx, user, scimUser)
+}
+
+func (s *Server) patchUser(ctx context.Context, id string, data []byte) (interface{}, error) {
+	user, err := s.getScimUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	operations, err := parsePatch(data)
+	if err != nil {
+		return nil, err
+	}
+	scimUser := s.toUser(user)
+	for _, operation := range operations {
+		if err := patchUserAttributes(scimUser, operation); err != nil {
+			return nil, err
+		}
+	}
+	if scimUser.UserName == "" {
+		return nil, badRequest("mutability", "userName cannot be removed")
+	}
+	return s.saveUser(ctx, user, scimUser)
+}
+
+// patchUserAttributes applies an operation to the attributes of a user that are stored
+func patchUserAttributes(scimUser *User, operation patchOperation) error {
+	values, err := patchValue(operation)
+	if err != nil {
+		return err
+	}
+	remove := operation.Op == "remove"
+	for path, value := range values {
+		attribute, valueFilter, subAttribute, err := splitPath(path)
+		if err != nil {
+			return err
+		}
+		var target interface{}
+		switch strings.ToLower(attribute) {
+		case "active":
+			target = &scimUser.Active
+		case "username":
+			target = &scimUser.UserName
+		case "displayname":
+			target = &scimUser.DisplayName
+		case "externalid":
+			target = &scimUser.ExternalId
+		case "emails":
+			if remove {
+				scimUser.Emails = nil
+				continue
+			}
+			if valueFilter != nil || subAttribute != "" {
+				// A single address, such as emails[type eq "work"].value
+				var email string
+				if err := json.Unmarshal(value, &email); err != nil {
+					return badRequest("invalidValue", "%s needs a string value", path)
+				}
+				scimUser.Emails = []Email{{Value: email, Type: "work", Primary: true}}
+				continue
+			}
+			target = &scimUser.Emails
+		default:
+			continue
+		}
+		if remove {
+			switch target := target.(type) {
+			case *string:
+				*target = ""
+			case **flexBool:
+				*target = nil
+			}
+			continue
+		}
+		if err := json.Unmarshal(value, target); err != nil {
+			return badRequest("invalidValue", "invalid value for %s: %s", path, err)
+		}
+	}
+	return nil
+}
+
+// saveUser writes the attributes of the SCIM user to its User, which is suspended while the
+// user is inactive. The id and the IAM user name stay as they were when the user was created.
+func (s *Server) saveUser(ctx context.Context, user *kuadrav1.User, scimUser *User) (interface{}, error) {
+	setUserAnnotations(user, scimUser)
+	if user.Spec.AwsAccount == nil {
+		user.Spec.AwsAccount = &kuadrav1.AwsAccountNestedSpec{Spec: kuadrav1.AwsSpec{User: kuadrav1.AwsAccountSpec{UserName: user.Name}}}
+	}
+	spec := &user.Spec.AwsAccount.Spec.User
+	if suspended := !scimUser.active(); spec.Suspended != suspended {
+		spec.Suspended = suspended
+		log.FromContext(ctx).Info("changed suspension of User", "user", user.Name, "userName", scimUser.UserName, "suspended", suspended)
+	}
+	if err := s.Client.Update(ctx, user); err != nil {
+		return nil, err
+	}
+	return s.toUser(user), nil
+}
+
+func (s *Server) deleteUser(ctx context.Context, id string) error {
+	user, err := s.getScimUser(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.Client.Delete(ctx, user); client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("deleted User", "user", user.Name)
+	return nil
+}
@@ -0,0 +1,233 @@
+// Package audit keeps an append-only trail of the privileged changes Kuadra makes in IAM
+// and Kubernetes. Every record carries the hash of the record before it, so removing or
+// editing a record breaks the chain and is found by Verify.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Outcome of an audited change
+type Outcome string
+
+const (
+	OutcomeSuccess Outcome = "Success"
+	OutcomeFailure Outcome = "Failure"
+)
+
+// Record describes one change
+type Record struct {
+	// Sequence numbers the records of a sink from 1, without gaps
+	Sequence uint64    `json:"sequence"`
+	Time     time.Time `json:"time"`
+	// Actor is the resource whose reconcile made the change
+	Actor Actor `json:"actor"`
+	// Service is the API the change was made in, "iam" or "kubernetes"
+	Service string `json:"service"`
+	// Operation is the API operation, for example AddUserToGroup or DeleteSecret
+	Operation string `json:"operation"`
+	// Target identifies what was changed, for example by UserName and GroupName
+	Target    map[string]string `json:"target"`
+	RequestId string            `json:"requestId,omitempty"`
+	Outcome   Outcome           `json:"outcome"`
+	Error     string            `json:"error,omitempty"`
+	// PrevHash is the Hash of the previous record in the sink, empty for the first one
+	PrevHash string `json:"prevHash"`
+	// Hash is the SHA-256 of the record with an empty Hash
+	Hash string `json:"hash"`
+}
+
+// Actor is the Kuadra resource a change was made for
+type Actor struct {
+	Kind      string `json:"kind,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+	// LastModifiedBy is the field manager that last changed the spec of the resource, taken
+	// from its managedFields, so that the controller adding its finalizer does not hide it.
+	// Resources whose managedFields own no spec fields fall back to the field manager that
+	// last changed them. Status updates are not counted.
+	LastModifiedBy string `json:"lastModifiedBy,omitempty"`
+}
+
+// ActorOf describes obj as the actor of the changes made while reconciling it
+func ActorOf(kind string, obj metav1.Object) Actor {
+	actor := Actor{Kind: kind, Namespace: obj.GetNamespace(), Name: obj.GetName()}
+	var lastModified time.Time
+	ownsSpec := false
+	for _, entry := range obj.GetManagedFields() {
+		if entry.Subresource != "" || entry.Time == nil {
+			continue
+		}
+		entryOwnsSpec := entry.FieldsV1 != nil && bytes.Contains(entry.FieldsV1.Raw, []byte(`"f:spec"`))
+		if ownsSpec && !entryOwnsSpec {
+			continue
+		}
+		if actor.LastModifiedBy == "" || entryOwnsSpec && !ownsSpec || !entry.Time.Time.Before(lastModified) {
+			actor.LastModifiedBy = entry.Manager
+			lastModified = entry.Time.Time
+			ownsSpec = entryOwnsSpec
+		}
+	}
+	return actor
+}
+
+type actorKey struct{}
+
+// WithActor returns a context recording changes made with it as done for actor
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor set with WithActor
+func ActorFromContext(ctx context.Context) Actor {
+	actor, _ := ctx.Value(actorKey{}).(Actor)
+	return actor
+}
+
+// hash returns the hash of record, ignoring its current Hash
+func hash(record Record) (string, error) {
+	record.Hash = ""
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Logger numbers, chains and writes records to its sinks
+type Logger struct {
+	sinks []*chainedSink
+}
+
+// chainedSink numbers and chains the records written to one sink. Every sink keeps a chain
+// of its own, which only advances once a record is written, so a sink that fails to take a
+// record does not end up with a gap. Its lock is only held while writing to that sink, so
+// a slow sink does not hold up the others.
+type chainedSink struct {
+	mu       sync.Mutex
+	sink     Sink
+	sequence uint64
+	lastHash string
+}
+
+// NewLogger returns a logger writing to sinks. A sink that already holds records, such as
+// an existing file, continues its chain.
+func NewLogger(sinks ...Sink) *Logger {
+	logger := &Logger{}
+	for _, sink := range sinks {
+		chained := &chainedSink{sink: sink}
+		if resumable, ok := sink.(interface{ Last() *Record }); ok {
+			if last := resumable.Last(); last != nil {
+				chained.sequence = last.Sequence
+				chained.lastHash = last.Hash
+			}
+		}
+		logger.sinks = append(logger.sinks, chained)
+	}
+	return logger
+}
+
+// Log completes record with its time, sequence and hashes and writes it to every sink.
+// Each sink takes records one at a time, in the order they are logged. The first sink
+// error is returned after all sinks have been tried.
+func (l *Logger) Log(ctx context.Context, record Record) error {
+	if record.Time.IsZero() {
+		record.Time = time.Now().UTC()
+	}
+	var sinkErr error
+	for _, sink := range l.sinks {
+		if err := sink.write(ctx, record); err != nil && sinkErr == nil {
+			sinkErr = err
+		}
+	}
+	return sinkErr
+}
+
+func (s *chainedSink) write(ctx context.Context, record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record.Sequence = s.sequence + 1
+	record.PrevHash = s.lastHash
+	var err error
+	if record.Hash, err = hash(record); err != nil {
+		return err
+	}
+	if err := s.sink.Write(ctx, record); err != nil {
+		return err
+	}
+	s.sequence = record.Sequence
+	s.lastHash = record.Hash
+	return nil
+}
+
+// Close closes every sink
+func (l *Logger) Close() error {
+	var closeErr error
+	for _, sink := range l.sinks {
+		sink.mu.Lock()
+		err := sink.sink.Close()
+		sink.mu.Unlock()
+		if err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
+	return closeErr
+}
+
+// LogError logs a failure to write a record. The change it describes has already been made
+// by then, so the failure is only logged.
+func LogError(ctx context.Context, err error) {
+	if err != nil {
+		log.FromContext(ctx).Error(err, "unable to write audit record")
+	}
+}
+
+// Verify reads JSON lines records from r and checks that none were changed, removed or
+// reordered. It returns the number of records read.
+func Verify(r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var previous *Record
+	count := 0
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return count, fmt.Errorf("record %d: %w", count+1, err)
+		}
+		expected, err := hash(record)
+		if err != nil {
+			return count, err
+		}
+		if record.Hash != expected {
+			return count, fmt.Errorf("record %d: hash does not match its content", record.Sequence)
+		}
+		if previous != nil {
+			if record.Sequence != previous.Sequence+1 {
+				return count, fmt.Errorf("record %d follows record %d", record.Sequence, previous.Sequence)
+			}
+			if record.PrevHash != previous.Hash {
+				return count, fmt.Errorf("record %d does not chain to record %d", record.Sequence, previous.Sequence)
+			}
+		}
+		previous = &record
+		count++
+	}
+	return count, scanner.Err()
+}
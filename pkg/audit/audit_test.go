@@ -0,0 +1,235 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Audit log", func() {
+	ctx := context.Background()
+
+	record := func(operation string) Record {
+		return Record{
+			Actor:     Actor{Kind: "AwsAccount", Namespace: "default", Name: "ib-dns"},
+			Service:   "iam",
+			Operation: operation,
+			Target:    map[string]string{"UserName": "ib-dns"},
+			Outcome:   OutcomeSuccess,
+		}
+	}
+
+	It("Should chain records and detect changes to them", func() {
+		var buffer bytes.Buffer
+		logger := NewLogger(NewWriterSink(&buffer))
+		Expect(logger.Log(ctx, record("CreateUser"))).Should(Succeed())
+		Expect(logger.Log(ctx, record("CreateAccessKey"))).Should(Succeed())
+		Expect(logger.Log(ctx, record("AddUserToGroup"))).Should(Succeed())
+
+		lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+		Expect(lines).Should(HaveLen(3))
+		var first, second Record
+		Expect(json.Unmarshal([]byte(lines[0]), &first)).Should(Succeed())
+		Expect(json.Unmarshal([]byte(lines[1]), &second)).Should(Succeed())
+		Expect(first.Sequence).Should(Equal(uint64(1)))
+		Expect(first.PrevHash).Should(BeEmpty())
+		Expect(second.PrevHash).Should(Equal(first.Hash))
+		Expect(Verify(strings.NewReader(buffer.String()))).Should(Equal(3))
+
+		By("By detecting an edited record")
+		edited := strings.Replace(buffer.String(), "CreateAccessKey", "DeleteAccessKey", 1)
+		_, err := Verify(strings.NewReader(edited))
+		Expect(err).Should(MatchError(ContainSubstring("record 2: hash does not match")))
+
+		By("By detecting a removed record")
+		_, err = Verify(strings.NewReader(lines[0] + "\n" + lines[2] + "\n"))
+		Expect(err).Should(MatchError(ContainSubstring("record 3 follows record 1")))
+	})
+
+	It("Should continue the chain of an existing file", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "audit.log")
+		sink, err := NewFileSink(path)
+		Expect(err).Should(BeNil())
+		logger := NewLogger(sink)
+		Expect(logger.Log(ctx, record("CreateUser"))).Should(Succeed())
+		Expect(logger.Close()).Should(Succeed())
+
+		sink, err = NewFileSink(path)
+		Expect(err).Should(BeNil())
+		Expect(sink.Last().Sequence).Should(Equal(uint64(1)))
+		logger = NewLogger(sink)
+		Expect(logger.Log(ctx, record("DeleteUser"))).Should(Succeed())
+		Expect(logger.Close()).Should(Succeed())
+
+		data, err := os.ReadFile(path)
+		Expect(err).Should(BeNil())
+		Expect(Verify(bytes.NewReader(data))).Should(Equal(2))
+
+		By("By refusing to append to a file that was tampered with")
+		Expect(os.WriteFile(path, bytes.Replace(data, []byte("DeleteUser"), []byte("CreateUser"), 1), 0o600)).Should(Succeed())
+		_, err = NewFileSink(path)
+		Expect(err).Should(MatchError(ContainSubstring("is not intact")))
+	})
+
+	It("Should POST records to a webhook", func() {
+		received := make(chan Record, 2)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var record Record
+			if err := json.NewDecoder(r.Body).Decode(&record); err != nil || record.Operation == "Fail" {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			received <- record
+		}))
+		defer server.Close()
+
+		logger := NewLogger(NewWebhookSink(server.URL))
+		Expect(logger.Log(ctx, record("CreateUser"))).Should(Succeed())
+		first := <-received
+		Expect(first.Operation).Should(Equal("CreateUser"))
+		Expect(logger.Log(ctx, record("Fail"))).Should(MatchError(ContainSubstring("500")))
+
+		By("By continuing the chain from the last record the webhook took")
+		Expect(logger.Log(ctx, record("DeleteUser"))).Should(Succeed())
+		second := <-received
+		Expect(second.Sequence).Should(Equal(uint64(2)))
+		Expect(second.PrevHash).Should(Equal(first.Hash))
+	})
+
+	It("Should not hold up a sink while another one is writing", func() {
+		fast := &blockingSink{release: make(chan struct{})}
+		close(fast.release)
+		slow := &blockingSink{release: make(chan struct{})}
+		logger := NewLogger(fast, slow)
+
+		done := make(chan error)
+		go func() { done <- logger.Log(ctx, record("CreateUser")) }()
+		Eventually(slow.writes).Should(Equal(1))
+		go func() { done <- logger.Log(ctx, record("DeleteUser")) }()
+		Eventually(fast.writes).Should(Equal(2))
+
+		close(slow.release)
+		Expect(<-done).Should(Succeed())
+		Expect(<-done).Should(Succeed())
+	})
+
+	It("Should move a partly written record away from the end of the file", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "audit.log")
+		sink, err := NewFileSink(path)
+		Expect(err).Should(BeNil())
+		logger := NewLogger(sink)
+		Expect(logger.Log(ctx, record("CreateUser"))).Should(Succeed())
+		Expect(logger.Close()).Should(Succeed())
+
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+		Expect(err).Should(BeNil())
+		_, err = file.WriteString(`{"sequence":2,"time":"2023-06`)
+		Expect(err).Should(BeNil())
+		Expect(file.Close()).Should(Succeed())
+
+		sink, err = NewFileSink(path)
+		Expect(err).Should(BeNil())
+		Expect(sink.Last().Sequence).Should(Equal(uint64(1)))
+		logger = NewLogger(sink)
+		Expect(logger.Log(ctx, record("DeleteUser"))).Should(Succeed())
+		Expect(logger.Close()).Should(Succeed())
+
+		data, err := os.ReadFile(path)
+		Expect(err).Should(BeNil())
+		Expect(Verify(bytes.NewReader(data))).Should(Equal(2))
+		torn, err := os.ReadFile(path + ".torn")
+		Expect(err).Should(BeNil())
+		Expect(string(torn)).Should(Equal(`{"sequence":2,"time":"2023-06` + "\n"))
+	})
+
+	It("Should record Secret changes with the actor last modifying the resource", func() {
+		var buffer bytes.Buffer
+		logger := NewLogger(NewWriterSink(&buffer))
+		c := NewClient(fake.NewClientBuilder().Build(), logger)
+
+		awsAccount := &metav1.ObjectMeta{
+			Name:      "ib-dns",
+			Namespace: "default",
+			ManagedFields: []metav1.ManagedFieldsEntry{
+				{Manager: "kubectl-client-side-apply", Time: &metav1.Time{Time: time.Now().Add(-time.Hour)}},
+				{Manager: "kubectl-edit", Time: &metav1.Time{Time: time.Now()}},
+				{Manager: "manager", Subresource: "status", Time: &metav1.Time{Time: time.Now()}},
+			},
+		}
+		ctx := WithActor(ctx, ActorOf("AwsAccount", awsAccount))
+
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "aws-credentials", Namespace: "ib-dns"}}
+		Expect(c.Create(ctx, secret)).Should(Succeed())
+		Expect(c.Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ib-dns"}})).Should(Succeed())
+		Expect(c.Delete(ctx, secret)).Should(Succeed())
+		Expect(c.Delete(ctx, secret)).ShouldNot(Succeed())
+
+		var records []Record
+		for _, line := range strings.Split(strings.TrimSpace(buffer.String()), "\n") {
+			var record Record
+			Expect(json.Unmarshal([]byte(line), &record)).Should(Succeed())
+			records = append(records, record)
+		}
+		Expect(records).Should(HaveLen(3))
+		Expect(records[0].Operation).Should(Equal("CreateSecret"))
+		Expect(records[0].Service).Should(Equal("kubernetes"))
+		Expect(records[0].Target).Should(Equal(map[string]string{"Namespace": "ib-dns", "Name": "aws-credentials"}))
+		Expect(records[0].Actor).Should(Equal(Actor{Kind: "AwsAccount", Namespace: "default", Name: "ib-dns", LastModifiedBy: "kubectl-edit"}))
+		Expect(records[1].Operation).Should(Equal("DeleteSecret"))
+		Expect(records[2].Outcome).Should(Equal(OutcomeFailure))
+		Expect(records[2].Error).Should(ContainSubstring("not found"))
+	})
+
+	It("Should take the actor from the last manager of the spec", func() {
+		awsAccount := &metav1.ObjectMeta{
+			Name:      "ib-dns",
+			Namespace: "default",
+			ManagedFields: []metav1.ManagedFieldsEntry{
+				{Manager: "kubectl-edit", Time: &metav1.Time{Time: time.Now().Add(-time.Hour)},
+					FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:groups":{}}}`)}},
+				{Manager: "manager", Time: &metav1.Time{Time: time.Now()},
+					FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:finalizers":{}}}`)}},
+			},
+		}
+		Expect(ActorOf("AwsAccount", awsAccount).LastModifiedBy).Should(Equal("kubectl-edit"))
+	})
+})
+
+// blockingSink holds every write until release is closed
+type blockingSink struct {
+	mu      sync.Mutex
+	started int
+	release chan struct{}
+}
+
+// writes returns the number of writes started so far
+func (s *blockingSink) writes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.started
+}
+
+func (s *blockingSink) Write(ctx context.Context, record Record) error {
+	s.mu.Lock()
+	s.started++
+	s.mu.Unlock()
+	<-s.release
+	return nil
+}
+
+func (s *blockingSink) Close() error {
+	return nil
+}
@@ -0,0 +1,68 @@
+package audit
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// auditedClient records the writes to Secrets made through it
+type auditedClient struct {
+	client.Client
+	logger *Logger
+}
+
+// NewClient wraps c so that every Secret it creates, updates, patches or deletes is
+// recorded in logger
+func NewClient(c client.Client, logger *Logger) client.Client {
+	return &auditedClient{Client: c, logger: logger}
+}
+
+func (c *auditedClient) record(ctx context.Context, operation string, obj client.Object, err error) {
+	if _, ok := obj.(*corev1.Secret); !ok {
+		return
+	}
+	record := Record{
+		Actor:     ActorFromContext(ctx),
+		Service:   "kubernetes",
+		Operation: operation + "Secret",
+		Target:    map[string]string{"Namespace": obj.GetNamespace(), "Name": obj.GetName()},
+		Outcome:   OutcomeSuccess,
+	}
+	if err != nil {
+		record.Outcome = OutcomeFailure
+		record.Error = err.Error()
+	}
+	LogError(ctx, c.logger.Log(ctx, record))
+}
+
+func (c *auditedClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	err := c.Client.Create(ctx, obj, opts...)
+	c.record(ctx, "Create", obj, err)
+	return err
+}
+
+func (c *auditedClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	err := c.Client.Update(ctx, obj, opts...)
+	c.record(ctx, "Update", obj, err)
+	return err
+}
+
+func (c *auditedClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	err := c.Client.Patch(ctx, obj, patch, opts...)
+	c.record(ctx, "Patch", obj, err)
+	return err
+}
+
+func (c *auditedClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	err := c.Client.Delete(ctx, obj, opts...)
+	c.record(ctx, "Delete", obj, err)
+	return err
+}
+
+func (c *auditedClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	err := c.Client.DeleteAllOf(ctx, obj, opts...)
+	c.record(ctx, "DeleteAllOf", obj, err)
+	return err
+}
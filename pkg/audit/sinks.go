@@ -0,0 +1,219 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Sink stores audit records
+type Sink interface {
+	Write(ctx context.Context, record Record) error
+	Close() error
+}
+
+// WriterSink writes records as JSON lines to an io.Writer such as stdout
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterSink returns a sink writing to w
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+func (s *WriterSink) Write(ctx context.Context, record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(data, '\n'))
+	return err
+}
+
+func (s *WriterSink) Close() error {
+	return nil
+}
+
+// FileSink appends records as JSON lines to a file, syncing it after every record
+type FileSink struct {
+	WriterSink
+	file *os.File
+	last *Record
+}
+
+// NewFileSink opens path for appending, creating it if needed. The records already in the
+// file are verified and the chain continues from the last one. A last record that was only
+// partly written, as happens when the controller is killed while writing it, is moved to
+// path.torn first.
+func NewFileSink(path string) (*FileSink, error) {
+	if err := quarantineTornTail(path); err != nil {
+		return nil, err
+	}
+	last, err := lastRecord(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{WriterSink: WriterSink{w: file}, file: file, last: last}, nil
+}
+
+// quarantineTornTail cuts whatever follows the last newline of the file at path. Every
+// record is written together with its newline, so those bytes can only be a record that
+// was not completely written. They are appended to path.torn to be looked at.
+func quarantineTornTail(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Read backwards from the end until a newline turns up
+	size := info.Size()
+	end := size
+	var tail []byte
+	for end > 0 {
+		chunk := make([]byte, 4096)
+		if int64(len(chunk)) > end {
+			chunk = chunk[:end]
+		}
+		if _, err := file.ReadAt(chunk, end-int64(len(chunk))); err != nil {
+			return err
+		}
+		if i := bytes.LastIndexByte(chunk, '\n'); i != -1 {
+			tail = append(chunk[i+1:], tail...)
+			break
+		}
+		tail = append(chunk, tail...)
+		end -= int64(len(chunk))
+	}
+	if len(tail) == 0 {
+		return nil
+	}
+
+	torn, err := os.OpenFile(path+".torn", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer torn.Close()
+	if _, err := torn.Write(append(tail, '\n')); err != nil {
+		return err
+	}
+	if err := torn.Sync(); err != nil {
+		return err
+	}
+	if err := file.Truncate(size - int64(len(tail))); err != nil {
+		return err
+	}
+	log.Log.WithName("audit").Info("moved a partly written record from the end of the audit log",
+		"path", path, "quarantine", path+".torn", "bytes", len(tail))
+	return file.Sync()
+}
+
+// lastRecord returns the last record of the audit log at path after verifying the log
+func lastRecord(path string) (*Record, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err := Verify(file); err != nil {
+		return nil, fmt.Errorf("audit log %s is not intact: %w", path, err)
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var last *Record
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, err
+		}
+		last = &record
+	}
+	return last, scanner.Err()
+}
+
+// Last returns the last record that was in the file when it was opened
+func (s *FileSink) Last() *Record {
+	return s.last
+}
+
+func (s *FileSink) Write(ctx context.Context, record Record) error {
+	if err := s.WriterSink.Write(ctx, record); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+// WebhookSink POSTs each record as JSON to a URL
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookSink returns a sink posting records to url
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (s *WebhookSink) Write(ctx context.Context, record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("audit webhook answered %s for record %d", res.Status, record.Sequence)
+	}
+	return nil
+}
+
+func (s *WebhookSink) Close() error {
+	return nil
+}
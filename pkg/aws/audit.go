@@ -0,0 +1,78 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/smithy-go/middleware"
+
+	"github.com/Kuadrant/kuadra/pkg/audit"
+)
+
+// auditTargetFields are the input fields identifying what an IAM operation changes. Other
+// fields, such as passwords and policy documents, are left out of the audit log.
+var auditTargetFields = []string{"UserName", "NewUserName", "GroupName", "NewGroupName", "PolicyArn", "PolicyName", "PermissionsBoundary", "AccessKeyId", "NewPath"}
+
+// addAuditMiddleware records every IAM call that changes something in logger, once per
+// call whatever the number of attempts it took
+func addAuditMiddleware(logger *audit.Logger) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("KuadraAudit",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				out, metadata, err := next.HandleInitialize(ctx, in)
+
+				operation := awsmiddleware.GetOperationName(ctx)
+				if isReadOnlyOperation(operation) {
+					return out, metadata, err
+				}
+				record := audit.Record{
+					Actor:     audit.ActorFromContext(ctx),
+					Service:   "iam",
+					Operation: operation,
+					Target:    auditTarget(in.Parameters),
+					Outcome:   audit.OutcomeSuccess,
+				}
+				record.RequestId, _ = awsmiddleware.GetRequestIDMetadata(metadata)
+				// The ID of a new access key is only known from the output
+				if created, ok := out.Result.(*iam.CreateAccessKeyOutput); ok && created.AccessKey != nil && created.AccessKey.AccessKeyId != nil {
+					record.Target["AccessKeyId"] = *created.AccessKey.AccessKeyId
+				}
+				if err != nil {
+					record.Outcome = audit.OutcomeFailure
+					record.Error = err.Error()
+					var responseError *awshttp.ResponseError
+					if errors.As(err, &responseError) {
+						record.RequestId = responseError.ServiceRequestID()
+					}
+				}
+				audit.LogError(ctx, logger.Log(ctx, record))
+				return out, metadata, err
+			}), middleware.After)
+	}
+}
+
+func isReadOnlyOperation(operation string) bool {
+	return strings.HasPrefix(operation, "Get") || strings.HasPrefix(operation, "List")
+}
+
+// auditTarget picks the auditTargetFields set in an operation input
+func auditTarget(input interface{}) map[string]string {
+	target := map[string]string{}
+	value := reflect.Indirect(reflect.ValueOf(input))
+	if value.Kind() != reflect.Struct {
+		return target
+	}
+	for _, name := range auditTargetFields {
+		field := value.FieldByName(name)
+		if field.Kind() != reflect.Pointer || field.IsNil() || field.Elem().Kind() != reflect.String {
+			continue
+		}
+		target[name] = field.Elem().String()
+	}
+	return target
+}
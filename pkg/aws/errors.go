@@ -0,0 +1,53 @@
+package aws
+
+import (
+	"errors"
+
+	"github.com/aws/smithy-go"
+)
+
+// transientErrorCodes are AWS error codes for failures that go away on their own, either
+// because the request was throttled or because IAM has not yet propagated a change
+var transientErrorCodes = map[string]struct{}{
+	"Throttling":             {},
+	"ThrottlingException":    {},
+	"RequestLimitExceeded":   {},
+	"ConcurrentModification": {},
+	"ServiceFailure":         {},
+	"ServiceUnavailable":     {},
+	"InternalFailure":        {},
+	"RequestTimeout":         {},
+	"RequestExpired":         {},
+}
+
+// IsTransientError reports whether retrying the call that returned err later can succeed
+// without anything being changed. Errors that did not come from AWS, such as connection
+// failures, are treated as transient.
+func IsTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var apiError smithy.APIError
+	if !errors.As(err, &apiError) {
+		return true
+	}
+	if _, ok := transientErrorCodes[apiError.ErrorCode()]; ok {
+		return true
+	}
+	return apiError.ErrorFault() == smithy.FaultServer
+}
+
+// IsPermanentError reports whether err was returned by AWS for a request that will keep
+// failing until the request itself, or the account it is made against, is changed.
+// MalformedPolicyDocument, InvalidInput and AccessDenied are examples.
+func IsPermanentError(err error) bool {
+	var apiError smithy.APIError
+	return errors.As(err, &apiError) && !IsTransientError(err)
+}
+
+// IsNoSuchEntityError reports whether err was returned by AWS because the entity the request
+// names does not exist, for example a user deleted after it was listed
+func IsNoSuchEntityError(err error) bool {
+	var apiError smithy.APIError
+	return errors.As(err, &apiError) && apiError.ErrorCode() == "NoSuchEntity"
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"log"
+	"net/url"
+	"sort"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -12,6 +14,7 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/service/iam"
 	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"golang.org/x/time/rate"
 )
 
 func isNoSuchEntityException(err error) bool {
@@ -25,21 +28,58 @@ func isNoSuchEntityException(err error) bool {
 	}
 }
 
+func isEntityAlreadyExistsException(err error) bool {
+	var apiError smithy.APIError
+	errors.As(err, &apiError)
+	switch apiError.(type) {
+	case *types.EntityAlreadyExistsException:
+		return true
+	default:
+		return false
+	}
+}
+
+// decodePolicyDocument undoes the URL encoding IAM applies to returned policy documents
+func decodePolicyDocument(document string) (string, error) {
+	return url.QueryUnescape(document)
+}
+
 type iamWrapper struct {
-	IamClient *iam.Client
+	IamClient IamAPI
 }
 
-func NewIamWrapper() (*iamWrapper, error) {
-	// TODO: take config/credentials in this constructor
-	sdkConfig, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion("us-west-2"))
+// NewIamWrapperFromClient wraps an existing IAM client, such as a test double
+func NewIamWrapperFromClient(client IamAPI) *iamWrapper {
+	return &iamWrapper{IamClient: client}
+}
+
+// NewIamWrapper creates an IAM client for region. All calls made through it share one
+// rate limiter, and calls failing with transient errors are retried as set in options.
+func NewIamWrapper(region string, options ClientOptions) (*iamWrapper, error) {
+	// TODO: take credentials in this constructor
+	sdkConfig, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
 	if err != nil {
 		return nil, err
 	}
+	return NewIamWrapperFromConfig(sdkConfig, options), nil
+}
 
-	iamWrapper := iamWrapper{
-		IamClient: iam.NewFromConfig(sdkConfig),
+// NewIamWrapperFromConfig creates an IAM client from an already loaded configuration, for
+// example one pointing at the iamfake server
+func NewIamWrapperFromConfig(sdkConfig aws.Config, options ClientOptions) *iamWrapper {
+	options = options.withDefaults()
+	limiter := rate.NewLimiter(rate.Limit(options.RequestsPerSecond), options.Burst)
+	return &iamWrapper{
+		IamClient: iam.NewFromConfig(sdkConfig, func(o *iam.Options) {
+			o.Retryer = newRetryer(options)
+			// The limiter goes in last so it ends up in front of the metrics middleware,
+			// keeping the time spent waiting for a token out of the request latency
+			o.APIOptions = append(o.APIOptions, addMetricsMiddleware, addRateLimitMiddleware(limiter))
+			if options.AuditLogger != nil {
+				o.APIOptions = append(o.APIOptions, addAuditMiddleware(options.AuditLogger))
+			}
+		}),
 	}
-	return &iamWrapper, nil
 }
 
 func (wrapper iamWrapper) GetUser(ctx context.Context, userName string) (*types.User, error) {
@@ -82,23 +122,26 @@ func (wrapper iamWrapper) HasLoginProfile(ctx context.Context, userName string)
 }
 
 func (wrapper iamWrapper) HasAccessKey(ctx context.Context, userName string) (bool, error) {
-	result, err := wrapper.IamClient.ListAccessKeys(ctx, &iam.ListAccessKeysInput{
-		UserName: aws.String(userName),
-	})
+	accessKeys, err := wrapper.ListAccessKeys(ctx, userName)
 	if err != nil {
 		return false, err
 	}
-	return len(result.AccessKeyMetadata) > 0, nil
+	return len(accessKeys) > 0, nil
 }
 
 func (wrapper iamWrapper) ListGroupsForUser(ctx context.Context, userName string) ([]types.Group, error) {
-	result, err := wrapper.IamClient.ListGroupsForUser(ctx, &iam.ListGroupsForUserInput{
+	var groups []types.Group
+	paginator := iam.NewListGroupsForUserPaginator(wrapper.IamClient, &iam.ListGroupsForUserInput{
 		UserName: aws.String(userName),
 	})
-	if err != nil {
-		return nil, err
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, page.Groups...)
 	}
-	return result.Groups, nil
+	return groups, nil
 }
 
 func (wrapper iamWrapper) CreateUser(ctx context.Context, userName string) (*types.User, error) {
@@ -114,27 +157,134 @@ func (wrapper iamWrapper) CreateUser(ctx context.Context, userName string) (*typ
 	return user, err
 }
 
-func (wrapper iamWrapper) CreateUserIfNotExists(ctx context.Context, userName string) error {
-	_, err := wrapper.IamClient.CreateUser(ctx, &iam.CreateUserInput{
+// UserOptions are the attributes an IAM user is created with
+type UserOptions struct {
+	// PermissionsBoundary is the ARN of the managed policy used as the user's permissions boundary
+	PermissionsBoundary string
+	// Path of the user, "/" when empty
+	Path string
+	// Tags added to the user
+	Tags map[string]string
+}
+
+func (wrapper iamWrapper) CreateUserIfNotExists(ctx context.Context, userName string, options UserOptions) error {
+	input := &iam.CreateUserInput{
 		UserName: aws.String(userName),
-	})
-	if err != nil && !isNoSuchEntityException(err) {
+	}
+	if options.PermissionsBoundary != "" {
+		input.PermissionsBoundary = aws.String(options.PermissionsBoundary)
+	}
+	if options.Path != "" {
+		input.Path = aws.String(options.Path)
+	}
+	input.Tags = toTags(options.Tags)
+	_, err := wrapper.IamClient.CreateUser(ctx, input)
+	if err != nil && !isEntityAlreadyExistsException(err) {
 		return err
 	}
 	return nil
 }
 
-func (wrapper iamWrapper) ListUsers(ctx context.Context, maxUsers int32) ([]types.User, error) {
-	var users []types.User
-	result, err := wrapper.IamClient.ListUsers(ctx, &iam.ListUsersInput{
-		MaxItems: aws.Int32(maxUsers),
+func (wrapper iamWrapper) PutUserPermissionsBoundary(ctx context.Context, userName string, boundaryArn string) error {
+	_, err := wrapper.IamClient.PutUserPermissionsBoundary(ctx, &iam.PutUserPermissionsBoundaryInput{
+		UserName:            aws.String(userName),
+		PermissionsBoundary: aws.String(boundaryArn),
+	}, retryNoSuchEntity)
+	return err
+}
+
+func (wrapper iamWrapper) DeleteUserPermissionsBoundary(ctx context.Context, userName string) error {
+	_, err := wrapper.IamClient.DeleteUserPermissionsBoundary(ctx, &iam.DeleteUserPermissionsBoundaryInput{
+		UserName: aws.String(userName),
 	})
-	if err != nil {
-		log.Printf("Couldn't list users. Here's why: %v\n", err)
-	} else {
-		users = result.Users
+	return err
+}
+
+func (wrapper iamWrapper) UpdateUserPath(ctx context.Context, userName string, path string) error {
+	_, err := wrapper.IamClient.UpdateUser(ctx, &iam.UpdateUserInput{
+		UserName: aws.String(userName),
+		NewPath:  aws.String(path),
+	}, retryNoSuchEntity)
+	return err
+}
+
+// UpdateUserName renames the user. Its groups, policies, keys and login profile go with it.
+func (wrapper iamWrapper) UpdateUserName(ctx context.Context, userName string, newUserName string) error {
+	_, err := wrapper.IamClient.UpdateUser(ctx, &iam.UpdateUserInput{
+		UserName:    aws.String(userName),
+		NewUserName: aws.String(newUserName),
+	})
+	return err
+}
+
+func (wrapper iamWrapper) ListUserTags(ctx context.Context, userName string) (map[string]string, error) {
+	tags := map[string]string{}
+	paginator := iam.NewListUserTagsPaginator(wrapper.IamClient, &iam.ListUserTagsInput{
+		UserName: aws.String(userName),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range page.Tags {
+			tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
 	}
-	return users, err
+	return tags, nil
+}
+
+func (wrapper iamWrapper) TagUser(ctx context.Context, userName string, tags map[string]string) error {
+	_, err := wrapper.IamClient.TagUser(ctx, &iam.TagUserInput{
+		UserName: aws.String(userName),
+		Tags:     toTags(tags),
+	}, retryNoSuchEntity)
+	return err
+}
+
+func (wrapper iamWrapper) UntagUser(ctx context.Context, userName string, tagKeys []string) error {
+	_, err := wrapper.IamClient.UntagUser(ctx, &iam.UntagUserInput{
+		UserName: aws.String(userName),
+		TagKeys:  tagKeys,
+	})
+	return err
+}
+
+// toTags converts a map of tags to IAM tags, ordered by key
+func toTags(tags map[string]string) []types.Tag {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var iamTags []types.Tag
+	for _, key := range keys {
+		iamTags = append(iamTags, types.Tag{Key: aws.String(key), Value: aws.String(tags[key])})
+	}
+	return iamTags
+}
+
+// ListUsers returns up to maxUsers users whose path starts with pathPrefix, reading as many
+// pages as needed. An empty pathPrefix lists every user.
+func (wrapper iamWrapper) ListUsers(ctx context.Context, pathPrefix string, maxUsers int32) ([]types.User, error) {
+	var users []types.User
+	input := &iam.ListUsersInput{}
+	if pathPrefix != "" {
+		input.PathPrefix = aws.String(pathPrefix)
+	}
+	paginator := iam.NewListUsersPaginator(wrapper.IamClient, input)
+	for paginator.HasMorePages() && int32(len(users)) < maxUsers {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			log.Printf("Couldn't list users. Here's why: %v\n", err)
+			return nil, err
+		}
+		users = append(users, page.Users...)
+	}
+	if int32(len(users)) > maxUsers {
+		users = users[:maxUsers]
+	}
+	return users, nil
 }
 
 func (wrapper iamWrapper) CreateLoginProfile(ctx context.Context, password string, userName string, passwordResetRequired bool) (types.LoginProfile, error) {
@@ -142,7 +292,7 @@ func (wrapper iamWrapper) CreateLoginProfile(ctx context.Context, password strin
 		Password:              &password,
 		UserName:              &userName,
 		PasswordResetRequired: passwordResetRequired,
-	})
+	}, retryNoSuchEntity)
 	var loginProfile types.LoginProfile
 	if err != nil {
 		log.Printf("Couldn't create login profile. Here's why: %v\n", err)
@@ -157,8 +307,9 @@ func (wrapper iamWrapper) CreateLoginProfileIfNotExists(ctx context.Context, pas
 		Password:              &password,
 		UserName:              &userName,
 		PasswordResetRequired: passwordResetRequired,
-	})
-	if err != nil && !isNoSuchEntityException(err) {
+	}, retryNoSuchEntity)
+	// An existing login profile is what this call ensures, so it is not an error
+	if err != nil && !isEntityAlreadyExistsException(err) {
 		return err
 	}
 	return nil
@@ -167,7 +318,7 @@ func (wrapper iamWrapper) CreateLoginProfileIfNotExists(ctx context.Context, pas
 func (wrapper iamWrapper) CreateAccessKeyPair(ctx context.Context, userName string) (*types.AccessKey, error) {
 	var key *types.AccessKey
 	result, err := wrapper.IamClient.CreateAccessKey(ctx, &iam.CreateAccessKeyInput{
-		UserName: aws.String(userName)})
+		UserName: aws.String(userName)}, retryNoSuchEntity)
 	if err != nil {
 		log.Printf("Couldn't create access key pair for user %v. Here's why: %v\n", userName, err)
 	} else {
@@ -220,13 +371,18 @@ func (wrapper iamWrapper) DeleteLoginProfileIfExists(ctx context.Context, userNa
 }
 
 func (wrapper iamWrapper) ListAccessKeys(ctx context.Context, userName string) ([]types.AccessKeyMetadata, error) {
-	result, err := wrapper.IamClient.ListAccessKeys(ctx, &iam.ListAccessKeysInput{
+	var accessKeys []types.AccessKeyMetadata
+	paginator := iam.NewListAccessKeysPaginator(wrapper.IamClient, &iam.ListAccessKeysInput{
 		UserName: aws.String(userName),
 	})
-	if err != nil {
-		return nil, err
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		accessKeys = append(accessKeys, page.AccessKeyMetadata...)
 	}
-	return result.AccessKeyMetadata, nil
+	return accessKeys, nil
 }
 
 func (wrapper iamWrapper) DeleteAccessKeyIfExists(ctx context.Context, userName string, keyId string) error {
@@ -239,3 +395,231 @@ func (wrapper iamWrapper) DeleteAccessKeyIfExists(ctx context.Context, userName
 	}
 	return err
 }
+
+// UpdateAccessKeyStatus activates or deactivates an access key
+func (wrapper iamWrapper) UpdateAccessKeyStatus(ctx context.Context, userName string, keyId string, status types.StatusType) error {
+	_, err := wrapper.IamClient.UpdateAccessKey(ctx, &iam.UpdateAccessKeyInput{
+		AccessKeyId: aws.String(keyId),
+		UserName:    aws.String(userName),
+		Status:      status,
+	})
+	return err
+}
+
+func (wrapper iamWrapper) ListAttachedUserPolicies(ctx context.Context, userName string) ([]types.AttachedPolicy, error) {
+	var policies []types.AttachedPolicy
+	paginator := iam.NewListAttachedUserPoliciesPaginator(wrapper.IamClient, &iam.ListAttachedUserPoliciesInput{
+		UserName: aws.String(userName),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, page.AttachedPolicies...)
+	}
+	return policies, nil
+}
+
+func (wrapper iamWrapper) AttachUserPolicy(ctx context.Context, userName string, policyArn string) error {
+	_, err := wrapper.IamClient.AttachUserPolicy(ctx, &iam.AttachUserPolicyInput{
+		UserName:  aws.String(userName),
+		PolicyArn: aws.String(policyArn),
+	})
+	return err
+}
+
+func (wrapper iamWrapper) DetachUserPolicyIfAttached(ctx context.Context, userName string, policyArn string) error {
+	_, err := wrapper.IamClient.DetachUserPolicy(ctx, &iam.DetachUserPolicyInput{
+		UserName:  aws.String(userName),
+		PolicyArn: aws.String(policyArn),
+	})
+	if isNoSuchEntityException(err) {
+		return nil
+	}
+	return err
+}
+
+func (wrapper iamWrapper) ListUserPolicies(ctx context.Context, userName string) ([]string, error) {
+	var policyNames []string
+	paginator := iam.NewListUserPoliciesPaginator(wrapper.IamClient, &iam.ListUserPoliciesInput{
+		UserName: aws.String(userName),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		policyNames = append(policyNames, page.PolicyNames...)
+	}
+	return policyNames, nil
+}
+
+// GetUserPolicy returns the decoded policy document, or an empty string if the policy does not exist
+func (wrapper iamWrapper) GetUserPolicy(ctx context.Context, userName string, policyName string) (string, error) {
+	result, err := wrapper.IamClient.GetUserPolicy(ctx, &iam.GetUserPolicyInput{
+		UserName:   aws.String(userName),
+		PolicyName: aws.String(policyName),
+	})
+	if isNoSuchEntityException(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return decodePolicyDocument(aws.ToString(result.PolicyDocument))
+}
+
+func (wrapper iamWrapper) PutUserPolicy(ctx context.Context, userName string, policyName string, policyDocument string) error {
+	_, err := wrapper.IamClient.PutUserPolicy(ctx, &iam.PutUserPolicyInput{
+		UserName:       aws.String(userName),
+		PolicyName:     aws.String(policyName),
+		PolicyDocument: aws.String(policyDocument),
+	})
+	return err
+}
+
+func (wrapper iamWrapper) DeleteUserPolicyIfExists(ctx context.Context, userName string, policyName string) error {
+	_, err := wrapper.IamClient.DeleteUserPolicy(ctx, &iam.DeleteUserPolicyInput{
+		UserName:   aws.String(userName),
+		PolicyName: aws.String(policyName),
+	})
+	if isNoSuchEntityException(err) {
+		return nil
+	}
+	return err
+}
+
+// GetGroup returns the group and its members, or nil if the group does not exist
+func (wrapper iamWrapper) GetGroup(ctx context.Context, groupName string) (*types.Group, []types.User, error) {
+	var group *types.Group
+	var members []types.User
+	paginator := iam.NewGetGroupPaginator(wrapper.IamClient, &iam.GetGroupInput{
+		GroupName: aws.String(groupName),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if isNoSuchEntityException(err) {
+			return nil, nil, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		group = page.Group
+		members = append(members, page.Users...)
+	}
+	return group, members, nil
+}
+
+func (wrapper iamWrapper) CreateGroupIfNotExists(ctx context.Context, groupName string, path string) error {
+	_, err := wrapper.IamClient.CreateGroup(ctx, &iam.CreateGroupInput{
+		GroupName: aws.String(groupName),
+		Path:      aws.String(path),
+	})
+	if err != nil && !isEntityAlreadyExistsException(err) {
+		return err
+	}
+	return nil
+}
+
+func (wrapper iamWrapper) UpdateGroupPath(ctx context.Context, groupName string, path string) error {
+	_, err := wrapper.IamClient.UpdateGroup(ctx, &iam.UpdateGroupInput{
+		GroupName: aws.String(groupName),
+		NewPath:   aws.String(path),
+	})
+	return err
+}
+
+func (wrapper iamWrapper) DeleteGroupIfExists(ctx context.Context, groupName string) error {
+	_, err := wrapper.IamClient.DeleteGroup(ctx, &iam.DeleteGroupInput{
+		GroupName: aws.String(groupName),
+	})
+	if isNoSuchEntityException(err) {
+		return nil
+	}
+	return err
+}
+
+func (wrapper iamWrapper) ListAttachedGroupPolicies(ctx context.Context, groupName string) ([]types.AttachedPolicy, error) {
+	var policies []types.AttachedPolicy
+	paginator := iam.NewListAttachedGroupPoliciesPaginator(wrapper.IamClient, &iam.ListAttachedGroupPoliciesInput{
+		GroupName: aws.String(groupName),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, page.AttachedPolicies...)
+	}
+	return policies, nil
+}
+
+func (wrapper iamWrapper) AttachGroupPolicy(ctx context.Context, groupName string, policyArn string) error {
+	_, err := wrapper.IamClient.AttachGroupPolicy(ctx, &iam.AttachGroupPolicyInput{
+		GroupName: aws.String(groupName),
+		PolicyArn: aws.String(policyArn),
+	})
+	return err
+}
+
+func (wrapper iamWrapper) DetachGroupPolicyIfAttached(ctx context.Context, groupName string, policyArn string) error {
+	_, err := wrapper.IamClient.DetachGroupPolicy(ctx, &iam.DetachGroupPolicyInput{
+		GroupName: aws.String(groupName),
+		PolicyArn: aws.String(policyArn),
+	})
+	if isNoSuchEntityException(err) {
+		return nil
+	}
+	return err
+}
+
+func (wrapper iamWrapper) ListGroupPolicies(ctx context.Context, groupName string) ([]string, error) {
+	var policyNames []string
+	paginator := iam.NewListGroupPoliciesPaginator(wrapper.IamClient, &iam.ListGroupPoliciesInput{
+		GroupName: aws.String(groupName),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		policyNames = append(policyNames, page.PolicyNames...)
+	}
+	return policyNames, nil
+}
+
+// GetGroupPolicy returns the decoded policy document, or an empty string if the policy does not exist
+func (wrapper iamWrapper) GetGroupPolicy(ctx context.Context, groupName string, policyName string) (string, error) {
+	result, err := wrapper.IamClient.GetGroupPolicy(ctx, &iam.GetGroupPolicyInput{
+		GroupName:  aws.String(groupName),
+		PolicyName: aws.String(policyName),
+	})
+	if isNoSuchEntityException(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return decodePolicyDocument(aws.ToString(result.PolicyDocument))
+}
+
+func (wrapper iamWrapper) PutGroupPolicy(ctx context.Context, groupName string, policyName string, policyDocument string) error {
+	_, err := wrapper.IamClient.PutGroupPolicy(ctx, &iam.PutGroupPolicyInput{
+		GroupName:      aws.String(groupName),
+		PolicyName:     aws.String(policyName),
+		PolicyDocument: aws.String(policyDocument),
+	})
+	return err
+}
+
+func (wrapper iamWrapper) DeleteGroupPolicyIfExists(ctx context.Context, groupName string, policyName string) error {
+	_, err := wrapper.IamClient.DeleteGroupPolicy(ctx, &iam.DeleteGroupPolicyInput{
+		GroupName:  aws.String(groupName),
+		PolicyName: aws.String(policyName),
+	})
+	if isNoSuchEntityException(err) {
+		return nil
+	}
+	return err
+}
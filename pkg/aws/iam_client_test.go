@@ -0,0 +1,251 @@
+package aws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/Kuadrant/kuadra/pkg/audit"
+	"github.com/Kuadrant/kuadra/pkg/aws/iamfake"
+)
+
+var _ = Describe("IAM wrapper", func() {
+	ctx := context.Background()
+
+	var server *iamfake.Server
+	var wrapper *iamWrapper
+
+	BeforeEach(func() {
+		server = iamfake.NewServer()
+		wrapper = NewIamWrapperFromConfig(server.Config(), ClientOptions{
+			RequestsPerSecond: 1000,
+			Burst:             1000,
+			MaxBackoff:        time.Millisecond,
+		})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("Should manage a user and everything attached to it", func() {
+		const userName = "ib-dns"
+		options := UserOptions{
+			Path:                "/kuadra/default/",
+			PermissionsBoundary: "arn:aws:iam::aws:policy/PowerUserAccess",
+			Tags:                map[string]string{"team": "dns"},
+		}
+		Expect(wrapper.CreateUserIfNotExists(ctx, userName, options)).Should(Succeed())
+		Expect(wrapper.CreateUserIfNotExists(ctx, userName, options)).Should(Succeed())
+
+		user, err := wrapper.GetUser(ctx, userName)
+		Expect(err).Should(BeNil())
+		Expect(*user.Path).Should(Equal("/kuadra/default/"))
+		Expect(*user.Arn).Should(Equal("arn:aws:iam::" + iamfake.AccountId + ":user/kuadra/default/ib-dns"))
+		Expect(*user.PermissionsBoundary.PermissionsBoundaryArn).Should(Equal(options.PermissionsBoundary))
+		tags, err := wrapper.ListUserTags(ctx, userName)
+		Expect(err).Should(BeNil())
+		Expect(tags).Should(Equal(map[string]string{"team": "dns"}))
+
+		Expect(wrapper.CreateLoginProfileIfNotExists(ctx, "password", userName, true)).Should(Succeed())
+		Expect(wrapper.CreateLoginProfileIfNotExists(ctx, "password", userName, true)).Should(Succeed())
+		Expect(wrapper.HasLoginProfile(ctx, userName)).Should(BeTrue())
+
+		accessKey, err := wrapper.CreateAccessKeyPair(ctx, userName)
+		Expect(err).Should(BeNil())
+		Expect(*accessKey.SecretAccessKey).ShouldNot(BeEmpty())
+		Expect(wrapper.UpdateAccessKeyStatus(ctx, userName, *accessKey.AccessKeyId, types.StatusTypeInactive)).Should(Succeed())
+		accessKeys, err := wrapper.ListAccessKeys(ctx, userName)
+		Expect(err).Should(BeNil())
+		Expect(accessKeys).Should(HaveLen(1))
+		Expect(accessKeys[0].Status).Should(Equal(types.StatusTypeInactive))
+
+		Expect(wrapper.CreateGroupIfNotExists(ctx, "dns-management", "/")).Should(Succeed())
+		_, err = wrapper.AddUserToGroup(ctx, "dns-management", userName)
+		Expect(err).Should(BeNil())
+
+		document := `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Action": "route53:*", "Resource": "*"}]}`
+		Expect(wrapper.PutUserPolicy(ctx, userName, "zone", document)).Should(Succeed())
+		Expect(wrapper.GetUserPolicy(ctx, userName, "zone")).Should(Equal(document))
+		Expect(wrapper.GetUserPolicy(ctx, userName, "missing")).Should(BeEmpty())
+		Expect(wrapper.AttachUserPolicy(ctx, userName, "arn:aws:iam::aws:policy/AmazonRoute53ReadOnlyAccess")).Should(Succeed())
+
+		By("By reporting a login profile for a missing user")
+		err = wrapper.CreateLoginProfileIfNotExists(ctx, "password", "missing", true)
+		Expect(isNoSuchEntityException(err)).Should(BeTrue())
+
+		By("By refusing to delete a user that still has resources")
+		err = wrapper.DeleteUser(ctx, userName)
+		Expect(IsPermanentError(err)).Should(BeTrue())
+
+		Expect(wrapper.DeleteLoginProfileIfExists(ctx, userName)).Should(Succeed())
+		Expect(wrapper.DeleteAccessKeyIfExists(ctx, userName, *accessKey.AccessKeyId)).Should(Succeed())
+		_, err = wrapper.RemoveUserFromGroup(ctx, "dns-management", userName)
+		Expect(err).Should(BeNil())
+		Expect(wrapper.DeleteUserPolicyIfExists(ctx, userName, "zone")).Should(Succeed())
+		Expect(wrapper.DetachUserPolicyIfAttached(ctx, userName, "arn:aws:iam::aws:policy/AmazonRoute53ReadOnlyAccess")).Should(Succeed())
+		Expect(wrapper.DeleteUser(ctx, userName)).Should(Succeed())
+		Expect(wrapper.IsExistingUser(ctx, userName)).Should(BeFalse())
+		_, found := server.User(userName)
+		Expect(found).Should(BeFalse())
+	})
+
+	It("Should rename a user with its groups", func() {
+		Expect(wrapper.CreateUserIfNotExists(ctx, "ib-dns", UserOptions{})).Should(Succeed())
+		Expect(wrapper.CreateUserIfNotExists(ctx, "ib-zone", UserOptions{})).Should(Succeed())
+		Expect(wrapper.CreateGroupIfNotExists(ctx, "dns-management", "/")).Should(Succeed())
+		_, err := wrapper.AddUserToGroup(ctx, "dns-management", "ib-dns")
+		Expect(err).Should(BeNil())
+
+		Expect(wrapper.UpdateUserName(ctx, "ib-dns", "ib-route53")).Should(Succeed())
+		Expect(wrapper.IsExistingUser(ctx, "ib-dns")).Should(BeFalse())
+		groups, err := wrapper.ListGroupsForUser(ctx, "ib-route53")
+		Expect(err).Should(BeNil())
+		Expect(groups).Should(HaveLen(1))
+
+		By("By refusing to take the name of another user")
+		err = wrapper.UpdateUserName(ctx, "ib-route53", "ib-zone")
+		Expect(IsPermanentError(err)).Should(BeTrue())
+	})
+
+	It("Should follow markers across pages", func() {
+		server.PageSize = 2
+		for _, userName := range []string{"a", "b", "c", "d", "e"} {
+			Expect(wrapper.CreateUserIfNotExists(ctx, userName, UserOptions{})).Should(Succeed())
+		}
+		for _, groupName := range []string{"g1", "g2", "g3"} {
+			Expect(wrapper.CreateGroupIfNotExists(ctx, groupName, "/")).Should(Succeed())
+			_, err := wrapper.AddUserToGroup(ctx, groupName, "a")
+			Expect(err).Should(BeNil())
+		}
+
+		users, err := wrapper.ListUsers(ctx, "", 4)
+		Expect(err).Should(BeNil())
+		Expect(users).Should(HaveLen(4))
+
+		groups, err := wrapper.ListGroupsForUser(ctx, "a")
+		Expect(err).Should(BeNil())
+		Expect(groups).Should(HaveLen(3))
+		Expect(*groups[2].GroupName).Should(Equal("g3"))
+		Expect(server.Calls("ListGroupsForUser")).Should(Equal(2))
+	})
+
+	It("Should retry transient errors and return permanent ones at once", func() {
+		const userName = "ib-dns"
+		Expect(wrapper.CreateUserIfNotExists(ctx, userName, UserOptions{})).Should(Succeed())
+
+		By("By retrying throttled calls")
+		server.Throttle("GetUser", 2)
+		user, err := wrapper.GetUser(ctx, userName)
+		Expect(err).Should(BeNil())
+		Expect(user).ShouldNot(BeNil())
+		Expect(server.Calls("GetUser")).Should(Equal(3))
+
+		By("By retrying NoSuchEntity on a user that was just created")
+		server.FailNthCall("CreateAccessKey", 1, "NoSuchEntity")
+		_, err = wrapper.CreateAccessKeyPair(ctx, userName)
+		Expect(err).Should(BeNil())
+		Expect(server.Calls("CreateAccessKey")).Should(Equal(2))
+
+		By("By not retrying NoSuchEntity where it is an expected answer")
+		_, err = wrapper.AddUserToGroup(ctx, "missing", userName)
+		Expect(isNoSuchEntityException(err)).Should(BeTrue())
+		Expect(server.Calls("AddUserToGroup")).Should(Equal(1))
+		server.FailNthCall("AttachUserPolicy", 1, "NoSuchEntity")
+		err = wrapper.AttachUserPolicy(ctx, userName, "arn:aws:iam::aws:policy/Missing")
+		Expect(isNoSuchEntityException(err)).Should(BeTrue())
+		Expect(server.Calls("AttachUserPolicy")).Should(Equal(1))
+		Expect(wrapper.DeleteLoginProfileIfExists(ctx, userName)).Should(Succeed())
+		Expect(server.Calls("DeleteLoginProfile")).Should(Equal(1))
+		err = wrapper.DeleteUser(ctx, "missing")
+		Expect(isNoSuchEntityException(err)).Should(BeTrue())
+		Expect(server.Calls("DeleteUser")).Should(Equal(1))
+
+		By("By giving up on transient errors once out of attempts")
+		server.Throttle("ListUserTags", 0)
+		_, err = wrapper.ListUserTags(ctx, userName)
+		Expect(IsTransientError(err)).Should(BeTrue())
+		Expect(server.Calls("ListUserTags")).Should(Equal(DefaultMaxAttempts))
+
+		By("By not retrying exhausted quotas")
+		_, err = wrapper.CreateAccessKeyPair(ctx, userName)
+		Expect(err).Should(BeNil())
+		_, err = wrapper.CreateAccessKeyPair(ctx, userName)
+		Expect(IsPermanentError(err)).Should(BeTrue())
+		Expect(errorCode(err)).Should(Equal("LimitExceeded"))
+		Expect(server.Calls("CreateAccessKey")).Should(Equal(4))
+
+		By("By not retrying permanent errors")
+		err = wrapper.PutUserPolicy(ctx, userName, "zone", "not json")
+		Expect(IsPermanentError(err)).Should(BeTrue())
+		Expect(errorCode(err)).Should(Equal("MalformedPolicyDocument"))
+		Expect(server.Calls("PutUserPolicy")).Should(Equal(1))
+	})
+
+	It("Should share the rate limit between calls", func() {
+		wrapper = NewIamWrapperFromConfig(server.Config(), ClientOptions{RequestsPerSecond: 20, Burst: 1})
+		start := time.Now()
+		for i := 0; i < 5; i++ {
+			_, err := wrapper.GetUser(ctx, "missing")
+			Expect(err).Should(BeNil())
+		}
+		Expect(time.Since(start)).Should(BeNumerically(">=", 180*time.Millisecond))
+	})
+
+	It("Should audit the calls that change IAM", func() {
+		var buffer bytes.Buffer
+		wrapper = NewIamWrapperFromConfig(server.Config(), ClientOptions{
+			RequestsPerSecond: 1000,
+			Burst:             1000,
+			MaxBackoff:        time.Millisecond,
+			AuditLogger:       audit.NewLogger(audit.NewWriterSink(&buffer)),
+		})
+		actor := audit.Actor{Kind: "AwsAccount", Namespace: "default", Name: "ib-dns"}
+		ctx := audit.WithActor(ctx, actor)
+
+		const userName = "ib-dns"
+		Expect(wrapper.CreateUserIfNotExists(ctx, userName, UserOptions{})).Should(Succeed())
+		Expect(wrapper.CreateLoginProfileIfNotExists(ctx, "password", userName, true)).Should(Succeed())
+		Expect(wrapper.CreateGroupIfNotExists(ctx, "dns-management", "/")).Should(Succeed())
+		server.Throttle("AddUserToGroup", 1)
+		metadata, err := wrapper.AddUserToGroup(ctx, "dns-management", userName)
+		Expect(err).Should(BeNil())
+		Expect(wrapper.PutUserPolicy(ctx, userName, "zone", "not json")).ShouldNot(Succeed())
+		accessKey, err := wrapper.CreateAccessKeyPair(ctx, userName)
+		Expect(err).Should(BeNil())
+
+		var records []audit.Record
+		for _, line := range strings.Split(strings.TrimSpace(buffer.String()), "\n") {
+			var record audit.Record
+			Expect(json.Unmarshal([]byte(line), &record)).Should(Succeed())
+			records = append(records, record)
+		}
+		var operations []string
+		for _, record := range records {
+			operations = append(operations, record.Operation)
+		}
+		Expect(operations).Should(Equal([]string{"CreateUser", "CreateLoginProfile", "CreateGroup", "AddUserToGroup", "PutUserPolicy", "CreateAccessKey"}))
+		Expect(records[0].Actor).Should(Equal(actor))
+		Expect(records[1].Target).Should(Equal(map[string]string{"UserName": userName}))
+
+		requestId, _ := awsmiddleware.GetRequestIDMetadata(metadata)
+		Expect(records[3].RequestId).Should(Equal(requestId))
+		Expect(records[3].Target).Should(Equal(map[string]string{"UserName": userName, "GroupName": "dns-management"}))
+		Expect(records[3].Outcome).Should(Equal(audit.OutcomeSuccess))
+
+		Expect(records[4].Outcome).Should(Equal(audit.OutcomeFailure))
+		Expect(records[4].Error).Should(ContainSubstring("MalformedPolicyDocument"))
+		Expect(records[4].RequestId).ShouldNot(BeEmpty())
+
+		Expect(records[5].Target).Should(Equal(map[string]string{"UserName": userName, "AccessKeyId": *accessKey.AccessKeyId}))
+		Expect(audit.Verify(strings.NewReader(buffer.String()))).Should(Equal(6))
+	})
+})
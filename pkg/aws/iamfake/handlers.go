@@ -0,0 +1,845 @@
+package iamfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+type apiError struct {
+	code    string
+	status  int
+	message string
+}
+
+func errNoSuchEntity(format string, args ...interface{}) *apiError {
+	return &apiError{code: "NoSuchEntity", message: fmt.Sprintf(format, args...)}
+}
+
+func errEntityAlreadyExists(format string, args ...interface{}) *apiError {
+	return &apiError{code: "EntityAlreadyExists", message: fmt.Sprintf(format, args...)}
+}
+
+func errLimitExceeded(format string, args ...interface{}) *apiError {
+	return &apiError{code: "LimitExceeded", message: fmt.Sprintf(format, args...)}
+}
+
+func errDeleteConflict(format string, args ...interface{}) *apiError {
+	return &apiError{code: "DeleteConflict", message: fmt.Sprintf(format, args...)}
+}
+
+func errInvalidInput(format string, args ...interface{}) *apiError {
+	return &apiError{code: "InvalidInput", message: fmt.Sprintf(format, args...)}
+}
+
+// statusFor returns the HTTP status IAM answers an error code with
+func statusFor(code string) int {
+	switch code {
+	case "NoSuchEntity":
+		return http.StatusNotFound
+	case "EntityAlreadyExists", "LimitExceeded", "DeleteConflict", "ConcurrentModification":
+		return http.StatusConflict
+	case "AccessDenied":
+		return http.StatusForbidden
+	case "ServiceFailure", "InternalFailure":
+		return http.StatusInternalServerError
+	case "ServiceUnavailable":
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+type handler func(s *Server, form url.Values) (*result, *apiError)
+
+var handlers = map[string]handler{
+	"CreateUser":                    createUser,
+	"GetUser":                       getUser,
+	"UpdateUser":                    updateUser,
+	"DeleteUser":                    deleteUser,
+	"ListUsers":                     listUsers,
+	"PutUserPermissionsBoundary":    putUserPermissionsBoundary,
+	"DeleteUserPermissionsBoundary": deleteUserPermissionsBoundary,
+	"ListUserTags":                  listUserTags,
+	"TagUser":                       tagUser,
+	"UntagUser":                     untagUser,
+	"CreateLoginProfile":            createLoginProfile,
+	"GetLoginProfile":               getLoginProfile,
+	"DeleteLoginProfile":            deleteLoginProfile,
+	"CreateAccessKey":               createAccessKey,
+	"ListAccessKeys":                listAccessKeys,
+	"DeleteAccessKey":               deleteAccessKey,
+	"UpdateAccessKey":               updateAccessKey,
+	"AddUserToGroup":                addUserToGroup,
+	"RemoveUserFromGroup":           removeUserFromGroup,
+	"ListGroupsForUser":             listGroupsForUser,
+	"AttachUserPolicy":              attachUserPolicy,
+	"DetachUserPolicy":              detachUserPolicy,
+	"ListAttachedUserPolicies":      listAttachedUserPolicies,
+	"PutUserPolicy":                 putUserPolicy,
+	"GetUserPolicy":                 getUserPolicy,
+	"DeleteUserPolicy":              deleteUserPolicy,
+	"ListUserPolicies":              listUserPolicies,
+	"CreateGroup":                   createGroup,
+	"GetGroup":                      getGroup,
+	"UpdateGroup":                   updateGroup,
+	"DeleteGroup":                   deleteGroup,
+	"AttachGroupPolicy":             attachGroupPolicy,
+	"DetachGroupPolicy":             detachGroupPolicy,
+	"ListAttachedGroupPolicies":     listAttachedGroupPolicies,
+	"PutGroupPolicy":                putGroupPolicy,
+	"GetGroupPolicy":                getGroupPolicy,
+	"DeleteGroupPolicy":             deleteGroupPolicy,
+	"ListGroupPolicies":             listGroupPolicies,
+}
+
+// members reads a list parameter, encoded as Name.member.1, Name.member.2 and so on
+func members(form url.Values, name string) []string {
+	var values []string
+	for i := 1; ; i++ {
+		value, ok := form[fmt.Sprintf("%s.member.%d", name, i)]
+		if !ok {
+			return values
+		}
+		values = append(values, value[0])
+	}
+}
+
+// tags reads the Tags parameter
+func tags(form url.Values) map[string]string {
+	tags := map[string]string{}
+	for i := 1; ; i++ {
+		key, ok := form[fmt.Sprintf("Tags.member.%d.Key", i)]
+		if !ok {
+			return tags
+		}
+		tags[key[0]] = form.Get(fmt.Sprintf("Tags.member.%d.Value", i))
+	}
+}
+
+func required(form url.Values, name string) (string, *apiError) {
+	value := form.Get(name)
+	if value == "" {
+		return "", &apiError{code: "ValidationError", message: fmt.Sprintf("%s is required", name)}
+	}
+	return value, nil
+}
+
+func validPath(path string) bool {
+	return strings.HasPrefix(path, "/") && strings.HasSuffix(path, "/")
+}
+
+func validPolicyDocument(document string) *apiError {
+	var parsed map[string]interface{}
+	if err := json.Unmarshal([]byte(document), &parsed); err != nil {
+		return &apiError{code: "MalformedPolicyDocument", message: fmt.Sprintf("Syntax errors in policy: %v", err)}
+	}
+	return nil
+}
+
+func policyName(policyArn string) string {
+	return policyArn[strings.LastIndex(policyArn, "/")+1:]
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+func remove(values []string, value string) []string {
+	if i := indexOf(values, value); i != -1 {
+		return append(values[:i:i], values[i+1:]...)
+	}
+	return values
+}
+
+func (s *Server) user(form url.Values) (*User, *apiError) {
+	userName, err := required(form, "UserName")
+	if err != nil {
+		return nil, err
+	}
+	user, ok := s.users[userName]
+	if !ok {
+		return nil, errNoSuchEntity("The user with name %s cannot be found.", userName)
+	}
+	return user, nil
+}
+
+func (s *Server) group(form url.Values) (*Group, *apiError) {
+	groupName, err := required(form, "GroupName")
+	if err != nil {
+		return nil, err
+	}
+	group, ok := s.groups[groupName]
+	if !ok {
+		return nil, errNoSuchEntity("The group with name %s cannot be found.", groupName)
+	}
+	return group, nil
+}
+
+func userXml(user *User) *xmlUser {
+	x := &xmlUser{
+		Path:       user.Path,
+		UserName:   user.UserName,
+		UserId:     user.UserId,
+		Arn:        user.Arn,
+		CreateDate: formatDate(user.CreateDate),
+		Tags:       tagsXml(user.Tags),
+	}
+	if user.PermissionsBoundary != "" {
+		x.PermissionsBoundary = &xmlPermissionsBounds{
+			PermissionsBoundaryType: "PermissionsBoundaryPolicy",
+			PermissionsBoundaryArn:  user.PermissionsBoundary,
+		}
+	}
+	return x
+}
+
+func groupXml(group *Group) *xmlGroup {
+	return &xmlGroup{
+		Path:       group.Path,
+		GroupName:  group.GroupName,
+		GroupId:    group.GroupId,
+		Arn:        group.Arn,
+		CreateDate: formatDate(group.CreateDate),
+	}
+}
+
+func tagsXml(tags map[string]string) []xmlTag {
+	var x []xmlTag
+	for _, key := range sortedKeys(tags) {
+		x = append(x, xmlTag{Key: key, Value: tags[key]})
+	}
+	return x
+}
+
+func attachedPoliciesXml(policyArns []string) []xmlAttachedPolicy {
+	var x []xmlAttachedPolicy
+	for _, policyArn := range policyArns {
+		x = append(x, xmlAttachedPolicy{PolicyName: policyName(policyArn), PolicyArn: policyArn})
+	}
+	return x
+}
+
+func createUser(s *Server, form url.Values) (*result, *apiError) {
+	userName, err := required(form, "UserName")
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := s.users[userName]; ok {
+		return nil, errEntityAlreadyExists("User with name %s already exists.", userName)
+	}
+	path := form.Get("Path")
+	if path == "" {
+		path = "/"
+	}
+	if !validPath(path) {
+		return nil, errInvalidInput("The specified value for path is invalid.")
+	}
+	userTags := tags(form)
+	if len(userTags) > MaxTagsPerUser {
+		return nil, errLimitExceeded("Cannot exceed quota for TagsPerUser: %d", MaxTagsPerUser)
+	}
+	user := &User{
+		UserName:            userName,
+		UserId:              s.newId("AIDA"),
+		Path:                path,
+		Arn:                 fmt.Sprintf("arn:aws:iam::%s:user%s%s", AccountId, path, userName),
+		CreateDate:          time.Now(),
+		PermissionsBoundary: form.Get("PermissionsBoundary"),
+		Tags:                userTags,
+		InlinePolicies:      map[string]string{},
+	}
+	s.users[userName] = user
+	return &result{User: userXml(user)}, nil
+}
+
+func getUser(s *Server, form url.Values) (*result, *apiError) {
+	user, err := s.user(form)
+	if err != nil {
+		return nil, err
+	}
+	return &result{User: userXml(user)}, nil
+}
+
+func updateUser(s *Server, form url.Values) (*result, *apiError) {
+	user, err := s.user(form)
+	if err != nil {
+		return nil, err
+	}
+	if path := form.Get("NewPath"); path != "" {
+		if !validPath(path) {
+			return nil, errInvalidInput("The specified value for path is invalid.")
+		}
+		user.Path = path
+	}
+	if newName := form.Get("NewUserName"); newName != "" && newName != user.UserName {
+		if _, ok := s.users[newName]; ok {
+			return nil, errEntityAlreadyExists("User with name %s already exists.", newName)
+		}
+		delete(s.users, user.UserName)
+		user.UserName = newName
+		s.users[newName] = user
+	}
+	user.Arn = fmt.Sprintf("arn:aws:iam::%s:user%s%s", AccountId, user.Path, user.UserName)
+	return nil, nil
+}
+
+func deleteUser(s *Server, form url.Values) (*result, *apiError) {
+	user, err := s.user(form)
+	if err != nil {
+		return nil, err
+	}
+	if user.LoginProfile || len(user.AccessKeys) > 0 || len(user.Groups) > 0 || len(user.AttachedPolicyArns) > 0 || len(user.InlinePolicies) > 0 {
+		return nil, errDeleteConflict("Cannot delete entity, must remove login profile, access keys, groups and policies first.")
+	}
+	delete(s.users, user.UserName)
+	return nil, nil
+}
+
+func listUsers(s *Server, form url.Values) (*result, *apiError) {
+	prefix := form.Get("PathPrefix")
+	var names []string
+	for name, user := range s.users {
+		if strings.HasPrefix(user.Path, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	names, marker, truncated := page(s, names, form)
+	res := &result{Marker: marker, IsTruncated: truncated}
+	for _, name := range names {
+		res.Users = append(res.Users, *userXml(s.users[name]))
+	}
+	return res, nil
+}
+
+func putUserPermissionsBoundary(s *Server, form url.Values) (*result, *apiError) {
+	user, err := s.user(form)
+	if err != nil {
+		return nil, err
+	}
+	boundary, err := required(form, "PermissionsBoundary")
+	if err != nil {
+		return nil, err
+	}
+	user.PermissionsBoundary = boundary
+	return nil, nil
+}
+
+func deleteUserPermissionsBoundary(s *Server, form url.Values) (*result, *apiError) {
+	user, err := s.user(form)
+	if err != nil {
+		return nil, err
+	}
+	user.PermissionsBoundary = ""
+	return nil, nil
+}
+
+func listUserTags(s *Server, form url.Values) (*result, *apiError) {
+	user, err := s.user(form)
+	if err != nil {
+		return nil, err
+	}
+	userTags, marker, truncated := page(s, tagsXml(user.Tags), form)
+	return &result{Tags: userTags, Marker: marker, IsTruncated: truncated}, nil
+}
+
+func tagUser(s *Server, form url.Values) (*result, *apiError) {
+	user, err := s.user(form)
+	if err != nil {
+		return nil, err
+	}
+	merged := copyMap(user.Tags)
+	for key, value := range tags(form) {
+		merged[key] = value
+	}
+	if len(merged) > MaxTagsPerUser {
+		return nil, errLimitExceeded("Cannot exceed quota for TagsPerUser: %d", MaxTagsPerUser)
+	}
+	user.Tags = merged
+	return nil, nil
+}
+
+func untagUser(s *Server, form url.Values) (*result, *apiError) {
+	user, err := s.user(form)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range members(form, "TagKeys") {
+		delete(user.Tags, key)
+	}
+	return nil, nil
+}
+
+func createLoginProfile(s *Server, form url.Values) (*result, *apiError) {
+	user, err := s.user(form)
+	if err != nil {
+		return nil, err
+	}
+	if user.LoginProfile {
+		return nil, errEntityAlreadyExists("Login Profile for user %s already exists.", user.UserName)
+	}
+	if _, err := required(form, "Password"); err != nil {
+		return nil, err
+	}
+	user.LoginProfile = true
+	user.PasswordResetRequired = form.Get("PasswordResetRequired") == "true"
+	return &result{LoginProfile: &xmlLoginProfile{
+		UserName:              user.UserName,
+		CreateDate:            formatDate(time.Now()),
+		PasswordResetRequired: user.PasswordResetRequired,
+	}}, nil
+}
+
+func getLoginProfile(s *Server, form url.Values) (*result, *apiError) {
+	user, err := s.user(form)
+	if err != nil {
+		return nil, err
+	}
+	if !user.LoginProfile {
+		return nil, errNoSuchEntity("Login Profile for User %s cannot be found.", user.UserName)
+	}
+	return &result{LoginProfile: &xmlLoginProfile{
+		UserName:              user.UserName,
+		CreateDate:            formatDate(user.CreateDate),
+		PasswordResetRequired: user.PasswordResetRequired,
+	}}, nil
+}
+
+func deleteLoginProfile(s *Server, form url.Values) (*result, *apiError) {
+	user, err := s.user(form)
+	if err != nil {
+		return nil, err
+	}
+	if !user.LoginProfile {
+		return nil, errNoSuchEntity("Login Profile for User %s cannot be found.", user.UserName)
+	}
+	user.LoginProfile = false
+	return nil, nil
+}
+
+func createAccessKey(s *Server, form url.Values) (*result, *apiError) {
+	user, err := s.user(form)
+	if err != nil {
+		return nil, err
+	}
+	if len(user.AccessKeys) >= MaxAccessKeysPerUser {
+		return nil, errLimitExceeded("Cannot exceed quota for AccessKeysPerUser: %d", MaxAccessKeysPerUser)
+	}
+	key := AccessKey{
+		AccessKeyId:     s.newId("AKIA"),
+		Status:          "Active",
+		CreateDate:      time.Now(),
+		SecretAccessKey: s.newId("secret"),
+	}
+	user.AccessKeys = append(user.AccessKeys, key)
+	return &result{AccessKey: &xmlAccessKey{
+		UserName:        user.UserName,
+		AccessKeyId:     key.AccessKeyId,
+		Status:          key.Status,
+		SecretAccessKey: key.SecretAccessKey,
+		CreateDate:      formatDate(key.CreateDate),
+	}}, nil
+}
+
+func listAccessKeys(s *Server, form url.Values) (*result, *apiError) {
+	user, err := s.user(form)
+	if err != nil {
+		return nil, err
+	}
+	keys, marker, truncated := page(s, user.AccessKeys, form)
+	res := &result{Marker: marker, IsTruncated: truncated}
+	for _, key := range keys {
+		res.AccessKeyMetadata = append(res.AccessKeyMetadata, xmlAccessKey{
+			UserName:    user.UserName,
+			AccessKeyId: key.AccessKeyId,
+			Status:      key.Status,
+			CreateDate:  formatDate(key.CreateDate),
+		})
+	}
+	return res, nil
+}
+
+func deleteAccessKey(s *Server, form url.Values) (*result, *apiError) {
+	user, err := s.user(form)
+	if err != nil {
+		return nil, err
+	}
+	keyId := form.Get("AccessKeyId")
+	for i, key := range user.AccessKeys {
+		if key.AccessKeyId == keyId {
+			user.AccessKeys = append(user.AccessKeys[:i:i], user.AccessKeys[i+1:]...)
+			return nil, nil
+		}
+	}
+	return nil, errNoSuchEntity("The Access Key with id %s cannot be found.", keyId)
+}
+
+func updateAccessKey(s *Server, form url.Values) (*result, *apiError) {
+	user, err := s.user(form)
+	if err != nil {
+		return nil, err
+	}
+	status := form.Get("Status")
+	if status != "Active" && status != "Inactive" {
+		return nil, errInvalidInput("Status must be Active or Inactive")
+	}
+	keyId := form.Get("AccessKeyId")
+	for i := range user.AccessKeys {
+		if user.AccessKeys[i].AccessKeyId == keyId {
+			user.AccessKeys[i].Status = status
+			return nil, nil
+		}
+	}
+	return nil, errNoSuchEntity("The Access Key with id %s cannot be found.", keyId)
+}
+
+func addUserToGroup(s *Server, form url.Values) (*result, *apiError) {
+	group, err := s.group(form)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.user(form)
+	if err != nil {
+		return nil, err
+	}
+	if indexOf(user.Groups, group.GroupName) != -1 {
+		return nil, nil
+	}
+	if len(user.Groups) >= MaxGroupsPerUser {
+		return nil, errLimitExceeded("Cannot exceed quota for GroupsPerUser: %d", MaxGroupsPerUser)
+	}
+	user.Groups = append(user.Groups, group.GroupName)
+	return nil, nil
+}
+
+func removeUserFromGroup(s *Server, form url.Values) (*result, *apiError) {
+	group, err := s.group(form)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.user(form)
+	if err != nil {
+		return nil, err
+	}
+	user.Groups = remove(user.Groups, group.GroupName)
+	return nil, nil
+}
+
+func listGroupsForUser(s *Server, form url.Values) (*result, *apiError) {
+	user, err := s.user(form)
+	if err != nil {
+		return nil, err
+	}
+	names, marker, truncated := page(s, user.Groups, form)
+	res := &result{Marker: marker, IsTruncated: truncated}
+	for _, name := range names {
+		res.Groups = append(res.Groups, *groupXml(s.groups[name]))
+	}
+	return res, nil
+}
+
+func attachPolicy(attached []string, form url.Values) ([]string, *apiError) {
+	policyArn, err := required(form, "PolicyArn")
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(policyArn, "arn:") {
+		return nil, errInvalidInput("ARN %s is not valid.", policyArn)
+	}
+	if indexOf(attached, policyArn) == -1 {
+		attached = append(attached, policyArn)
+	}
+	return attached, nil
+}
+
+func detachPolicy(attached []string, form url.Values) ([]string, *apiError) {
+	policyArn := form.Get("PolicyArn")
+	if indexOf(attached, policyArn) == -1 {
+		return nil, errNoSuchEntity("Policy %s was not found.", policyArn)
+	}
+	return remove(attached, policyArn), nil
+}
+
+func putPolicy(policies map[string]string, form url.Values) *apiError {
+	name, err := required(form, "PolicyName")
+	if err != nil {
+		return err
+	}
+	document, err := required(form, "PolicyDocument")
+	if err != nil {
+		return err
+	}
+	if err := validPolicyDocument(document); err != nil {
+		return err
+	}
+	policies[name] = document
+	return nil
+}
+
+func attachUserPolicy(s *Server, form url.Values) (*result, *apiError) {
+	user, err := s.user(form)
+	if err != nil {
+		return nil, err
+	}
+	attached, err := attachPolicy(user.AttachedPolicyArns, form)
+	if err != nil {
+		return nil, err
+	}
+	user.AttachedPolicyArns = attached
+	return nil, nil
+}
+
+func detachUserPolicy(s *Server, form url.Values) (*result, *apiError) {
+	user, err := s.user(form)
+	if err != nil {
+		return nil, err
+	}
+	attached, err := detachPolicy(user.AttachedPolicyArns, form)
+	if err != nil {
+		return nil, err
+	}
+	user.AttachedPolicyArns = attached
+	return nil, nil
+}
+
+func listAttachedUserPolicies(s *Server, form url.Values) (*result, *apiError) {
+	user, err := s.user(form)
+	if err != nil {
+		return nil, err
+	}
+	policies, marker, truncated := page(s, attachedPoliciesXml(user.AttachedPolicyArns), form)
+	return &result{AttachedPolicies: policies, Marker: marker, IsTruncated: truncated}, nil
+}
+
+func putUserPolicy(s *Server, form url.Values) (*result, *apiError) {
+	user, err := s.user(form)
+	if err != nil {
+		return nil, err
+	}
+	return nil, putPolicy(user.InlinePolicies, form)
+}
+
+func getUserPolicy(s *Server, form url.Values) (*result, *apiError) {
+	user, err := s.user(form)
+	if err != nil {
+		return nil, err
+	}
+	name := form.Get("PolicyName")
+	document, ok := user.InlinePolicies[name]
+	if !ok {
+		return nil, errNoSuchEntity("The user policy with name %s cannot be found.", name)
+	}
+	// IAM returns policy documents URL encoded
+	return &result{UserName: user.UserName, PolicyName: name, PolicyDocument: url.QueryEscape(document)}, nil
+}
+
+func deleteUserPolicy(s *Server, form url.Values) (*result, *apiError) {
+	user, err := s.user(form)
+	if err != nil {
+		return nil, err
+	}
+	name := form.Get("PolicyName")
+	if _, ok := user.InlinePolicies[name]; !ok {
+		return nil, errNoSuchEntity("The user policy with name %s cannot be found.", name)
+	}
+	delete(user.InlinePolicies, name)
+	return nil, nil
+}
+
+func listUserPolicies(s *Server, form url.Values) (*result, *apiError) {
+	user, err := s.user(form)
+	if err != nil {
+		return nil, err
+	}
+	names, marker, truncated := page(s, sortedKeys(user.InlinePolicies), form)
+	return &result{PolicyNames: names, Marker: marker, IsTruncated: truncated}, nil
+}
+
+func createGroup(s *Server, form url.Values) (*result, *apiError) {
+	groupName, err := required(form, "GroupName")
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := s.groups[groupName]; ok {
+		return nil, errEntityAlreadyExists("Group with name %s already exists.", groupName)
+	}
+	path := form.Get("Path")
+	if path == "" {
+		path = "/"
+	}
+	if !validPath(path) {
+		return nil, errInvalidInput("The specified value for path is invalid.")
+	}
+	group := &Group{
+		GroupName:      groupName,
+		GroupId:        s.newId("AGPA"),
+		Path:           path,
+		Arn:            fmt.Sprintf("arn:aws:iam::%s:group%s%s", AccountId, path, groupName),
+		CreateDate:     time.Now(),
+		InlinePolicies: map[string]string{},
+	}
+	s.groups[groupName] = group
+	return &result{Group: groupXml(group)}, nil
+}
+
+func getGroup(s *Server, form url.Values) (*result, *apiError) {
+	group, err := s.group(form)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name, user := range s.users {
+		if indexOf(user.Groups, group.GroupName) != -1 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	names, marker, truncated := page(s, names, form)
+	res := &result{Group: groupXml(group), Marker: marker, IsTruncated: truncated}
+	for _, name := range names {
+		res.Users = append(res.Users, *userXml(s.users[name]))
+	}
+	return res, nil
+}
+
+func updateGroup(s *Server, form url.Values) (*result, *apiError) {
+	group, err := s.group(form)
+	if err != nil {
+		return nil, err
+	}
+	if path := form.Get("NewPath"); path != "" {
+		if !validPath(path) {
+			return nil, errInvalidInput("The specified value for path is invalid.")
+		}
+		group.Path = path
+	}
+	if newName := form.Get("NewGroupName"); newName != "" && newName != group.GroupName {
+		if _, ok := s.groups[newName]; ok {
+			return nil, errEntityAlreadyExists("Group with name %s already exists.", newName)
+		}
+		for _, user := range s.users {
+			if i := indexOf(user.Groups, group.GroupName); i != -1 {
+				user.Groups[i] = newName
+			}
+		}
+		delete(s.groups, group.GroupName)
+		group.GroupName = newName
+		s.groups[newName] = group
+	}
+	group.Arn = fmt.Sprintf("arn:aws:iam::%s:group%s%s", AccountId, group.Path, group.GroupName)
+	return nil, nil
+}
+
+func deleteGroup(s *Server, form url.Values) (*result, *apiError) {
+	group, err := s.group(form)
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range s.users {
+		if indexOf(user.Groups, group.GroupName) != -1 {
+			return nil, errDeleteConflict("Cannot delete entity, must remove users from group first.")
+		}
+	}
+	if len(group.AttachedPolicyArns) > 0 || len(group.InlinePolicies) > 0 {
+		return nil, errDeleteConflict("Cannot delete entity, must delete policies first.")
+	}
+	delete(s.groups, group.GroupName)
+	return nil, nil
+}
+
+func attachGroupPolicy(s *Server, form url.Values) (*result, *apiError) {
+	group, err := s.group(form)
+	if err != nil {
+		return nil, err
+	}
+	attached, err := attachPolicy(group.AttachedPolicyArns, form)
+	if err != nil {
+		return nil, err
+	}
+	group.AttachedPolicyArns = attached
+	return nil, nil
+}
+
+func detachGroupPolicy(s *Server, form url.Values) (*result, *apiError) {
+	group, err := s.group(form)
+	if err != nil {
+		return nil, err
+	}
+	attached, err := detachPolicy(group.AttachedPolicyArns, form)
+	if err != nil {
+		return nil, err
+	}
+	group.AttachedPolicyArns = attached
+	return nil, nil
+}
+
+func listAttachedGroupPolicies(s *Server, form url.Values) (*result, *apiError) {
+	group, err := s.group(form)
+	if err != nil {
+		return nil, err
+	}
+	policies, marker, truncated := page(s, attachedPoliciesXml(group.AttachedPolicyArns), form)
+	return &result{AttachedPolicies: policies, Marker: marker, IsTruncated: truncated}, nil
+}
+
+func putGroupPolicy(s *Server, form url.Values) (*result, *apiError) {
+	group, err := s.group(form)
+	if err != nil {
+		return nil, err
+	}
+	return nil, putPolicy(group.InlinePolicies, form)
+}
+
+func getGroupPolicy(s *Server, form url.Values) (*result, *apiError) {
+	group, err := s.group(form)
+	if err != nil {
+		return nil, err
+	}
+	name := form.Get("PolicyName")
+	document, ok := group.InlinePolicies[name]
+	if !ok {
+		return nil, errNoSuchEntity("The group policy with name %s cannot be found.", name)
+	}
+	return &result{GroupName: group.GroupName, PolicyName: name, PolicyDocument: url.QueryEscape(document)}, nil
+}
+
+func deleteGroupPolicy(s *Server, form url.Values) (*result, *apiError) {
+	group, err := s.group(form)
+	if err != nil {
+		return nil, err
+	}
+	name := form.Get("PolicyName")
+	if _, ok := group.InlinePolicies[name]; !ok {
+		return nil, errNoSuchEntity("The group policy with name %s cannot be found.", name)
+	}
+	delete(group.InlinePolicies, name)
+	return nil, nil
+}
+
+func listGroupPolicies(s *Server, form url.Values) (*result, *apiError) {
+	group, err := s.group(form)
+	if err != nil {
+		return nil, err
+	}
+	names, marker, truncated := page(s, sortedKeys(group.InlinePolicies), form)
+	return &result{PolicyNames: names, Marker: marker, IsTruncated: truncated}, nil
+}
@@ -0,0 +1,100 @@
+package iamfake
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+const (
+	xmlns      = "https://iam.amazonaws.com/doc/2010-05-08/"
+	dateFormat = "2006-01-02T15:04:05Z"
+)
+
+// response is the envelope of every successful IAM Query API response
+type response struct {
+	XMLName   xml.Name
+	Xmlns     string  `xml:"xmlns,attr"`
+	Result    *result `xml:",omitempty"`
+	RequestId string  `xml:"ResponseMetadata>RequestId"`
+}
+
+// result holds the fields of any operation output, only the ones set are written out
+type result struct {
+	XMLName           xml.Name
+	User              *xmlUser            `xml:",omitempty"`
+	Group             *xmlGroup           `xml:",omitempty"`
+	LoginProfile      *xmlLoginProfile    `xml:",omitempty"`
+	AccessKey         *xmlAccessKey       `xml:",omitempty"`
+	Users             []xmlUser           `xml:"Users>member"`
+	Groups            []xmlGroup          `xml:"Groups>member"`
+	AccessKeyMetadata []xmlAccessKey      `xml:"AccessKeyMetadata>member"`
+	AttachedPolicies  []xmlAttachedPolicy `xml:"AttachedPolicies>member"`
+	PolicyNames       []string            `xml:"PolicyNames>member"`
+	Tags              []xmlTag            `xml:"Tags>member"`
+	UserName          string              `xml:",omitempty"`
+	GroupName         string              `xml:",omitempty"`
+	PolicyName        string              `xml:",omitempty"`
+	PolicyDocument    string              `xml:",omitempty"`
+	IsTruncated       bool                `xml:",omitempty"`
+	Marker            string              `xml:",omitempty"`
+}
+
+type errorResponse struct {
+	XMLName   xml.Name `xml:"ErrorResponse"`
+	Xmlns     string   `xml:"xmlns,attr"`
+	Type      string   `xml:"Error>Type"`
+	Code      string   `xml:"Error>Code"`
+	Message   string   `xml:"Error>Message"`
+	RequestId string   `xml:"RequestId"`
+}
+
+type xmlUser struct {
+	Path                string                `xml:"Path"`
+	UserName            string                `xml:"UserName"`
+	UserId              string                `xml:"UserId"`
+	Arn                 string                `xml:"Arn"`
+	CreateDate          string                `xml:"CreateDate"`
+	PermissionsBoundary *xmlPermissionsBounds `xml:",omitempty"`
+	Tags                []xmlTag              `xml:"Tags>member"`
+}
+
+type xmlPermissionsBounds struct {
+	PermissionsBoundaryType string `xml:"PermissionsBoundaryType"`
+	PermissionsBoundaryArn  string `xml:"PermissionsBoundaryArn"`
+}
+
+type xmlGroup struct {
+	Path       string `xml:"Path"`
+	GroupName  string `xml:"GroupName"`
+	GroupId    string `xml:"GroupId"`
+	Arn        string `xml:"Arn"`
+	CreateDate string `xml:"CreateDate"`
+}
+
+type xmlLoginProfile struct {
+	UserName              string `xml:"UserName"`
+	CreateDate            string `xml:"CreateDate"`
+	PasswordResetRequired bool   `xml:"PasswordResetRequired"`
+}
+
+type xmlAccessKey struct {
+	UserName        string `xml:"UserName"`
+	AccessKeyId     string `xml:"AccessKeyId"`
+	Status          string `xml:"Status"`
+	SecretAccessKey string `xml:",omitempty"`
+	CreateDate      string `xml:"CreateDate"`
+}
+
+type xmlAttachedPolicy struct {
+	PolicyName string `xml:"PolicyName"`
+	PolicyArn  string `xml:"PolicyArn"`
+}
+
+type xmlTag struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+func formatDate(t time.Time) string {
+	return t.UTC().Format(dateFormat)
+}
@@ -0,0 +1,350 @@
+// Package iamfake is an in-memory implementation of the IAM Query API served over HTTP.
+// Pointing the real SDK client at it exercises the IAM wrapper and the controllers
+// end to end without an AWS account, and faults can be injected to test retries.
+package iamfake
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+const (
+	// AccountId is the AWS account every resource of the fake belongs to
+	AccountId = "123456789012"
+	// Region the fake signs as; IAM is a global service
+	Region = "us-east-1"
+
+	// MaxAccessKeysPerUser is the IAM quota of access keys, creating more fails with LimitExceeded
+	MaxAccessKeysPerUser = 2
+	// MaxGroupsPerUser is the IAM quota of group memberships of a user
+	MaxGroupsPerUser = 10
+	// MaxTagsPerUser is the IAM quota of tags on a user
+	MaxTagsPerUser = 50
+
+	defaultPageSize = 100
+)
+
+// User is a snapshot of an IAM user held by the server
+type User struct {
+	UserName              string
+	UserId                string
+	Path                  string
+	Arn                   string
+	CreateDate            time.Time
+	PermissionsBoundary   string
+	Tags                  map[string]string
+	LoginProfile          bool
+	PasswordResetRequired bool
+	AccessKeys            []AccessKey
+	Groups                []string
+	AttachedPolicyArns    []string
+	InlinePolicies        map[string]string
+}
+
+// AccessKey is an access key of a User
+type AccessKey struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	Status          string
+	CreateDate      time.Time
+}
+
+// Group is a snapshot of an IAM group held by the server. Members are recorded on the
+// users, in User.Groups.
+type Group struct {
+	GroupName          string
+	GroupId            string
+	Path               string
+	Arn                string
+	CreateDate         time.Time
+	AttachedPolicyArns []string
+	InlinePolicies     map[string]string
+}
+
+// Fault changes how the server answers calls to an action
+type Fault struct {
+	// Action the fault applies to, every action when empty
+	Action string
+	// Code of the error returned, for example Throttling. A fault without a code only adds
+	// Latency.
+	Code string
+	// Status is the HTTP status of the error, derived from Code when 0
+	Status int
+	// Latency delays the response
+	Latency time.Duration
+	// Skip lets that many matching calls through before the fault applies
+	Skip int
+	// Times is the number of calls the fault applies to, every call after Skip when 0
+	Times int
+}
+
+type activeFault struct {
+	Fault
+	seen    int
+	applied int
+}
+
+// Server is an httptest server implementing the IAM Query API
+type Server struct {
+	*httptest.Server
+
+	// PageSize is the number of items list operations return per page when the caller does
+	// not ask for fewer. It defaults to the IAM default of 100.
+	PageSize int
+
+	mu     sync.Mutex
+	users  map[string]*User
+	groups map[string]*Group
+	faults []*activeFault
+	calls  map[string]int
+	nextId int
+}
+
+// NewServer starts a server with no users or groups. Close it once done.
+func NewServer() *Server {
+	s := &Server{
+		PageSize: defaultPageSize,
+		users:    map[string]*User{},
+		groups:   map[string]*Group{},
+		calls:    map[string]int{},
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// Config returns an SDK configuration that sends IAM requests to the server with static
+// credentials
+func (s *Server) Config() aws.Config {
+	return aws.Config{
+		Region: Region,
+		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "AKIAIAMFAKE", SecretAccessKey: "iamfake", Source: "iamfake"}, nil
+		}),
+		EndpointResolverWithOptions: aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+			return aws.Endpoint{URL: s.URL, SigningRegion: Region, HostnameImmutable: true}, nil
+		}),
+		HTTPClient: s.Client(),
+	}
+}
+
+// User returns a copy of the named user
+func (s *Server) User(userName string) (User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userName]
+	if !ok {
+		return User{}, false
+	}
+	return copyUser(user), true
+}
+
+// Group returns a copy of the named group
+func (s *Server) Group(groupName string) (Group, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	group, ok := s.groups[groupName]
+	if !ok {
+		return Group{}, false
+	}
+	return copyGroup(group), true
+}
+
+// Calls returns how many times action was called, failed calls included
+func (s *Server) Calls(action string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[action]
+}
+
+// InjectFault adds a fault. Faults are checked in the order they were added and the first
+// one returning an error wins; latencies of all matching faults add up.
+func (s *Server) InjectFault(fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &activeFault{Fault: fault})
+}
+
+// Throttle fails the next times calls of action with a Throttling error
+func (s *Server) Throttle(action string, times int) {
+	s.InjectFault(Fault{Action: action, Code: "Throttling", Times: times})
+}
+
+// FailNthCall fails only the nth call of action from now on with the given error code
+func (s *Server) FailNthCall(action string, n int, code string) {
+	s.InjectFault(Fault{Action: action, Code: code, Skip: n - 1, Times: 1})
+}
+
+// SetLatency delays every response by latency
+func (s *Server) SetLatency(latency time.Duration) {
+	s.InjectFault(Fault{Latency: latency})
+}
+
+// ClearFaults removes all injected faults
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// ServeHTTP answers an IAM Query API request
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	requestId := s.requestId()
+	s.mu.Unlock()
+	if err := r.ParseForm(); err != nil {
+		s.writeError(w, requestId, errInvalidInput("malformed request body: %v", err))
+		return
+	}
+	action := r.Form.Get("Action")
+
+	s.mu.Lock()
+	s.calls[action]++
+	latency, fault := s.matchFaults(action)
+	s.mu.Unlock()
+
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	if fault != nil {
+		s.writeError(w, requestId, fault)
+		return
+	}
+
+	handler, ok := handlers[action]
+	if !ok {
+		s.writeError(w, requestId, &apiError{code: "InvalidAction", status: http.StatusBadRequest, message: fmt.Sprintf("unsupported action %q", action)})
+		return
+	}
+	s.mu.Lock()
+	res, apiErr := handler(s, r.Form)
+	s.mu.Unlock()
+	if apiErr != nil {
+		s.writeError(w, requestId, apiErr)
+		return
+	}
+
+	if res == nil {
+		res = &result{}
+	}
+	res.XMLName = xml.Name{Local: action + "Result"}
+	s.write(w, requestId, http.StatusOK, response{
+		XMLName:   xml.Name{Local: action + "Response"},
+		Xmlns:     xmlns,
+		Result:    res,
+		RequestId: requestId,
+	})
+}
+
+// matchFaults returns the added latency and the error of the faults applying to this call
+func (s *Server) matchFaults(action string) (time.Duration, *apiError) {
+	var latency time.Duration
+	var fault *apiError
+	for _, f := range s.faults {
+		if f.Action != "" && f.Action != action {
+			continue
+		}
+		f.seen++
+		if f.seen <= f.Skip || (f.Times > 0 && f.applied >= f.Times) {
+			continue
+		}
+		if f.Code != "" && fault != nil {
+			continue
+		}
+		f.applied++
+		latency += f.Latency
+		if f.Code != "" {
+			fault = &apiError{code: f.Code, status: f.Status, message: "injected fault"}
+		}
+	}
+	return latency, fault
+}
+
+func (s *Server) requestId() string {
+	s.nextId++
+	return fmt.Sprintf("00000000-0000-4000-8000-%012d", s.nextId)
+}
+
+func (s *Server) newId(prefix string) string {
+	s.nextId++
+	return fmt.Sprintf("%s%016d", prefix, s.nextId)
+}
+
+func (s *Server) writeError(w http.ResponseWriter, requestId string, err *apiError) {
+	status := err.status
+	if status == 0 {
+		status = statusFor(err.code)
+	}
+	errorType := "Sender"
+	if status >= 500 {
+		errorType = "Receiver"
+	}
+	s.write(w, requestId, status, errorResponse{
+		Xmlns:     xmlns,
+		Type:      errorType,
+		Code:      err.code,
+		Message:   err.message,
+		RequestId: requestId,
+	})
+}
+
+func (s *Server) write(w http.ResponseWriter, requestId string, status int, body interface{}) {
+	w.Header().Set("Content-Type", "text/xml")
+	w.Header().Set("X-Amzn-Requestid", requestId)
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(xml.Header))
+	_ = xml.NewEncoder(w).Encode(body)
+}
+
+// page returns the items of the page starting at the request's Marker
+func page[T any](s *Server, items []T, form url.Values) ([]T, string, bool) {
+	start := 0
+	if marker := form.Get("Marker"); marker != "" {
+		start, _ = strconv.Atoi(marker)
+	}
+	if start > len(items) {
+		start = len(items)
+	}
+	size := s.PageSize
+	if maxItems, err := strconv.Atoi(form.Get("MaxItems")); err == nil && maxItems > 0 && maxItems < size {
+		size = maxItems
+	}
+	end := start + size
+	if end >= len(items) {
+		return items[start:], "", false
+	}
+	return items[start:end], strconv.Itoa(end), true
+}
+
+func copyUser(user *User) User {
+	c := *user
+	c.Tags = copyMap(user.Tags)
+	c.InlinePolicies = copyMap(user.InlinePolicies)
+	c.AccessKeys = append([]AccessKey(nil), user.AccessKeys...)
+	c.Groups = append([]string(nil), user.Groups...)
+	c.AttachedPolicyArns = append([]string(nil), user.AttachedPolicyArns...)
+	return c
+}
+
+func copyGroup(group *Group) Group {
+	c := *group
+	c.InlinePolicies = copyMap(group.InlinePolicies)
+	c.AttachedPolicyArns = append([]string(nil), group.AttachedPolicyArns...)
+	return c
+}
+
+func copyMap(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,65 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	apiRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kuadra_aws_api_requests_total",
+		Help: "Number of AWS API request attempts, retries included",
+	}, []string{"service", "operation"})
+
+	apiErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kuadra_aws_api_errors_total",
+		Help: "Number of failed AWS API request attempts, by AWS error code",
+	}, []string{"service", "operation", "error_code"})
+
+	apiRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kuadra_aws_api_request_duration_seconds",
+		Help:    "Latency of AWS API request attempts",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"service", "operation"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(apiRequestsTotal, apiErrorsTotal, apiRequestDuration)
+}
+
+// addMetricsMiddleware records every attempt of an API call. It runs after the retry
+// middleware so that throttled attempts that succeed on retry are still counted.
+func addMetricsMiddleware(stack *middleware.Stack) error {
+	return stack.Finalize.Insert(middleware.FinalizeMiddlewareFunc("KuadraMetrics",
+		func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+			service := awsmiddleware.GetServiceID(ctx)
+			operation := awsmiddleware.GetOperationName(ctx)
+			start := time.Now()
+
+			out, metadata, err := next.HandleFinalize(ctx, in)
+
+			apiRequestsTotal.WithLabelValues(service, operation).Inc()
+			apiRequestDuration.WithLabelValues(service, operation).Observe(time.Since(start).Seconds())
+			if err != nil {
+				apiErrorsTotal.WithLabelValues(service, operation, errorCode(err)).Inc()
+			}
+			return out, metadata, err
+		}), "Retry", middleware.After)
+}
+
+// errorCode returns the AWS error code of an API error, or "Unknown" for errors that did
+// not come from the service, such as connection failures
+func errorCode(err error) string {
+	var apiError smithy.APIError
+	if errors.As(err, &apiError) {
+		return apiError.ErrorCode()
+	}
+	return "Unknown"
+}
@@ -0,0 +1,94 @@
+package aws
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/smithy-go/middleware"
+	"golang.org/x/time/rate"
+
+	"github.com/Kuadrant/kuadra/pkg/audit"
+)
+
+const (
+	DefaultRequestsPerSecond = 10
+	DefaultBurst             = 20
+	DefaultMaxAttempts       = 5
+	DefaultMaxBackoff        = 20 * time.Second
+)
+
+// ClientOptions tunes how hard the IAM client pushes against the IAM API rate limits,
+// which are shared by every caller in the AWS account
+type ClientOptions struct {
+	// RequestsPerSecond is the sustained rate of requests the client sends, retries included
+	RequestsPerSecond float64
+	// Burst is the number of requests that can be sent at once before the rate applies
+	Burst int
+	// MaxAttempts is the number of times a call failing with a transient error is tried
+	MaxAttempts int
+	// MaxBackoff caps the delay between two attempts of the same call
+	MaxBackoff time.Duration
+	// AuditLogger records every call changing something in IAM when set
+	AuditLogger *audit.Logger
+}
+
+func (o ClientOptions) withDefaults() ClientOptions {
+	if o.RequestsPerSecond <= 0 {
+		o.RequestsPerSecond = DefaultRequestsPerSecond
+	}
+	if o.Burst <= 0 {
+		o.Burst = DefaultBurst
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = DefaultMaxAttempts
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = DefaultMaxBackoff
+	}
+	return o
+}
+
+// newRetryer retries the transient errors of IsTransientError with exponential backoff.
+// The standard retryer already covers throttling and server faults, IAM additionally
+// reports conflicting writes and its own failures with its own codes. LimitExceeded is
+// left out: IAM returns it for exhausted quotas, which retrying cannot fix.
+func newRetryer(options ClientOptions) aws.Retryer {
+	return retry.NewStandard(func(o *retry.StandardOptions) {
+		o.MaxAttempts = options.MaxAttempts
+		o.MaxBackoff = options.MaxBackoff
+		o.Retryables = append(o.Retryables, retry.RetryableErrorCode{
+			Codes: map[string]struct{}{
+				"ConcurrentModification": {},
+				"ServiceFailure":         {},
+			},
+		})
+	})
+}
+
+// retryNoSuchEntity is passed to the calls made on a user right after CreateUser, before
+// any of them has seen the user. IAM is eventually consistent and can report the new user
+// as missing for a few seconds. Calls where NoSuchEntity is an expected answer, such as
+// deletes, or where it usually means that another entity is missing, such as the group of
+// AddUserToGroup or the policy of AttachUserPolicy, must not use it, or every missing
+// entity costs a full round of backoff.
+func retryNoSuchEntity(o *iam.Options) {
+	o.Retryer = retry.AddWithErrorCodes(o.Retryer, "NoSuchEntity")
+}
+
+// addRateLimitMiddleware makes every attempt of a call wait for a token from limiter.
+// The limiter is shared by everything using the client, so all reconcilers together stay
+// under the configured rate.
+func addRateLimitMiddleware(limiter *rate.Limiter) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Finalize.Insert(middleware.FinalizeMiddlewareFunc("KuadraRateLimit",
+			func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+				if err := limiter.Wait(ctx); err != nil {
+					return middleware.FinalizeOutput{}, middleware.Metadata{}, err
+				}
+				return next.HandleFinalize(ctx, in)
+			}), "Retry", middleware.After)
+	}
+}
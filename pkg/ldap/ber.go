@@ -0,0 +1,200 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Class is the class of a BER tag
+type Class byte
+
+const (
+	ClassUniversal   Class = 0
+	ClassApplication Class = 1
+	ClassContext     Class = 2
+)
+
+// Universal tags used by LDAP
+const (
+	TagBoolean     = 1
+	TagInteger     = 2
+	TagOctetString = 4
+	TagNull        = 5
+	TagEnumerated  = 10
+	TagSequence    = 16
+	TagSet         = 17
+)
+
+// maxPacketSize bounds the length of a packet read, so that a broken peer cannot make the
+// reader allocate without limit
+const maxPacketSize = 16 << 20
+
+// Packet is a BER element as used by LDAP: definite lengths and tags below 31 only. A
+// constructed packet holds its elements in Children, a primitive one its content in Value.
+type Packet struct {
+	Class       Class
+	Constructed bool
+	Tag         int
+	Value       []byte
+	Children    []*Packet
+}
+
+// NewSequence returns a constructed packet holding children
+func NewSequence(class Class, tag int, children ...*Packet) *Packet {
+	return &Packet{Class: class, Constructed: true, Tag: tag, Children: children}
+}
+
+// NewString returns a primitive packet holding value
+func NewString(class Class, tag int, value string) *Packet {
+	return &Packet{Class: class, Tag: tag, Value: []byte(value)}
+}
+
+// NewInteger returns a primitive packet holding value in two's complement
+func NewInteger(class Class, tag int, value int64) *Packet {
+	var content []byte
+	for {
+		content = append([]byte{byte(value)}, content...)
+		value >>= 8
+		if (value == 0 && content[0]&0x80 == 0) || (value == -1 && content[0]&0x80 != 0) {
+			break
+		}
+	}
+	return &Packet{Class: class, Tag: tag, Value: content}
+}
+
+// NewBoolean returns a primitive packet holding value
+func NewBoolean(class Class, tag int, value bool) *Packet {
+	content := byte(0)
+	if value {
+		content = 0xff
+	}
+	return &Packet{Class: class, Tag: tag, Value: []byte{content}}
+}
+
+// Is reports whether the packet has the class and tag
+func (p *Packet) Is(class Class, tag int) bool {
+	return p.Class == class && p.Tag == tag
+}
+
+// String returns the content of a primitive packet as a string
+func (p *Packet) String() string {
+	return string(p.Value)
+}
+
+// Int returns the content of an integer, enumerated or boolean packet
+func (p *Packet) Int() (int64, error) {
+	if len(p.Value) == 0 || len(p.Value) > 8 {
+		return 0, fmt.Errorf("invalid integer of %d bytes", len(p.Value))
+	}
+	value := int64(int8(p.Value[0]))
+	for _, b := range p.Value[1:] {
+		value = value<<8 | int64(b)
+	}
+	return value, nil
+}
+
+// Child returns the i-th element of a constructed packet, or an error naming what was expected
+func (p *Packet) Child(i int, what string) (*Packet, error) {
+	if i >= len(p.Children) {
+		return nil, fmt.Errorf("missing %s", what)
+	}
+	return p.Children[i], nil
+}
+
+// Bytes encodes the packet
+func (p *Packet) Bytes() []byte {
+	content := p.Value
+	if p.Constructed {
+		content = nil
+		for _, child := range p.Children {
+			content = append(content, child.Bytes()...)
+		}
+	}
+	identifier := byte(p.Class)<<6 | byte(p.Tag)
+	if p.Constructed {
+		identifier |= 0x20
+	}
+	encoded := []byte{identifier}
+	if length := len(content); length < 0x80 {
+		encoded = append(encoded, byte(length))
+	} else {
+		var lengthBytes []byte
+		for ; length > 0; length >>= 8 {
+			lengthBytes = append([]byte{byte(length)}, lengthBytes...)
+		}
+		encoded = append(encoded, 0x80|byte(len(lengthBytes)))
+		encoded = append(encoded, lengthBytes...)
+	}
+	return append(encoded, content...)
+}
+
+// ReadPacket reads one packet from r
+func ReadPacket(r *bufio.Reader) (*Packet, error) {
+	identifier, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if identifier&0x1f == 0x1f {
+		return nil, errors.New("multi-byte BER tags are not supported")
+	}
+	lengthByte, err := r.ReadByte()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	length := int(lengthByte)
+	if lengthByte&0x80 != 0 {
+		count := int(lengthByte & 0x7f)
+		if count == 0 || count > 4 {
+			return nil, errors.New("indefinite or oversized BER lengths are not supported")
+		}
+		length = 0
+		for i := 0; i < count; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxPacketSize {
+		return nil, fmt.Errorf("packet of %d bytes is too large", length)
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return decodePacket(identifier, content)
+}
+
+func decodePacket(identifier byte, content []byte) (*Packet, error) {
+	p := &Packet{
+		Class:       Class(identifier >> 6),
+		Constructed: identifier&0x20 != 0,
+		Tag:         int(identifier & 0x1f),
+	}
+	if !p.Constructed {
+		p.Value = content
+		return p, nil
+	}
+	r := bufio.NewReader(bytes.NewReader(content))
+	for {
+		child, err := ReadPacket(r)
+		if err == io.EOF {
+			return p, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		p.Children = append(p.Children, child)
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,487 @@
+// Package ldap is a minimal LDAPv3 client (RFC 4511) implementing what directory sync
+// needs: StartTLS, simple binds and paged searches. It stands in for a full client library
+// such as go-ldap, which is not a dependency of the module, and only needs the standard library.
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Application tags of the protocol operations
+const (
+	OpBindRequest           = 0
+	OpBindResponse          = 1
+	OpUnbindRequest         = 2
+	OpSearchRequest         = 3
+	OpSearchResultEntry     = 4
+	OpSearchResultDone      = 5
+	OpSearchResultReference = 19
+	OpExtendedRequest       = 23
+	OpExtendedResponse      = 24
+)
+
+// Result codes checked by callers
+const (
+	ResultSuccess            = 0
+	ResultNoSuchObject       = 32
+	ResultInvalidCredentials = 49
+	ResultUnavailable        = 52
+)
+
+// PagedResultsOID is the simple paged results control of RFC 2696
+const PagedResultsOID = "1.2.840.113556.1.4.319"
+
+// StartTLSOID is the extended operation of RFC 4511 section 4.14 starting TLS
+const StartTLSOID = "1.3.6.1.4.1.1466.20037"
+
+// Scope of a search
+type Scope int
+
+const (
+	ScopeBaseObject   Scope = 0
+	ScopeSingleLevel  Scope = 1
+	ScopeWholeSubtree Scope = 2
+)
+
+// defaultPageSize is the page size of searches that do not set one
+const defaultPageSize = 500
+
+// Error is an LDAP result other than success
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("LDAP result code %d", e.Code)
+	}
+	return fmt.Sprintf("LDAP result code %d: %s", e.Code, e.Message)
+}
+
+// IsResultCode reports whether err is an LDAP result with the code
+func IsResultCode(err error, code int) bool {
+	var ldapError *Error
+	return errors.As(err, &ldapError) && ldapError.Code == code
+}
+
+// Entry is a directory entry returned by a search
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// Values returns the values of an attribute, whose name is case insensitive
+func (e *Entry) Values(attribute string) []string {
+	if values, ok := e.Attributes[attribute]; ok {
+		return values
+	}
+	for name, values := range e.Attributes {
+		if strings.EqualFold(name, attribute) {
+			return values
+		}
+	}
+	return nil
+}
+
+// Value returns the first value of an attribute, or "" if it has none
+func (e *Entry) Value(attribute string) string {
+	if values := e.Values(attribute); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// SearchRequest describes a search
+type SearchRequest struct {
+	BaseDN string
+	Scope  Scope
+	// Filter in its string form, (objectClass=*) if empty
+	Filter string
+	// Attributes to return, all user attributes if empty
+	Attributes []string
+	// PageSize of the paged results control, defaultPageSize if zero
+	PageSize int
+}
+
+// Conn is a connection to a directory server. Operations are sent one at a time.
+type Conn struct {
+	mu        sync.Mutex
+	conn      net.Conn
+	reader    *bufio.Reader
+	messageId int64
+	// host is verified against the certificate of the server by StartTLS
+	host string
+}
+
+// Dial connects to an ldap:// or ldaps:// URL. tlsConfig is used by ldaps:// and may be nil.
+// An ldap:// connection is not encrypted until StartTLS is called.
+func Dial(ctx context.Context, rawURL string, tlsConfig *tls.Config) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP URL %q: %w", rawURL, err)
+	}
+	host := u.Host
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", host)
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		config := &tls.Config{}
+		if tlsConfig != nil {
+			config = tlsConfig.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName = u.Hostname()
+		}
+		conn, err = (&tls.Dialer{Config: config}).DialContext(ctx, "tcp", host)
+	default:
+		return nil, fmt.Errorf("invalid LDAP URL %q: scheme must be ldap or ldaps", rawURL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := NewConn(conn)
+	c.host = u.Hostname()
+	return c, nil
+}
+
+// NewConn returns a Conn speaking LDAP over an established connection
+func NewConn(conn net.Conn) *Conn {
+	return &Conn{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// Close sends an unbind request and closes the connection
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messageId++
+	_ = c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	_, _ = c.conn.Write(Message(c.messageId, &Packet{Class: ClassApplication, Tag: OpUnbindRequest}).Bytes())
+	return c.conn.Close()
+}
+
+// StartTLS upgrades an ldap:// connection to TLS before anything else is sent over it.
+// tlsConfig may be nil, and its server name defaults to the host of the URL dialed.
+func (c *Conn) StartTLS(ctx context.Context, tlsConfig *tls.Config) error {
+	request := NewSequence(ClassApplication, OpExtendedRequest, NewString(ClassContext, 0, StartTLSOID))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.conn.(*tls.Conn); ok {
+		return errors.New("the connection already uses TLS")
+	}
+	id, err := c.send(ctx, request)
+	if err != nil {
+		return err
+	}
+	response, _, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if !response.Is(ClassApplication, OpExtendedResponse) {
+		return fmt.Errorf("unexpected response to StartTLS with tag %d", response.Tag)
+	}
+	if err := resultError(response); err != nil {
+		return err
+	}
+
+	config := &tls.Config{}
+	if tlsConfig != nil {
+		config = tlsConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = c.host
+	}
+	conn := tls.Client(c.conn, config)
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return fmt.Errorf("TLS handshake failed: %w", err)
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+	return nil
+}
+
+// Bind authenticates with a simple bind. An empty dn and password bind anonymously.
+func (c *Conn) Bind(ctx context.Context, dn string, password string) error {
+	request := NewSequence(ClassApplication, OpBindRequest,
+		NewInteger(ClassUniversal, TagInteger, 3),
+		NewString(ClassUniversal, TagOctetString, dn),
+		NewString(ClassContext, 0, password))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id, err := c.send(ctx, request)
+	if err != nil {
+		return err
+	}
+	response, _, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if !response.Is(ClassApplication, OpBindResponse) {
+		return fmt.Errorf("unexpected response to bind with tag %d", response.Tag)
+	}
+	return resultError(response)
+}
+
+// Search returns the entries matching a request, following pages until the server
+// returns the last one. Search result references are ignored.
+func (c *Conn) Search(ctx context.Context, request SearchRequest) ([]*Entry, error) {
+	filterString := request.Filter
+	if filterString == "" {
+		filterString = "(objectClass=*)"
+	}
+	filter, err := ParseFilter(filterString)
+	if err != nil {
+		return nil, err
+	}
+	pageSize := request.PageSize
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+	attributes := NewSequence(ClassUniversal, TagSequence)
+	for _, attribute := range request.Attributes {
+		attributes.Children = append(attributes.Children, NewString(ClassUniversal, TagOctetString, attribute))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var entries []*Entry
+	cookie := ""
+	for {
+		search := NewSequence(ClassApplication, OpSearchRequest,
+			NewString(ClassUniversal, TagOctetString, request.BaseDN),
+			NewInteger(ClassUniversal, TagEnumerated, int64(request.Scope)),
+			NewInteger(ClassUniversal, TagEnumerated, 0),
+			NewInteger(ClassUniversal, TagInteger, 0),
+			NewInteger(ClassUniversal, TagInteger, 0),
+			NewBoolean(ClassUniversal, TagBoolean, false),
+			filter.Packet(),
+			attributes)
+		id, err := c.send(ctx, search, PagedResultsControl(pageSize, cookie))
+		if err != nil {
+			return nil, err
+		}
+
+		for {
+			response, controls, err := c.receive(id)
+			if err != nil {
+				return nil, err
+			}
+			if response.Is(ClassApplication, OpSearchResultEntry) {
+				entry, err := decodeEntry(response)
+				if err != nil {
+					return nil, err
+				}
+				entries = append(entries, entry)
+				continue
+			}
+			if response.Is(ClassApplication, OpSearchResultReference) {
+				continue
+			}
+			if !response.Is(ClassApplication, OpSearchResultDone) {
+				return nil, fmt.Errorf("unexpected response to search with tag %d", response.Tag)
+			}
+			if err := resultError(response); err != nil {
+				return nil, err
+			}
+			cookie = pagedResultsCookie(controls)
+			break
+		}
+		if cookie == "" {
+			return entries, nil
+		}
+	}
+}
+
+// PagedResultsControl returns the control requesting a page of size results after cookie
+func PagedResultsControl(size int, cookie string) *Packet {
+	value := NewSequence(ClassUniversal, TagSequence,
+		NewInteger(ClassUniversal, TagInteger, int64(size)),
+		NewString(ClassUniversal, TagOctetString, cookie))
+	return NewSequence(ClassUniversal, TagSequence,
+		NewString(ClassUniversal, TagOctetString, PagedResultsOID),
+		&Packet{Class: ClassUniversal, Tag: TagOctetString, Value: value.Bytes()})
+}
+
+// DecodePagedResultsControl returns the size and cookie of a paged results control, and
+// false if the control is another one
+func DecodePagedResultsControl(control *Packet) (int, string, bool) {
+	if len(control.Children) < 2 || control.Children[0].String() != PagedResultsOID {
+		return 0, "", false
+	}
+	encoded := control.Children[len(control.Children)-1].Value
+	value, err := ReadPacket(bufio.NewReader(strings.NewReader(string(encoded))))
+	if err != nil || len(value.Children) != 2 {
+		return 0, "", false
+	}
+	size, err := value.Children[0].Int()
+	if err != nil {
+		return 0, "", false
+	}
+	return int(size), value.Children[1].String(), true
+}
+
+func pagedResultsCookie(controls []*Packet) string {
+	for _, control := range controls {
+		if _, cookie, ok := DecodePagedResultsControl(control); ok {
+			return cookie
+		}
+	}
+	return ""
+}
+
+// send writes a request with the next message ID and returns the ID
+func (c *Conn) send(ctx context.Context, operation *Packet, controls ...*Packet) (int64, error) {
+	// A zero deadline, when ctx has none, clears the one of a previous operation
+	deadline, _ := ctx.Deadline()
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return 0, err
+	}
+	c.messageId++
+	if _, err := c.conn.Write(Message(c.messageId, operation, controls...).Bytes()); err != nil {
+		return 0, err
+	}
+	return c.messageId, nil
+}
+
+// receive reads the next response to message id, returning its operation and controls
+func (c *Conn) receive(id int64) (*Packet, []*Packet, error) {
+	for {
+		response, err := ReadPacket(c.reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(response.Children) < 2 {
+			return nil, nil, errors.New("malformed LDAP message")
+		}
+		responseId, err := response.Children[0].Int()
+		if err != nil {
+			return nil, nil, err
+		}
+		// Unsolicited notifications have ID 0, such as the notice of disconnection
+		if responseId == 0 {
+			if err := resultError(response.Children[1]); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if responseId != id {
+			return nil, nil, fmt.Errorf("response to message %d while waiting for %d", responseId, id)
+		}
+		var controls []*Packet
+		if len(response.Children) > 2 && response.Children[2].Is(ClassContext, 0) {
+			controls = response.Children[2].Children
+		}
+		return response.Children[1], controls, nil
+	}
+}
+
+// Message wraps an operation and its controls in an LDAPMessage
+func Message(id int64, operation *Packet, controls ...*Packet) *Packet {
+	message := NewSequence(ClassUniversal, TagSequence, NewInteger(ClassUniversal, TagInteger, id), operation)
+	if len(controls) > 0 {
+		message.Children = append(message.Children, NewSequence(ClassContext, 0, controls...))
+	}
+	return message
+}
+
+// Result returns the LDAPResult sequence of a response with code, matched DN and message
+func Result(tag int, code int, message string) *Packet {
+	return NewSequence(ClassApplication, tag,
+		NewInteger(ClassUniversal, TagEnumerated, int64(code)),
+		NewString(ClassUniversal, TagOctetString, ""),
+		NewString(ClassUniversal, TagOctetString, message))
+}
+
+// resultError returns the error of an LDAPResult, or nil on success
+func resultError(response *Packet) error {
+	codePacket, err := response.Child(0, "result code")
+	if err != nil {
+		return err
+	}
+	code, err := codePacket.Int()
+	if err != nil {
+		return err
+	}
+	if code == ResultSuccess {
+		return nil
+	}
+	message := ""
+	if len(response.Children) > 2 {
+		message = response.Children[2].String()
+	}
+	return &Error{Code: int(code), Message: message}
+}
+
+func decodeEntry(response *Packet) (*Entry, error) {
+	dn, err := response.Child(0, "entry name")
+	if err != nil {
+		return nil, err
+	}
+	attributes, err := response.Child(1, "entry attributes")
+	if err != nil {
+		return nil, err
+	}
+	entry := &Entry{DN: dn.String(), Attributes: map[string][]string{}}
+	for _, attribute := range attributes.Children {
+		name, err := attribute.Child(0, "attribute type")
+		if err != nil {
+			return nil, err
+		}
+		values, err := attribute.Child(1, "attribute values")
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range values.Children {
+			entry.Attributes[name.String()] = append(entry.Attributes[name.String()], value.String())
+		}
+	}
+	return entry, nil
+}
+
+// EncodeEntry returns the search result entry of an entry, with only the given attributes
+// if any are given
+func EncodeEntry(entry *Entry, attributes []string) *Packet {
+	encoded := NewSequence(ClassUniversal, TagSequence)
+	for name, values := range entry.Attributes {
+		if !selected(name, attributes) {
+			continue
+		}
+		set := NewSequence(ClassUniversal, TagSet)
+		for _, value := range values {
+			set.Children = append(set.Children, NewString(ClassUniversal, TagOctetString, value))
+		}
+		encoded.Children = append(encoded.Children, NewSequence(ClassUniversal, TagSequence,
+			NewString(ClassUniversal, TagOctetString, name), set))
+	}
+	return NewSequence(ClassApplication, OpSearchResultEntry, NewString(ClassUniversal, TagOctetString, entry.DN), encoded)
+}
+
+func selected(name string, attributes []string) bool {
+	if len(attributes) == 0 {
+		return true
+	}
+	for _, attribute := range attributes {
+		if attribute == "*" || strings.EqualFold(attribute, name) {
+			return true
+		}
+	}
+	return false
+}
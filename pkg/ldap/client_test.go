@@ -0,0 +1,96 @@
+package ldap_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/Kuadrant/kuadra/pkg/ldap"
+	"github.com/Kuadrant/kuadra/pkg/ldap/ldapfake"
+)
+
+var _ = Describe("LDAP client", func() {
+	const BaseDN = "dc=example,dc=org"
+
+	var server *ldapfake.Server
+	var conn *ldap.Conn
+	var ctx context.Context
+
+	BeforeEach(func() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		DeferCleanup(cancel)
+
+		server = ldapfake.NewServer()
+		DeferCleanup(server.Close)
+		server.AddEntry(BaseDN, map[string][]string{"objectClass": {"domain"}})
+		server.AddEntry("ou=people,"+BaseDN, map[string][]string{"objectClass": {"organizationalUnit"}})
+		for _, uid := range []string{"alice", "bob", "carol"} {
+			server.AddEntry("uid="+uid+",ou=people,"+BaseDN, map[string][]string{
+				"objectClass": {"person", "inetOrgPerson"},
+				"uid":         {uid},
+				"mail":        {uid + "@example.org"},
+			})
+		}
+		server.SetPassword("cn=admin,"+BaseDN, "secret")
+
+		var err error
+		conn, err = ldap.Dial(ctx, server.URL(), nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		DeferCleanup(conn.Close)
+	})
+
+	It("Should bind with a password", func() {
+		Expect(conn.Bind(ctx, "cn=admin,"+BaseDN, "secret")).Should(Succeed())
+		Expect(conn.Bind(ctx, "", "")).Should(Succeed())
+		err := conn.Bind(ctx, "cn=admin,"+BaseDN, "wrong")
+		Expect(ldap.IsResultCode(err, ldap.ResultInvalidCredentials)).Should(BeTrue())
+	})
+
+	It("Should start TLS before binding", func() {
+		roots := x509.NewCertPool()
+		Expect(roots.AppendCertsFromPEM(server.EnableStartTLS())).Should(BeTrue())
+
+		err := conn.StartTLS(ctx, &tls.Config{ServerName: "ldap.example.org", RootCAs: roots})
+		Expect(err).Should(MatchError(ContainSubstring("TLS handshake failed")))
+
+		conn, err = ldap.Dial(ctx, server.URL(), nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		DeferCleanup(conn.Close)
+		Expect(conn.StartTLS(ctx, &tls.Config{RootCAs: roots})).Should(Succeed())
+		Expect(conn.Bind(ctx, "cn=admin,"+BaseDN, "secret")).Should(Succeed())
+		Expect(server.PlainBinds()).Should(Equal(0))
+		entries, err := conn.Search(ctx, ldap.SearchRequest{BaseDN: "ou=people," + BaseDN, Scope: ldap.ScopeSingleLevel})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(entries).Should(HaveLen(3))
+		Expect(conn.StartTLS(ctx, &tls.Config{RootCAs: roots})).ShouldNot(Succeed())
+	})
+
+	It("Should search across pages", func() {
+		entries, err := conn.Search(ctx, ldap.SearchRequest{
+			BaseDN:     BaseDN,
+			Scope:      ldap.ScopeWholeSubtree,
+			Filter:     "(&(objectClass=person)(!(uid=b*)))",
+			Attributes: []string{"uid"},
+			PageSize:   1,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(entries).Should(HaveLen(2))
+		Expect(entries[0].DN).Should(Equal("uid=alice,ou=people," + BaseDN))
+		Expect(entries[0].Value("UID")).Should(Equal("alice"))
+		Expect(entries[0].Values("mail")).Should(BeEmpty())
+		Expect(entries[1].Value("uid")).Should(Equal("carol"))
+		Expect(server.Searches()).Should(Equal(2))
+
+		entries, err = conn.Search(ctx, ldap.SearchRequest{BaseDN: "ou=people," + BaseDN, Scope: ldap.ScopeSingleLevel})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(entries).Should(HaveLen(3))
+
+		_, err = conn.Search(ctx, ldap.SearchRequest{BaseDN: "dc=example,dc=com"})
+		Expect(ldap.IsResultCode(err, ldap.ResultNoSuchObject)).Should(BeTrue())
+	})
+})
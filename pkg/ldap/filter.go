@@ -0,0 +1,333 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// FilterOp is the kind of a search filter, numbered as its BER tag
+type FilterOp int
+
+const (
+	FilterAnd            FilterOp = 0
+	FilterOr             FilterOp = 1
+	FilterNot            FilterOp = 2
+	FilterEqual          FilterOp = 3
+	FilterSubstrings     FilterOp = 4
+	FilterGreaterOrEqual FilterOp = 5
+	FilterLessOrEqual    FilterOp = 6
+	FilterPresent        FilterOp = 7
+	FilterApprox         FilterOp = 8
+)
+
+// Filter is a search filter as written in RFC 4515, for example (&(objectClass=person)(uid=a*))
+type Filter struct {
+	Op FilterOp
+	// Children of an and, or or not filter
+	Children []*Filter
+	// Attribute compared by the other filters
+	Attribute string
+	// Value compared by equality, ordering and approximate filters
+	Value string
+	// Initial, Any and Final are the parts of a substrings filter
+	Initial string
+	Any     []string
+	Final   string
+}
+
+// ParseFilter parses the string form of a filter. Extensible matches are not supported.
+func ParseFilter(filter string) (*Filter, error) {
+	filter = strings.TrimSpace(filter)
+	f, rest, err := parseFilter(filter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %w", filter, err)
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("invalid filter %q: unexpected %q after the filter", filter, rest)
+	}
+	return f, nil
+}
+
+func parseFilter(s string) (*Filter, string, error) {
+	if !strings.HasPrefix(s, "(") {
+		return nil, "", fmt.Errorf("expected ( at %q", s)
+	}
+	s = s[1:]
+	if s == "" {
+		return nil, "", fmt.Errorf("unterminated filter")
+	}
+
+	switch s[0] {
+	case '&', '|':
+		f := &Filter{Op: FilterAnd}
+		if s[0] == '|' {
+			f.Op = FilterOr
+		}
+		s = s[1:]
+		for strings.HasPrefix(s, "(") {
+			child, rest, err := parseFilter(s)
+			if err != nil {
+				return nil, "", err
+			}
+			f.Children = append(f.Children, child)
+			s = rest
+		}
+		if !strings.HasPrefix(s, ")") {
+			return nil, "", fmt.Errorf("expected ) at %q", s)
+		}
+		return f, s[1:], nil
+	case '!':
+		child, rest, err := parseFilter(s[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		if !strings.HasPrefix(rest, ")") {
+			return nil, "", fmt.Errorf("expected ) at %q", rest)
+		}
+		return &Filter{Op: FilterNot, Children: []*Filter{child}}, rest[1:], nil
+	}
+
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", fmt.Errorf("unterminated filter")
+	}
+	item, rest := s[:end], s[end+1:]
+	f, err := parseItem(item)
+	return f, rest, err
+}
+
+// parseItem parses the comparison inside the parentheses of a simple filter
+func parseItem(item string) (*Filter, error) {
+	equals := strings.IndexByte(item, '=')
+	if equals <= 0 {
+		return nil, fmt.Errorf("expected attribute=value in %q", item)
+	}
+	attribute, value := item[:equals], item[equals+1:]
+	f := &Filter{Op: FilterEqual}
+	switch attribute[len(attribute)-1] {
+	case '>':
+		f.Op = FilterGreaterOrEqual
+	case '<':
+		f.Op = FilterLessOrEqual
+	case '~':
+		f.Op = FilterApprox
+	case ':':
+		return nil, fmt.Errorf("extensible matches are not supported")
+	}
+	if f.Op != FilterEqual {
+		attribute = attribute[:len(attribute)-1]
+	}
+	if attribute == "" || strings.ContainsAny(attribute, "()*\\ ") {
+		return nil, fmt.Errorf("invalid attribute %q", attribute)
+	}
+	f.Attribute = attribute
+
+	if f.Op == FilterEqual && value == "*" {
+		f.Op = FilterPresent
+		return f, nil
+	}
+	parts := strings.Split(value, "*")
+	if len(parts) > 1 && f.Op != FilterEqual {
+		return nil, fmt.Errorf("wildcards are only allowed in equality filters")
+	}
+	unescaped := make([]string, len(parts))
+	for i, part := range parts {
+		var err error
+		if unescaped[i], err = unescape(part); err != nil {
+			return nil, err
+		}
+	}
+	if len(parts) == 1 {
+		f.Value = unescaped[0]
+		return f, nil
+	}
+	f.Op = FilterSubstrings
+	f.Initial, f.Final = unescaped[0], unescaped[len(unescaped)-1]
+	for _, part := range unescaped[1 : len(unescaped)-1] {
+		if part == "" {
+			return nil, fmt.Errorf("consecutive wildcards in %q", value)
+		}
+		f.Any = append(f.Any, part)
+	}
+	return f, nil
+}
+
+// unescape replaces the \XX escapes of a filter value
+func unescape(value string) (string, error) {
+	if !strings.Contains(value, "\\") {
+		return value, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			b.WriteByte(value[i])
+			continue
+		}
+		if i+2 >= len(value) {
+			return "", fmt.Errorf("incomplete escape in %q", value)
+		}
+		decoded, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in %q", value)
+		}
+		b.Write(decoded)
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// EscapeFilterValue escapes the characters that have a meaning in filters
+func EscapeFilterValue(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// Packet encodes the filter
+func (f *Filter) Packet() *Packet {
+	tag := int(f.Op)
+	switch f.Op {
+	case FilterAnd, FilterOr, FilterNot:
+		p := NewSequence(ClassContext, tag)
+		for _, child := range f.Children {
+			p.Children = append(p.Children, child.Packet())
+		}
+		return p
+	case FilterPresent:
+		return NewString(ClassContext, tag, f.Attribute)
+	case FilterSubstrings:
+		substrings := NewSequence(ClassUniversal, TagSequence)
+		if f.Initial != "" {
+			substrings.Children = append(substrings.Children, NewString(ClassContext, 0, f.Initial))
+		}
+		for _, any := range f.Any {
+			substrings.Children = append(substrings.Children, NewString(ClassContext, 1, any))
+		}
+		if f.Final != "" {
+			substrings.Children = append(substrings.Children, NewString(ClassContext, 2, f.Final))
+		}
+		return NewSequence(ClassContext, tag, NewString(ClassUniversal, TagOctetString, f.Attribute), substrings)
+	}
+	return NewSequence(ClassContext, tag,
+		NewString(ClassUniversal, TagOctetString, f.Attribute),
+		NewString(ClassUniversal, TagOctetString, f.Value))
+}
+
+// DecodeFilter decodes the filter of a search request
+func DecodeFilter(p *Packet) (*Filter, error) {
+	if p.Class != ClassContext || p.Tag > int(FilterApprox) {
+		return nil, fmt.Errorf("unsupported filter tag %d", p.Tag)
+	}
+	f := &Filter{Op: FilterOp(p.Tag)}
+	switch f.Op {
+	case FilterAnd, FilterOr, FilterNot:
+		for _, child := range p.Children {
+			decoded, err := DecodeFilter(child)
+			if err != nil {
+				return nil, err
+			}
+			f.Children = append(f.Children, decoded)
+		}
+		if f.Op == FilterNot && len(f.Children) != 1 {
+			return nil, fmt.Errorf("a not filter needs one filter")
+		}
+		return f, nil
+	case FilterPresent:
+		f.Attribute = p.String()
+		return f, nil
+	}
+	attribute, err := p.Child(0, "filter attribute")
+	if err != nil {
+		return nil, err
+	}
+	value, err := p.Child(1, "filter value")
+	if err != nil {
+		return nil, err
+	}
+	f.Attribute = attribute.String()
+	if f.Op != FilterSubstrings {
+		f.Value = value.String()
+		return f, nil
+	}
+	for _, part := range value.Children {
+		switch part.Tag {
+		case 0:
+			f.Initial = part.String()
+		case 1:
+			f.Any = append(f.Any, part.String())
+		case 2:
+			f.Final = part.String()
+		}
+	}
+	return f, nil
+}
+
+// Matches evaluates the filter against an entry, comparing values case insensitively as
+// the caseIgnoreMatch rule of most user attributes does
+func (f *Filter) Matches(entry *Entry) bool {
+	switch f.Op {
+	case FilterAnd:
+		for _, child := range f.Children {
+			if !child.Matches(entry) {
+				return false
+			}
+		}
+		return true
+	case FilterOr:
+		for _, child := range f.Children {
+			if child.Matches(entry) {
+				return true
+			}
+		}
+		return false
+	case FilterNot:
+		return !f.Children[0].Matches(entry)
+	case FilterPresent:
+		return len(entry.Values(f.Attribute)) > 0
+	}
+	for _, value := range entry.Values(f.Attribute) {
+		value, expected := strings.ToLower(value), strings.ToLower(f.Value)
+		switch f.Op {
+		case FilterEqual, FilterApprox:
+			if value == expected {
+				return true
+			}
+		case FilterGreaterOrEqual:
+			if value >= expected {
+				return true
+			}
+		case FilterLessOrEqual:
+			if value <= expected {
+				return true
+			}
+		case FilterSubstrings:
+			if matchSubstrings(value, strings.ToLower(f.Initial), f.Any, strings.ToLower(f.Final)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchSubstrings(value string, initial string, any []string, final string) bool {
+	if !strings.HasPrefix(value, initial) {
+		return false
+	}
+	value = value[len(initial):]
+	for _, part := range any {
+		i := strings.Index(value, strings.ToLower(part))
+		if i < 0 {
+			return false
+		}
+		value = value[i+len(part):]
+	}
+	return strings.HasSuffix(value, final)
+}
@@ -0,0 +1,53 @@
+package ldap_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/Kuadrant/kuadra/pkg/ldap"
+)
+
+var _ = Describe("Filters", func() {
+	entry := &ldap.Entry{DN: "uid=alice,dc=example,dc=org", Attributes: map[string][]string{
+		"objectClass": {"top", "person"},
+		"uid":         {"alice"},
+		"cn":          {"Alice (Admin) Smith"},
+		"memberOf":    {"cn=dns,ou=groups,dc=example,dc=org"},
+	}}
+
+	DescribeTable("Should match entries",
+		func(filter string, matches bool) {
+			f, err := ldap.ParseFilter(filter)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(f.Matches(entry)).Should(Equal(matches))
+
+			decoded, err := ldap.DecodeFilter(f.Packet())
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(decoded).Should(Equal(f))
+		},
+		Entry("equality", "(objectclass=Person)", true),
+		Entry("presence", "(mail=*)", false),
+		Entry("and", "(&(objectClass=person)(uid=alice))", true),
+		Entry("or", "(|(uid=bob)(uid=carol))", false),
+		Entry("not", "(!(uid=bob))", true),
+		Entry("substrings", "(cn=alice*smith)", true),
+		Entry("substrings with any", "(cn=*admin*jones)", false),
+		Entry("escapes", `(cn=*\28admin\29*)`, true),
+		Entry("ordering", "(uid>=b)", false),
+		Entry("distinguished name", "(memberOf=CN=dns,ou=groups,dc=example,dc=org)", true),
+	)
+
+	DescribeTable("Should reject invalid filters",
+		func(filter string) {
+			_, err := ldap.ParseFilter(filter)
+			Expect(err).Should(HaveOccurred())
+		},
+		Entry("no parentheses", "uid=alice"),
+		Entry("unterminated", "(&(uid=alice)"),
+		Entry("trailing text", "(uid=alice)x"),
+		Entry("unescaped parenthesis", "(cn=*(admin)*)"),
+		Entry("wildcard in ordering", "(uid>=a*)"),
+		Entry("bad escape", `(uid=\zz)`),
+		Entry("extensible match", "(uid:dn:=alice)"),
+	)
+})
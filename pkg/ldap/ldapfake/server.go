@@ -0,0 +1,338 @@
+// Package ldapfake is an in-memory directory server speaking enough LDAPv3 over TCP for
+// the ldap client: StartTLS, simple binds and paged searches with filters. Pointing a controller at
+// it exercises directory sync end to end without a real directory.
+package ldapfake
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Kuadrant/kuadra/pkg/ldap"
+)
+
+// Server is a directory server listening on a local port
+type Server struct {
+	listener net.Listener
+
+	mu sync.Mutex
+	// entries by lower-cased DN
+	entries   map[string]*ldap.Entry
+	passwords map[string]string
+	searches  int
+	// searchError is the result code failing searches, ResultSuccess when they succeed
+	searchError int
+	// tlsConfig serves StartTLS, which is refused while it is nil
+	tlsConfig *tls.Config
+	// plainBinds counts the binds with a password over connections without TLS
+	plainBinds int
+	conns      map[net.Conn]struct{}
+	wg         sync.WaitGroup
+}
+
+// NewServer starts a server with no entries that only accepts anonymous binds. Close it
+// once done.
+func NewServer() *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("ldapfake: failed to listen: " + err.Error())
+	}
+	s := &Server{
+		listener:  listener,
+		entries:   map[string]*ldap.Entry{},
+		passwords: map[string]string{},
+		conns:     map[net.Conn]struct{}{},
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// URL returns the ldap:// URL of the server
+func (s *Server) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+// Close stops the server and closes its connections
+func (s *Server) Close() {
+	s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// AddEntry adds or replaces the entry with the DN
+func (s *Server) AddEntry(dn string, attributes map[string][]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[strings.ToLower(dn)] = &ldap.Entry{DN: dn, Attributes: attributes}
+}
+
+// DeleteEntry removes the entry with the DN
+func (s *Server) DeleteEntry(dn string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, strings.ToLower(dn))
+}
+
+// SetPassword lets dn bind with password
+func (s *Server) SetPassword(dn string, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.passwords[strings.ToLower(dn)] = password
+}
+
+// FailSearches makes searches return the result code, or succeed again with ResultSuccess
+func (s *Server) FailSearches(code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.searchError = code
+}
+
+// EnableStartTLS lets clients start TLS with a self-signed certificate for 127.0.0.1, and
+// returns the certificate in PEM for them to trust
+func (s *Server) EnableStartTLS() []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic("ldapfake: failed to generate key: " + err.Error())
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ldapfake"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		panic("ldapfake: failed to create certificate: " + err.Error())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tlsConfig = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// PlainBinds returns how many binds with a password were received without TLS
+func (s *Server) PlainBinds() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.plainBinds
+}
+
+// Searches returns how many search requests were received, one per page
+func (s *Server) Searches() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.searches
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+// handle answers the requests of a connection until it is unbound or closed. After
+// StartTLS conn is replaced by the TLS connection over it.
+func (s *Server) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer func(plain net.Conn) {
+		plain.Close()
+		s.mu.Lock()
+		delete(s.conns, plain)
+		s.mu.Unlock()
+	}(conn)
+
+	reader := bufio.NewReader(conn)
+	for {
+		request, err := ldap.ReadPacket(reader)
+		if err != nil || len(request.Children) < 2 {
+			return
+		}
+		id, err := request.Children[0].Int()
+		if err != nil {
+			return
+		}
+		operation := request.Children[1]
+		var controls []*ldap.Packet
+		if len(request.Children) > 2 {
+			controls = request.Children[2].Children
+		}
+
+		var responses []*ldap.Packet
+		switch {
+		case operation.Is(ldap.ClassApplication, ldap.OpExtendedRequest):
+			config := s.startTLS(operation)
+			code := ldap.ResultSuccess
+			if config == nil {
+				code = protocolError
+			}
+			if _, err := conn.Write(ldap.Message(id, ldap.Result(ldap.OpExtendedResponse, code, "")).Bytes()); err != nil || config == nil {
+				return
+			}
+			tlsConn := tls.Server(conn, config)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, reader = tlsConn, bufio.NewReader(tlsConn)
+			continue
+		case operation.Is(ldap.ClassApplication, ldap.OpBindRequest):
+			_, encrypted := conn.(*tls.Conn)
+			responses = []*ldap.Packet{ldap.Message(id, s.bind(operation, encrypted))}
+		case operation.Is(ldap.ClassApplication, ldap.OpSearchRequest):
+			responses = s.search(id, operation, controls)
+		default:
+			return
+		}
+		for _, response := range responses {
+			if _, err := conn.Write(response.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// startTLS returns the configuration to start TLS with for a StartTLS request, or nil if
+// the request is another extended operation or StartTLS is not enabled
+func (s *Server) startTLS(request *ldap.Packet) *tls.Config {
+	if len(request.Children) < 1 || request.Children[0].String() != ldap.StartTLSOID {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tlsConfig
+}
+
+func (s *Server) bind(request *ldap.Packet, encrypted bool) *ldap.Packet {
+	if len(request.Children) < 3 {
+		return ldap.Result(ldap.OpBindResponse, protocolError, "malformed bind request")
+	}
+	dn, password := request.Children[1].String(), request.Children[2].String()
+	if dn == "" && password == "" {
+		return ldap.Result(ldap.OpBindResponse, ldap.ResultSuccess, "")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !encrypted {
+		s.plainBinds++
+	}
+	if expected, ok := s.passwords[strings.ToLower(dn)]; !ok || expected != password {
+		return ldap.Result(ldap.OpBindResponse, ldap.ResultInvalidCredentials, "invalid credentials")
+	}
+	return ldap.Result(ldap.OpBindResponse, ldap.ResultSuccess, "")
+}
+
+// protocolError is the result code of malformed requests
+const protocolError = 2
+
+// search returns the entry and done messages answering a search request. Paging uses the
+// offset of the next entry as cookie.
+func (s *Server) search(id int64, request *ldap.Packet, controls []*ldap.Packet) []*ldap.Packet {
+	done := func(code int, message string, controls ...*ldap.Packet) *ldap.Packet {
+		return ldap.Message(id, ldap.Result(ldap.OpSearchResultDone, code, message), controls...)
+	}
+	if len(request.Children) < 8 {
+		return []*ldap.Packet{done(protocolError, "malformed search request")}
+	}
+	baseDN := strings.ToLower(request.Children[0].String())
+	scope, err := request.Children[1].Int()
+	if err != nil {
+		return []*ldap.Packet{done(protocolError, "malformed scope")}
+	}
+	filter, err := ldap.DecodeFilter(request.Children[6])
+	if err != nil {
+		return []*ldap.Packet{done(protocolError, err.Error())}
+	}
+	var attributes []string
+	for _, attribute := range request.Children[7].Children {
+		attributes = append(attributes, attribute.String())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.searches++
+	if s.searchError != ldap.ResultSuccess {
+		return []*ldap.Packet{done(s.searchError, "search failed")}
+	}
+	if _, ok := s.entries[baseDN]; !ok {
+		return []*ldap.Packet{done(ldap.ResultNoSuchObject, "no such object")}
+	}
+
+	var matches []*ldap.Entry
+	for dn, entry := range s.entries {
+		if inScope(dn, baseDN, ldap.Scope(scope)) && filter.Matches(entry) {
+			matches = append(matches, entry)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].DN < matches[j].DN })
+
+	var responseControls []*ldap.Packet
+	for _, control := range controls {
+		size, cookie, ok := ldap.DecodePagedResultsControl(control)
+		if !ok || size <= 0 {
+			continue
+		}
+		offset, _ := strconv.Atoi(cookie)
+		if offset > len(matches) {
+			offset = len(matches)
+		}
+		matches = matches[offset:]
+		next := ""
+		if len(matches) > size {
+			matches = matches[:size]
+			next = strconv.Itoa(offset + size)
+		}
+		responseControls = append(responseControls, ldap.PagedResultsControl(0, next))
+	}
+
+	var responses []*ldap.Packet
+	for _, entry := range matches {
+		responses = append(responses, ldap.Message(id, ldap.EncodeEntry(entry, attributes)))
+	}
+	return append(responses, done(ldap.ResultSuccess, "", responseControls...))
+}
+
+// inScope reports whether the lower-cased dn is within the scope of a search of baseDN
+func inScope(dn string, baseDN string, scope ldap.Scope) bool {
+	if dn == baseDN {
+		return scope == ldap.ScopeBaseObject || scope == ldap.ScopeWholeSubtree
+	}
+	if scope == ldap.ScopeBaseObject || !strings.HasSuffix(dn, ","+baseDN) {
+		return false
+	}
+	if scope == ldap.ScopeSingleLevel {
+		return !strings.Contains(strings.TrimSuffix(dn, ","+baseDN), ",")
+	}
+	return true
+}
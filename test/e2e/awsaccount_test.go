@@ -0,0 +1,132 @@
+package e2e
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	kuadrav1 "github.com/Kuadrant/kuadra/api/v1"
+	"github.com/Kuadrant/kuadra/pkg/aws/iamfake"
+)
+
+var _ = Describe("AwsAccount against IAM", func() {
+	const (
+		timeout  = time.Second * 20
+		interval = time.Millisecond * 250
+	)
+
+	ctx := context.Background()
+
+	ready := func(key types.NamespacedName) func() bool {
+		return func() bool {
+			var awsAccount kuadrav1.AwsAccount
+			if err := k8sClient.Get(ctx, key, &awsAccount); err != nil {
+				return false
+			}
+			return meta.IsStatusConditionTrue(awsAccount.Status.Conditions, kuadrav1.ConditionReady)
+		}
+	}
+
+	It("Should create the user, its group and credentials, and remove them again", func() {
+		awsGroup := &kuadrav1.AwsGroup{
+			ObjectMeta: metav1.ObjectMeta{Name: "dns-management", Namespace: "default"},
+			Spec: kuadrav1.AwsGroupSpec{
+				ManagedPolicyArns: []string{"arn:aws:iam::aws:policy/AmazonRoute53FullAccess"},
+			},
+		}
+		Expect(k8sClient.Create(ctx, awsGroup)).Should(Succeed())
+
+		awsAccount := &kuadrav1.AwsAccount{
+			ObjectMeta: metav1.ObjectMeta{Name: "ib-dns", Namespace: "default"},
+			Spec: kuadrav1.AwsAccountSpec{
+				UserName:  "ib-dns",
+				GroupRefs: []string{"dns-management"},
+				InlinePolicies: map[string]kuadrav1.InlinePolicy{
+					"zone": {Document: `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"route53:ListHostedZones","Resource":"*"}]}`},
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, awsAccount)).Should(Succeed())
+		key := types.NamespacedName{Name: awsAccount.Name, Namespace: awsAccount.Namespace}
+		Eventually(ready(key), timeout, interval).Should(BeTrue())
+
+		var group kuadrav1.AwsGroup
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: awsGroup.Name, Namespace: awsGroup.Namespace}, &group)).Should(Succeed())
+		user, found := iamServer.User("ib-dns")
+		Expect(found).Should(BeTrue())
+		Expect(user.Path).Should(Equal("/kuadra/default/"))
+		Expect(user.LoginProfile).Should(BeTrue())
+		Expect(user.AccessKeys).Should(HaveLen(1))
+		Expect(user.Groups).Should(Equal([]string{group.Status.GroupName}))
+		Expect(user.InlinePolicies).Should(HaveKey("zone"))
+
+		var secret corev1.Secret
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "aws-credentials", Namespace: "ib-dns"}, &secret)).Should(Succeed())
+		Expect(string(secret.Data["AWS_ACCESS_KEY_ID"])).Should(Equal(user.AccessKeys[0].AccessKeyId))
+		Expect(string(secret.Data["AWS_ACCOUNT_ID"])).Should(Equal(iamfake.AccountId))
+
+		By("By deleting the user and everything attached to it")
+		Expect(k8sClient.Delete(ctx, awsAccount)).Should(Succeed())
+		Eventually(func() bool {
+			_, found := iamServer.User("ib-dns")
+			return found
+		}, timeout, interval).Should(BeFalse())
+	})
+
+	It("Should ride out throttling", func() {
+		iamServer.Throttle("CreateAccessKey", 3)
+		defer iamServer.ClearFaults()
+		calls := iamServer.Calls("CreateAccessKey")
+
+		awsAccount := &kuadrav1.AwsAccount{
+			ObjectMeta: metav1.ObjectMeta{Name: "throttled", Namespace: "default"},
+			Spec:       kuadrav1.AwsAccountSpec{UserName: "throttled"},
+		}
+		Expect(k8sClient.Create(ctx, awsAccount)).Should(Succeed())
+		key := types.NamespacedName{Name: awsAccount.Name, Namespace: awsAccount.Namespace}
+		Eventually(ready(key), timeout, interval).Should(BeTrue())
+
+		Expect(iamServer.Calls("CreateAccessKey") - calls).Should(Equal(4))
+		user, _ := iamServer.User("throttled")
+		Expect(user.AccessKeys).Should(HaveLen(1))
+	})
+
+	It("Should report permanent errors in the Ready condition without retrying them", func() {
+		awsAccount := &kuadrav1.AwsAccount{
+			ObjectMeta: metav1.ObjectMeta{Name: "malformed", Namespace: "default"},
+			Spec: kuadrav1.AwsAccountSpec{
+				UserName: "malformed",
+				InlinePolicies: map[string]kuadrav1.InlinePolicy{
+					"zone": {Document: `{"Version":"2012-10-17"}`},
+				},
+			},
+		}
+		iamServer.InjectFault(iamfake.Fault{Action: "PutUserPolicy", Code: "MalformedPolicyDocument"})
+		defer iamServer.ClearFaults()
+
+		Expect(k8sClient.Create(ctx, awsAccount)).Should(Succeed())
+		key := types.NamespacedName{Name: awsAccount.Name, Namespace: awsAccount.Namespace}
+		Eventually(func() string {
+			var latest kuadrav1.AwsAccount
+			if err := k8sClient.Get(ctx, key, &latest); err != nil {
+				return ""
+			}
+			condition := meta.FindStatusCondition(latest.Status.Conditions, kuadrav1.ConditionReady)
+			if condition == nil {
+				return ""
+			}
+			return condition.Reason
+		}, timeout, interval).Should(Equal("PermanentError"))
+		calls := iamServer.Calls("PutUserPolicy")
+		Consistently(func() int {
+			return iamServer.Calls("PutUserPolicy")
+		}, 2*time.Second, interval).Should(Equal(calls))
+	})
+})